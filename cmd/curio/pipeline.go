@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"text/tabwriter"
 
 	"github.com/urfave/cli/v2"
 	"golang.org/x/xerrors"
@@ -15,6 +19,7 @@ import (
 	"github.com/filecoin-project/lotus/cmd/curio/deps"
 	"github.com/filecoin-project/lotus/curiosrc/seal"
 	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/node/config"
 )
 
 var sealCmd = &cli.Command{
@@ -22,6 +27,7 @@ var sealCmd = &cli.Command{
 	Usage: "Manage the sealing pipeline",
 	Subcommands: []*cli.Command{
 		sealStartCmd,
+		sealPipelineCmd,
 	},
 }
 
@@ -133,3 +139,87 @@ var sealStartCmd = &cli.Command{
 		return nil
 	},
 }
+
+var sealPipelineCmd = &cli.Command{
+	Name:  "pipeline",
+	Usage: "List sectors in the sealing pipeline with their current stage",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "sp",
+			Usage: "Only list sectors of this actor address",
+		},
+		&cli.BoolFlag{
+			Name:  "failed-only",
+			Usage: "Only list failed sectors",
+		},
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: "Output format: table or json",
+			Value: "table",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		var spID int64
+		if cctx.IsSet("sp") {
+			act, err := address.NewFromString(cctx.String("sp"))
+			if err != nil {
+				return xerrors.Errorf("parsing --sp: %w", err)
+			}
+			mid, err := address.IDFromAddress(act)
+			if err != nil {
+				return xerrors.Errorf("getting miner id: %w", err)
+			}
+			spID = int64(mid)
+		}
+
+		db, err := deps.MakeDB(cctx)
+		if err != nil {
+			return err
+		}
+
+		// listing only reads the pipeline table, the poller doesn't need a chain node
+		sp := seal.NewPoller(db, nil, config.CurioSealConfig{})
+
+		return printSealPipeline(lcli.ReqContext(cctx), cctx.App.Writer, sp, spID, cctx.Bool("failed-only"), cctx.String("format"))
+	},
+}
+
+func printSealPipeline(ctx context.Context, w io.Writer, sp *seal.SealPoller, spID int64, failedOnly bool, format string) error {
+	sectors, err := sp.ListSectors(ctx, spID)
+	if err != nil {
+		return err
+	}
+
+	if failedOnly {
+		failed := sectors[:0]
+		for _, sector := range sectors {
+			if sector.Failed {
+				failed = append(failed, sector)
+			}
+		}
+		sectors = failed
+	}
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(sectors)
+	case "table":
+		tw := tabwriter.NewWriter(w, 2, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintln(tw, "SP\tSector\tStage\tFailed")
+		for _, sector := range sectors {
+			failed := ""
+			if sector.Failed {
+				failed = sector.FailedReason
+				if sector.FailedReasonMsg != "" {
+					failed += ": " + sector.FailedReasonMsg
+				}
+			}
+			_, _ = fmt.Fprintf(tw, "f0%d\t%d\t%s\t%s\n", sector.SpID, sector.SectorNumber, sector.Stage, failed)
+		}
+		return tw.Flush()
+	default:
+		return xerrors.Errorf("unknown format %q, expected table or json", format)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/lotus/curiosrc/seal"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/node/config"
+)
+
+func TestPrintSealPipeline(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := harmonydb.NewFromConfigWithITestID(config.DefaultStorageMiner().HarmonyDB)(harmonydb.ITestNewID())
+	if err != nil {
+		t.Skipf("harmonydb not available: %s", err)
+	}
+	t.Cleanup(db.ITestDeleteAll)
+
+	_, err = db.Exec(ctx, `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof, after_sdr) VALUES
+		(1000, 1, 0, TRUE),
+		(1000, 2, 0, FALSE),
+		(1001, 1, 0, FALSE)`)
+	require.NoError(t, err)
+
+	_, err = db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET failed = TRUE, failed_reason = 'precommit-check', failed_reason_msg = 'bad ticket'
+		WHERE sp_id = 1000 AND sector_number = 2`)
+	require.NoError(t, err)
+
+	sp := seal.NewPoller(db, nil, config.CurioSealConfig{})
+
+	render := func(spID int64, failedOnly bool, format string) string {
+		var out bytes.Buffer
+		require.NoError(t, printSealPipeline(ctx, &out, sp, spID, failedOnly, format))
+		return out.String()
+	}
+
+	t.Run("table", func(t *testing.T) {
+		lines := strings.Split(strings.TrimSpace(render(0, false, "table")), "\n")
+		require.Len(t, lines, 4)
+		require.Equal(t, []string{"SP", "Sector", "Stage", "Failed"}, strings.Fields(lines[0]))
+		require.Equal(t, []string{"f01000", "1", "trees"}, strings.Fields(lines[1]))
+		require.Equal(t, []string{"f01000", "2", "sdr", "precommit-check:", "bad", "ticket"}, strings.Fields(lines[2]))
+		require.Equal(t, []string{"f01001", "1", "sdr"}, strings.Fields(lines[3]))
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var sectors []seal.SectorPipelineState
+		require.NoError(t, json.Unmarshal([]byte(render(1000, false, "json")), &sectors))
+		require.Equal(t, []seal.SectorPipelineState{
+			{SpID: 1000, SectorNumber: 1, Stage: "trees"},
+			{SpID: 1000, SectorNumber: 2, Stage: "sdr", Failed: true, FailedReason: "precommit-check", FailedReasonMsg: "bad ticket"},
+		}, sectors)
+	})
+
+	t.Run("failed-only", func(t *testing.T) {
+		lines := strings.Split(strings.TrimSpace(render(0, true, "table")), "\n")
+		require.Len(t, lines, 2)
+		require.Equal(t, []string{"f01000", "2"}, strings.Fields(lines[1])[:2])
+	})
+
+	t.Run("bad format", func(t *testing.T) {
+		require.Error(t, printSealPipeline(ctx, &bytes.Buffer{}, sp, 0, false, "yaml"))
+	})
+}
@@ -6,8 +6,11 @@ import (
 
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/samber/lo"
+	"go.opentelemetry.io/otel"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
 	"golang.org/x/xerrors"
 
+	"github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/cmd/curio/deps"
 	curio "github.com/filecoin-project/lotus/curiosrc"
 	"github.com/filecoin-project/lotus/curiosrc/chainsched"
@@ -20,10 +23,18 @@ import (
 	"github.com/filecoin-project/lotus/lib/lazy"
 	"github.com/filecoin-project/lotus/lib/must"
 	"github.com/filecoin-project/lotus/node/modules"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
 )
 
 var log = logging.Logger("curio/deps")
 
+// sealPollerAPI is the chain API of the seal poller, with the proof verifier it
+// checks PoRep proofs with, see CurioSealConfig.VerifyCommitProof
+type sealPollerAPI struct {
+	api.FullNode
+	storiface.Verifier
+}
+
 func StartTasks(ctx context.Context, dependencies *deps.Deps) (*harmonytask.TaskEngine, error) {
 	cfg := dependencies.Cfg
 	db := dependencies.DB
@@ -84,6 +95,8 @@ func StartTasks(ctx context.Context, dependencies *deps.Deps) (*harmonytask.Task
 
 	hasAnySealingTask := cfg.Subsystems.EnableSealSDR ||
 		cfg.Subsystems.EnableSealSDRTrees ||
+		cfg.Subsystems.EnableSealTreeD ||
+		cfg.Subsystems.EnableSealTreeRC ||
 		cfg.Subsystems.EnableSendPrecommitMsg ||
 		cfg.Subsystems.EnablePoRepProof ||
 		cfg.Subsystems.EnableMoveStorage ||
@@ -94,7 +107,18 @@ func StartTasks(ctx context.Context, dependencies *deps.Deps) (*harmonytask.Task
 		var sp *seal.SealPoller
 		var slr *ffi.SealCalls
 		if hasAnySealingTask {
-			sp = seal.NewPoller(db, full)
+			sp = seal.NewPoller(db, sealPollerAPI{full, verif}, cfg.Seal)
+			sp.SetMsgReplacer(sender, cfg.Fees.MaxPreCommitGasFee, cfg.Fees.MaxCommitGasFee)
+			// the global provider is an SDK one once a tracer is configured,
+			// see tracing.SetupJaegerTracing
+			if tp, ok := otel.GetTracerProvider().(*tracesdk.TracerProvider); ok {
+				sp.SetTracerProvider(tp)
+			}
+			if cfg.Seal.ValidatePollerSchema {
+				if err := sp.ValidateSchema(ctx); err != nil {
+					return nil, err
+				}
+			}
 			go sp.RunPoller(ctx)
 
 			slr = must.One(slrLazy.Val())
@@ -105,7 +129,21 @@ func StartTasks(ctx context.Context, dependencies *deps.Deps) (*harmonytask.Task
 			sdrTask := seal.NewSDRTask(full, db, sp, slr, cfg.Subsystems.SealSDRMaxTasks)
 			activeTasks = append(activeTasks, sdrTask)
 		}
-		if cfg.Subsystems.EnableSealSDRTrees {
+		if cfg.Seal.SplitTrees {
+			treeD := cfg.Subsystems.EnableSealSDRTrees || cfg.Subsystems.EnableSealTreeD
+			treeRC := cfg.Subsystems.EnableSealSDRTrees || cfg.Subsystems.EnableSealTreeRC
+
+			if treeD {
+				activeTasks = append(activeTasks, seal.NewTreeDTask(sp, db, slr, cfg.Subsystems.SealSDRTreesMaxTasks))
+			}
+			if treeRC {
+				activeTasks = append(activeTasks, seal.NewTreeRCTask(sp, db, slr, cfg.Subsystems.SealSDRTreesMaxTasks))
+			}
+			if treeD || treeRC {
+				finalizeTask := seal.NewFinalizeTask(cfg.Subsystems.FinalizeMaxTasks, sp, slr, db)
+				activeTasks = append(activeTasks, finalizeTask)
+			}
+		} else if cfg.Subsystems.EnableSealSDRTrees {
 			treesTask := seal.NewTreesTask(sp, db, slr, cfg.Subsystems.SealSDRTreesMaxTasks)
 			finalizeTask := seal.NewFinalizeTask(cfg.Subsystems.FinalizeMaxTasks, sp, slr, db)
 			activeTasks = append(activeTasks, treesTask, finalizeTask)
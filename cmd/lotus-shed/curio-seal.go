@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/filecoin-project/lotus/cmd/curio/deps"
+	"github.com/filecoin-project/lotus/curiosrc/seal"
+)
+
+var lpUtilSealExplainCmd = &cli.Command{
+	Name:  "seal-explain",
+	Usage: "Explain what the seal poller would do for a sector at a given chain head",
+	Description: `Loads the pipeline row of the sector and runs the seal poller stage checks
+in dry-run, printing for each stage whether the poller would act on it, and why.
+Nothing is written to the database and no chain node is needed, so the database
+may be a restored dump of a production one.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "actor",
+			Usage:    "Address of the miner actor",
+			Required: true,
+		},
+		&cli.Int64Flag{
+			Name:     "sector",
+			Usage:    "Sector number",
+			Required: true,
+		},
+		&cli.Int64Flag{
+			Name:     "head",
+			Usage:    "Chain head epoch to explain the poll cycle at",
+			Required: true,
+		},
+
+		&cli.StringFlag{
+			Name:    "db-host",
+			EnvVars: []string{"LOTUS_DB_HOST"},
+			Usage:   "Command separated list of hostnames for yugabyte cluster",
+			Value:   "yugabyte",
+		},
+		&cli.StringFlag{
+			Name:    "db-name",
+			EnvVars: []string{"LOTUS_DB_NAME", "LOTUS_HARMONYDB_HOSTS"},
+			Value:   "yugabyte",
+		},
+		&cli.StringFlag{
+			Name:    "db-user",
+			EnvVars: []string{"LOTUS_DB_USER", "LOTUS_HARMONYDB_USERNAME"},
+			Value:   "yugabyte",
+		},
+		&cli.StringFlag{
+			Name:    "db-password",
+			EnvVars: []string{"LOTUS_DB_PASSWORD", "LOTUS_HARMONYDB_PASSWORD"},
+			Value:   "yugabyte",
+		},
+		&cli.StringFlag{
+			Name:    "db-port",
+			EnvVars: []string{"LOTUS_DB_PORT", "LOTUS_HARMONYDB_PORT"},
+			Hidden:  true,
+			Value:   "5433",
+		},
+		&cli.StringSliceFlag{
+			Name:  "layers",
+			Usage: "list of layers to read the seal config from (atop defaults). Default: base",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := lcli.ReqContext(cctx)
+
+		maddr, err := address.NewFromString(cctx.String("actor"))
+		if err != nil {
+			return xerrors.Errorf("parsing miner address: %w", err)
+		}
+		mid, err := address.IDFromAddress(maddr)
+		if err != nil {
+			return xerrors.Errorf("getting miner id: %w", err)
+		}
+
+		db, err := deps.MakeDB(cctx)
+		if err != nil {
+			return err
+		}
+
+		cfg, err := deps.GetConfig(cctx, db)
+		if err != nil {
+			return xerrors.Errorf("getting config: %w", err)
+		}
+
+		poller := seal.NewPoller(db, nil, cfg.Seal)
+
+		decisions, err := poller.ExplainSector(ctx, int64(mid), cctx.Int64("sector"), abi.ChainEpoch(cctx.Int64("head")))
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 2, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintf(w, "Stage\tAct\tReason\n")
+		for _, d := range decisions {
+			act := ""
+			if d.Act {
+				act = "yes"
+			}
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", d.Stage, act, d.Reason)
+		}
+		return w.Flush()
+	},
+}
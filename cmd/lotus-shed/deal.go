@@ -53,6 +53,7 @@ var lpUtilCmd = &cli.Command{
 	Subcommands: []*cli.Command{
 		lpUtilStartDealCmd,
 		lpBoostProxyCmd,
+		lpUtilSealExplainCmd,
 	},
 }
 
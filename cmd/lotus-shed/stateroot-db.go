@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "github.com/mattn/go-sqlite3"
+	"golang.org/x/xerrors"
+
+	lbuiltin "github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// openStaterootDB opens the database of a stateroot stat --db DSN: postgres://
+// and postgresql:// URLs are opened with pgx, anything else as a sqlite file
+func openStaterootDB(dsn string) (*sql.DB, error) {
+	driver := "sqlite3"
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		driver = "pgx"
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, xerrors.Errorf("opening stats database: %w", err)
+	}
+	return db, nil
+}
+
+// writeStaterootDB records the state sizes of actors at ts in the
+// stateroot_actor_stats table, creating it if absent. Rows are keyed by height
+// and actor, so that repeated runs build a time series and re-running a height
+// replaces its rows.
+func writeStaterootDB(ctx context.Context, dsn string, ts *types.TipSet, actors []statItem) error {
+	db, err := openStaterootDB(dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close() //nolint:errcheck
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS stateroot_actor_stats (
+		height BIGINT NOT NULL,
+		timestamp BIGINT NOT NULL,
+		tipset TEXT NOT NULL,
+		addr TEXT NOT NULL,
+		actor_type TEXT NOT NULL,
+		size BIGINT NOT NULL,
+		links BIGINT NOT NULL,
+		PRIMARY KEY (height, addr)
+	)`)
+	if err != nil {
+		return xerrors.Errorf("creating stateroot_actor_stats table: %w", err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return xerrors.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	for _, inf := range actors {
+		_, err := tx.ExecContext(ctx, `INSERT INTO stateroot_actor_stats (height, timestamp, tipset, addr, actor_type, size, links)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (height, addr) DO UPDATE SET timestamp = excluded.timestamp, tipset = excluded.tipset,
+				actor_type = excluded.actor_type, size = excluded.size, links = excluded.links`,
+			int64(ts.Height()), int64(ts.MinTimestamp()), ts.Key().String(), inf.Addr.String(),
+			lbuiltin.ActorNameByCode(inf.Actor.Code), int64(inf.Stat.Size), int64(inf.Stat.Links))
+		if err != nil {
+			return xerrors.Errorf("inserting stats of %s: %w", inf.Addr, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return xerrors.Errorf("committing stats: %w", err)
+	}
+	return nil
+}
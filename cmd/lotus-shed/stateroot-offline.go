@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/ipfs/boxo/blockservice"
+	offline "github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipld/go-car"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/consensus/filcns"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/store"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/filecoin-project/lotus/node/repo"
+)
+
+// staterootAPI is the subset of the full node API used by the stateroot commands.
+type staterootAPI interface {
+	lcli.TipSetResolver
+
+	ChainReadObj(ctx context.Context, obj cid.Cid) ([]byte, error)
+	ChainStatObj(ctx context.Context, obj cid.Cid, base cid.Cid) (api.ObjStat, error)
+	StateListActors(ctx context.Context, tsk types.TipSetKey) ([]address.Address, error)
+	StateGetActor(ctx context.Context, actor address.Address, tsk types.TipSetKey) (*types.Actor, error)
+	StateAccountKey(ctx context.Context, addr address.Address, tsk types.TipSetKey) (address.Address, error)
+}
+
+var staterootOfflineFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "car",
+		Usage: "read chain state from a CAR file instead of the full node API; the CAR roots are used as the head tipset",
+	},
+	&cli.BoolFlag{
+		Name:  "repo-blockstore",
+		Usage: "read chain state directly from the blockstore of the (stopped) node at --repo instead of the full node API",
+	},
+}
+
+// getStaterootAPI returns an offline state reader when --car or --repo-blockstore
+// is set, and the full node API otherwise.
+func getStaterootAPI(cctx *cli.Context) (staterootAPI, func(), error) {
+	ctx := lcli.ReqContext(cctx)
+
+	switch {
+	case cctx.IsSet("car") && cctx.Bool("repo-blockstore"):
+		return nil, nil, xerrors.Errorf("--car and --repo-blockstore are mutually exclusive")
+	case cctx.IsSet("car"):
+		return openCarStaterootAPI(ctx, cctx.String("car"))
+	case cctx.Bool("repo-blockstore"):
+		return openRepoStaterootAPI(ctx, cctx.String("repo"))
+	}
+
+	fapi, closer, err := lcli.GetFullNodeAPI(cctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return fapi, closer, nil
+}
+
+func openCarStaterootAPI(ctx context.Context, path string) (staterootAPI, func(), error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("opening car file: %w", err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	return loadCarStaterootAPI(ctx, f)
+}
+
+func loadCarStaterootAPI(ctx context.Context, r io.Reader) (staterootAPI, func(), error) {
+	bs := blockstore.NewMemory()
+
+	hdr, err := car.LoadCar(ctx, bs, r)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("loading car file: %w", err)
+	}
+	if len(hdr.Roots) == 0 {
+		return nil, nil, xerrors.Errorf("car file has no roots")
+	}
+
+	cs := store.NewChainStore(bs, bs, dssync.MutexWrap(datastore.NewMapDatastore()), filcns.Weight, nil)
+
+	head, err := cs.LoadTipSet(ctx, types.NewTipSetKey(hdr.Roots...))
+	if err != nil {
+		_ = cs.Close()
+		return nil, nil, xerrors.Errorf("loading head tipset from car roots: %w", err)
+	}
+
+	return &offlineStaterootAPI{cs: cs, head: head}, func() { _ = cs.Close() }, nil
+}
+
+func openRepoStaterootAPI(ctx context.Context, path string) (staterootAPI, func(), error) {
+	fsrepo, err := repo.NewFS(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lkrepo, err := fsrepo.Lock(repo.FullNode)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	closeRepo := func() {
+		if err := lkrepo.Close(); err != nil {
+			log.Warnf("failed to close repo: %s", err)
+		}
+	}
+
+	bs, err := lkrepo.Blockstore(ctx, repo.UniversalBlockstore)
+	if err != nil {
+		closeRepo()
+		return nil, nil, xerrors.Errorf("failed to open blockstore: %w", err)
+	}
+
+	closeBs := func() {
+		if c, ok := bs.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				log.Warnf("failed to close blockstore: %s", err)
+			}
+		}
+	}
+
+	mds, err := lkrepo.Datastore(ctx, "/metadata")
+	if err != nil {
+		closeBs()
+		closeRepo()
+		return nil, nil, err
+	}
+
+	cs := store.NewChainStore(bs, bs, mds, filcns.Weight, nil)
+	closer := func() {
+		_ = cs.Close()
+		closeBs()
+		closeRepo()
+	}
+
+	if err := cs.Load(ctx); err != nil {
+		closer()
+		return nil, nil, xerrors.Errorf("loading chain store: %w", err)
+	}
+
+	return &offlineStaterootAPI{cs: cs, head: cs.GetHeaviestTipSet()}, closer, nil
+}
+
+// offlineStaterootAPI serves the stateroot commands from a local chain store.
+type offlineStaterootAPI struct {
+	cs   *store.ChainStore
+	head *types.TipSet
+}
+
+var _ staterootAPI = (*offlineStaterootAPI)(nil)
+
+func (o *offlineStaterootAPI) ChainHead(context.Context) (*types.TipSet, error) {
+	return o.head, nil
+}
+
+func (o *offlineStaterootAPI) ChainGetTipSet(ctx context.Context, tsk types.TipSetKey) (*types.TipSet, error) {
+	return o.cs.LoadTipSet(ctx, tsk)
+}
+
+func (o *offlineStaterootAPI) ChainGetTipSetByHeight(ctx context.Context, h abi.ChainEpoch, tsk types.TipSetKey) (*types.TipSet, error) {
+	ts := o.head
+	if tsk != types.EmptyTSK {
+		var err error
+		ts, err = o.cs.LoadTipSet(ctx, tsk)
+		if err != nil {
+			return nil, xerrors.Errorf("loading tipset %s: %w", tsk, err)
+		}
+	}
+
+	return o.cs.GetTipsetByHeight(ctx, h, ts, true)
+}
+
+func (o *offlineStaterootAPI) loadStateTree(ctx context.Context, tsk types.TipSetKey) (*state.StateTree, error) {
+	ts, err := o.ChainGetTipSet(ctx, tsk)
+	if err != nil {
+		return nil, xerrors.Errorf("loading tipset %s: %w", tsk, err)
+	}
+
+	return state.LoadStateTree(o.cs.ActorStore(ctx), ts.ParentState())
+}
+
+func (o *offlineStaterootAPI) StateListActors(ctx context.Context, tsk types.TipSetKey) ([]address.Address, error) {
+	st, err := o.loadStateTree(ctx, tsk)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []address.Address
+	err = st.ForEach(func(addr address.Address, act *types.Actor) error {
+		out = append(out, addr)
+		return nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("listing actors: %w", err)
+	}
+
+	return out, nil
+}
+
+func (o *offlineStaterootAPI) StateGetActor(ctx context.Context, actor address.Address, tsk types.TipSetKey) (*types.Actor, error) {
+	st, err := o.loadStateTree(ctx, tsk)
+	if err != nil {
+		return nil, err
+	}
+
+	return st.GetActor(actor)
+}
+
+// StateAccountKey resolves the address in the parent state of the tipset, it
+// doesn't compute tipset state like the full node implementation can.
+func (o *offlineStaterootAPI) StateAccountKey(ctx context.Context, addr address.Address, tsk types.TipSetKey) (address.Address, error) {
+	st, err := o.loadStateTree(ctx, tsk)
+	if err != nil {
+		return address.Undef, err
+	}
+
+	return vm.ResolveToDeterministicAddr(st, o.cs.ActorStore(ctx), addr)
+}
+
+func (o *offlineStaterootAPI) ChainReadObj(ctx context.Context, obj cid.Cid) ([]byte, error) {
+	blk, err := o.cs.StateBlockstore().Get(ctx, obj)
+	if err != nil {
+		return nil, xerrors.Errorf("reading object %s: %w", obj, err)
+	}
+
+	return blk.RawData(), nil
+}
+
+// ChainStatObj mirrors the full node implementation, walking the local blockstore.
+func (o *offlineStaterootAPI) ChainStatObj(ctx context.Context, obj cid.Cid, base cid.Cid) (api.ObjStat, error) {
+	bs := o.cs.StateBlockstore()
+	bsvc := blockservice.New(bs, offline.Exchange(bs))
+
+	dag := merkledag.NewDAGService(bsvc)
+
+	seen := cid.NewSet()
+
+	var statslk sync.Mutex
+	var stats api.ObjStat
+	var collect = true
+
+	walker := func(ctx context.Context, c cid.Cid) ([]*ipld.Link, error) {
+		if c.Prefix().Codec == cid.FilCommitmentSealed || c.Prefix().Codec == cid.FilCommitmentUnsealed {
+			return []*ipld.Link{}, nil
+		}
+
+		nd, err := dag.Get(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+
+		if collect {
+			s := uint64(len(nd.RawData()))
+			statslk.Lock()
+			stats.Size = stats.Size + s
+			stats.Links = stats.Links + 1
+			statslk.Unlock()
+		}
+
+		return nd.Links(), nil
+	}
+
+	if base != cid.Undef {
+		collect = false
+		if err := merkledag.Walk(ctx, walker, base, seen.Visit, merkledag.Concurrent()); err != nil {
+			return api.ObjStat{}, err
+		}
+		collect = true
+	}
+
+	if err := merkledag.Walk(ctx, walker, obj, seen.Visit, merkledag.Concurrent()); err != nil {
+		return api.ObjStat{}, err
+	}
+
+	return stats, nil
+}
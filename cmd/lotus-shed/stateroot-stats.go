@@ -1,25 +1,50 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"sort"
+	"strings"
 
+	"github.com/ipfs/boxo/ipld/merkledag"
+	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
 	"github.com/multiformats/go-multihash"
 	"github.com/urfave/cli/v2"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin"
 
 	"github.com/filecoin-project/lotus/api"
+	lbuiltin "github.com/filecoin-project/lotus/chain/actors/builtin"
 	"github.com/filecoin-project/lotus/chain/types"
 	lcli "github.com/filecoin-project/lotus/cli"
 )
 
 var staterootCmd = &cli.Command{
 	Name: "stateroot",
+	Flags: append([]cli.Flag{
+		&cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "fail the command if it doesn't complete within this time (0 = no timeout)",
+		},
+	}, staterootOfflineFlags...),
 	Subcommands: []*cli.Command{
 		staterootDiffsCmd,
 		staterootStatCmd,
+		staterootLargestObjCmd,
+		staterootTypeTrendCmd,
 	},
 }
 
@@ -36,66 +61,301 @@ var staterootDiffsCmd = &cli.Command{
 			Usage: "number of tipsets to count back",
 			Value: 30,
 		},
+		&cli.Int64Flag{
+			Name:  "start-height",
+			Usage: "start walking from the tipset at this height instead of --tipset",
+		},
+		&cli.Int64Flag{
+			Name:  "end-height",
+			Usage: "walk down to this height instead of walking back --count tipsets",
+		},
 		&cli.BoolFlag{
 			Name:  "diff",
 			Usage: "compare tipset with previous",
 			Value: false,
 		},
+		&cli.StringFlag{
+			Name:  "actor",
+			Usage: "follow the state of a single actor, printing its head and state size at each tipset",
+		},
+		&cli.BoolFlag{
+			Name:  "jsonl",
+			Usage: "print one JSON object per tipset instead of a table",
+		},
 	},
 	Action: func(cctx *cli.Context) error {
-		api, closer, err := lcli.GetFullNodeAPI(cctx)
+		api, closer, err := getStaterootAPI(cctx)
 		if err != nil {
 			return err
 		}
 
 		defer closer()
-		ctx := lcli.ReqContext(cctx)
+		ctx, cancel := staterootContext(cctx)
+		defer cancel()
 
 		ts, err := lcli.LoadTipSet(ctx, cctx, api)
 		if err != nil {
 			return err
 		}
 
-		fn := func(ts *types.TipSet) (cid.Cid, []cid.Cid) {
-			blk := ts.Blocks()[0]
-			strt := blk.ParentStateRoot
-			cids := blk.Parents
+		walk := staterootWalk{count: cctx.Int("count")}
+		if cctx.IsSet("end-height") {
+			walk.byHeight = true
+			walk.endHeight = abi.ChainEpoch(cctx.Int64("end-height"))
+		}
 
-			return strt, cids
+		var startHeight *abi.ChainEpoch
+		if cctx.IsSet("start-height") {
+			h := abi.ChainEpoch(cctx.Int64("start-height"))
+			startHeight = &h
+		}
+
+		ts, err = staterootWalkStart(ctx, api, ts, walk, startHeight)
+		if err != nil {
+			return err
 		}
 
-		count := cctx.Int("count")
 		diff := cctx.Bool("diff")
 
-		fmt.Printf("Height\tSize\tLinks\tObj\tBase\n")
-		for i := 0; i < count; i++ {
-			if ts.Height() == 0 {
-				return nil
+		if cctx.IsSet("actor") {
+			if cctx.Bool("jsonl") {
+				return xerrors.Errorf("--jsonl can't be used with --actor")
 			}
-			strt, cids := fn(ts)
 
-			k := types.NewTipSetKey(cids...)
-			ts, err = api.ChainGetTipSet(ctx, k)
+			addr, err := address.NewFromString(cctx.String("actor"))
 			if err != nil {
 				return err
 			}
 
-			pstrt, _ := fn(ts)
+			return staterootActorDiffs(ctx, cctx.App.Writer, api, ts, addr, walk, diff)
+		}
+
+		return staterootDiffs(ctx, cctx.App.Writer, api, ts, walk, diff, cctx.Bool("jsonl"))
+	},
+}
+
+// staterootContext returns the request context of a stateroot command, with
+// the --timeout deadline if one is set
+func staterootContext(cctx *cli.Context) (context.Context, context.CancelFunc) {
+	ctx := lcli.ReqContext(cctx)
+	if d := cctx.Duration("timeout"); d > 0 {
+		return context.WithTimeout(ctx, d)
+	}
+	return context.WithCancel(ctx)
+}
+
+// staterootWalk bounds how far down the chain the diffs command walks
+type staterootWalk struct {
+	// count is the number of tipsets to walk back, unless byHeight is set
+	count int
+
+	// byHeight makes the walk go down to endHeight instead
+	byHeight  bool
+	endHeight abi.ChainEpoch
+}
+
+// done returns true if the walk should stop at ts, after walking i tipsets
+func (w staterootWalk) done(i int, ts *types.TipSet) bool {
+	if ts.Height() == 0 {
+		return true
+	}
+	if w.byHeight {
+		return ts.Height() <= w.endHeight
+	}
+	return i >= w.count
+}
+
+// staterootWalkStart returns the tipset a walk starts from: ts, or the tipset at
+// startHeight below it if set. It errors if the walk down to the end height
+// would be empty.
+func staterootWalkStart(ctx context.Context, sapi staterootAPI, ts *types.TipSet, walk staterootWalk, startHeight *abi.ChainEpoch) (*types.TipSet, error) {
+	if startHeight != nil {
+		if walk.byHeight && *startHeight < walk.endHeight {
+			return nil, xerrors.Errorf("--start-height %d is below --end-height %d", *startHeight, walk.endHeight)
+		}
+
+		var err error
+		ts, err = sapi.ChainGetTipSetByHeight(ctx, *startHeight, ts.Key())
+		if err != nil {
+			return nil, xerrors.Errorf("getting tipset at start height: %w", err)
+		}
+	}
+
+	if walk.byHeight && ts.Height() <= walk.endHeight {
+		return nil, xerrors.Errorf("empty range: start height %d is not above --end-height %d", ts.Height(), walk.endHeight)
+	}
+
+	return ts, nil
+}
+
+// staterootDiffRow is a row of the diffs command output, as printed with --jsonl
+type staterootDiffRow struct {
+	Height abi.ChainEpoch `json:"height"`
+	Size   uint64         `json:"size"`
+	Links  uint64         `json:"links"`
+	Obj    string         `json:"obj"`
+	Base   string         `json:"base,omitempty"`
+}
+
+// staterootDiffs walks down the chain, printing stats of the state root of each
+// tipset, optionally diffed against the state root of its parent. Rows are
+// written as the walk goes, as a table or as JSON lines with jsonl set, so an
+// interrupted walk leaves the rows of the tipsets it got through.
+func staterootDiffs(ctx context.Context, w io.Writer, sapi staterootAPI, ts *types.TipSet, walk staterootWalk, diff, jsonl bool) error {
+	fn := func(ts *types.TipSet) (cid.Cid, []cid.Cid) {
+		blk := ts.Blocks()[0]
+		strt := blk.ParentStateRoot
+		cids := blk.Parents
+
+		return strt, cids
+	}
+
+	enc := json.NewEncoder(w)
+	if !jsonl {
+		_, _ = fmt.Fprintf(w, "Height\tSize\tLinks\tObj\tBase\n")
+	}
+	for i := 0; !walk.done(i, ts); i++ {
+		if err := ctx.Err(); err != nil {
+			return xerrors.Errorf("walk interrupted at height %d: %w", ts.Height(), err)
+		}
+
+		strt, cids := fn(ts)
+
+		k := types.NewTipSetKey(cids...)
+		var err error
+		ts, err = sapi.ChainGetTipSet(ctx, k)
+		if err != nil {
+			return err
+		}
+
+		pstrt, _ := fn(ts)
+
+		if !diff {
+			pstrt = cid.Undef
+		}
+
+		stats, err := sapi.ChainStatObj(ctx, strt, pstrt)
+		if err != nil {
+			return err
+		}
+
+		if !jsonl {
+			_, _ = fmt.Fprintf(w, "%d\t%d\t%d\t%s\t%s\n", ts.Height(), stats.Size, stats.Links, strt, pstrt)
+			continue
+		}
+
+		row := staterootDiffRow{Height: ts.Height(), Size: stats.Size, Links: stats.Links, Obj: strt.String()}
+		if pstrt.Defined() {
+			row.Base = pstrt.String()
+		}
+		if err := enc.Encode(row); err != nil {
+			return xerrors.Errorf("writing row: %w", err)
+		}
+		// let consumers see each row as soon as it's walked
+		if f, ok := w.(interface{ Flush() error }); ok {
+			if err := f.Flush(); err != nil {
+				return xerrors.Errorf("flushing row: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
 
-			if !diff {
-				pstrt = cid.Undef
+// staterootActorDiffs walks down the chain like the diffs command, printing the
+// state head and state size of a single actor at each tipset
+func staterootActorDiffs(ctx context.Context, w io.Writer, sapi staterootAPI, ts *types.TipSet, addr address.Address, walk staterootWalk, diff bool) error {
+	actorHead := func(ts *types.TipSet) (cid.Cid, bool, error) {
+		act, err := sapi.StateGetActor(ctx, addr, ts.Key())
+		if err != nil {
+			if strings.Contains(err.Error(), types.ErrActorNotFound.Error()) {
+				return cid.Undef, false, nil
 			}
+			return cid.Undef, false, xerrors.Errorf("loading actor %s at %d: %w", addr, ts.Height(), err)
+		}
+		return act.Head, true, nil
+	}
 
-			stats, err := api.ChainStatObj(ctx, strt, pstrt)
+	_, _ = fmt.Fprintf(w, "Height\tHead\tSize\tLinks\tBase\n")
+	for i := 0; !walk.done(i, ts); i++ {
+		if err := ctx.Err(); err != nil {
+			return xerrors.Errorf("walk interrupted at height %d: %w", ts.Height(), err)
+		}
+
+		head, found, err := actorHead(ts)
+		if err != nil {
+			return err
+		}
+
+		ts, err = sapi.ChainGetTipSet(ctx, ts.Parents())
+		if err != nil {
+			return err
+		}
+
+		base := cid.Undef
+		if diff {
+			phead, pfound, err := actorHead(ts)
 			if err != nil {
 				return err
 			}
+			if pfound {
+				base = phead
+			}
+		}
 
-			fmt.Printf("%d\t%d\t%d\t%s\t%s\n", ts.Height(), stats.Size, stats.Links, strt, pstrt)
+		if !found {
+			_, _ = fmt.Fprintf(w, "%d\t<not found>\n", ts.Height())
+			continue
 		}
 
-		return nil
-	},
+		stats, err := sapi.ChainStatObj(ctx, head, base)
+		if err != nil {
+			return err
+		}
+
+		_, _ = fmt.Fprintf(w, "%d\t%s\t%d\t%d\t%s\n", ts.Height(), head, stats.Size, stats.Links, base)
+	}
+
+	return nil
+}
+
+// readAddrsFile reads newline-separated addresses from a file, or stdin if path
+// is '-'. Empty lines are skipped.
+func readAddrsFile(path string) ([]address.Address, error) {
+	if path == "-" {
+		return readAddrs(os.Stdin)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, xerrors.Errorf("opening addresses file: %w", err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	return readAddrs(f)
+}
+
+func readAddrs(r io.Reader) ([]address.Address, error) {
+	var out []address.Address
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" {
+			continue
+		}
+
+		a, err := address.NewFromString(s)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing address on line %d: %w", line, err)
+		}
+		out = append(out, a)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, xerrors.Errorf("reading addresses: %w", err)
+	}
+
+	return out, nil
 }
 
 type statItem struct {
@@ -105,28 +365,95 @@ type statItem struct {
 }
 
 var staterootStatCmd = &cli.Command{
-	Name:  "stat",
-	Usage: "print statistics for the stateroot of a given block",
+	Name:      "stat",
+	Usage:     "print statistics for the stateroot of a given block",
+	ArgsUsage: "[actor addresses...]",
 	Flags: []cli.Flag{
 		&cli.StringFlag{
 			Name:  "tipset",
 			Usage: "specify tipset to start from",
 		},
+		&cli.StringFlag{
+			Name:  "addrs-file",
+			Usage: "read newline-separated actor addresses to stat from a file ('-' for stdin), in addition to the arguments",
+		},
+		&cli.BoolFlag{
+			Name:  "balance",
+			Usage: "also print the balance of each actor",
+		},
+		&cli.BoolFlag{
+			Name:  "attofil",
+			Usage: "print balances in attoFIL instead of FIL",
+		},
+		&cli.BoolFlag{
+			Name:  "resolve",
+			Usage: "also print the robust (key) address of each actor, blank for actors without one",
+		},
+		&cli.BoolFlag{
+			Name:  "exclude-system",
+			Usage: "leave built-in singleton actors (system, init, reward, cron, power, market, ...) out of actor stats",
+		},
+		&cli.StringFlag{
+			Name:  "explode",
+			Usage: "instead of actor stats, print the size of each object linked from the state head of the given actor",
+		},
+		&cli.StringFlag{
+			Name:  "compare",
+			Usage: "instead of actor stats, print the change in actor state sizes from the tipset to the given tipset",
+		},
+		&cli.IntFlag{
+			Name:        "top",
+			Usage:       "number of largest actors to print (0 = all)",
+			DefaultText: "10, or the number of given addresses if larger",
+		},
+		&cli.Uint64Flag{
+			Name:  "min-size",
+			Usage: "only print actors with a state of at least this many bytes",
+		},
+		&cli.BoolFlag{
+			Name:  "verbose-obj",
+			Usage: "also print the number of dag-cbor nodes, raw leaves and dag-pb nodes in the state of each printed actor, and their average size; walks these states once more",
+		},
+		&cli.BoolFlag{
+			Name:  "percentiles",
+			Usage: "also print the count, mean and p50/p90/p99 of the state sizes of all statted actors, regardless of --top and --min-size",
+		},
+		&cli.BoolFlag{
+			Name:  "treemap",
+			Usage: "instead of the stats table, print the state sizes of all statted actors as nested JSON grouped by actor type, for treemap tools such as d3",
+		},
+		&cli.StringFlag{
+			Name:  "prom-file",
+			Usage: "also write actor and total sizes as Prometheus metrics to this file, for the node_exporter textfile collector",
+		},
+		&cli.StringFlag{
+			Name:  "db",
+			Usage: "also record the printed actor sizes with the tipset height and timestamp in the stateroot_actor_stats table of this database, created if absent; a postgres:// URL or a sqlite file path",
+		},
 	},
 	Action: func(cctx *cli.Context) error {
-		api, closer, err := lcli.GetFullNodeAPI(cctx)
+		api, closer, err := getStaterootAPI(cctx)
 		if err != nil {
 			return err
 		}
 
 		defer closer()
-		ctx := lcli.ReqContext(cctx)
+		ctx, cancel := staterootContext(cctx)
+		defer cancel()
 
 		ts, err := lcli.LoadTipSet(ctx, cctx, api)
 		if err != nil {
 			return err
 		}
 
+		if cctx.IsSet("explode") {
+			addr, err := address.NewFromString(cctx.String("explode"))
+			if err != nil {
+				return err
+			}
+			return staterootExplode(ctx, cctx.App.Writer, api, ts, addr)
+		}
+
 		var addrs []address.Address
 
 		for _, inp := range cctx.Args().Slice() {
@@ -137,68 +464,824 @@ var staterootStatCmd = &cli.Command{
 			addrs = append(addrs, a)
 		}
 
-		if len(addrs) == 0 {
-			allActors, err := api.StateListActors(ctx, ts.Key())
+		if cctx.IsSet("addrs-file") {
+			fileAddrs, err := readAddrsFile(cctx.String("addrs-file"))
 			if err != nil {
 				return err
 			}
-			addrs = allActors
+			addrs = append(addrs, fileAddrs...)
 		}
 
-		var infos []statItem
-		for _, a := range addrs {
-			act, err := api.StateGetActor(ctx, a, ts.Key())
+		outcap := 10
+		if len(addrs) > outcap {
+			outcap = len(addrs)
+		}
+		if cctx.IsSet("top") {
+			outcap = cctx.Int("top")
+		}
+
+		if cctx.IsSet("compare") {
+			cmpTs, err := lcli.ParseTipSetRef(ctx, api, cctx.String("compare"))
 			if err != nil {
 				return err
 			}
+			return staterootCompare(ctx, cctx.App.Writer, api, ts, cmpTs, addrs, outcap)
+		}
+
+		return staterootStat(ctx, cctx.App.Writer, api, ts, addrs, staterootStatOpts{
+			outcap:        outcap,
+			minSize:       cctx.Uint64("min-size"),
+			balance:       cctx.Bool("balance"),
+			attoFIL:       cctx.Bool("attofil"),
+			resolve:       cctx.Bool("resolve"),
+			excludeSystem: cctx.Bool("exclude-system"),
+			verboseObj:    cctx.Bool("verbose-obj"),
+			percentiles:   cctx.Bool("percentiles"),
+			treemap:       cctx.Bool("treemap"),
+			promFile:      cctx.String("prom-file"),
+			dbDSN:         cctx.String("db"),
+		})
+	},
+}
+
+// systemActorAddrs are the addresses of built-in singleton actors
+var systemActorAddrs = map[address.Address]struct{}{
+	builtin.SystemActorAddr:                 {},
+	builtin.InitActorAddr:                   {},
+	builtin.RewardActorAddr:                 {},
+	builtin.CronActorAddr:                   {},
+	builtin.StoragePowerActorAddr:           {},
+	builtin.StorageMarketActorAddr:          {},
+	builtin.VerifiedRegistryActorAddr:       {},
+	builtin.DatacapActorAddr:                {},
+	builtin.EthereumAddressManagerActorAddr: {},
+	builtin.BurntFundsActorAddr:             {},
+}
+
+// staterootStatOpts are the output options of staterootStat
+type staterootStatOpts struct {
+	// outcap is the number of largest actors printed, 0 for all
+	outcap int
+	// minSize leaves actors with a smaller state out of the printed actors
+	minSize uint64
+	// balance prints actor balances in FIL, or attoFIL if attoFIL is set
+	balance, attoFIL bool
+	// resolve prints the key address of each actor next to its address
+	resolve bool
+	// excludeSystem leaves built-in singleton actors out of the actor stats
+	// and sums, the state tree totals still cover them
+	excludeSystem bool
+	// verboseObj prints the codec breakdown of the state of each printed
+	// actor, see staterootCodecStat
+	verboseObj bool
+	// percentiles prints the distribution of the state sizes of all statted
+	// actors, see statSizeDistribution
+	percentiles bool
+	// treemap prints the state sizes of all statted actors as nested JSON
+	// instead of the stats table, see statTreemap
+	treemap bool
+	// promFile, if set, is where the printed stats are also written as
+	// Prometheus metrics
+	promFile string
+	// dbDSN, if set, is the database the printed actor stats are recorded in,
+	// see writeStaterootDB
+	dbDSN string
+}
+
+// staterootStat prints the total stateroot stats, and stats of the largest
+// actors out of addrs (or all actors if addrs is empty), as selected by opts.
+// It stops with an error as soon as ctx is cancelled.
+func staterootStat(ctx context.Context, w io.Writer, sapi staterootAPI, ts *types.TipSet, addrs []address.Address, opts staterootStatOpts) error {
+	if len(addrs) == 0 {
+		allActors, err := sapi.StateListActors(ctx, ts.Key())
+		if err != nil {
+			return err
+		}
+		addrs = allActors
+	}
+
+	if opts.excludeSystem {
+		var filtered []address.Address
+		for _, a := range addrs {
+			if _, ok := systemActorAddrs[a]; !ok {
+				filtered = append(filtered, a)
+			}
+		}
+		addrs = filtered
+	}
+
+	var infos []statItem
+	for i, a := range addrs {
+		if err := ctx.Err(); err != nil {
+			return xerrors.Errorf("stat interrupted after %d of %d actors: %w", i, len(addrs), err)
+		}
+
+		act, err := sapi.StateGetActor(ctx, a, ts.Key())
+		if err != nil {
+			return err
+		}
+
+		stat, err := sapi.ChainStatObj(ctx, act.Head, cid.Undef)
+		if err != nil {
+			return err
+		}
+
+		infos = append(infos, statItem{
+			Addr:  a,
+			Actor: act,
+			Stat:  stat,
+		})
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Stat.Size > infos[j].Stat.Size
+	})
+
+	var totalActorsSize, totalActorsLinks uint64
+	for _, info := range infos {
+		totalActorsSize += info.Stat.Size
+		totalActorsLinks += info.Stat.Links
+	}
+
+	top := infos
+	if opts.outcap > 0 && len(top) > opts.outcap {
+		top = top[:opts.outcap]
+	}
+	for len(top) > 0 && top[len(top)-1].Stat.Size < opts.minSize {
+		top = top[:len(top)-1]
+	}
+
+	totalStat, err := sapi.ChainStatObj(ctx, ts.ParentState(), cid.Undef)
+	if err != nil {
+		return err
+	}
+
+	if opts.treemap {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(statTreemap(infos)); err != nil {
+			return err
+		}
+
+		return staterootStatExport(ctx, opts, ts, totalStat, totalActorsSize, top)
+	}
+
+	_, _ = fmt.Fprintln(w, "Total state tree size: ", totalStat.Size)
+	_, _ = fmt.Fprintln(w, "Total state tree links: ", totalStat.Links)
+	_, _ = fmt.Fprintln(w, "Sum of actor state size: ", totalActorsSize)
+	_, _ = fmt.Fprintln(w, "Sum of actor state links: ", totalActorsLinks)
+	if !opts.excludeSystem {
+		// with system actors excluded the sums don't cover the whole tree
+		_, _ = fmt.Fprintln(w, "State tree structure size: ", totalStat.Size-totalActorsSize)
+	}
+	if opts.percentiles {
+		d := statSizeDistribution(infos)
+		_, _ = fmt.Fprintln(w, "Actor state size count: ", d.Count)
+		_, _ = fmt.Fprintf(w, "Actor state size mean: %.1f\n", d.Mean)
+		_, _ = fmt.Fprintln(w, "Actor state size p50: ", d.P50)
+		_, _ = fmt.Fprintln(w, "Actor state size p90: ", d.P90)
+		_, _ = fmt.Fprintln(w, "Actor state size p99: ", d.P99)
+	}
+
+	header := "Addr"
+	if opts.resolve {
+		header += "\tKey"
+	}
+	header += "\tType\tSize"
+	if opts.balance {
+		header += "\tBalance"
+	}
+	if opts.verboseObj {
+		header += "\tCBOR Nodes\tRaw Leaves\tPB Nodes\tAvg Node Size"
+	}
+	_, _ = fmt.Fprintln(w, header)
+
+	for _, inf := range top {
+		cmh, err := multihash.Decode(inf.Actor.Code.Hash())
+		if err != nil {
+			return err
+		}
+
+		_, _ = fmt.Fprint(w, inf.Addr)
+		if opts.resolve {
+			// actors other than accounts and actors with delegated addresses
+			// have no key address
+			key := ""
+			if ka, err := sapi.StateAccountKey(ctx, inf.Addr, ts.Key()); err == nil {
+				key = ka.String()
+			}
+			_, _ = fmt.Fprintf(w, "\t%s", key)
+		}
+		_, _ = fmt.Fprintf(w, "\t%x\t%d", cmh.Digest, inf.Stat.Size)
 
-			stat, err := api.ChainStatObj(ctx, act.Head, cid.Undef)
+		if opts.balance {
+			bal := types.FIL(inf.Actor.Balance).String()
+			if opts.attoFIL {
+				bal = inf.Actor.Balance.String()
+			}
+			_, _ = fmt.Fprintf(w, "\t%s", bal)
+		}
+		if opts.verboseObj {
+			cs, err := staterootCodecStat(ctx, sapi, inf.Actor.Head)
 			if err != nil {
-				return err
+				return xerrors.Errorf("walking state of %s: %w", inf.Addr, err)
 			}
+			_, _ = fmt.Fprintf(w, "\t%d\t%d\t%d\t%d", cs.CBORNodes, cs.RawLeaves, cs.PBNodes, cs.avgNodeSize())
+		}
+		_, _ = fmt.Fprintln(w)
+	}
 
-			infos = append(infos, statItem{
-				Addr:  a,
-				Actor: act,
-				Stat:  stat,
-			})
+	return staterootStatExport(ctx, opts, ts, totalStat, totalActorsSize, top)
+}
+
+// staterootStatExport writes the printed stats to the Prometheus textfile and
+// database selected by opts
+func staterootStatExport(ctx context.Context, opts staterootStatOpts, ts *types.TipSet, totalStat api.ObjStat, totalActorsSize uint64, top []statItem) error {
+	if opts.promFile != "" {
+		if err := writeStaterootPromFile(opts.promFile, ts, totalStat, totalActorsSize, top); err != nil {
+			return err
+		}
+	}
+	if opts.dbDSN != "" {
+		if err := writeStaterootDB(ctx, opts.dbDSN, ts, top); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sizeDistribution summarizes actor state sizes
+type sizeDistribution struct {
+	Count         int
+	Mean          float64
+	P50, P90, P99 uint64
+}
+
+// statSizeDistribution returns the count, mean and nearest-rank percentiles of
+// the state sizes of infos
+func statSizeDistribution(infos []statItem) sizeDistribution {
+	if len(infos) == 0 {
+		return sizeDistribution{}
+	}
+
+	sizes := make([]uint64, len(infos))
+	var sum float64
+	for i, info := range infos {
+		sizes[i] = info.Stat.Size
+		sum += float64(info.Stat.Size)
+	}
+	sort.Slice(sizes, func(i, j int) bool {
+		return sizes[i] < sizes[j]
+	})
+
+	percentile := func(p int) uint64 {
+		// smallest size with at least p% of sizes at or below it
+		rank := (p*len(sizes) + 99) / 100
+		if rank < 1 {
+			rank = 1
+		}
+		return sizes[rank-1]
+	}
+
+	return sizeDistribution{
+		Count: len(sizes),
+		Mean:  sum / float64(len(sizes)),
+		P50:   percentile(50),
+		P90:   percentile(90),
+		P99:   percentile(99),
+	}
+}
+
+// treemapNode is a node of the --treemap output: the root, an actor type, or
+// an actor. Size is the state size of an actor, or the sum of the sizes of
+// the children; treemap tools should sum leaf sizes only, e.g. in d3 with
+// hierarchy.sum(d => d.children ? 0 : d.size).
+type treemapNode struct {
+	Name     string         `json:"name"`
+	Size     uint64         `json:"size"`
+	Children []*treemapNode `json:"children,omitempty"`
+}
+
+// statTreemap builds the actor type -> actor -> state size hierarchy of
+// infos. Types and actors are ordered by size, largest first.
+func statTreemap(infos []statItem) *treemapNode {
+	root := &treemapNode{Name: "actors"}
+	byType := map[string]*treemapNode{}
+
+	for _, info := range infos {
+		name := lbuiltin.ActorNameByCode(info.Actor.Code)
+
+		typ, ok := byType[name]
+		if !ok {
+			typ = &treemapNode{Name: name}
+			byType[name] = typ
+			root.Children = append(root.Children, typ)
+		}
+
+		typ.Children = append(typ.Children, &treemapNode{Name: info.Addr.String(), Size: info.Stat.Size})
+		typ.Size += info.Stat.Size
+		root.Size += info.Stat.Size
+	}
+
+	for _, typ := range root.Children {
+		sort.SliceStable(typ.Children, func(i, j int) bool {
+			return typ.Children[i].Size > typ.Children[j].Size
+		})
+	}
+	sort.SliceStable(root.Children, func(i, j int) bool {
+		return root.Children[i].Size > root.Children[j].Size
+	})
+
+	return root
+}
+
+// promLabelEscaper escapes Prometheus text format label values
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeStaterootPromFile writes stateroot stats as Prometheus metrics in the
+// text exposition format. The file is written next to path and renamed over
+// it, so that collectors never read a partially written file.
+func writeStaterootPromFile(path string, ts *types.TipSet, totalStat api.ObjStat, totalActorsSize uint64, actors []statItem) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return xerrors.Errorf("creating prometheus textfile: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	bw := bufio.NewWriter(tmp)
+	gauge := func(name, help string) {
+		_, _ = fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+
+	gauge("lotus_stateroot_height", "Height of the tipset the stateroot stats are for.")
+	_, _ = fmt.Fprintf(bw, "lotus_stateroot_height %d\n", ts.Height())
+	gauge("lotus_stateroot_size_bytes", "Total size of the state tree in bytes.")
+	_, _ = fmt.Fprintf(bw, "lotus_stateroot_size_bytes %d\n", totalStat.Size)
+	gauge("lotus_stateroot_links", "Total number of links in the state tree.")
+	_, _ = fmt.Fprintf(bw, "lotus_stateroot_links %d\n", totalStat.Links)
+	gauge("lotus_stateroot_actors_size_bytes", "Sum of the state sizes of the selected actors in bytes.")
+	_, _ = fmt.Fprintf(bw, "lotus_stateroot_actors_size_bytes %d\n", totalActorsSize)
+
+	gauge("lotus_stateroot_actor_size_bytes", "Size of the state of an actor in bytes.")
+	for _, inf := range actors {
+		_, _ = fmt.Fprintf(bw, "lotus_stateroot_actor_size_bytes{addr=\"%s\",type=\"%s\"} %d\n",
+			promLabelEscaper.Replace(inf.Addr.String()), promLabelEscaper.Replace(lbuiltin.ActorNameByCode(inf.Actor.Code)), inf.Stat.Size)
+	}
+
+	if err := bw.Flush(); err != nil {
+		_ = tmp.Close()
+		return xerrors.Errorf("writing prometheus textfile: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return xerrors.Errorf("closing prometheus textfile: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return xerrors.Errorf("replacing prometheus textfile: %w", err)
+	}
+	return nil
+}
+
+type explodeItem struct {
+	Path string
+	Link cid.Cid
+	Stat api.ObjStat
+}
+
+// staterootExplode decodes the state head of an actor one level and prints the
+// stats of each object linked from it, by path within the head block. Objects
+// linked from more than one field are counted in each of them.
+func staterootExplode(ctx context.Context, w io.Writer, sapi staterootAPI, ts *types.TipSet, addr address.Address) error {
+	act, err := sapi.StateGetActor(ctx, addr, ts.Key())
+	if err != nil {
+		return err
+	}
+
+	raw, err := sapi.ChainReadObj(ctx, act.Head)
+	if err != nil {
+		return err
+	}
+
+	blk, err := blocks.NewBlockWithCid(raw, act.Head)
+	if err != nil {
+		return err
+	}
+
+	nd, err := cbor.DecodeBlock(blk)
+	if err != nil {
+		return xerrors.Errorf("decoding state head of %s: %w", addr, err)
+	}
+
+	var items []explodeItem
+	for _, path := range nd.Tree("", -1) {
+		lnk, rest, err := nd.ResolveLink(strings.Split(path, "/"))
+		if err != nil || len(rest) > 0 {
+			// not a link
+			continue
 		}
 
-		sort.Slice(infos, func(i, j int) bool {
-			return infos[i].Stat.Size > infos[j].Stat.Size
+		stat, err := sapi.ChainStatObj(ctx, lnk.Cid, cid.Undef)
+		if err != nil {
+			return err
+		}
+
+		items = append(items, explodeItem{
+			Path: path,
+			Link: lnk.Cid,
+			Stat: stat,
 		})
+	}
 
-		var totalActorsSize uint64
-		for _, info := range infos {
-			totalActorsSize += info.Stat.Size
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Stat.Size != items[j].Stat.Size {
+			return items[i].Stat.Size > items[j].Stat.Size
 		}
+		return items[i].Path < items[j].Path
+	})
 
-		outcap := 10
-		if cctx.NArg() > outcap {
-			outcap = cctx.NArg()
+	totalStat, err := sapi.ChainStatObj(ctx, act.Head, cid.Undef)
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintln(w, "Actor state size: ", totalStat.Size)
+	_, _ = fmt.Fprintln(w, "Actor state links: ", totalStat.Links)
+	_, _ = fmt.Fprintln(w, "State head size: ", len(raw))
+
+	_, _ = fmt.Fprint(w, "Field\tSize\tLinks\tCid\n")
+	for _, item := range items {
+		_, _ = fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", item.Path, item.Stat.Size, item.Stat.Links, item.Link)
+	}
+	return nil
+}
+
+var staterootLargestObjCmd = &cli.Command{
+	Name:      "largest-obj",
+	Usage:     "find the largest single object in the state of an actor",
+	ArgsUsage: "<actor address>",
+	Description: `Walks the state DAG of the actor and prints the CID, size and path of the
+largest individual object, e.g. an oversized HAMT bucket. The path lists the
+fields and link names leading to the object from the state head.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "tipset",
+			Usage: "specify tipset to start from",
+		},
+		&cli.IntFlag{
+			Name:  "max-objects",
+			Usage: "stop the walk after this many objects (0 = no limit)",
+			Value: 1_000_000,
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return lcli.IncorrectNumArgs(cctx)
 		}
-		if len(infos) < outcap {
-			outcap = len(infos)
+
+		addr, err := address.NewFromString(cctx.Args().First())
+		if err != nil {
+			return err
 		}
 
-		totalStat, err := api.ChainStatObj(ctx, ts.ParentState(), cid.Undef)
+		api, closer, err := getStaterootAPI(cctx)
 		if err != nil {
 			return err
 		}
 
-		fmt.Println("Total state tree size: ", totalStat.Size)
-		fmt.Println("Sum of actor state size: ", totalActorsSize)
-		fmt.Println("State tree structure size: ", totalStat.Size-totalActorsSize)
+		defer closer()
+		ctx, cancel := staterootContext(cctx)
+		defer cancel()
 
-		fmt.Print("Addr\tType\tSize\n")
-		for _, inf := range infos[:outcap] {
-			cmh, err := multihash.Decode(inf.Actor.Code.Hash())
-			if err != nil {
-				return err
-			}
+		ts, err := lcli.LoadTipSet(ctx, cctx, api)
+		if err != nil {
+			return err
+		}
 
-			fmt.Printf("%s\t%x\t%d\n", inf.Addr, cmh.Digest, inf.Stat.Size)
+		act, err := api.StateGetActor(ctx, addr, ts.Key())
+		if err != nil {
+			return err
+		}
+
+		lo, err := staterootLargestObj(ctx, api, act.Head, cctx.Int("max-objects"))
+		if err != nil {
+			return err
+		}
+
+		w := cctx.App.Writer
+		_, _ = fmt.Fprintln(w, "Objects walked: ", lo.Walked)
+		_, _ = fmt.Fprintln(w, "Largest object: ", lo.Cid)
+		_, _ = fmt.Fprintln(w, "Size: ", lo.Size)
+		_, _ = fmt.Fprintln(w, "Path: ", lo.Path)
+		if lo.Truncated {
+			_, _ = fmt.Fprintln(w, "Walk stopped at --max-objects, larger objects may exist")
 		}
 		return nil
 	},
 }
+
+// largestObj is the largest object found in a DAG by staterootLargestObj
+type largestObj struct {
+	Cid  cid.Cid
+	Size uint64
+	// Path is the path of the object from the DAG root, "/" for the root
+	Path string
+
+	// Walked is the number of objects walked, Truncated is set if the walk
+	// stopped at the object limit
+	Walked    int
+	Truncated bool
+}
+
+// staterootLargestObj walks the DAG under obj breadth-first, visiting each
+// object once, and returns the largest object by serialized size. Objects
+// shared by several parents are reported with the shortest path to them. The
+// walk stops after maxObjects objects if maxObjects is positive.
+func staterootLargestObj(ctx context.Context, sapi staterootAPI, obj cid.Cid, maxObjects int) (largestObj, error) {
+	type queued struct {
+		c    cid.Cid
+		path string
+	}
+
+	var out largestObj
+
+	seen := cid.NewSet()
+	queue := []queued{{c: obj, path: ""}}
+	for len(queue) > 0 {
+		if err := ctx.Err(); err != nil {
+			return largestObj{}, err
+		}
+
+		q := queue[0]
+		queue = queue[1:]
+
+		codec := q.c.Prefix().Codec
+		if codec == cid.FilCommitmentSealed || codec == cid.FilCommitmentUnsealed || q.c.Prefix().MhType == multihash.IDENTITY {
+			continue
+		}
+		if !seen.Visit(q.c) {
+			continue
+		}
+
+		if maxObjects > 0 && out.Walked >= maxObjects {
+			out.Truncated = true
+			break
+		}
+		out.Walked++
+
+		raw, err := sapi.ChainReadObj(ctx, q.c)
+		if err != nil {
+			return largestObj{}, err
+		}
+
+		if uint64(len(raw)) > out.Size || !out.Cid.Defined() {
+			out.Cid = q.c
+			out.Size = uint64(len(raw))
+			out.Path = q.path
+		}
+
+		switch codec {
+		case cid.DagCBOR:
+			blk, err := blocks.NewBlockWithCid(raw, q.c)
+			if err != nil {
+				return largestObj{}, err
+			}
+			nd, err := cbor.DecodeBlock(blk)
+			if err != nil {
+				return largestObj{}, xerrors.Errorf("decoding %s: %w", q.c, err)
+			}
+			for _, field := range nd.Tree("", -1) {
+				lnk, rest, err := nd.ResolveLink(strings.Split(field, "/"))
+				if err != nil || len(rest) > 0 {
+					// not a link
+					continue
+				}
+				queue = append(queue, queued{c: lnk.Cid, path: q.path + "/" + field})
+			}
+		case cid.DagProtobuf:
+			nd, err := merkledag.DecodeProtobuf(raw)
+			if err != nil {
+				return largestObj{}, xerrors.Errorf("decoding %s: %w", q.c, err)
+			}
+			for i, l := range nd.Links() {
+				name := l.Name
+				if name == "" {
+					name = fmt.Sprint(i)
+				}
+				queue = append(queue, queued{c: l.Cid, path: q.path + "/" + name})
+			}
+		}
+	}
+
+	if out.Path == "" {
+		out.Path = "/"
+	}
+	return out, nil
+}
+
+// codecStat breaks the objects of a DAG down by codec
+type codecStat struct {
+	CBORNodes  uint64
+	RawLeaves  uint64
+	PBNodes    uint64
+	OtherNodes uint64
+	// Size is the total size of all objects
+	Size uint64
+}
+
+func (cs codecStat) avgNodeSize() uint64 {
+	n := cs.CBORNodes + cs.RawLeaves + cs.PBNodes + cs.OtherNodes
+	if n == 0 {
+		return 0
+	}
+	return cs.Size / n
+}
+
+// staterootCodecStat walks the DAG under obj like ChainStatObj does, counting
+// each unique object once, and classifies the objects by codec. Links of
+// objects with codecs other than dag-cbor and dag-pb aren't followed, and
+// inline (identity hashed) objects aren't counted.
+func staterootCodecStat(ctx context.Context, sapi staterootAPI, obj cid.Cid) (codecStat, error) {
+	var cs codecStat
+
+	seen := cid.NewSet()
+	queue := []cid.Cid{obj}
+	for len(queue) > 0 {
+		if err := ctx.Err(); err != nil {
+			return codecStat{}, err
+		}
+
+		c := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+
+		codec := c.Prefix().Codec
+		if codec == cid.FilCommitmentSealed || codec == cid.FilCommitmentUnsealed || c.Prefix().MhType == multihash.IDENTITY {
+			continue
+		}
+		if !seen.Visit(c) {
+			continue
+		}
+
+		raw, err := sapi.ChainReadObj(ctx, c)
+		if err != nil {
+			return codecStat{}, err
+		}
+		cs.Size += uint64(len(raw))
+
+		switch codec {
+		case cid.DagCBOR:
+			cs.CBORNodes++
+			err = cbg.ScanForLinks(bytes.NewReader(raw), func(l cid.Cid) {
+				queue = append(queue, l)
+			})
+			if err != nil {
+				return codecStat{}, xerrors.Errorf("scanning links of %s: %w", c, err)
+			}
+		case cid.DagProtobuf:
+			cs.PBNodes++
+			nd, err := merkledag.DecodeProtobuf(raw)
+			if err != nil {
+				return codecStat{}, xerrors.Errorf("decoding %s: %w", c, err)
+			}
+			for _, l := range nd.Links() {
+				queue = append(queue, l.Cid)
+			}
+		case cid.Raw:
+			cs.RawLeaves++
+		default:
+			cs.OtherNodes++
+		}
+	}
+
+	return cs, nil
+}
+
+// staterootActorSizes returns the state size of each of addrs (or all actors if
+// addrs is empty) in the tipset. Actors which don't exist in the tipset are
+// left out.
+func staterootActorSizes(ctx context.Context, sapi staterootAPI, ts *types.TipSet, addrs []address.Address) (map[address.Address]uint64, error) {
+	if len(addrs) == 0 {
+		allActors, err := sapi.StateListActors(ctx, ts.Key())
+		if err != nil {
+			return nil, err
+		}
+		addrs = allActors
+	}
+
+	sizes := make(map[address.Address]uint64, len(addrs))
+	for i, a := range addrs {
+		if err := ctx.Err(); err != nil {
+			return nil, xerrors.Errorf("stat interrupted after %d of %d actors: %w", i, len(addrs), err)
+		}
+
+		act, err := sapi.StateGetActor(ctx, a, ts.Key())
+		if errors.Is(err, types.ErrActorNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		stat, err := sapi.ChainStatObj(ctx, act.Head, cid.Undef)
+		if err != nil {
+			return nil, err
+		}
+
+		sizes[a] = stat.Size
+	}
+
+	return sizes, nil
+}
+
+type compareItem struct {
+	Addr address.Address
+	// Base and Cmp are the state sizes in each tipset, nil if the actor
+	// doesn't exist in it
+	Base, Cmp *uint64
+	Delta     int64
+}
+
+// staterootCompare prints the outcap actors out of addrs (or all actors if addrs
+// is empty) whose state size changed the most between the base and cmp
+// tipsets. Actors existing in only one of the tipsets count as having size 0 in
+// the other one.
+func staterootCompare(ctx context.Context, w io.Writer, sapi staterootAPI, base, cmp *types.TipSet, addrs []address.Address, outcap int) error {
+	baseSizes, err := staterootActorSizes(ctx, sapi, base, addrs)
+	if err != nil {
+		return xerrors.Errorf("stat base tipset: %w", err)
+	}
+
+	cmpSizes, err := staterootActorSizes(ctx, sapi, cmp, addrs)
+	if err != nil {
+		return xerrors.Errorf("stat compared tipset: %w", err)
+	}
+
+	items := map[address.Address]*compareItem{}
+	item := func(a address.Address) *compareItem {
+		if items[a] == nil {
+			items[a] = &compareItem{Addr: a}
+		}
+		return items[a]
+	}
+
+	var onlyBase, onlyCmp int
+	for a, size := range baseSizes {
+		size := size
+		it := item(a)
+		it.Base = &size
+		it.Delta -= int64(size)
+		if _, ok := cmpSizes[a]; !ok {
+			onlyBase++
+		}
+	}
+	for a, size := range cmpSizes {
+		size := size
+		it := item(a)
+		it.Cmp = &size
+		it.Delta += int64(size)
+		if _, ok := baseSizes[a]; !ok {
+			onlyCmp++
+		}
+	}
+
+	sorted := make([]*compareItem, 0, len(items))
+	var totalDelta int64
+	for _, it := range items {
+		sorted = append(sorted, it)
+		totalDelta += it.Delta
+	}
+
+	abs := func(v int64) int64 {
+		if v < 0 {
+			return -v
+		}
+		return v
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if di, dj := abs(sorted[i].Delta), abs(sorted[j].Delta); di != dj {
+			return di > dj
+		}
+		return sorted[i].Addr.String() < sorted[j].Addr.String()
+	})
+
+	if len(sorted) < outcap {
+		outcap = len(sorted)
+	}
+
+	size := func(s *uint64) string {
+		if s == nil {
+			return "-"
+		}
+		return fmt.Sprint(*s)
+	}
+
+	_, _ = fmt.Fprintln(w, "Sum of actor state size change: ", totalDelta)
+	_, _ = fmt.Fprintln(w, "Actors only in base tipset: ", onlyBase)
+	_, _ = fmt.Fprintln(w, "Actors only in compared tipset: ", onlyCmp)
+
+	_, _ = fmt.Fprint(w, "Addr\tBase\tCompare\tDelta\n")
+	for _, it := range sorted[:outcap] {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%+d\n", it.Addr, size(it.Base), size(it.Cmp), it.Delta)
+	}
+	return nil
+}
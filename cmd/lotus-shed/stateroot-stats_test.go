@@ -0,0 +1,1240 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	actorstypes "github.com/filecoin-project/go-state-types/actors"
+	"github.com/filecoin-project/go-state-types/builtin"
+	builtin0 "github.com/filecoin-project/specs-actors/actors/builtin"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/actors/adt"
+	lbuiltin "github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/account"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/market"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/mock"
+)
+
+// makeStaterootCar builds a small CAR containing a single block header whose
+// parent state tree holds the given number of actors.
+func makeStaterootCar(t *testing.T, actors int) ([]byte, *types.BlockHeader, []address.Address) {
+	var addrs []address.Address
+	for i := 0; i < actors; i++ {
+		addrs = append(addrs, mock.Address(uint64(1000+i)))
+	}
+	carBytes, blk := makeStaterootCarWith(t, addrs)
+	return carBytes, blk, addrs
+}
+
+// makeStaterootCarWith is makeStaterootCar with the given actor addresses
+func makeStaterootCarWith(t *testing.T, addrs []address.Address) ([]byte, *types.BlockHeader) {
+	ctx := context.Background()
+
+	bs := blockstore.NewMemory()
+	cst := cbor.NewCborStore(bs)
+
+	st, err := state.NewStateTree(cst, types.StateTreeVersion5)
+	require.NoError(t, err)
+
+	for i, addr := range addrs {
+		head, err := cst.Put(ctx, mock.UnsignedMessage(addr, addr, uint64(i)))
+		require.NoError(t, err)
+
+		require.NoError(t, st.SetActor(addr, &types.Actor{
+			Code:    head,
+			Head:    head,
+			Balance: types.NewInt(uint64(i)),
+		}))
+	}
+
+	root, err := st.Flush(ctx)
+	require.NoError(t, err)
+
+	blk := mock.MkBlock(nil, 1, 1)
+	blk.ParentStateRoot = root
+
+	sblk, err := blk.ToStorageBlock()
+	require.NoError(t, err)
+	require.NoError(t, bs.Put(ctx, sblk))
+
+	return writeStaterootCar(t, bs, blk.Cid()), blk
+}
+
+// writeStaterootCar writes all blocks of the blockstore to a CAR with the given root
+func writeStaterootCar(t *testing.T, bs blockstore.MemBlockstore, root cid.Cid) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, car.WriteHeader(&car.CarHeader{
+		Roots:   []cid.Cid{root},
+		Version: 1,
+	}, &buf))
+
+	for _, b := range bs {
+		require.NoError(t, carutil.LdWrite(&buf, b.Cid().Bytes(), b.RawData()))
+	}
+
+	return buf.Bytes()
+}
+
+func TestStaterootOfflineCar(t *testing.T) {
+	ctx := context.Background()
+
+	carBytes, blk, addrs := makeStaterootCar(t, 5)
+
+	sapi, closer, err := loadCarStaterootAPI(ctx, bytes.NewReader(carBytes))
+	require.NoError(t, err)
+	defer closer()
+
+	head, err := sapi.ChainHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, types.NewTipSetKey(blk.Cid()), head.Key())
+	require.Equal(t, blk.ParentStateRoot, head.ParentState())
+
+	listed, err := sapi.StateListActors(ctx, head.Key())
+	require.NoError(t, err)
+	require.ElementsMatch(t, addrs, listed)
+
+	for _, addr := range addrs {
+		act, err := sapi.StateGetActor(ctx, addr, head.Key())
+		require.NoError(t, err)
+
+		stat, err := sapi.ChainStatObj(ctx, act.Head, cid.Undef)
+		require.NoError(t, err)
+		require.EqualValues(t, 1, stat.Links)
+		require.NotZero(t, stat.Size)
+	}
+
+	total, err := sapi.ChainStatObj(ctx, head.ParentState(), cid.Undef)
+	require.NoError(t, err)
+	require.Greater(t, total.Links, uint64(len(addrs)))
+}
+
+func TestStaterootActorDiffs(t *testing.T) {
+	ctx := context.Background()
+
+	bs := blockstore.NewMemory()
+	cst := cbor.NewCborStore(bs)
+
+	addr := mock.Address(1000)
+
+	// state of the actor at each height, the actor doesn't exist before height 2
+	var heads []cid.Cid
+	var parent *types.TipSet
+	for h := 0; h < 4; h++ {
+		st, err := state.NewStateTree(cst, types.StateTreeVersion5)
+		require.NoError(t, err)
+
+		head := cid.Undef
+		if h >= 2 {
+			head, err = cst.Put(ctx, mock.UnsignedMessage(addr, addr, uint64(h)))
+			require.NoError(t, err)
+
+			require.NoError(t, st.SetActor(addr, &types.Actor{Code: head, Head: head, Balance: types.NewInt(0)}))
+		}
+		heads = append(heads, head)
+
+		root, err := st.Flush(ctx)
+		require.NoError(t, err)
+
+		blk := mock.MkBlock(parent, 1, uint64(h))
+		blk.ParentStateRoot = root
+
+		sblk, err := blk.ToStorageBlock()
+		require.NoError(t, err)
+		require.NoError(t, bs.Put(ctx, sblk))
+
+		parent = mock.TipSet(blk)
+	}
+
+	sapi, closer, err := loadCarStaterootAPI(ctx, bytes.NewReader(writeStaterootCar(t, bs, parent.Cids()[0])))
+	require.NoError(t, err)
+	defer closer()
+
+	head, err := sapi.ChainHead(ctx)
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, staterootActorDiffs(ctx, &out, sapi, head, addr, staterootWalk{count: 10}, true))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 4) // header and one line per walked tipset
+
+	row := func(line string) []string {
+		return strings.Split(line, "\t")
+	}
+
+	// height 2 shows the state of the block at height 3, diffed against height 2
+	require.Equal(t, "2", row(lines[1])[0])
+	require.Equal(t, heads[3].String(), row(lines[1])[1])
+	require.Equal(t, heads[2].String(), row(lines[1])[4])
+
+	// the actor was created in the state of height 2, there is nothing to diff against
+	require.Equal(t, "1", row(lines[2])[0])
+	require.Equal(t, heads[2].String(), row(lines[2])[1])
+	require.NotEqual(t, "0", row(lines[2])[2])
+	require.Equal(t, cid.Undef.String(), row(lines[2])[4])
+
+	require.Equal(t, []string{"0", "<not found>"}, row(lines[3]))
+}
+
+// stubChainStaterootAPI serves a linear chain of tipsets. The state root of each
+// tipset stats with its height as the size.
+type stubChainStaterootAPI struct {
+	staterootAPI
+
+	tipsets []*types.TipSet
+	roots   map[cid.Cid]uint64
+}
+
+func newStubChainStaterootAPI(height int) *stubChainStaterootAPI {
+	s := &stubChainStaterootAPI{roots: map[cid.Cid]uint64{}}
+
+	var parent *types.TipSet
+	for h := 0; h <= height; h++ {
+		blk := mock.MkBlock(parent, 1, uint64(h))
+		blk.ParentStateRoot = mock.MkBlock(nil, 1, uint64(1000+h)).Cid()
+		s.roots[blk.ParentStateRoot] = uint64(h)
+
+		parent = mock.TipSet(blk)
+		s.tipsets = append(s.tipsets, parent)
+	}
+
+	return s
+}
+
+func (s *stubChainStaterootAPI) ChainHead(context.Context) (*types.TipSet, error) {
+	return s.tipsets[len(s.tipsets)-1], nil
+}
+
+func (s *stubChainStaterootAPI) ChainGetTipSet(_ context.Context, tsk types.TipSetKey) (*types.TipSet, error) {
+	for _, ts := range s.tipsets {
+		if ts.Key() == tsk {
+			return ts, nil
+		}
+	}
+	return nil, xerrors.Errorf("tipset %s not found", tsk)
+}
+
+func (s *stubChainStaterootAPI) ChainGetTipSetByHeight(_ context.Context, h abi.ChainEpoch, _ types.TipSetKey) (*types.TipSet, error) {
+	return s.tipsets[h], nil
+}
+
+func (s *stubChainStaterootAPI) ChainStatObj(_ context.Context, obj cid.Cid, _ cid.Cid) (api.ObjStat, error) {
+	return api.ObjStat{Size: s.roots[obj]}, nil
+}
+
+func TestStaterootDiffsHeightRange(t *testing.T) {
+	ctx := context.Background()
+
+	sapi := newStubChainStaterootAPI(10)
+	head, err := sapi.ChainHead(ctx)
+	require.NoError(t, err)
+
+	heights := func(out string) []string {
+		var hs []string
+		for _, line := range strings.Split(strings.TrimSpace(out), "\n")[1:] {
+			hs = append(hs, strings.Split(line, "\t")[0])
+		}
+		return hs
+	}
+
+	walk := staterootWalk{count: 30, byHeight: true, endHeight: 4}
+
+	start := abi.ChainEpoch(8)
+	ts, err := staterootWalkStart(ctx, sapi, head, walk, &start)
+	require.NoError(t, err)
+	require.Equal(t, start, ts.Height())
+
+	var out bytes.Buffer
+	require.NoError(t, staterootDiffs(ctx, &out, sapi, ts, walk, false, false))
+	require.Equal(t, []string{"7", "6", "5", "4"}, heights(out.String()))
+
+	// the row of a height shows the state root of the tipset above it
+	row := strings.Split(strings.Split(out.String(), "\n")[1], "\t")
+	require.Equal(t, "8", row[1])
+
+	// --count is used without height flags
+	out.Reset()
+	require.NoError(t, staterootDiffs(ctx, &out, sapi, head, staterootWalk{count: 3}, false, false))
+	require.Equal(t, []string{"9", "8", "7"}, heights(out.String()))
+
+	// start below end
+	start = 3
+	_, err = staterootWalkStart(ctx, sapi, head, walk, &start)
+	require.ErrorContains(t, err, "below --end-height")
+
+	// empty range
+	start = 4
+	_, err = staterootWalkStart(ctx, sapi, head, walk, &start)
+	require.ErrorContains(t, err, "empty range")
+}
+
+// flushCountingWriter records how many rows were written before each flush
+type flushCountingWriter struct {
+	bytes.Buffer
+	flushedLines []int
+}
+
+func (f *flushCountingWriter) Flush() error {
+	f.flushedLines = append(f.flushedLines, strings.Count(f.String(), "\n"))
+	return nil
+}
+
+func TestStaterootDiffsJSONL(t *testing.T) {
+	ctx := context.Background()
+
+	sapi := newStubChainStaterootAPI(10)
+	head, err := sapi.ChainHead(ctx)
+	require.NoError(t, err)
+
+	for _, diff := range []bool{false, true} {
+		var out flushCountingWriter
+		require.NoError(t, staterootDiffs(ctx, &out, sapi, head, staterootWalk{count: 3}, diff, true))
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		require.Len(t, lines, 3)
+		require.Equal(t, []int{1, 2, 3}, out.flushedLines, "each row must be flushed as it's written")
+
+		for i, line := range lines {
+			var row map[string]any
+			require.NoError(t, json.Unmarshal([]byte(line), &row), line)
+
+			require.EqualValues(t, 9-i, row["height"])
+			require.Contains(t, row, "size")
+			require.Contains(t, row, "links")
+			require.NotEmpty(t, row["obj"])
+			if diff {
+				require.NotEmpty(t, row["base"])
+			} else {
+				require.NotContains(t, row, "base")
+			}
+		}
+	}
+}
+
+func TestStaterootStatLinks(t *testing.T) {
+	ctx := context.Background()
+
+	carBytes, _, addrs := makeStaterootCar(t, 5)
+
+	sapi, closer, err := loadCarStaterootAPI(ctx, bytes.NewReader(carBytes))
+	require.NoError(t, err)
+	defer closer()
+
+	head, err := sapi.ChainHead(ctx)
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, staterootStat(ctx, &out, sapi, head, nil, staterootStatOpts{outcap: 10}))
+
+	totals := map[string]uint64{}
+	for _, line := range strings.Split(out.String(), "\n") {
+		name, val, ok := strings.Cut(line, ": ")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseUint(strings.TrimSpace(val), 10, 64)
+		require.NoError(t, err)
+		totals[name] = n
+	}
+
+	require.Greater(t, totals["Total state tree links"], uint64(len(addrs)))
+	// each stub actor state is a single block
+	require.EqualValues(t, len(addrs), totals["Sum of actor state links"])
+}
+
+func TestStaterootStatBalance(t *testing.T) {
+	ctx := context.Background()
+
+	carBytes, _, addrs := makeStaterootCar(t, 3)
+
+	sapi, closer, err := loadCarStaterootAPI(ctx, bytes.NewReader(carBytes))
+	require.NoError(t, err)
+	defer closer()
+
+	head, err := sapi.ChainHead(ctx)
+	require.NoError(t, err)
+
+	// actor rows by address, the stub actor at index i has a balance of i attoFIL
+	rows := func(attoFIL bool) map[string][]string {
+		var out bytes.Buffer
+		require.NoError(t, staterootStat(ctx, &out, sapi, head, addrs, staterootStatOpts{outcap: 10, balance: true, attoFIL: attoFIL}))
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		require.Contains(t, lines, "Addr\tType\tSize\tBalance")
+
+		rows := map[string][]string{}
+		for _, line := range lines {
+			row := strings.Split(line, "\t")
+			if len(row) == 4 && row[0] != "Addr" {
+				rows[row[0]] = row
+			}
+		}
+		require.Len(t, rows, len(addrs))
+		return rows
+	}
+
+	fil := rows(false)
+	atto := rows(true)
+	for i, addr := range addrs {
+		require.Equal(t, types.FIL(types.NewInt(uint64(i))).String(), fil[addr.String()][3])
+		require.Equal(t, strconv.Itoa(i), atto[addr.String()][3])
+	}
+}
+
+func TestStaterootStatResolve(t *testing.T) {
+	ctx := context.Background()
+
+	bs := blockstore.NewMemory()
+	cst := cbor.NewCborStore(bs)
+
+	st, err := state.NewStateTree(cst, types.StateTreeVersion5)
+	require.NoError(t, err)
+
+	accountAddr, otherAddr := mock.Address(1000), mock.Address(1001)
+	keyAddr, err := address.NewSecp256k1Address([]byte("stateroot resolve test key"))
+	require.NoError(t, err)
+
+	ast, err := account.MakeState(adt.WrapStore(ctx, cst), actorstypes.Version0, keyAddr)
+	require.NoError(t, err)
+	astHead, err := cst.Put(ctx, ast.GetState())
+	require.NoError(t, err)
+	require.NoError(t, st.SetActor(accountAddr, &types.Actor{Code: ast.Code(), Head: astHead, Balance: types.NewInt(0)}))
+
+	otherHead, err := cst.Put(ctx, mock.UnsignedMessage(otherAddr, otherAddr, 0))
+	require.NoError(t, err)
+	require.NoError(t, st.SetActor(otherAddr, &types.Actor{Code: otherHead, Head: otherHead, Balance: types.NewInt(0)}))
+
+	root, err := st.Flush(ctx)
+	require.NoError(t, err)
+
+	blk := mock.MkBlock(nil, 1, 1)
+	blk.ParentStateRoot = root
+	sblk, err := blk.ToStorageBlock()
+	require.NoError(t, err)
+	require.NoError(t, bs.Put(ctx, sblk))
+
+	sapi, closer, err := loadCarStaterootAPI(ctx, bytes.NewReader(writeStaterootCar(t, bs, blk.Cid())))
+	require.NoError(t, err)
+	defer closer()
+
+	head, err := sapi.ChainHead(ctx)
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	addrs := []address.Address{accountAddr, otherAddr}
+	require.NoError(t, staterootStat(ctx, &out, sapi, head, addrs, staterootStatOpts{outcap: 10, resolve: true, balance: true}))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Contains(t, lines, "Addr\tKey\tType\tSize\tBalance")
+
+	rows := map[string][]string{}
+	for _, line := range lines {
+		row := strings.Split(line, "\t")
+		if len(row) == 5 && row[0] != "Addr" {
+			rows[row[0]] = row
+		}
+	}
+	require.Len(t, rows, 2)
+	require.Equal(t, keyAddr.String(), rows[accountAddr.String()][1], "account actors must show their key address")
+	require.Equal(t, "", rows[otherAddr.String()][1], "actors without a key address must show a blank")
+}
+
+func TestStaterootStatVerboseObj(t *testing.T) {
+	ctx := context.Background()
+
+	bs := blockstore.NewMemory()
+	cst := cbor.NewCborStore(bs)
+
+	// a dag-cbor head linking to a raw leaf, a dag-pb node and another dag-cbor
+	// node, which links to the same raw leaf
+	leafData := []byte("stateroot verbose obj leaf")
+	leafCid, err := cid.V1Builder{Codec: cid.Raw, MhType: multihash.SHA2_256}.Sum(leafData)
+	require.NoError(t, err)
+	leaf, err := blocks.NewBlockWithCid(leafData, leafCid)
+	require.NoError(t, err)
+	require.NoError(t, bs.Put(ctx, leaf))
+
+	pb := merkledag.NodeWithData([]byte("stateroot verbose obj pb"))
+	require.NoError(t, bs.Put(ctx, pb))
+
+	child, err := cbor.WrapObject(map[string]interface{}{"leaf": leafCid}, multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	require.NoError(t, bs.Put(ctx, child))
+
+	head, err := cbor.WrapObject(map[string]interface{}{"leaf": leafCid, "pb": pb.Cid(), "child": child.Cid()}, multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	require.NoError(t, bs.Put(ctx, head))
+
+	st, err := state.NewStateTree(cst, types.StateTreeVersion5)
+	require.NoError(t, err)
+	addr := mock.Address(1000)
+	require.NoError(t, st.SetActor(addr, &types.Actor{Code: head.Cid(), Head: head.Cid(), Balance: types.NewInt(0)}))
+
+	root, err := st.Flush(ctx)
+	require.NoError(t, err)
+
+	blk := mock.MkBlock(nil, 1, 1)
+	blk.ParentStateRoot = root
+	sblk, err := blk.ToStorageBlock()
+	require.NoError(t, err)
+	require.NoError(t, bs.Put(ctx, sblk))
+
+	sapi, closer, err := loadCarStaterootAPI(ctx, bytes.NewReader(writeStaterootCar(t, bs, blk.Cid())))
+	require.NoError(t, err)
+	defer closer()
+
+	ts, err := sapi.ChainHead(ctx)
+	require.NoError(t, err)
+
+	cs, err := staterootCodecStat(ctx, sapi, head.Cid())
+	require.NoError(t, err)
+	require.EqualValues(t, 2, cs.CBORNodes)
+	require.EqualValues(t, 1, cs.RawLeaves)
+	require.EqualValues(t, 1, cs.PBNodes)
+	require.Zero(t, cs.OtherNodes)
+
+	// the walk covers the same objects as ChainStatObj
+	stat, err := sapi.ChainStatObj(ctx, head.Cid(), cid.Undef)
+	require.NoError(t, err)
+	require.Equal(t, stat.Size, cs.Size)
+	require.EqualValues(t, stat.Links, cs.CBORNodes+cs.RawLeaves+cs.PBNodes)
+
+	var out bytes.Buffer
+	require.NoError(t, staterootStat(ctx, &out, sapi, ts, []address.Address{addr}, staterootStatOpts{outcap: 10, verboseObj: true}))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Contains(t, lines, "Addr\tType\tSize\tCBOR Nodes\tRaw Leaves\tPB Nodes\tAvg Node Size")
+
+	row := strings.Split(lines[len(lines)-1], "\t")
+	require.Len(t, row, 7)
+	require.Equal(t, addr.String(), row[0])
+	require.Equal(t, []string{"2", "1", "1", strconv.FormatUint(cs.Size/4, 10)}, row[3:])
+}
+
+func TestStaterootStatExcludeSystem(t *testing.T) {
+	ctx := context.Background()
+
+	user := []address.Address{mock.Address(1000), mock.Address(1001)}
+	system := []address.Address{builtin.SystemActorAddr, builtin.InitActorAddr, builtin.StoragePowerActorAddr, builtin.BurntFundsActorAddr}
+
+	carBytes, _ := makeStaterootCarWith(t, append(append([]address.Address{}, system...), user...))
+
+	sapi, closer, err := loadCarStaterootAPI(ctx, bytes.NewReader(carBytes))
+	require.NoError(t, err)
+	defer closer()
+
+	head, err := sapi.ChainHead(ctx)
+	require.NoError(t, err)
+
+	listed := func(excludeSystem bool) ([]string, string) {
+		var out bytes.Buffer
+		require.NoError(t, staterootStat(ctx, &out, sapi, head, nil, staterootStatOpts{outcap: 10, excludeSystem: excludeSystem}))
+
+		var addrs []string
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			row := strings.Split(line, "\t")
+			if len(row) == 3 && row[0] != "Addr" {
+				addrs = append(addrs, row[0])
+			}
+		}
+		sort.Strings(addrs)
+		return addrs, out.String()
+	}
+
+	var all, users []string
+	for _, a := range append(append([]address.Address{}, system...), user...) {
+		all = append(all, a.String())
+	}
+	for _, a := range user {
+		users = append(users, a.String())
+	}
+	sort.Strings(all)
+
+	got, out := listed(false)
+	require.Equal(t, all, got)
+	require.Contains(t, out, "State tree structure size: ")
+
+	got, out = listed(true)
+	require.Equal(t, users, got)
+	require.Contains(t, out, "Total state tree size: ")
+	require.NotContains(t, out, "State tree structure size: ")
+}
+
+func TestStaterootStatPercentiles(t *testing.T) {
+	items := func(sizes ...uint64) []statItem {
+		var out []statItem
+		for _, sz := range sizes {
+			out = append(out, statItem{Stat: api.ObjStat{Size: sz}})
+		}
+		return out
+	}
+
+	// 1..100 in descending order, as staterootStat sorts them
+	var sizes []uint64
+	for i := uint64(100); i > 0; i-- {
+		sizes = append(sizes, i)
+	}
+	require.Equal(t, sizeDistribution{Count: 100, Mean: 50.5, P50: 50, P90: 90, P99: 99}, statSizeDistribution(items(sizes...)))
+
+	require.Equal(t, sizeDistribution{Count: 10, Mean: 5.5, P50: 5, P90: 9, P99: 10}, statSizeDistribution(items(3, 1, 2, 10, 9, 4, 5, 8, 7, 6)))
+	require.Equal(t, sizeDistribution{Count: 1, Mean: 7, P50: 7, P90: 7, P99: 7}, statSizeDistribution(items(7)))
+	require.Equal(t, sizeDistribution{}, statSizeDistribution(nil))
+
+	ctx := context.Background()
+
+	carBytes, _, addrs := makeStaterootCar(t, 5)
+
+	sapi, closer, err := loadCarStaterootAPI(ctx, bytes.NewReader(carBytes))
+	require.NoError(t, err)
+	defer closer()
+
+	head, err := sapi.ChainHead(ctx)
+	require.NoError(t, err)
+
+	// the distribution covers all actors, not only the printed top
+	var out bytes.Buffer
+	require.NoError(t, staterootStat(ctx, &out, sapi, head, addrs, staterootStatOpts{outcap: 1, percentiles: true}))
+	require.Contains(t, out.String(), fmt.Sprintf("Actor state size count:  %d\n", len(addrs)))
+	require.Contains(t, out.String(), "Actor state size p99: ")
+
+	out.Reset()
+	require.NoError(t, staterootStat(ctx, &out, sapi, head, addrs, staterootStatOpts{outcap: 1}))
+	require.NotContains(t, out.String(), "Actor state size count: ")
+}
+
+func TestStaterootStatPromFile(t *testing.T) {
+	ctx := context.Background()
+
+	carBytes, _, addrs := makeStaterootCar(t, 5)
+
+	sapi, closer, err := loadCarStaterootAPI(ctx, bytes.NewReader(carBytes))
+	require.NoError(t, err)
+	defer closer()
+
+	head, err := sapi.ChainHead(ctx)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	promFile := filepath.Join(dir, "stateroot.prom")
+
+	sampleRe := regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{(.*)\})? ([0-9]+)$`)
+	labelRe := regexp.MustCompile(`^([a-zA-Z_][a-zA-Z0-9_]*)="((?:[^"\\]|\\.)*)"$`)
+
+	// series parses the textfile, returning the label sets of each metric
+	series := func(opts staterootStatOpts) map[string][]map[string]string {
+		opts.promFile = promFile
+		require.NoError(t, staterootStat(ctx, io.Discard, sapi, head, addrs, opts))
+
+		data, err := os.ReadFile(promFile)
+		require.NoError(t, err)
+
+		typed := map[string]bool{}
+		out := map[string][]map[string]string{}
+		for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+			if strings.HasPrefix(line, "# ") {
+				f := strings.SplitN(line, " ", 4)
+				require.Len(t, f, 4, line)
+				require.Contains(t, []string{"HELP", "TYPE"}, f[1], line)
+				if f[1] == "TYPE" {
+					require.Equal(t, "gauge", f[3], line)
+					typed[f[2]] = true
+				}
+				continue
+			}
+
+			m := sampleRe.FindStringSubmatch(line)
+			require.NotNil(t, m, "malformed sample %q", line)
+			require.True(t, typed[m[1]], "sample %q before its TYPE line", line)
+
+			labels := map[string]string{}
+			if m[3] != "" {
+				for _, l := range strings.Split(m[3], ",") {
+					lm := labelRe.FindStringSubmatch(l)
+					require.NotNil(t, lm, "malformed label %q", l)
+					labels[lm[1]] = lm[2]
+				}
+			}
+			out[m[1]] = append(out[m[1]], labels)
+		}
+		return out
+	}
+
+	got := series(staterootStatOpts{outcap: 2})
+	for _, m := range []string{"lotus_stateroot_height", "lotus_stateroot_size_bytes", "lotus_stateroot_links", "lotus_stateroot_actors_size_bytes"} {
+		require.Len(t, got[m], 1, m)
+	}
+	require.Len(t, got["lotus_stateroot_actor_size_bytes"], 2, "--top must limit actor series")
+	for _, labels := range got["lotus_stateroot_actor_size_bytes"] {
+		require.NotEmpty(t, labels["addr"])
+		require.NotEmpty(t, labels["type"])
+	}
+
+	got = series(staterootStatOpts{outcap: 10})
+	require.Len(t, got["lotus_stateroot_actor_size_bytes"], len(addrs))
+
+	// the file is replaced on each run
+	got = series(staterootStatOpts{outcap: 10, minSize: 1 << 40})
+	require.Empty(t, got["lotus_stateroot_actor_size_bytes"], "--min-size must limit actor series")
+	require.Len(t, got["lotus_stateroot_size_bytes"], 1)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "temporary files must not be left behind")
+}
+
+func TestStaterootStatDB(t *testing.T) {
+	ctx := context.Background()
+
+	carBytes, _, addrs := makeStaterootCar(t, 5)
+
+	sapi, closer, err := loadCarStaterootAPI(ctx, bytes.NewReader(carBytes))
+	require.NoError(t, err)
+	defer closer()
+
+	head, err := sapi.ChainHead(ctx)
+	require.NoError(t, err)
+
+	dsn := filepath.Join(t.TempDir(), "stateroot.db")
+
+	// re-running a height replaces its rows instead of duplicating them
+	for i := 0; i < 2; i++ {
+		require.NoError(t, staterootStat(ctx, io.Discard, sapi, head, addrs, staterootStatOpts{outcap: 3, dbDSN: dsn}))
+	}
+
+	db, err := openStaterootDB(dsn)
+	require.NoError(t, err)
+	defer db.Close() //nolint:errcheck
+
+	rows, err := db.QueryContext(ctx, `SELECT height, timestamp, tipset, addr, actor_type, size FROM stateroot_actor_stats ORDER BY size DESC, addr`)
+	require.NoError(t, err)
+	defer rows.Close() //nolint:errcheck
+
+	seen := map[string]bool{}
+	for rows.Next() {
+		var height, timestamp, size int64
+		var tipset, addr, actorType string
+		require.NoError(t, rows.Scan(&height, &timestamp, &tipset, &addr, &actorType, &size))
+
+		require.EqualValues(t, head.Height(), height)
+		require.EqualValues(t, head.MinTimestamp(), timestamp)
+		require.Equal(t, head.Key().String(), tipset)
+		require.NotEmpty(t, actorType)
+		require.Positive(t, size)
+		require.False(t, seen[addr], "duplicate row for %s", addr)
+		seen[addr] = true
+	}
+	require.NoError(t, rows.Err())
+	require.Len(t, seen, 3, "--top must limit recorded actors")
+}
+
+// cancellingStaterootAPI cancels the context after the given number of
+// StateGetActor calls
+type cancellingStaterootAPI struct {
+	staterootAPI
+
+	cancel func()
+	after  int
+	calls  int
+}
+
+func (c *cancellingStaterootAPI) StateGetActor(ctx context.Context, actor address.Address, tsk types.TipSetKey) (*types.Actor, error) {
+	c.calls++
+	if c.calls == c.after {
+		c.cancel()
+	}
+	return c.staterootAPI.StateGetActor(ctx, actor, tsk)
+}
+
+func TestStaterootStatCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	carBytes, _, addrs := makeStaterootCar(t, 10)
+
+	sapi, closer, err := loadCarStaterootAPI(ctx, bytes.NewReader(carBytes))
+	require.NoError(t, err)
+	defer closer()
+
+	head, err := sapi.ChainHead(ctx)
+	require.NoError(t, err)
+
+	capi := &cancellingStaterootAPI{staterootAPI: sapi, cancel: cancel, after: 3}
+
+	var out bytes.Buffer
+	err = staterootStat(ctx, &out, capi, head, addrs, staterootStatOpts{outcap: 10})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 3, capi.calls, "actors after the cancellation must not be processed")
+	require.Empty(t, out.String())
+}
+
+func TestStaterootExplode(t *testing.T) {
+	ctx := context.Background()
+
+	bs := blockstore.NewMemory()
+	cst := cbor.NewCborStore(bs)
+
+	mst, err := market.MakeState(adt.WrapStore(ctx, cst), actorstypes.Version12)
+	require.NoError(t, err)
+
+	head, err := cst.Put(ctx, mst.GetState())
+	require.NoError(t, err)
+
+	st, err := state.NewStateTree(cst, types.StateTreeVersion5)
+	require.NoError(t, err)
+
+	addr := mock.Address(1000)
+	require.NoError(t, st.SetActor(addr, &types.Actor{Code: head, Head: head, Balance: types.NewInt(0)}))
+
+	root, err := st.Flush(ctx)
+	require.NoError(t, err)
+
+	blk := mock.MkBlock(nil, 1, 1)
+	blk.ParentStateRoot = root
+
+	sblk, err := blk.ToStorageBlock()
+	require.NoError(t, err)
+	require.NoError(t, bs.Put(ctx, sblk))
+
+	sapi, closer, err := loadCarStaterootAPI(ctx, bytes.NewReader(writeStaterootCar(t, bs, blk.Cid())))
+	require.NoError(t, err)
+	defer closer()
+
+	ts, err := sapi.ChainHead(ctx)
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, staterootExplode(ctx, &out, sapi, ts, addr))
+
+	totals := map[string]uint64{}
+	var fieldsSize, uniqueSize uint64
+	var fields int
+	seen := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if name, val, ok := strings.Cut(line, ": "); ok {
+			n, err := strconv.ParseUint(strings.TrimSpace(val), 10, 64)
+			require.NoError(t, err)
+			totals[name] = n
+			continue
+		}
+
+		row := strings.Split(line, "\t")
+		require.Len(t, row, 4)
+		if row[0] == "Field" {
+			continue
+		}
+
+		size, err := strconv.ParseUint(row[1], 10, 64)
+		require.NoError(t, err)
+
+		fields++
+		fieldsSize += size
+		if !seen[row[3]] {
+			seen[row[3]] = true
+			uniqueSize += size
+		}
+	}
+
+	// proposals, states, pending proposals, escrow, locked, deal ops by epoch
+	// and pending deal allocation ids
+	require.Equal(t, 7, fields)
+
+	// empty collections share objects, which the actor total counts only once
+	require.NotZero(t, totals["State head size"])
+	require.Equal(t, totals["Actor state size"], totals["State head size"]+uniqueSize)
+	require.GreaterOrEqual(t, totals["State head size"]+fieldsSize, totals["Actor state size"])
+}
+
+func TestStaterootLargestObj(t *testing.T) {
+	ctx := context.Background()
+
+	bs := blockstore.NewMemory()
+	cst := cbor.NewCborStore(bs)
+
+	wrap := func(v interface{}) *cbor.Node {
+		nd, err := cbor.WrapObject(v, multihash.SHA2_256, -1)
+		require.NoError(t, err)
+		require.NoError(t, bs.Put(ctx, nd))
+		return nd
+	}
+
+	// the large node is two levels down, next to a small one which is also
+	// linked from the head
+	big := wrap(bytes.Repeat([]byte{1}, 4096))
+	small := wrap("small")
+	mid := wrap(map[string]interface{}{"big": big.Cid(), "small": small.Cid()})
+	head := wrap(map[string]interface{}{"inner": mid.Cid(), "other": small.Cid()})
+
+	st, err := state.NewStateTree(cst, types.StateTreeVersion5)
+	require.NoError(t, err)
+
+	addr := mock.Address(1000)
+	require.NoError(t, st.SetActor(addr, &types.Actor{Code: head.Cid(), Head: head.Cid(), Balance: types.NewInt(0)}))
+
+	root, err := st.Flush(ctx)
+	require.NoError(t, err)
+
+	blk := mock.MkBlock(nil, 1, 1)
+	blk.ParentStateRoot = root
+
+	sblk, err := blk.ToStorageBlock()
+	require.NoError(t, err)
+	require.NoError(t, bs.Put(ctx, sblk))
+
+	sapi, closer, err := loadCarStaterootAPI(ctx, bytes.NewReader(writeStaterootCar(t, bs, blk.Cid())))
+	require.NoError(t, err)
+	defer closer()
+
+	lo, err := staterootLargestObj(ctx, sapi, head.Cid(), 0)
+	require.NoError(t, err)
+	require.Equal(t, big.Cid(), lo.Cid)
+	require.EqualValues(t, len(big.RawData()), lo.Size)
+	require.Equal(t, "/inner/big", lo.Path)
+	// the shared small node is walked once
+	require.Equal(t, 4, lo.Walked)
+	require.False(t, lo.Truncated)
+
+	// a bounded walk reports the largest object it reached
+	lo, err = staterootLargestObj(ctx, sapi, head.Cid(), 2)
+	require.NoError(t, err)
+	require.True(t, lo.Truncated)
+	require.Equal(t, 2, lo.Walked)
+	require.NotEqual(t, big.Cid(), lo.Cid)
+}
+
+func TestReadAddrsFile(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "addrs")
+	require.NoError(t, os.WriteFile(path, []byte("f01000\n\n  f01001  \n"), 0644))
+
+	addrs, err := readAddrsFile(path)
+	require.NoError(t, err)
+	require.Equal(t, []address.Address{mock.Address(1000), mock.Address(1001)}, addrs)
+
+	badPath := filepath.Join(dir, "bad")
+	require.NoError(t, os.WriteFile(badPath, []byte("f01000\n\nnot-an-address\n"), 0644))
+
+	_, err = readAddrsFile(badPath)
+	require.ErrorContains(t, err, "line 3")
+}
+
+// compareStaterootAPI serves actors with a different state head in each tipset,
+// with state sizes by head
+type compareStaterootAPI struct {
+	staterootAPI
+
+	heads map[types.TipSetKey]map[address.Address]cid.Cid
+	sizes map[cid.Cid]uint64
+}
+
+func (c *compareStaterootAPI) StateListActors(_ context.Context, tsk types.TipSetKey) ([]address.Address, error) {
+	var out []address.Address
+	for a := range c.heads[tsk] {
+		out = append(out, a)
+	}
+	return out, nil
+}
+
+func (c *compareStaterootAPI) StateGetActor(_ context.Context, actor address.Address, tsk types.TipSetKey) (*types.Actor, error) {
+	head, ok := c.heads[tsk][actor]
+	if !ok {
+		return nil, xerrors.Errorf("load actor %s: %w", actor, types.ErrActorNotFound)
+	}
+	return &types.Actor{Head: head}, nil
+}
+
+func (c *compareStaterootAPI) ChainStatObj(_ context.Context, obj cid.Cid, _ cid.Cid) (api.ObjStat, error) {
+	return api.ObjStat{Size: c.sizes[obj]}, nil
+}
+
+func TestStaterootCompare(t *testing.T) {
+	ctx := context.Background()
+
+	base := mock.TipSet(mock.MkBlock(nil, 1, 1))
+	cmp := mock.TipSet(mock.MkBlock(base, 1, 2))
+
+	grows, shrinks, same, gone, created := mock.Address(1000), mock.Address(1001), mock.Address(1002), mock.Address(1003), mock.Address(1004)
+
+	c := &compareStaterootAPI{
+		heads: map[types.TipSetKey]map[address.Address]cid.Cid{},
+		sizes: map[cid.Cid]uint64{},
+	}
+	head := func(ts *types.TipSet, a address.Address, size uint64) {
+		if c.heads[ts.Key()] == nil {
+			c.heads[ts.Key()] = map[address.Address]cid.Cid{}
+		}
+		h := mock.MkBlock(ts, 1, uint64(len(c.sizes)+100)).Cid()
+		c.heads[ts.Key()][a] = h
+		c.sizes[h] = size
+	}
+
+	head(base, grows, 100)
+	head(cmp, grows, 150)
+	head(base, shrinks, 300)
+	head(cmp, shrinks, 200)
+	head(base, same, 40)
+	head(cmp, same, 40)
+	head(base, gone, 70)
+	head(cmp, created, 20)
+
+	var out bytes.Buffer
+	require.NoError(t, staterootCompare(ctx, &out, c, base, cmp, nil, 10))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Equal(t, []string{
+		"Sum of actor state size change:  -100",
+		"Actors only in base tipset:  1",
+		"Actors only in compared tipset:  1",
+		"Addr\tBase\tCompare\tDelta",
+		shrinks.String() + "\t300\t200\t-100",
+		gone.String() + "\t70\t-\t-70",
+		grows.String() + "\t100\t150\t+50",
+		created.String() + "\t-\t20\t+20",
+		same.String() + "\t40\t40\t+0",
+	}, lines)
+
+	// explicitly listed actors missing from a tipset are handled the same way
+	out.Reset()
+	require.NoError(t, staterootCompare(ctx, &out, c, base, cmp, []address.Address{created}, 10))
+	require.Contains(t, out.String(), created.String()+"\t-\t20\t+20\n")
+}
+
+// slowStaterootAPI answers ChainStatObj calls after the first fast ones only
+// after a delay, or when the context is done
+type slowStaterootAPI struct {
+	*stubChainStaterootAPI
+
+	fast  int
+	delay time.Duration
+}
+
+func (s *slowStaterootAPI) ChainStatObj(ctx context.Context, obj cid.Cid, base cid.Cid) (api.ObjStat, error) {
+	if s.fast > 0 {
+		s.fast--
+		return s.stubChainStaterootAPI.ChainStatObj(ctx, obj, base)
+	}
+
+	select {
+	case <-time.After(s.delay):
+	case <-ctx.Done():
+		return api.ObjStat{}, ctx.Err()
+	}
+	return s.stubChainStaterootAPI.ChainStatObj(ctx, obj, base)
+}
+
+func TestStaterootTimeout(t *testing.T) {
+	sapi := &slowStaterootAPI{
+		stubChainStaterootAPI: newStubChainStaterootAPI(10),
+		fast:                  2,
+		delay:                 time.Minute,
+	}
+
+	app := &cli.App{
+		Flags: staterootCmd.Flags,
+		Action: func(cctx *cli.Context) error {
+			ctx, cancel := staterootContext(cctx)
+			defer cancel()
+
+			head, err := sapi.ChainHead(ctx)
+			if err != nil {
+				return err
+			}
+
+			return staterootDiffs(ctx, cctx.App.Writer, sapi, head, staterootWalk{count: 10}, false, false)
+		},
+	}
+
+	var out bytes.Buffer
+	app.Writer = &out
+
+	start := time.Now()
+	err := app.Run([]string{"stateroot", "--timeout", "100ms"})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), sapi.delay)
+
+	// rows of the tipsets walked before the timeout are written
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 3)
+	require.True(t, strings.HasPrefix(lines[1], "9\t"))
+	require.True(t, strings.HasPrefix(lines[2], "8\t"))
+}
+
+func TestStaterootStatTreemap(t *testing.T) {
+	item := func(id uint64, code cid.Cid, size uint64) statItem {
+		return statItem{Addr: mock.Address(id), Actor: &types.Actor{Code: code}, Stat: api.ObjStat{Size: size}}
+	}
+
+	// sumsMatch checks that the size of every parent is the sum of the sizes
+	// of its children, returning the number of leaves
+	var sumsMatch func(n *treemapNode) int
+	sumsMatch = func(n *treemapNode) int {
+		if len(n.Children) == 0 {
+			return 1
+		}
+		var sum uint64
+		var leaves int
+		for _, c := range n.Children {
+			sum += c.Size
+			leaves += sumsMatch(c)
+		}
+		require.Equal(t, sum, n.Size, "size of %s", n.Name)
+		return leaves
+	}
+
+	tm := statTreemap([]statItem{
+		item(1000, builtin0.StorageMinerActorCodeID, 300),
+		item(1001, builtin0.AccountActorCodeID, 10),
+		item(1002, builtin0.StorageMinerActorCodeID, 200),
+		item(1003, builtin0.AccountActorCodeID, 20),
+		item(1004, builtin0.MultisigActorCodeID, 5),
+	})
+	require.Equal(t, 5, sumsMatch(tm))
+	require.EqualValues(t, 535, tm.Size)
+
+	require.Len(t, tm.Children, 3)
+	require.Equal(t, lbuiltin.ActorNameByCode(builtin0.StorageMinerActorCodeID), tm.Children[0].Name)
+	require.EqualValues(t, 500, tm.Children[0].Size)
+	require.Equal(t, []string{mock.Address(1000).String(), mock.Address(1002).String()},
+		[]string{tm.Children[0].Children[0].Name, tm.Children[0].Children[1].Name})
+	require.Equal(t, lbuiltin.ActorNameByCode(builtin0.AccountActorCodeID), tm.Children[1].Name)
+	require.EqualValues(t, 30, tm.Children[1].Size)
+
+	ctx := context.Background()
+
+	carBytes, _, addrs := makeStaterootCar(t, 5)
+
+	sapi, closer, err := loadCarStaterootAPI(ctx, bytes.NewReader(carBytes))
+	require.NoError(t, err)
+	defer closer()
+
+	head, err := sapi.ChainHead(ctx)
+	require.NoError(t, err)
+
+	// the treemap covers all actors, not only the printed top, and is the
+	// only output
+	var out bytes.Buffer
+	require.NoError(t, staterootStat(ctx, &out, sapi, head, addrs, staterootStatOpts{outcap: 1, treemap: true}))
+
+	var got treemapNode
+	require.NoError(t, json.Unmarshal(out.Bytes(), &got))
+	require.Equal(t, len(addrs), sumsMatch(&got))
+}
+
+// typeTrendStaterootAPI is stubChainStaterootAPI with actors in the state of
+// each tipset, counting the actor states it stats
+type typeTrendStaterootAPI struct {
+	*stubChainStaterootAPI
+
+	actors map[types.TipSetKey]map[address.Address]*types.Actor
+	sizes  map[cid.Cid]uint64
+	stats  atomic.Int64
+}
+
+func (s *typeTrendStaterootAPI) StateListActors(_ context.Context, tsk types.TipSetKey) ([]address.Address, error) {
+	var out []address.Address
+	for a := range s.actors[tsk] {
+		out = append(out, a)
+	}
+	return out, nil
+}
+
+func (s *typeTrendStaterootAPI) StateGetActor(_ context.Context, a address.Address, tsk types.TipSetKey) (*types.Actor, error) {
+	act, ok := s.actors[tsk][a]
+	if !ok {
+		return nil, types.ErrActorNotFound
+	}
+	return act, nil
+}
+
+func (s *typeTrendStaterootAPI) ChainStatObj(_ context.Context, obj cid.Cid, _ cid.Cid) (api.ObjStat, error) {
+	s.stats.Add(1)
+	return api.ObjStat{Size: s.sizes[obj]}, nil
+}
+
+func TestStaterootTypeTrend(t *testing.T) {
+	ctx := context.Background()
+
+	sapi := &typeTrendStaterootAPI{
+		stubChainStaterootAPI: newStubChainStaterootAPI(3),
+		actors:                map[types.TipSetKey]map[address.Address]*types.Actor{},
+		sizes:                 map[cid.Cid]uint64{},
+	}
+	head := func(n uint64, size uint64) cid.Cid {
+		c := mock.MkBlock(nil, 1, 2000+n).Cid()
+		sapi.sizes[c] = size
+		return c
+	}
+
+	// the miner state grows by 100 each tipset, the first account's state
+	// doesn't change, the second account appears in the state of height 3
+	account := head(0, 10)
+	for h, ts := range sapi.tipsets {
+		actors := map[address.Address]*types.Actor{
+			mock.Address(1000): {Code: builtin0.StorageMinerActorCodeID, Head: head(uint64(1+h), uint64(100*h))},
+			mock.Address(1001): {Code: builtin0.AccountActorCodeID, Head: account},
+		}
+		if h >= 3 {
+			actors[mock.Address(1002)] = &types.Actor{Code: builtin0.AccountActorCodeID, Head: head(100, 20)}
+		}
+		sapi.actors[ts.Key()] = actors
+	}
+
+	tip, err := sapi.ChainHead(ctx)
+	require.NoError(t, err)
+
+	run := func(cache bool) []string {
+		sapi.stats.Store(0)
+
+		var out bytes.Buffer
+		require.NoError(t, staterootTypeTrend(ctx, &out, sapi, tip, staterootWalk{count: 3}, staterootTypeTrendOpts{workers: 4, cache: cache}))
+		return strings.Split(strings.TrimSpace(out.String()), "\n")
+	}
+
+	miner := lbuiltin.ActorNameByCode(builtin0.StorageMinerActorCodeID)
+	acct := lbuiltin.ActorNameByCode(builtin0.AccountActorCodeID)
+	expect := []string{
+		"Type\tHeight\tActors\tSize\tDelta",
+		miner + "\t2\t1\t300\t100",
+		miner + "\t1\t1\t200\t100",
+		miner + "\t0\t1\t100\t-",
+		acct + "\t2\t2\t30\t20",
+		acct + "\t1\t1\t10\t0",
+		acct + "\t0\t1\t10\t-",
+	}
+
+	require.Equal(t, expect, run(false))
+	require.EqualValues(t, 7, sapi.stats.Load())
+
+	// the unchanged account state is stat'd once with the cache
+	require.Equal(t, expect, run(true))
+	require.EqualValues(t, 5, sapi.stats.Load())
+}
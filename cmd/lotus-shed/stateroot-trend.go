@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	lbuiltin "github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/types"
+	lcli "github.com/filecoin-project/lotus/cli"
+)
+
+var staterootTypeTrendCmd = &cli.Command{
+	Name:        "type-trend",
+	Description: "Walk down the chain and print how the summed state size of each actor type changed",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "tipset",
+			Usage: "specify tipset to start from",
+		},
+		&cli.IntFlag{
+			Name:  "count",
+			Usage: "number of tipsets to count back",
+			Value: 30,
+		},
+		&cli.IntFlag{
+			Name:  "workers",
+			Usage: "number of actor states to stat in parallel",
+			Value: 8,
+		},
+		&cli.BoolFlag{
+			Name:  "cache",
+			Usage: "reuse the stats of actor states which didn't change between walked tipsets",
+			Value: true,
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := getStaterootAPI(cctx)
+		if err != nil {
+			return err
+		}
+
+		defer closer()
+		ctx, cancel := staterootContext(cctx)
+		defer cancel()
+
+		ts, err := lcli.LoadTipSet(ctx, cctx, api)
+		if err != nil {
+			return err
+		}
+
+		if cctx.Int("workers") < 1 {
+			return xerrors.Errorf("--workers must be at least 1")
+		}
+
+		return staterootTypeTrend(ctx, cctx.App.Writer, api, ts, staterootWalk{count: cctx.Int("count")}, staterootTypeTrendOpts{
+			workers: cctx.Int("workers"),
+			cache:   cctx.Bool("cache"),
+		})
+	},
+}
+
+type staterootTypeTrendOpts struct {
+	// workers is the number of actor states stat'd in parallel
+	workers int
+	// cache keeps state sizes by head CID for the whole walk
+	cache bool
+}
+
+// typeTrendPoint is the summed state size of the actors of a type in the state
+// of a walked tipset
+type typeTrendPoint struct {
+	Height abi.ChainEpoch
+	Actors int
+	Size   uint64
+}
+
+// staterootTypeTrend walks down the chain like the diffs command, grouping the
+// actors in each state by type, and prints the series of summed state sizes of
+// each type, largest type in the latest state first. Delta is the change from
+// the next older point of the series.
+func staterootTypeTrend(ctx context.Context, w io.Writer, sapi staterootAPI, ts *types.TipSet, walk staterootWalk, opts staterootTypeTrendOpts) error {
+	var sizes *headSizeCache
+	if opts.cache {
+		sizes = &headSizeCache{sizes: map[cid.Cid]uint64{}}
+	}
+
+	series := map[string][]typeTrendPoint{}
+	for i := 0; !walk.done(i, ts); i++ {
+		if err := ctx.Err(); err != nil {
+			return xerrors.Errorf("walk interrupted at height %d: %w", ts.Height(), err)
+		}
+
+		byType, err := staterootTypeSizes(ctx, sapi, ts, opts.workers, sizes)
+		if err != nil {
+			return xerrors.Errorf("stat of state at %d: %w", ts.Height(), err)
+		}
+
+		ts, err = sapi.ChainGetTipSet(ctx, ts.Parents())
+		if err != nil {
+			return err
+		}
+
+		for name, p := range byType {
+			p.Height = ts.Height()
+			series[name] = append(series[name], p)
+		}
+	}
+
+	names := make([]string, 0, len(series))
+	for name := range series {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		si, sj := series[names[i]][0].Size, series[names[j]][0].Size
+		if si != sj {
+			return si > sj
+		}
+		return names[i] < names[j]
+	})
+
+	_, _ = fmt.Fprintf(w, "Type\tHeight\tActors\tSize\tDelta\n")
+	for _, name := range names {
+		points := series[name]
+		for i, p := range points {
+			delta := "-"
+			if i+1 < len(points) {
+				delta = fmt.Sprint(int64(p.Size) - int64(points[i+1].Size))
+			}
+			_, _ = fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\n", name, p.Height, p.Actors, p.Size, delta)
+		}
+	}
+
+	return nil
+}
+
+// headSizeCache keeps actor state sizes by head CID; states unchanged between
+// tipsets share their head, so most of them are stat'd only once in a walk
+type headSizeCache struct {
+	lk    sync.Mutex
+	sizes map[cid.Cid]uint64
+}
+
+func (c *headSizeCache) get(head cid.Cid) (uint64, bool) {
+	if c == nil {
+		return 0, false
+	}
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	size, ok := c.sizes[head]
+	return size, ok
+}
+
+func (c *headSizeCache) put(head cid.Cid, size uint64) {
+	if c == nil {
+		return
+	}
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	c.sizes[head] = size
+}
+
+// staterootTypeSizes sums the state sizes of all actors in the state of ts by
+// actor type name, statting up to workers actor states at a time
+func staterootTypeSizes(ctx context.Context, sapi staterootAPI, ts *types.TipSet, workers int, cache *headSizeCache) (map[string]typeTrendPoint, error) {
+	addrs, err := sapi.StateListActors(ctx, ts.Key())
+	if err != nil {
+		return nil, err
+	}
+
+	var lk sync.Mutex
+	byType := map[string]typeTrendPoint{}
+
+	eg, egctx := errgroup.WithContext(ctx)
+	eg.SetLimit(workers)
+	for _, a := range addrs {
+		a := a
+		eg.Go(func() error {
+			return staterootTypeSize(egctx, sapi, ts, a, cache, func(name string, size uint64) {
+				lk.Lock()
+				defer lk.Unlock()
+				p := byType[name]
+				p.Actors++
+				p.Size += size
+				byType[name] = p
+			})
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+
+	return byType, nil
+}
+
+func staterootTypeSize(ctx context.Context, sapi staterootAPI, ts *types.TipSet, a address.Address, cache *headSizeCache, add func(name string, size uint64)) error {
+	act, err := sapi.StateGetActor(ctx, a, ts.Key())
+	if err != nil {
+		return err
+	}
+
+	name := lbuiltin.ActorNameByCode(act.Code)
+
+	if size, ok := cache.get(act.Head); ok {
+		add(name, size)
+		return nil
+	}
+
+	stat, err := sapi.ChainStatObj(ctx, act.Head, cid.Undef)
+	if err != nil {
+		return err
+	}
+	cache.put(act.Head, stat.Size)
+
+	add(name, stat.Size)
+	return nil
+}
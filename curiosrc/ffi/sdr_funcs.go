@@ -162,31 +162,36 @@ func (sb *SealCalls) GenerateSDR(ctx context.Context, taskID harmonytask.TaskID,
 }
 
 func (sb *SealCalls) TreeDRC(ctx context.Context, sector storiface.SectorRef, unsealed cid.Cid, size abi.PaddedPieceSize, data io.Reader, unpaddedData bool) (cid.Cid, cid.Cid, error) {
-	p1o, err := sb.makePhase1Out(unsealed, sector.ProofType)
-	if err != nil {
-		return cid.Undef, cid.Undef, xerrors.Errorf("make phase1 output: %w", err)
+	if _, err := sb.TreeD(ctx, sector, unsealed, size, data, unpaddedData); err != nil {
+		return cid.Undef, cid.Undef, err
 	}
 
+	return sb.TreeRC(ctx, sector, unsealed)
+}
+
+// TreeD builds TreeD of the sector data, and prepares the sealed sector file
+// from it for TreeRC. It returns the unsealed CID computed from TreeD.
+func (sb *SealCalls) TreeD(ctx context.Context, sector storiface.SectorRef, unsealed cid.Cid, size abi.PaddedPieceSize, data io.Reader, unpaddedData bool) (cid.Cid, error) {
 	paths, releaseSector, err := sb.sectors.AcquireSector(ctx, nil, sector, storiface.FTCache, storiface.FTSealed, storiface.PathSealing)
 	if err != nil {
-		return cid.Undef, cid.Undef, xerrors.Errorf("acquiring sector paths: %w", err)
+		return cid.Undef, xerrors.Errorf("acquiring sector paths: %w", err)
 	}
 	defer releaseSector()
 
 	treeDUnsealed, err := proof.BuildTreeD(data, unpaddedData, filepath.Join(paths.Cache, proofpaths.TreeDName), size)
 	if err != nil {
-		return cid.Undef, cid.Undef, xerrors.Errorf("building tree-d: %w", err)
+		return cid.Undef, xerrors.Errorf("building tree-d: %w", err)
 	}
 
 	if treeDUnsealed != unsealed {
-		return cid.Undef, cid.Undef, xerrors.Errorf("tree-d cid mismatch with supplied unsealed cid")
+		return cid.Undef, xerrors.Errorf("tree-d cid mismatch with supplied unsealed cid")
 	}
 
 	{
 		// create sector-sized file at paths.Sealed; PC2 transforms it into a sealed sector in-place
 		ssize, err := sector.ProofType.SectorSize()
 		if err != nil {
-			return cid.Undef, cid.Undef, xerrors.Errorf("getting sector size: %w", err)
+			return cid.Undef, xerrors.Errorf("getting sector size: %w", err)
 		}
 
 		{
@@ -197,7 +202,7 @@ func (sb *SealCalls) TreeDRC(ctx context.Context, sector storiface.SectorRef, un
 			if err == nil {
 				err = os.Truncate(paths.Sealed, int64(ssize))
 				if err != nil {
-					return cid.Undef, cid.Undef, xerrors.Errorf("truncating reflinked sealed file: %w", err)
+					return cid.Undef, xerrors.Errorf("truncating reflinked sealed file: %w", err)
 				}
 			} else {
 				log.Errorw("reflink treed -> sealed failed, falling back to slow copy, use single scratch btrfs or xfs filesystem", "error", err, "sector", sector, "cache", paths.Cache, "sealed", paths.Sealed)
@@ -205,26 +210,43 @@ func (sb *SealCalls) TreeDRC(ctx context.Context, sector storiface.SectorRef, un
 				// fallback to slow copy, copy ssize bytes from treed to sealed
 				dst, err := os.OpenFile(paths.Sealed, os.O_WRONLY|os.O_CREATE, 0644)
 				if err != nil {
-					return cid.Undef, cid.Undef, xerrors.Errorf("opening sealed sector file: %w", err)
+					return cid.Undef, xerrors.Errorf("opening sealed sector file: %w", err)
 				}
 				src, err := os.Open(filepath.Join(paths.Cache, proofpaths.TreeDName))
 				if err != nil {
-					return cid.Undef, cid.Undef, xerrors.Errorf("opening treed sector file: %w", err)
+					return cid.Undef, xerrors.Errorf("opening treed sector file: %w", err)
 				}
 
 				_, err = io.CopyN(dst, src, int64(ssize))
 				derr := dst.Close()
 				_ = src.Close()
 				if err != nil {
-					return cid.Undef, cid.Undef, xerrors.Errorf("copying treed -> sealed: %w", err)
+					return cid.Undef, xerrors.Errorf("copying treed -> sealed: %w", err)
 				}
 				if derr != nil {
-					return cid.Undef, cid.Undef, xerrors.Errorf("closing sealed file: %w", derr)
+					return cid.Undef, xerrors.Errorf("closing sealed file: %w", derr)
 				}
 			}
 		}
 	}
 
+	return treeDUnsealed, nil
+}
+
+// TreeRC builds TreeC and TreeR of a sector on which TreeD already ran,
+// returning the sealed and unsealed CIDs
+func (sb *SealCalls) TreeRC(ctx context.Context, sector storiface.SectorRef, unsealed cid.Cid) (cid.Cid, cid.Cid, error) {
+	p1o, err := sb.makePhase1Out(unsealed, sector.ProofType)
+	if err != nil {
+		return cid.Undef, cid.Undef, xerrors.Errorf("make phase1 output: %w", err)
+	}
+
+	paths, releaseSector, err := sb.sectors.AcquireSector(ctx, nil, sector, storiface.FTCache|storiface.FTSealed, storiface.FTNone, storiface.PathSealing)
+	if err != nil {
+		return cid.Undef, cid.Undef, xerrors.Errorf("acquiring sector paths: %w", err)
+	}
+	defer releaseSector()
+
 	sl, uns, err := ffi.SealPreCommitPhase2(p1o, paths.Cache, paths.Sealed)
 	if err != nil {
 		return cid.Undef, cid.Undef, xerrors.Errorf("computing seal proof: %w", err)
@@ -12,14 +12,17 @@ import (
 	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/big"
 
 	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/messagepool"
 	"github.com/filecoin-project/lotus/chain/types"
 	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
 	"github.com/filecoin-project/lotus/lib/harmony/harmonytask"
 	"github.com/filecoin-project/lotus/lib/harmony/resources"
 	"github.com/filecoin-project/lotus/lib/promise"
+	"github.com/filecoin-project/lotus/node/config"
 )
 
 var log = logging.Logger("curio/message")
@@ -77,10 +80,10 @@ func (s *SendTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done b
 	}
 
 	err = s.db.QueryRow(ctx, `
-		SELECT from_key, nonce, to_addr, unsigned_data, unsigned_cid 
+		SELECT from_key, nonce, to_addr, unsigned_data, unsigned_cid, signed_data 
 		FROM message_sends 
 		WHERE send_task_id = $1`, taskID).Scan(
-		&dbMsg.FromKey, &dbMsg.Nonce, &dbMsg.ToAddr, &dbMsg.UnsignedData, &dbMsg.UnsignedCid)
+		&dbMsg.FromKey, &dbMsg.Nonce, &dbMsg.ToAddr, &dbMsg.UnsignedData, &dbMsg.UnsignedCid, &dbMsg.SignedData)
 	if err != nil {
 		return false, xerrors.Errorf("getting message from db: %w", err)
 	}
@@ -135,25 +138,29 @@ func (s *SendTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done b
 	// assign nonce IF NOT ASSIGNED (max(api.MpoolGetNonce, db nonce+1))
 	var sigMsg *types.SignedMessage
 
-	if dbMsg.Nonce == nil {
-		msgNonce, err := s.api.MpoolGetNonce(ctx, msg.From)
-		if err != nil {
-			return false, xerrors.Errorf("getting nonce from mpool: %w", err)
-		}
+	if dbMsg.Nonce == nil || dbMsg.SignedData == nil {
+		// replacements (see Sender.Replace) come with the nonce of the replaced
+		// message, and only need to be signed
+		if dbMsg.Nonce == nil {
+			msgNonce, err := s.api.MpoolGetNonce(ctx, msg.From)
+			if err != nil {
+				return false, xerrors.Errorf("getting nonce from mpool: %w", err)
+			}
 
-		// get nonce from db
-		var dbNonce *uint64
-		r := s.db.QueryRow(ctx, `
+			// get nonce from db
+			var dbNonce *uint64
+			r := s.db.QueryRow(ctx, `
 			SELECT MAX(nonce) FROM message_sends WHERE from_key = $1 AND send_success = true`, msg.From.String())
-		if err := r.Scan(&dbNonce); err != nil {
-			return false, xerrors.Errorf("getting nonce from db: %w", err)
-		}
+			if err := r.Scan(&dbNonce); err != nil {
+				return false, xerrors.Errorf("getting nonce from db: %w", err)
+			}
 
-		if dbNonce != nil && *dbNonce+1 > msgNonce {
-			msgNonce = *dbNonce + 1
-		}
+			if dbNonce != nil && *dbNonce+1 > msgNonce {
+				msgNonce = *dbNonce + 1
+			}
 
-		msg.Nonce = msgNonce
+			msg.Nonce = msgNonce
+		}
 
 		// sign message
 		sigMsg, err = s.signer.WalletSignMessage(ctx, msg.From, &msg)
@@ -209,9 +216,25 @@ func (s *SendTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done b
 		sendError = err.Error()
 	}
 
-	_, err = s.db.Exec(ctx, `
+	_, err = s.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		_, err = tx.Exec(`
 		UPDATE message_sends SET send_success = $1, send_error = $2, send_time = CURRENT_TIMESTAMP 
 		WHERE send_task_id = $3`, sendSuccess, sendError, taskID)
+		if err != nil {
+			return false, err
+		}
+
+		if !sendSuccess {
+			// a replacement which couldn't be sent leaves the replaced message
+			// as the last one sent at its nonce
+			_, err = tx.Exec(`UPDATE message_sends SET replaced_by_task_id = NULL WHERE replaced_by_task_id = $1`, taskID)
+			if err != nil {
+				return false, err
+			}
+		}
+
+		return true, nil
+	}, harmonydb.OptionRetry())
 	if err != nil {
 		return false, xerrors.Errorf("updating db record: %w", err)
 	}
@@ -394,3 +417,115 @@ func (s *Sender) Send(ctx context.Context, msg *types.Message, mss *api.MessageS
 
 	return sigCid, sendErr
 }
+
+// Replace replaces a message which was sent and didn't land yet with a message
+// with the same nonce and a higher gas premium, like MpoolReplace. The message
+// last sent at the nonce is replaced, so that a message can be replaced more
+// than once, but not while its previous replacement is still being sent.
+//
+// Replace only queues the replacement, which is signed and pushed by a send
+// task. The MessageWatcher resolves replaced messages in message_waits to the
+// replacement landing on chain, so waiters of the original message don't need
+// to know about the replacement.
+func (s *Sender) Replace(ctx context.Context, signedCid cid.Cid, mss *api.MessageSendSpec, reason string) error {
+	if mss == nil {
+		return xerrors.Errorf("MessageSendSpec cannot be nil")
+	}
+
+	var cur []struct {
+		FromKey     string `db:"from_key"`
+		Nonce       uint64 `db:"nonce"`
+		SignedData  []byte `db:"signed_data"`
+		SendTaskID  int64  `db:"send_task_id"`
+		SendSuccess *bool  `db:"send_success"`
+	}
+	err := s.db.Select(ctx, &cur, `SELECT cur.from_key, cur.nonce, cur.signed_data, cur.send_task_id, cur.send_success
+		FROM message_sends orig
+		JOIN message_sends cur ON cur.from_key = orig.from_key AND cur.nonce = orig.nonce
+		WHERE orig.signed_cid = $1 AND cur.replaced_by_task_id IS NULL AND cur.send_success IS NOT FALSE`, signedCid.String())
+	if err != nil {
+		return xerrors.Errorf("getting message to replace: %w", err)
+	}
+	if len(cur) == 0 {
+		return xerrors.Errorf("message %s wasn't sent", signedCid)
+	}
+	if cur[0].SendSuccess == nil {
+		return xerrors.Errorf("replacement of message %s is still being sent", signedCid)
+	}
+
+	var sm types.SignedMessage
+	if err := sm.UnmarshalCBOR(bytes.NewReader(cur[0].SignedData)); err != nil {
+		return xerrors.Errorf("unmarshaling signed db message: %w", err)
+	}
+	msg := sm.Message
+
+	minRBF := messagepool.ComputeMinRBF(msg.GasPremium)
+
+	msg.GasFeeCap = abi.NewTokenAmount(0)
+	msg.GasPremium = abi.NewTokenAmount(0)
+	retm, err := s.api.GasEstimateMessageGas(ctx, &msg, mss, types.EmptyTSK)
+	if err != nil {
+		return xerrors.Errorf("GasEstimateMessageGas error: %w", err)
+	}
+
+	msg.GasPremium = big.Max(retm.GasPremium, minRBF)
+	msg.GasFeeCap = big.Max(retm.GasFeeCap, msg.GasPremium)
+
+	messagepool.CapGasFee(func() (abi.TokenAmount, error) {
+		return abi.TokenAmount(config.DefaultDefaultMaxFee), nil
+	}, &msg, mss)
+
+	if msg.GasPremium.LessThan(minRBF) {
+		return xerrors.Errorf("fee limit too low to replace message %s: gas premium %s below %s", signedCid, msg.GasPremium, minRBF)
+	}
+
+	b, err := s.api.WalletBalance(ctx, msg.From)
+	if err != nil {
+		return xerrors.Errorf("mpool replace: getting origin balance: %w", err)
+	}
+
+	requiredFunds := big.Add(msg.Value, msg.RequiredFunds())
+	if b.LessThan(requiredFunds) {
+		return xerrors.Errorf("mpool replace: not enough funds: %s < %s", b, requiredFunds)
+	}
+
+	taskAdder := s.sendTask.sendTF.Val(ctx)
+
+	unsBytes := new(bytes.Buffer)
+	err = msg.MarshalCBOR(unsBytes)
+	if err != nil {
+		return xerrors.Errorf("marshaling message: %w", err)
+	}
+
+	var replaced bool
+	taskAdder(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+		n, err := tx.Exec(`UPDATE message_sends SET replaced_by_task_id = $1
+			WHERE send_task_id = $2 AND from_key = $3 AND replaced_by_task_id IS NULL AND send_success = TRUE`,
+			id, cur[0].SendTaskID, cur[0].FromKey)
+		if err != nil {
+			return false, xerrors.Errorf("marking message as replaced: %w", err)
+		}
+		if n != 1 {
+			// replaced concurrently
+			return false, nil
+		}
+
+		_, err = tx.Exec(`insert into message_sends (from_key, to_addr, send_reason, unsigned_data, unsigned_cid, send_task_id, nonce) values ($1, $2, $3, $4, $5, $6, $7)`,
+			msg.From.String(), msg.To.String(), reason, unsBytes.Bytes(), msg.Cid().String(), id, cur[0].Nonce)
+		if err != nil {
+			return false, xerrors.Errorf("inserting message into db: %w", err)
+		}
+
+		replaced = true
+
+		return true, nil
+	})
+
+	if !replaced {
+		return xerrors.Errorf("failed to add replacement task for message %s", signedCid)
+	}
+
+	log.Infow("replacing message", "cid", signedCid, "nonce", cur[0].Nonce, "premium", msg.GasPremium, "feecap", msg.GasFeeCap)
+
+	return nil
+}
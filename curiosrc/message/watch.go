@@ -106,11 +106,14 @@ func (mw *MessageWatcher) update() {
 		From  string `db:"from_key"`
 		Nonce uint64 `db:"nonce"`
 
+		// Replaced messages land as the replacement, see Sender.Replace
+		Replaced bool `db:"replaced"`
+
 		FromAddr address.Address `db:"-"`
 	}
 
 	// really large limit in case of things getting stuck and backlogging severely
-	err = mw.db.Select(ctx, &msgs, `SELECT signed_message_cid, from_key, nonce FROM message_waits
+	err = mw.db.Select(ctx, &msgs, `SELECT signed_message_cid, from_key, nonce, replaced_by_task_id IS NOT NULL AS replaced FROM message_waits
                           JOIN message_sends ON signed_message_cid = signed_cid
                           WHERE waiter_machine_id = $1 LIMIT 10000`, machineID)
 	if err != nil {
@@ -147,7 +150,7 @@ func (mw *MessageWatcher) update() {
 			continue // definitely not on chain yet
 		}
 
-		look, err := mw.api.StateSearchMsg(ctx, lbtsk, cid.MustParse(msg.Cid), api.LookbackNoLimit, false)
+		look, err := mw.api.StateSearchMsg(ctx, lbtsk, cid.MustParse(msg.Cid), api.LookbackNoLimit, msg.Replaced)
 		if err != nil {
 			log.Errorf("failed to search for message: %+v", err)
 			return
@@ -2,19 +2,30 @@ package seal
 
 import (
 	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
 	logging "github.com/ipfs/go-log/v2"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/filecoin-project/go-state-types/network"
+	"github.com/filecoin-project/go-state-types/proof"
 
 	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
 	"github.com/filecoin-project/lotus/chain/types"
 	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
 	"github.com/filecoin-project/lotus/lib/harmony/harmonytask"
 	"github.com/filecoin-project/lotus/lib/promise"
+	"github.com/filecoin-project/lotus/node/config"
 )
 
 var log = logging.Logger("lpseal")
@@ -22,6 +33,8 @@ var log = logging.Logger("lpseal")
 const (
 	pollerSDR = iota
 	pollerTrees
+	pollerTreeD
+	pollerTreeRC
 	pollerPrecommitMsg
 	pollerPoRep
 	pollerCommitMsg
@@ -34,39 +47,347 @@ const (
 const sealPollerInterval = 10 * time.Second
 const seedEpochConfidence = 3
 
+// sealPollerLease is the harmony lease held by the elected seal poller leader
+const sealPollerLease = "seal_poller"
+
+// sealPollerLeaseTTL is how long the leader lease lasts without being renewed.
+// The leader renews it every poll tick, so it only expires when the leader
+// stops polling.
+const sealPollerLeaseTTL = 3 * sealPollerInterval
+
 type SealPollerAPI interface {
 	StateSectorPreCommitInfo(context.Context, address.Address, abi.SectorNumber, types.TipSetKey) (*miner.SectorPreCommitOnChainInfo, error)
 	StateSectorGetInfo(ctx context.Context, maddr address.Address, sectorNumber abi.SectorNumber, tsk types.TipSetKey) (*miner.SectorOnChainInfo, error)
 	ChainHead(context.Context) (*types.TipSet, error)
+	StateGetRandomnessDigestFromBeacon(ctx context.Context, randEpoch abi.ChainEpoch, tsk types.TipSetKey) (abi.Randomness, error)
+	ChainGetMessage(ctx context.Context, mc cid.Cid) (*types.Message, error)
+	StateNetworkVersion(ctx context.Context, tsk types.TipSetKey) (network.Version, error)
+	VerifySeal(proof.SealVerifyInfo) (bool, error)
 }
 
 type SealPoller struct {
 	db  *harmonydb.DB
 	api SealPollerAPI
 
+	// apiCache is the caching wrapper of api, nil if caching is disabled
+	apiCache *cachedPollerAPI
+	// apiBreaker guards api calls, nil if the circuit breaker is disabled
+	apiBreaker *apiBreaker
+	// chainSectors is set if api supports listing miner sectors, see
+	// ReconcileFromChain
+	chainSectors chainSectorsAPI
+	// tipSetsByHeight is set if api can look up tipsets by height, used to
+	// find the tipsets messages were executed in, see execTipSet
+	tipSetsByHeight tipSetByHeightAPI
+
+	maxTaskAttempts int
+
+	maxPrecommitMsgInFlight int
+	maxCommitMsgInFlight    int
+	// maxMinerMsgInFlight limits precommit and commit messages in flight per
+	// miner, across both stages
+	maxMinerMsgInFlight int
+
+	// commitMsgUrgentEpochs is how close to precommit expiry commit messages
+	// bypass maxCommitMsgInFlight, 0 if they never do
+	commitMsgUrgentEpochs int
+
+	commitLandConfidence int
+
+	// msgReplaceTimeout is how long precommit and commit messages can take to
+	// land before they are replaced, 0 if they are never replaced. Messages are
+	// only replaced once SetMsgReplacer was called.
+	msgReplaceTimeout time.Duration
+	msgReplacer       MsgReplacer
+	// msgReplaceMaxFee are the fee limits of replacement messages, by poller
+	msgReplaceMaxFee map[int]types.FIL
+
+	// msgSendTimeout is how long a precommit or commit message task can end
+	// without sending its message before the stage is retried, 0 to never retry
+	msgSendTimeout time.Duration
+	// maxUnstartedAge is how long a sector can wait for its SDR task to be
+	// started before it's failed, 0 to wait forever
+	maxUnstartedAge time.Duration
+
+	// stageTimeDefaults are the expected stage times of sector ETAs for stages
+	// without event history, by sector event stage name
+	stageTimeDefaults map[string]time.Duration
+
+	// msgExitDispositions are what to do with sectors whose messages landed
+	// with non-zero exit codes, see MsgExitCodeDispositions
+	msgExitDispositions map[exitcode.ExitCode]msgExitDisposition
+
+	// pollJitter is the fraction by which poll intervals are randomized
+	pollJitter float64
+
+	// spIDs are the miners the poller services, all miners if empty
+	spIDs []int64
+
+	checkSeedRandomness   bool
+	skipExistingPrecommit bool
+	verifyPrecommitMsg    bool
+	verifyCommitProof     bool
+	recoverSeedEpoch      bool
+
+	// splitTrees makes the poller start separate TreeD and TreeRC tasks
+	// instead of a combined trees task
+	splitTrees bool
+
+	leaderElection bool
+	// leaderID identifies this poller as the owner of the leader lease
+	leaderID string
+	// leaderLease is held while this poller is the elected leader. leaderLk
+	// guards it, as leadership is checked on ticks while a poll may still be
+	// running, and given up when RunPoller returns.
+	leaderLk    sync.Mutex
+	leaderLease *harmonydb.Lease
+
+	// unsafeForceAdvance enables ForceAdvance
+	unsafeForceAdvance bool
+
+	// stageDisabled marks stages the poller doesn't start tasks for, see
+	// SetStageEnabled
+	stageDisabled [numPollers]atomic.Bool
+
+	// stagePeriods is the number of poll cycles between start checks of each
+	// stage, every cycle if below 2, see StagePollPeriods
+	stagePeriods [numPollers]int
+	// stageSkipped marks stages whose start checks don't run in the current
+	// poll cycle, pollCycles counts cycles; both owned by poll, see planStages
+	stageSkipped [numPollers]bool
+	pollCycles   int
+
+	// unsetEligibleSince is when sectors became eligible for each stage
+	// without a task adder, unsetWarnedAt when that was last warned about;
+	// both owned by poll, see checkUnsetPollers
+	unsetEligibleSince [numPollers]time.Time
+	unsetWarnedAt      [numPollers]time.Time
+
+	// poRepBatchSize is the number of sectors a PoRep task can prove, sectors
+	// are proven one per task if below 2
+	poRepBatchSize int
+	// poRepBatch collects sectors ready for PoRep in a cycle, owned by poll
+	poRepBatch *poRepBatches
+	// poRepRecovered is set once recoverPoRepBatches ran, owned by poll
+	poRepRecovered bool
+
+	// networkVersions caches network versions by tipset for a poll cycle,
+	// owned by poll, see networkVersion
+	networkVersions map[types.TipSetKey]network.Version
+
+	// warnRegressions enables checkStageRegressions, which compares stage
+	// flags against prevStageFlags, owned by poll
+	warnRegressions bool
+	prevStageFlags  map[abi.SectorID]stageFlags
+
+	// selectTasks loads the pipeline sectors of a poll cycle, see
+	// selectPollTasksRetry
+	selectTasks func(ctx context.Context, tasks *[]pollTask) error
+
+	// polling is set while a poll cycle runs, see runPoll
+	polling atomic.Bool
+
+	// cycle counts what the current poll cycle did, see logPollSummary
+	cycleLk sync.Mutex
+	cycle   pollCycleStats
+
+	healthLk sync.Mutex
+	health   PollerHealth
+
+	// logHook receives logged events, see SetLogHook
+	logHook LogHook
+
+	// tracer emits sector stage spans, nil if tracing is off, see
+	// SetTracerProvider
+	tracer trace.Tracer
+
+	// streamLk guards the event stream, see StreamEvents
+	streamLk sync.Mutex
+	stream   chan PipelineEvent
+	// streamAfter is the ID of the last event considered for the stream
+	streamAfter   int64
+	streamDropped int64
+
 	pollers [numPollers]promise.Promise[harmonytask.AddTaskFunc]
 }
 
-func NewPoller(db *harmonydb.DB, api SealPollerAPI) *SealPoller {
-	return &SealPoller{
+func NewPoller(db *harmonydb.DB, api SealPollerAPI, cfg config.CurioSealConfig) *SealPoller {
+	s := &SealPoller{
 		db:  db,
 		api: api,
+
+		maxTaskAttempts: cfg.MaxTaskAttempts,
+
+		maxPrecommitMsgInFlight: cfg.MaxPrecommitMsgInFlight,
+		maxCommitMsgInFlight:    cfg.MaxCommitMsgInFlight,
+		maxMinerMsgInFlight:     cfg.MaxMinerMsgInFlight,
+
+		commitMsgUrgentEpochs: cfg.CommitMsgUrgentEpochs,
+
+		commitLandConfidence: cfg.CommitLandConfidence,
+		msgReplaceTimeout:    time.Duration(cfg.MsgReplaceTimeout),
+		msgSendTimeout:       time.Duration(cfg.MsgSendTimeout),
+		maxUnstartedAge:      time.Duration(cfg.MaxUnstartedAge),
+
+		pollJitter: cfg.PollerJitter,
+
+		spIDs: cfg.PollerSpIDs,
+
+		checkSeedRandomness:   cfg.CheckSeedRandomness,
+		skipExistingPrecommit: cfg.SkipExistingPrecommit,
+		verifyPrecommitMsg:    cfg.VerifyPrecommitMsg,
+		verifyCommitProof:     cfg.VerifyCommitProof,
+		recoverSeedEpoch:      cfg.RecoverMissingSeedEpoch,
+
+		splitTrees: cfg.SplitTrees,
+
+		leaderElection: cfg.PollerLeaderElection,
+		leaderID:       uuid.New().String(),
+
+		poRepBatchSize: cfg.PoRepBatchSize,
+
+		warnRegressions: cfg.WarnStageRegressions,
+	}
+
+	s.selectTasks = s.selectPollTasks
+
+	s.stageTimeDefaults = make(map[string]time.Duration, len(defaultStageTimes))
+	for stage, d := range defaultStageTimes {
+		s.stageTimeDefaults[stage] = d
+	}
+	for stage, d := range cfg.StageTimeDefaults {
+		s.stageTimeDefaults[stage] = time.Duration(d)
+	}
+
+	s.setStagePollPeriods(cfg.StagePollPeriods)
+	s.setMsgExitDispositions(cfg.MsgExitCodeDispositions)
+
+	if s.pollJitter < 0 || s.pollJitter >= 1 {
+		s.warnw("invalid seal poller jitter, polling without jitter", "jitter", s.pollJitter)
+		s.pollJitter = 0
+	}
+
+	s.chainSectors, _ = api.(chainSectorsAPI)
+	s.tipSetsByHeight, _ = api.(tipSetByHeightAPI)
+
+	if cfg.PollerBreakerThreshold > 0 {
+		s.apiBreaker = newAPIBreaker(cfg.PollerBreakerThreshold, time.Duration(cfg.PollerBreakerCooldown))
+		s.api = newBreakerPollerAPI(s, s.api, s.apiBreaker)
 	}
+
+	// cached values are served without going through the breaker
+	if cfg.PollerCacheTTL > 0 {
+		s.apiCache = newCachedPollerAPI(s.api, time.Duration(cfg.PollerCacheTTL))
+		s.api = s.apiCache
+	}
+
+	return s
 }
 
 func (s *SealPoller) RunPoller(ctx context.Context) {
-	ticker := time.NewTicker(sealPollerInterval)
-	defer ticker.Stop()
+	timer := time.NewTimer(s.pollInterval())
+	defer timer.Stop()
+	defer s.releaseLeadership()
+
+	// polls run in the background so slow polls don't delay ticks, wait for
+	// the last one before giving up leadership
+	var wg sync.WaitGroup
+	defer wg.Wait()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-ticker.C:
-			if err := s.poll(ctx); err != nil {
-				log.Errorw("polling failed", "error", err)
+		case <-timer.C:
+			timer.Reset(s.pollInterval())
+
+			if !s.isLeader(ctx) {
+				continue
 			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.runPoll(ctx, s.poll)
+			}()
+		}
+	}
+}
+
+// runPoll runs a poll cycle unless the previous one is still running, returns
+// false if the cycle was skipped
+func (s *SealPoller) runPoll(ctx context.Context, poll func(context.Context) error) bool {
+	if !s.polling.CompareAndSwap(false, true) {
+		s.warnw("poll still running, skipping tick")
+		return false
+	}
+	defer s.polling.Store(false)
+
+	if err := poll(ctx); err != nil {
+		s.errorw("polling failed", "error", err)
+	}
+	return true
+}
+
+// pollInterval returns the time until the next poll cycle, sealPollerInterval
+// randomized by up to pollJitter of it in either direction
+func (s *SealPoller) pollInterval() time.Duration {
+	if s.pollJitter == 0 {
+		return sealPollerInterval
+	}
+
+	return time.Duration(float64(sealPollerInterval) * (1 + s.pollJitter*(2*rand.Float64()-1)))
+}
+
+// isLeader returns true if this poller should poll the pipeline. With leader
+// election enabled only the poller holding the leader lease polls, renewing it
+// on each cycle; others try to claim the lease once it expires.
+func (s *SealPoller) isLeader(ctx context.Context) bool {
+	if !s.leaderElection {
+		return true
+	}
+
+	s.leaderLk.Lock()
+	defer s.leaderLk.Unlock()
+
+	if s.leaderLease != nil {
+		renewed, err := s.leaderLease.Renew(ctx)
+		if err != nil {
+			// keep the lease, it's still ours until it expires
+			s.errorw("renewing seal poller leader lease failed", "error", err)
+			return false
+		}
+		if renewed {
+			return true
+		}
+
+		s.warnw("seal poller lost leadership, leader lease expired")
+		s.leaderLease = nil
+	}
+
+	lease, err := s.db.TryLease(ctx, sealPollerLease, s.leaderID, sealPollerLeaseTTL)
+	if err != nil {
+		s.errorw("seal poller leader election failed", "error", err)
+		return false
+	}
+	if lease == nil {
+		return false
+	}
+
+	s.infow("seal poller elected as leader")
+	s.leaderLease = lease
+	return true
+}
+
+func (s *SealPoller) releaseLeadership() {
+	s.leaderLk.Lock()
+	defer s.leaderLk.Unlock()
+
+	if s.leaderLease != nil {
+		if err := s.leaderLease.Release(context.Background()); err != nil {
+			s.warnw("releasing seal poller leader lease failed", "error", err)
 		}
+		s.leaderLease = nil
 	}
 }
 
@@ -82,6 +403,7 @@ NOTE: TaskIDs are ONLY set while the tasks are executing or waiting to execute.
 type pollTask struct {
 	SpID         int64 `db:"sp_id"`
 	SectorNumber int64 `db:"sector_number"`
+	RegSealProof int64 `db:"reg_seal_proof"`
 
 	TaskSDR  *int64 `db:"task_id_sdr"`
 	AfterSDR bool   `db:"after_sdr"`
@@ -118,13 +440,26 @@ type pollTask struct {
 
 	Failed       bool   `db:"failed"`
 	FailedReason string `db:"failed_reason"`
-}
 
-func (s *SealPoller) poll(ctx context.Context) error {
-	var tasks []pollTask
+	Paused bool `db:"paused"`
+
+	// TraceID is the hex OpenTelemetry trace ID of the sector's stage spans,
+	// set when the first stage task is started with tracing enabled
+	TraceID *string `db:"trace_id"`
 
-	err := s.db.Select(ctx, &tasks, `SELECT 
-       sp_id, sector_number,
+	AttemptsSDR          int `db:"attempts_sdr"`
+	AttemptsTrees        int `db:"attempts_trees"`
+	AttemptsTreeRC       int `db:"attempts_tree_rc"`
+	AttemptsPrecommitMsg int `db:"attempts_precommit_msg"`
+	AttemptsPoRep        int `db:"attempts_porep"`
+	AttemptsFinalize     int `db:"attempts_finalize"`
+	AttemptsMoveStorage  int `db:"attempts_move_storage"`
+	AttemptsCommitMsg    int `db:"attempts_commit_msg"`
+}
+
+// pollTaskColumns are the sectors_sdr_pipeline columns scanned into pollTask
+const pollTaskColumns = `
+       sp_id, sector_number, reg_seal_proof,
        task_id_sdr, after_sdr,
        task_id_tree_d, after_tree_d,
        task_id_tree_c, after_tree_c,
@@ -136,41 +471,107 @@ func (s *SealPoller) poll(ctx context.Context) error {
        task_id_move_storage, after_move_storage,
        task_id_commit_msg, after_commit_msg,
        after_commit_msg_success,
-       failed, failed_reason
-    FROM sectors_sdr_pipeline WHERE after_commit_msg_success != TRUE OR after_move_storage != TRUE`)
+       failed, failed_reason, paused, trace_id,
+       attempts_sdr, attempts_trees, attempts_tree_rc, attempts_precommit_msg, attempts_porep,
+       attempts_finalize, attempts_move_storage, attempts_commit_msg`
+
+// poll runs a poll cycle. Each stage update it makes is conditioned on the
+// state the update was decided on, so that a cycle interrupted by a crash can
+// safely run again from the start; tasks which are gone after a crash mid-batch
+// are handled by recoverPoRepBatches.
+func (s *SealPoller) poll(ctx context.Context) (err error) {
+	if s.apiCache != nil {
+		s.apiCache.reset()
+	}
+
+	start := time.Now()
+	s.resetCycle()
+
+	var tasks []pollTask
+	defer func() {
+		s.recordPoll(len(tasks), err)
+		if err == nil {
+			s.logPollSummary(len(tasks), time.Since(start))
+		}
+	}()
+
+	if !s.poRepRecovered {
+		if err := s.recoverPoRepBatches(ctx); err != nil {
+			return xerrors.Errorf("recovering porep batches: %w", err)
+		}
+		s.poRepRecovered = true
+	}
+
+	tasks, err = s.selectPollTasksRetry(ctx)
 	if err != nil {
 		return err
 	}
 
+	if s.warnRegressions {
+		s.checkStageRegressions(tasks)
+	}
+
+	ts, err := s.api.ChainHead(ctx)
+	if err != nil && !xerrors.Is(err, errAPIBreakerOpen) {
+		return xerrors.Errorf("getting chain head: %w", err)
+	}
+	if err != nil {
+		s.warnw("seal poller chain API degraded, skipping stages which need it")
+	}
+
+	inFlight := countMsgInFlight(tasks)
+	s.planStages()
+
+	var head abi.ChainEpoch
+	if ts != nil {
+		head = ts.Height()
+	}
+	s.checkUnsetPollers(tasks, head, inFlight, time.Now())
+
+	if s.poRepBatchSize > 1 {
+		s.poRepBatch = &poRepBatches{groups: map[poRepBatchKey][]pollTask{}}
+	}
+	s.networkVersions = nil
+
 	for _, task := range tasks {
 		task := task
-		if task.Failed {
+		if task.Failed || s.failNeverStarted(ctx, task) {
 			continue
 		}
 
-		ts, err := s.api.ChainHead(ctx)
-		if err != nil {
-			return xerrors.Errorf("getting chain head: %w", err)
-		}
-
 		s.pollStartSDR(ctx, task)
 		s.pollStartSDRTrees(ctx, task)
-		s.pollStartPrecommitMsg(ctx, task)
+		s.pollStartSDRTreeD(ctx, task)
+		s.pollStartSDRTreeRC(ctx, task)
+		s.mustPoll(s.retryUnsentMsg(ctx, task, pollerPrecommitMsg))
+		s.pollStartMoveStorage(ctx, task)
+		s.mustPoll(s.retryUnsentMsg(ctx, task, pollerCommitMsg))
+
+		// the breaker can open mid-cycle
+		if ts == nil || s.apiDegraded() {
+			continue
+		}
+
+		s.pollStartPrecommitMsg(ctx, task, &inFlight)
 		s.mustPoll(s.pollPrecommitMsgLanded(ctx, task))
+		s.mustPoll(s.pollMissingSeedEpoch(ctx, task))
 		s.pollStartPoRep(ctx, task, ts)
 		s.pollStartFinalize(ctx, task, ts)
-		s.pollStartMoveStorage(ctx, task)
-		s.pollStartCommitMsg(ctx, task)
-		s.mustPoll(s.pollCommitMsgLanded(ctx, task))
+		s.pollStartCommitMsg(ctx, task, ts, &inFlight)
+		s.mustPoll(s.pollCommitMsgLanded(ctx, task, ts))
 	}
+	s.startPoRepBatches(ctx)
+
+	s.mustPoll(s.streamEvents(ctx))
 
 	return nil
 }
 
 func (s *SealPoller) pollStartSDR(ctx context.Context, task pollTask) {
-	if !task.AfterSDR && task.TaskSDR == nil && s.pollers[pollerSDR].IsSet() {
-		s.pollers[pollerSDR].Val(ctx)(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
-			n, err := tx.Exec(`UPDATE sectors_sdr_pipeline SET task_id_sdr = $1 WHERE sp_id = $2 AND sector_number = $3 AND task_id_sdr IS NULL`, id, task.SpID, task.SectorNumber)
+	if !task.AfterSDR && task.TaskSDR == nil && s.canStart(pollerSDR, task) &&
+		s.checkAttempts(ctx, task, "sdr", task.AttemptsSDR) {
+		s.addTask(ctx, pollerSDR, task, func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+			n, err := tx.Exec(`UPDATE sectors_sdr_pipeline SET task_id_sdr = $1, attempts_sdr = attempts_sdr + 1 WHERE sp_id = $2 AND sector_number = $3 AND task_id_sdr IS NULL`, id, task.SpID, task.SectorNumber)
 			if err != nil {
 				return false, xerrors.Errorf("update sectors_sdr_pipeline: %w", err)
 			}
@@ -188,12 +589,13 @@ func (t pollTask) afterSDR() bool {
 }
 
 func (s *SealPoller) pollStartSDRTrees(ctx context.Context, task pollTask) {
-	if !task.AfterTreeD && !task.AfterTreeC && !task.AfterTreeR &&
+	if !s.splitTrees && !task.AfterTreeD && !task.AfterTreeC && !task.AfterTreeR &&
 		task.TaskTreeD == nil && task.TaskTreeC == nil && task.TaskTreeR == nil &&
-		s.pollers[pollerTrees].IsSet() && task.AfterSDR {
+		s.canStart(pollerTrees, task) && task.AfterSDR &&
+		s.checkAttempts(ctx, task, "trees", task.AttemptsTrees) {
 
-		s.pollers[pollerTrees].Val(ctx)(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
-			n, err := tx.Exec(`UPDATE sectors_sdr_pipeline SET task_id_tree_d = $1, task_id_tree_c = $1, task_id_tree_r = $1
+		s.addTask(ctx, pollerTrees, task, func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+			n, err := tx.Exec(`UPDATE sectors_sdr_pipeline SET task_id_tree_d = $1, task_id_tree_c = $1, task_id_tree_r = $1, attempts_trees = attempts_trees + 1
                             WHERE sp_id = $2 AND sector_number = $3 AND after_sdr = TRUE AND task_id_tree_d IS NULL AND task_id_tree_c IS NULL AND task_id_tree_r IS NULL`, id, task.SpID, task.SectorNumber)
 			if err != nil {
 				return false, xerrors.Errorf("update sectors_sdr_pipeline: %w", err)
@@ -207,6 +609,47 @@ func (s *SealPoller) pollStartSDRTrees(ctx context.Context, task pollTask) {
 	}
 }
 
+func (s *SealPoller) pollStartSDRTreeD(ctx context.Context, task pollTask) {
+	if s.splitTrees && !task.AfterTreeD && task.TaskTreeD == nil &&
+		s.canStart(pollerTreeD, task) && task.AfterSDR &&
+		s.checkAttempts(ctx, task, "tree_d", task.AttemptsTrees) {
+
+		s.addTask(ctx, pollerTreeD, task, func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+			n, err := tx.Exec(`UPDATE sectors_sdr_pipeline SET task_id_tree_d = $1, attempts_trees = attempts_trees + 1
+                            WHERE sp_id = $2 AND sector_number = $3 AND after_sdr = TRUE AND task_id_tree_d IS NULL`, id, task.SpID, task.SectorNumber)
+			if err != nil {
+				return false, xerrors.Errorf("update sectors_sdr_pipeline: %w", err)
+			}
+			if n != 1 {
+				return false, xerrors.Errorf("expected to update 1 row, updated %d", n)
+			}
+
+			return true, nil
+		})
+	}
+}
+
+func (s *SealPoller) pollStartSDRTreeRC(ctx context.Context, task pollTask) {
+	if s.splitTrees && !task.AfterTreeC && !task.AfterTreeR &&
+		task.TaskTreeC == nil && task.TaskTreeR == nil &&
+		s.canStart(pollerTreeRC, task) && task.AfterTreeD && task.AfterSDR &&
+		s.checkAttempts(ctx, task, "tree_rc", task.AttemptsTreeRC) {
+
+		s.addTask(ctx, pollerTreeRC, task, func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+			n, err := tx.Exec(`UPDATE sectors_sdr_pipeline SET task_id_tree_c = $1, task_id_tree_r = $1, attempts_tree_rc = attempts_tree_rc + 1
+                            WHERE sp_id = $2 AND sector_number = $3 AND after_tree_d = TRUE AND task_id_tree_c IS NULL AND task_id_tree_r IS NULL`, id, task.SpID, task.SectorNumber)
+			if err != nil {
+				return false, xerrors.Errorf("update sectors_sdr_pipeline: %w", err)
+			}
+			if n != 1 {
+				return false, xerrors.Errorf("expected to update 1 row, updated %d", n)
+			}
+
+			return true, nil
+		})
+	}
+}
+
 func (t pollTask) afterTrees() bool {
 	return t.AfterTreeD && t.AfterTreeC && t.AfterTreeR && t.afterSDR()
 }
@@ -219,23 +662,38 @@ func (t pollTask) afterPrecommitMsgSuccess() bool {
 	return t.AfterPrecommitMsgSuccess && t.afterPrecommitMsg()
 }
 
+// pollStartPoRep starts the PoRep task once the seed is available. PoRep reads
+// the sealed replica and tree_r, so the task is only started when tree_r is
+// marked done, even if the precommit success recorded says otherwise. With
+// PoRep batching, sectors are collected for startPoRepBatches instead.
 func (s *SealPoller) pollStartPoRep(ctx context.Context, task pollTask, ts *types.TipSet) {
-	if s.pollers[pollerPoRep].IsSet() && task.afterPrecommitMsgSuccess() && task.SeedEpoch != nil &&
+	if s.canStart(pollerPoRep, task) && task.AfterTreeR && task.afterPrecommitMsgSuccess() && task.SeedEpoch != nil &&
 		task.TaskPoRep == nil && !task.AfterPoRep &&
-		ts.Height() >= abi.ChainEpoch(*task.SeedEpoch+seedEpochConfidence) {
+		ts.Height() >= abi.ChainEpoch(*task.SeedEpoch+seedEpochConfidence) &&
+		s.seedRandomnessAvailable(ctx, task, ts) &&
+		s.checkAttempts(ctx, task, "porep", task.AttemptsPoRep) {
 
-		s.pollers[pollerPoRep].Val(ctx)(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
-			n, err := tx.Exec(`UPDATE sectors_sdr_pipeline SET task_id_porep = $1 WHERE sp_id = $2 AND sector_number = $3 AND task_id_porep IS NULL`, id, task.SpID, task.SectorNumber)
-			if err != nil {
-				return false, xerrors.Errorf("update sectors_sdr_pipeline: %w", err)
-			}
-			if n != 1 {
-				return false, xerrors.Errorf("expected to update 1 row, updated %d", n)
-			}
+		if s.poRepBatch != nil && poRepBatchable(abi.RegisteredSealProof(task.RegSealProof)) {
+			s.poRepBatch.add(task)
+			return
+		}
+		s.startPoRepTask(ctx, []pollTask{task})
+	}
+}
 
-			return true, nil
-		})
+// seedRandomnessAvailable checks that the chain node can serve the beacon
+// randomness for the sector seed epoch, which the PoRep task needs
+func (s *SealPoller) seedRandomnessAvailable(ctx context.Context, task pollTask, ts *types.TipSet) bool {
+	if !s.checkSeedRandomness {
+		return true
+	}
+
+	if _, err := s.api.StateGetRandomnessDigestFromBeacon(ctx, abi.ChainEpoch(*task.SeedEpoch), ts.Key()); err != nil {
+		s.warnw("seed randomness not available, deferring porep", "sp", task.SpID, "sector", task.SectorNumber, "seed_epoch", *task.SeedEpoch, "error", err)
+		return false
 	}
+
+	return true
 }
 
 func (t pollTask) afterPoRep() bool {
@@ -243,9 +701,10 @@ func (t pollTask) afterPoRep() bool {
 }
 
 func (s *SealPoller) pollStartFinalize(ctx context.Context, task pollTask, ts *types.TipSet) {
-	if s.pollers[pollerFinalize].IsSet() && task.afterPoRep() && !task.AfterFinalize && task.TaskFinalize == nil {
-		s.pollers[pollerFinalize].Val(ctx)(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
-			n, err := tx.Exec(`UPDATE sectors_sdr_pipeline SET task_id_finalize = $1 WHERE sp_id = $2 AND sector_number = $3 AND task_id_finalize IS NULL`, id, task.SpID, task.SectorNumber)
+	if s.canStart(pollerFinalize, task) && task.afterPoRep() && !task.AfterFinalize && task.TaskFinalize == nil &&
+		s.checkAttempts(ctx, task, "finalize", task.AttemptsFinalize) {
+		s.addTask(ctx, pollerFinalize, task, func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+			n, err := tx.Exec(`UPDATE sectors_sdr_pipeline SET task_id_finalize = $1, attempts_finalize = attempts_finalize + 1 WHERE sp_id = $2 AND sector_number = $3 AND task_id_finalize IS NULL`, id, task.SpID, task.SectorNumber)
 			if err != nil {
 				return false, xerrors.Errorf("update sectors_sdr_pipeline: %w", err)
 			}
@@ -263,9 +722,10 @@ func (t pollTask) afterFinalize() bool {
 }
 
 func (s *SealPoller) pollStartMoveStorage(ctx context.Context, task pollTask) {
-	if s.pollers[pollerMoveStorage].IsSet() && task.afterFinalize() && !task.AfterMoveStorage && task.TaskMoveStorage == nil {
-		s.pollers[pollerMoveStorage].Val(ctx)(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
-			n, err := tx.Exec(`UPDATE sectors_sdr_pipeline SET task_id_move_storage = $1 WHERE sp_id = $2 AND sector_number = $3 AND task_id_move_storage IS NULL`, id, task.SpID, task.SectorNumber)
+	if s.canStart(pollerMoveStorage, task) && task.afterFinalize() && !task.AfterMoveStorage && task.TaskMoveStorage == nil &&
+		s.checkAttempts(ctx, task, "move_storage", task.AttemptsMoveStorage) {
+		s.addTask(ctx, pollerMoveStorage, task, func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+			n, err := tx.Exec(`UPDATE sectors_sdr_pipeline SET task_id_move_storage = $1, attempts_move_storage = attempts_move_storage + 1 WHERE sp_id = $2 AND sector_number = $3 AND task_id_move_storage IS NULL`, id, task.SpID, task.SectorNumber)
 			if err != nil {
 				return false, xerrors.Errorf("update sectors_sdr_pipeline: %w", err)
 			}
@@ -278,8 +738,166 @@ func (s *SealPoller) pollStartMoveStorage(ctx context.Context, task pollTask) {
 	}
 }
 
+// checkAttempts returns true if a task can be started for the pipeline stage.
+// When the stage was already attempted maxTaskAttempts times, the sector is
+// marked as failed instead.
+func (s *SealPoller) checkAttempts(ctx context.Context, task pollTask, stage string, attempts int) bool {
+	if !attemptsExceeded(s.maxTaskAttempts, attempts) {
+		return true
+	}
+
+	s.errorw("pipeline stage attempted too many times, failing sector", "sp", task.SpID, "sector", task.SectorNumber, "stage", stage, "attempts", attempts)
+
+	reason := fmt.Sprintf("%s task started %d times", stage, attempts)
+
+	failed, err := s.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		_, err = tx.Exec(`UPDATE sectors_sdr_pipeline
+			SET failed = TRUE, failed_at = NOW(), failed_reason = 'max_retries_exceeded', failed_reason_msg = $1
+			WHERE sp_id = $2 AND sector_number = $3`,
+			reason, task.SpID, task.SectorNumber)
+		if err != nil {
+			return false, xerrors.Errorf("update sectors_sdr_pipeline: %w", err)
+		}
+
+		if err := recordSectorEvent(tx, task.SpID, task.SectorNumber, stage, sectorEventFailed, reason); err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}, harmonydb.OptionRetry())
+	s.mustPoll(err)
+	if failed {
+		s.cycleFailed()
+	}
+
+	return false
+}
+
+// servicesSp returns true if the poller handles sectors of the miner
+func (s *SealPoller) servicesSp(spID int64) bool {
+	if len(s.spIDs) == 0 {
+		return true
+	}
+	for _, id := range s.spIDs {
+		if id == spID {
+			return true
+		}
+	}
+	return false
+}
+
+func attemptsExceeded(maxAttempts, attempts int) bool {
+	return maxAttempts > 0 && attempts >= maxAttempts
+}
+
+// msgInFlight counts sectors with precommit or commit messages being sent, or
+// waiting to land on chain
+type msgInFlight struct {
+	precommit int
+	commit    int
+
+	// miner counts sectors with a message of either stage in flight, by
+	// miner actor ID
+	miner map[int64]int
+}
+
+func countMsgInFlight(tasks []pollTask) msgInFlight {
+	var out msgInFlight
+	for _, task := range tasks {
+		if task.Failed {
+			continue
+		}
+		pc := (task.TaskPrecommitMsg != nil || task.AfterPrecommitMsg) && !task.AfterPrecommitMsgSuccess
+		c := (task.TaskCommitMsg != nil || task.AfterCommitMsg) && !task.AfterCommitMsgSuccess
+		if pc {
+			out.precommit++
+		}
+		if c {
+			out.commit++
+		}
+		if pc || c {
+			out.addMiner(task.SpID)
+		}
+	}
+	return out
+}
+
+func (m *msgInFlight) addMiner(spID int64) {
+	if m.miner == nil {
+		m.miner = map[int64]int{}
+	}
+	m.miner[spID]++
+}
+
+// msgStageAllowed returns true if another sector can enter a message stage
+// with the given in-flight limit
+func msgStageAllowed(maxInFlight, inFlight int) bool {
+	return maxInFlight <= 0 || inFlight < maxInFlight
+}
+
+// addTask adds a task with the poller's task adder, recording a sector event
+// when the task is added, and a stage span if tracing is on. If ctx is cancelled while waiting for the adder to
+// be set, no task is added.
+func (s *SealPoller) addTask(ctx context.Context, poller int, task pollTask, extraInfo func(harmonytask.TaskID, *harmonydb.Tx) (bool, error)) {
+	s.addTasks(ctx, poller, []pollTask{task}, extraInfo)
+}
+
+// addTasks adds a single task for the stage of all the sectors, like addTask
+func (s *SealPoller) addTasks(ctx context.Context, poller int, tasks []pollTask, extraInfo func(harmonytask.TaskID, *harmonydb.Tx) (bool, error)) {
+	add := s.pollers[poller].Val(ctx)
+	if add == nil {
+		s.debugw("not adding task, context done before task adder was set", "poller", poller, "error", ctx.Err())
+		return
+	}
+
+	// trace IDs of the sectors, set if the task was started with tracing on
+	var traceIDs []trace.TraceID
+	var started harmonytask.TaskID
+
+	add(func(id harmonytask.TaskID, tx *harmonydb.Tx) (bool, error) {
+		traceIDs = nil
+
+		commit, err := extraInfo(id, tx)
+		if err != nil || !commit {
+			return commit, err
+		}
+
+		for _, task := range tasks {
+			if err := recordSectorEvent(tx, task.SpID, task.SectorNumber, pollerStages[poller], sectorEventTaskStarted, fmt.Sprint(id)); err != nil {
+				return false, err
+			}
+		}
+
+		if s.tracer != nil {
+			for _, task := range tasks {
+				tid, err := sectorTraceID(tx, task)
+				if err != nil {
+					return false, err
+				}
+				traceIDs = append(traceIDs, tid)
+			}
+		}
+
+		s.cycleStarted(poller)
+		for _, task := range tasks {
+			s.debugw("started pipeline task", "sp", task.SpID, "sector", task.SectorNumber, "stage", pollerStages[poller], "task", id)
+		}
+		started = id
+		return true, nil
+	})
+
+	for i, tid := range traceIDs {
+		s.traceStage(ctx, poller, tasks[i], tid, started)
+	}
+}
+
+// apiDegraded returns true while the API circuit breaker is open
+func (s *SealPoller) apiDegraded() bool {
+	return s.apiBreaker != nil && s.apiBreaker.isOpen()
+}
+
 func (s *SealPoller) mustPoll(err error) {
 	if err != nil {
-		log.Errorw("poller operation failed", "error", err)
+		s.errorw("poller operation failed", "error", err)
 	}
 }
@@ -0,0 +1,171 @@
+package seal
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/network"
+
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+var errAPIBreakerOpen = xerrors.New("seal poller API circuit breaker open")
+
+// apiBreaker is a circuit breaker for the chain API. After threshold
+// consecutive failures it opens and rejects calls; once cooldown passes it lets
+// a single trial call through, closing again if the call succeeds.
+type apiBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	// now is overridden in tests
+	now func() time.Time
+
+	lk       sync.Mutex
+	failures int
+	open     bool
+	openedAt time.Time
+	// trial is set while the half-open trial call is in flight
+	trial bool
+}
+
+func newAPIBreaker(threshold int, cooldown time.Duration) *apiBreaker {
+	return &apiBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// allow returns true if a call can be made
+func (b *apiBreaker) allow() bool {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	if !b.open {
+		return true
+	}
+	if b.trial || b.now().Sub(b.openedAt) < b.cooldown {
+		return false
+	}
+
+	b.trial = true
+	return true
+}
+
+// record records the result of an allowed call, returning whether the call
+// opened or closed the breaker
+func (b *apiBreaker) record(err error) (opened, closed bool) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	wasTrial := b.trial
+	b.trial = false
+
+	if err == nil {
+		b.failures = 0
+		if b.open {
+			b.open = false
+			return false, true
+		}
+		return false, false
+	}
+
+	b.failures++
+	if wasTrial || (!b.open && b.failures >= b.threshold) {
+		b.open = true
+		b.openedAt = b.now()
+		return !wasTrial, false
+	}
+	return false, false
+}
+
+// isOpen returns true while the breaker rejects calls, including while a
+// half-open trial call is in flight
+func (b *apiBreaker) isOpen() bool {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	return b.open
+}
+
+// breakerPollerAPI guards calls made by the seal poller with an apiBreaker
+type breakerPollerAPI struct {
+	SealPollerAPI
+
+	s       *SealPoller
+	breaker *apiBreaker
+}
+
+func newBreakerPollerAPI(s *SealPoller, api SealPollerAPI, breaker *apiBreaker) *breakerPollerAPI {
+	return &breakerPollerAPI{
+		SealPollerAPI: api,
+		s:             s,
+		breaker:       breaker,
+	}
+}
+
+func breakerCall[T any](b *breakerPollerAPI, call func() (T, error)) (T, error) {
+	if !b.breaker.allow() {
+		var zero T
+		return zero, errAPIBreakerOpen
+	}
+
+	out, err := call()
+
+	opened, closed := b.breaker.record(err)
+	if opened {
+		b.s.warnw("seal poller chain API degraded, only running stages which don't need it",
+			"failures", b.breaker.threshold, "cooldown", b.breaker.cooldown, "error", err)
+	}
+	if closed {
+		b.s.infow("seal poller chain API recovered")
+	}
+
+	return out, err
+}
+
+func (b *breakerPollerAPI) ChainHead(ctx context.Context) (*types.TipSet, error) {
+	return breakerCall(b, func() (*types.TipSet, error) {
+		return b.SealPollerAPI.ChainHead(ctx)
+	})
+}
+
+func (b *breakerPollerAPI) StateSectorPreCommitInfo(ctx context.Context, maddr address.Address, sector abi.SectorNumber, tsk types.TipSetKey) (*miner.SectorPreCommitOnChainInfo, error) {
+	return breakerCall(b, func() (*miner.SectorPreCommitOnChainInfo, error) {
+		return b.SealPollerAPI.StateSectorPreCommitInfo(ctx, maddr, sector, tsk)
+	})
+}
+
+func (b *breakerPollerAPI) StateSectorGetInfo(ctx context.Context, maddr address.Address, sector abi.SectorNumber, tsk types.TipSetKey) (*miner.SectorOnChainInfo, error) {
+	return breakerCall(b, func() (*miner.SectorOnChainInfo, error) {
+		return b.SealPollerAPI.StateSectorGetInfo(ctx, maddr, sector, tsk)
+	})
+}
+
+func (b *breakerPollerAPI) StateGetRandomnessDigestFromBeacon(ctx context.Context, randEpoch abi.ChainEpoch, tsk types.TipSetKey) (abi.Randomness, error) {
+	return breakerCall(b, func() (abi.Randomness, error) {
+		return b.SealPollerAPI.StateGetRandomnessDigestFromBeacon(ctx, randEpoch, tsk)
+	})
+}
+
+func (b *breakerPollerAPI) ChainGetMessage(ctx context.Context, mc cid.Cid) (*types.Message, error) {
+	return breakerCall(b, func() (*types.Message, error) {
+		return b.SealPollerAPI.ChainGetMessage(ctx, mc)
+	})
+}
+
+func (b *breakerPollerAPI) StateNetworkVersion(ctx context.Context, tsk types.TipSetKey) (network.Version, error) {
+	return breakerCall(b, func() (network.Version, error) {
+		return b.SealPollerAPI.StateNetworkVersion(ctx, tsk)
+	})
+}
+
+var _ SealPollerAPI = &breakerPollerAPI{}
@@ -0,0 +1,98 @@
+package seal
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// cachedPollerAPI memoizes chain state reads made by the seal poller. Cached
+// values are dropped at the start of every poll cycle, and after ttl.
+type cachedPollerAPI struct {
+	SealPollerAPI
+
+	ttl time.Duration
+
+	lk         sync.Mutex
+	head       *types.TipSet
+	headAt     time.Time
+	precommits map[precommitKey]cachedPrecommit
+}
+
+type precommitKey struct {
+	maddr  address.Address
+	sector abi.SectorNumber
+	tsk    types.TipSetKey
+}
+
+type cachedPrecommit struct {
+	info *miner.SectorPreCommitOnChainInfo
+	at   time.Time
+}
+
+func newCachedPollerAPI(api SealPollerAPI, ttl time.Duration) *cachedPollerAPI {
+	return &cachedPollerAPI{
+		SealPollerAPI: api,
+		ttl:           ttl,
+		precommits:    map[precommitKey]cachedPrecommit{},
+	}
+}
+
+// reset drops all cached values
+func (c *cachedPollerAPI) reset() {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	c.head = nil
+	c.precommits = map[precommitKey]cachedPrecommit{}
+}
+
+func (c *cachedPollerAPI) ChainHead(ctx context.Context) (*types.TipSet, error) {
+	c.lk.Lock()
+	if c.head != nil && time.Since(c.headAt) < c.ttl {
+		defer c.lk.Unlock()
+		return c.head, nil
+	}
+	c.lk.Unlock()
+
+	ts, err := c.SealPollerAPI.ChainHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.lk.Lock()
+	c.head, c.headAt = ts, time.Now()
+	c.lk.Unlock()
+
+	return ts, nil
+}
+
+func (c *cachedPollerAPI) StateSectorPreCommitInfo(ctx context.Context, maddr address.Address, sector abi.SectorNumber, tsk types.TipSetKey) (*miner.SectorPreCommitOnChainInfo, error) {
+	key := precommitKey{maddr: maddr, sector: sector, tsk: tsk}
+
+	c.lk.Lock()
+	if pc, ok := c.precommits[key]; ok && time.Since(pc.at) < c.ttl {
+		defer c.lk.Unlock()
+		return pc.info, nil
+	}
+	c.lk.Unlock()
+
+	info, err := c.SealPollerAPI.StateSectorPreCommitInfo(ctx, maddr, sector, tsk)
+	if err != nil {
+		return nil, err
+	}
+
+	c.lk.Lock()
+	c.precommits[key] = cachedPrecommit{info: info, at: time.Now()}
+	c.lk.Unlock()
+
+	return info, nil
+}
+
+var _ SealPollerAPI = &cachedPollerAPI{}
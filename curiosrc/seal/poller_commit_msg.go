@@ -7,17 +7,26 @@ import (
 
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/abi"
+	actorstypes "github.com/filecoin-project/go-state-types/actors"
 	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/filecoin-project/go-state-types/network"
 
+	"github.com/filecoin-project/lotus/chain/actors"
+	"github.com/filecoin-project/lotus/chain/actors/policy"
 	"github.com/filecoin-project/lotus/chain/types"
 	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
 	"github.com/filecoin-project/lotus/lib/harmony/harmonytask"
 )
 
-func (s *SealPoller) pollStartCommitMsg(ctx context.Context, task pollTask) {
-	if task.afterPoRep() && len(task.PoRepProof) > 0 && task.TaskCommitMsg == nil && !task.AfterCommitMsg && s.pollers[pollerCommitMsg].IsSet() {
-		s.pollers[pollerCommitMsg].Val(ctx)(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
-			n, err := tx.Exec(`UPDATE sectors_sdr_pipeline SET task_id_commit_msg = $1 WHERE sp_id = $2 AND sector_number = $3 AND task_id_commit_msg IS NULL`, id, task.SpID, task.SectorNumber)
+func (s *SealPoller) pollStartCommitMsg(ctx context.Context, task pollTask, ts *types.TipSet, inFlight *msgInFlight) {
+	if task.afterPoRep() && len(task.PoRepProof) > 0 && task.TaskCommitMsg == nil && !task.AfterCommitMsg && s.canStart(pollerCommitMsg, task) &&
+		((msgStageAllowed(s.maxCommitMsgInFlight, inFlight.commit) && msgStageAllowed(s.maxMinerMsgInFlight, inFlight.miner[task.SpID])) || s.commitUrgent(task, ts)) &&
+		s.checkAttempts(ctx, task, "commit_msg", task.AttemptsCommitMsg) && s.commitProofValid(ctx, task) {
+		inFlight.commit++
+		inFlight.addMiner(task.SpID)
+
+		s.addTask(ctx, pollerCommitMsg, task, func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+			n, err := tx.Exec(`UPDATE sectors_sdr_pipeline SET task_id_commit_msg = $1, attempts_commit_msg = attempts_commit_msg + 1 WHERE sp_id = $2 AND sector_number = $3 AND task_id_commit_msg IS NULL`, id, task.SpID, task.SectorNumber)
 			if err != nil {
 				return false, xerrors.Errorf("update sectors_sdr_pipeline: %w", err)
 			}
@@ -30,7 +39,43 @@ func (s *SealPoller) pollStartCommitMsg(ctx context.Context, task pollTask) {
 	}
 }
 
-func (s *SealPoller) pollCommitMsgLanded(ctx context.Context, task pollTask) error {
+// commitUrgent returns true if the precommit of the sector expires within
+// commitMsgUrgentEpochs, in which case the commit message is started without
+// waiting for other commit messages to land
+func (s *SealPoller) commitUrgent(task pollTask, ts *types.TipSet) bool {
+	if s.commitMsgUrgentEpochs <= 0 || task.SeedEpoch == nil {
+		return false
+	}
+
+	expiry, err := precommitExpiry(task)
+	if err != nil {
+		s.mustPoll(err)
+		return false
+	}
+
+	left := expiry - ts.Height()
+	if left > abi.ChainEpoch(s.commitMsgUrgentEpochs) {
+		return false
+	}
+
+	s.warnw("precommit expires soon, starting commit message over the in-flight limit", "sp", task.SpID, "sector", task.SectorNumber, "expiry", expiry, "epochs_left", left)
+	return true
+}
+
+// precommitExpiry returns the epoch after which the sector precommit can't be
+// proven anymore, derived from the seed epoch. The max prove commit duration
+// of the latest actors version is used, it didn't change in recent versions.
+func precommitExpiry(task pollTask) (abi.ChainEpoch, error) {
+	msd, err := policy.GetMaxProveCommitDuration(actorstypes.Version(actors.LatestVersion), abi.RegisteredSealProof(task.RegSealProof))
+	if err != nil {
+		return 0, xerrors.Errorf("getting max prove commit duration: %w", err)
+	}
+
+	precommitEpoch := abi.ChainEpoch(*task.SeedEpoch) - policy.GetPreCommitChallengeDelay()
+	return precommitEpoch + msd, nil
+}
+
+func (s *SealPoller) pollCommitMsgLanded(ctx context.Context, task pollTask, ts *types.TipSet) error {
 	if task.AfterCommitMsg && !task.AfterCommitMsgSuccess && s.pollers[pollerCommitMsg].IsSet() {
 		var execResult []dbExecResult
 
@@ -39,7 +84,16 @@ func (s *SealPoller) pollCommitMsgLanded(ctx context.Context, task pollTask) err
 					JOIN message_waits ON spipeline.commit_msg_cid = message_waits.signed_message_cid
 					WHERE sp_id = $1 AND sector_number = $2 AND executed_tsk_epoch IS NOT NULL`, task.SpID, task.SectorNumber)
 		if err != nil {
-			log.Errorw("failed to query message_waits", "error", err)
+			s.errorw("failed to query message_waits", "error", err)
+		}
+
+		if err == nil && len(execResult) == 0 {
+			if task.TaskCommitMsg == nil {
+				if err := s.enrollMsgWait(ctx, task, pollerCommitMsg); err != nil {
+					return err
+				}
+			}
+			return s.replaceStuckMsg(ctx, task, pollerCommitMsg)
 		}
 
 		if len(execResult) > 0 {
@@ -52,23 +106,63 @@ func (s *SealPoller) pollCommitMsgLanded(ctx context.Context, task pollTask) err
 				return s.pollCommitMsgFail(ctx, task, execResult[0])
 			}
 
-			si, err := s.api.StateSectorGetInfo(ctx, maddr, abi.SectorNumber(task.SectorNumber), types.EmptyTSK)
+			if ts.Height() < abi.ChainEpoch(execResult[0].ExecutedTskEpoch+int64(s.commitLandConfidence)) {
+				// wait for the message to be buried deep enough, a reorg could still undo it
+				return nil
+			}
+
+			// look up the sector at the exact tipset the message was executed in
+			// when it can be found and the network version activates sectors as
+			// the message executes, at head otherwise
+			execTsk := s.execTipSet(ctx, ts, execResult[0])
+
+			nvTsk := execTsk
+			if nvTsk.IsEmpty() {
+				nvTsk = ts.Key()
+			}
+			nv, err := s.networkVersion(ctx, nvTsk)
+			if err != nil {
+				return xerrors.Errorf("getting network version: %w", err)
+			}
+			if commitLandedLookup(nv) == commitLookupHead {
+				execTsk = types.EmptyTSK
+			}
+
+			si, err := s.api.StateSectorGetInfo(ctx, maddr, abi.SectorNumber(task.SectorNumber), execTsk)
 			if err != nil {
 				return xerrors.Errorf("get sector info: %w", err)
 			}
 
 			if si == nil {
-				log.Errorw("todo handle missing sector info (not found after cron)", "sp", task.SpID, "sector", task.SectorNumber, "exec_epoch", execResult[0].ExecutedTskEpoch, "exec_tskcid", execResult[0].ExecutedTskCID, "msg_cid", execResult[0].ExecutedMsgCID)
+				s.errorw("todo handle missing sector info (not found after cron)", "sp", task.SpID, "sector", task.SectorNumber, "exec_epoch", execResult[0].ExecutedTskEpoch, "exec_tskcid", execResult[0].ExecutedTskCID, "msg_cid", execResult[0].ExecutedMsgCID)
 				// todo handdle missing sector info (not found after cron)
 			} else {
 				// yay!
 
-				_, err := s.db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET
-						after_commit_msg_success = TRUE, commit_msg_tsk = $1
-						WHERE sp_id = $2 AND sector_number = $3 AND after_commit_msg_success = FALSE`,
-					execResult[0].ExecutedTskCID, task.SpID, task.SectorNumber)
+				landed, err := s.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+					n, err := tx.Exec(`UPDATE sectors_sdr_pipeline SET
+						after_commit_msg_success = TRUE, commit_msg_tsk = $1, commit_msg_gas_used = $2
+						WHERE sp_id = $3 AND sector_number = $4 AND after_commit_msg_success = FALSE`,
+						execResult[0].ExecutedTskCID, execResult[0].ExecutedRcptGasUsed, task.SpID, task.SectorNumber)
+					if err != nil {
+						return false, xerrors.Errorf("update sectors_sdr_pipeline: %w", err)
+					}
+					if n == 0 {
+						return false, nil
+					}
+
+					if err := recordSectorEvent(tx, task.SpID, task.SectorNumber, pollerStages[pollerCommitMsg], sectorEventLanded, execResult[0].ExecutedTskCID); err != nil {
+						return false, err
+					}
+
+					return true, nil
+				}, harmonydb.OptionRetry())
 				if err != nil {
-					return xerrors.Errorf("update sectors_sdr_pipeline: %w", err)
+					return err
+				}
+				if landed {
+					s.cycleLanded()
+					s.debugw("commit message landed", "sp", task.SpID, "sector", task.SectorNumber)
 				}
 			}
 		}
@@ -77,16 +171,89 @@ func (s *SealPoller) pollCommitMsgLanded(ctx context.Context, task pollTask) err
 	return nil
 }
 
+// commitLookup is the state pollCommitMsgLanded reads the sector info of a
+// landed commit message from
+type commitLookup int
+
+const (
+	// commitLookupHead reads sector info at the chain head. Before network
+	// version 22 ProveCommitSector only schedules the proof for verification
+	// in power actor cron, so the state of the tipset the message executed in
+	// can predate the sector's activation.
+	commitLookupHead commitLookup = iota
+	// commitLookupExec reads sector info at the tipset the commit message
+	// executed in. From network version 22 (direct data onboarding) sectors
+	// are activated by the commit message itself.
+	commitLookupExec
+)
+
+// commitLandedLookup returns where commit messages executed under the given
+// network version make sector info appear
+func commitLandedLookup(nv network.Version) commitLookup {
+	if nv >= network.Version22 {
+		return commitLookupExec
+	}
+	return commitLookupHead
+}
+
+// networkVersion returns the network version at tsk, looked up once per
+// tipset in a poll cycle
+func (s *SealPoller) networkVersion(ctx context.Context, tsk types.TipSetKey) (network.Version, error) {
+	if nv, ok := s.networkVersions[tsk]; ok {
+		return nv, nil
+	}
+
+	nv, err := s.api.StateNetworkVersion(ctx, tsk)
+	if err != nil {
+		return 0, err
+	}
+
+	if s.networkVersions == nil {
+		s.networkVersions = map[types.TipSetKey]network.Version{}
+	}
+	s.networkVersions[tsk] = nv
+	return nv, nil
+}
+
+// tipSetByHeightAPI is optionally implemented by SealPollerAPI implementations
+// which can look up tipsets by height
+type tipSetByHeightAPI interface {
+	ChainGetTipSetByHeight(ctx context.Context, h abi.ChainEpoch, tsk types.TipSetKey) (*types.TipSet, error)
+}
+
+// execTipSet returns the key of the tipset in which a message was executed,
+// as recorded in message_waits, looked up on the chain of ts. It returns
+// EmptyTSK, which resolves to the chain head, if the tipset can't be found on
+// that chain.
+func (s *SealPoller) execTipSet(ctx context.Context, ts *types.TipSet, execResult dbExecResult) types.TipSetKey {
+	if s.tipSetsByHeight == nil {
+		return types.EmptyTSK
+	}
+
+	ets, err := s.tipSetsByHeight.ChainGetTipSetByHeight(ctx, abi.ChainEpoch(execResult.ExecutedTskEpoch), ts.Key())
+	if err != nil {
+		s.warnw("looking up executed tipset, using chain head", "epoch", execResult.ExecutedTskEpoch, "error", err)
+		return types.EmptyTSK
+	}
+
+	tskCid, err := ets.Key().Cid()
+	if err != nil {
+		s.warnw("getting executed tipset cid, using chain head", "epoch", execResult.ExecutedTskEpoch, "error", err)
+		return types.EmptyTSK
+	}
+	if tskCid.String() != execResult.ExecutedTskCID {
+		s.warnw("executed tipset not on the current chain, using chain head", "epoch", execResult.ExecutedTskEpoch, "tsk", execResult.ExecutedTskCID, "found", tskCid)
+		return types.EmptyTSK
+	}
+
+	return ets.Key()
+}
+
 func (s *SealPoller) pollCommitMsgFail(ctx context.Context, task pollTask, execResult dbExecResult) error {
-	switch exitcode.ExitCode(execResult.ExecutedRcptExitCode) {
-	case exitcode.SysErrInsufficientFunds:
-		fallthrough
-	case exitcode.SysErrOutOfGas:
-		// just retry
+	if s.msgExitDisposition(exitcode.ExitCode(execResult.ExecutedRcptExitCode)) == msgExitRetry {
 		return s.pollRetryCommitMsgSend(ctx, task, execResult)
-	default:
-		return xerrors.Errorf("commit message failed with exit code %s", exitcode.ExitCode(execResult.ExecutedRcptExitCode))
 	}
+	return s.failMsgExitCode(ctx, task, pollerCommitMsg, execResult)
 }
 
 func (s *SealPoller) pollRetryCommitMsgSend(ctx context.Context, task pollTask, execResult dbExecResult) error {
@@ -96,13 +263,23 @@ func (s *SealPoller) pollRetryCommitMsgSend(ctx context.Context, task pollTask,
 
 	// make the pipeline entry seem like precommit send didn't happen, next poll loop will retry
 
-	_, err := s.db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET
+	_, err := s.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		n, err := tx.Exec(`UPDATE sectors_sdr_pipeline SET
                                 commit_msg_cid = NULL, task_id_commit_msg = NULL, after_commit_msg = FALSE
                             	WHERE commit_msg_cid = $1 AND sp_id = $2 AND sector_number = $3 AND after_commit_msg_success = FALSE`,
-		*execResult.CommitMsgCID, task.SpID, task.SectorNumber)
-	if err != nil {
-		return xerrors.Errorf("update sectors_sdr_pipeline to retry precommit msg send: %w", err)
-	}
+			*execResult.CommitMsgCID, task.SpID, task.SectorNumber)
+		if err != nil {
+			return false, xerrors.Errorf("update sectors_sdr_pipeline to retry precommit msg send: %w", err)
+		}
+		if n == 0 {
+			return false, nil
+		}
 
-	return nil
+		if err := recordSectorEvent(tx, task.SpID, task.SectorNumber, pollerStages[pollerCommitMsg], sectorEventRetry, exitcode.ExitCode(execResult.ExecutedRcptExitCode).String()); err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}, harmonydb.OptionRetry())
+	return err
 }
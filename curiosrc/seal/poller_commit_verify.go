@@ -0,0 +1,110 @@
+package seal
+
+import (
+	"context"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/proof"
+
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+)
+
+// commitProofValid verifies the PoRep proof of a sector before its commit
+// message task is started, if VerifyCommitProof is enabled. A sector with an
+// invalid proof is failed, so that no commit message is wasted on it; if the
+// proof can't be verified, the sector waits for the next cycle.
+func (s *SealPoller) commitProofValid(ctx context.Context, task pollTask) bool {
+	if !s.verifyCommitProof {
+		return true
+	}
+
+	svi, err := s.sealVerifyInfo(ctx, task)
+	if err != nil {
+		s.warnw("failed to load sector seal info for proof verification", "sp", task.SpID, "sector", task.SectorNumber, "error", err)
+		return false
+	}
+
+	ok, err := s.api.VerifySeal(svi)
+	if err != nil {
+		s.warnw("failed to verify porep proof", "sp", task.SpID, "sector", task.SectorNumber, "error", err)
+		return false
+	}
+	if ok {
+		return true
+	}
+
+	s.mustPoll(s.failCommitProof(ctx, task))
+	return false
+}
+
+// sealVerifyInfo loads what's needed to verify the PoRep proof of a sector
+func (s *SealPoller) sealVerifyInfo(ctx context.Context, task pollTask) (proof.SealVerifyInfo, error) {
+	var info []struct {
+		TicketValue []byte `db:"ticket_value"`
+		SeedValue   []byte `db:"seed_value"`
+		SealedCID   string `db:"tree_r_cid"`
+		UnsealedCID string `db:"tree_d_cid"`
+	}
+	err := s.db.Select(ctx, &info, `SELECT ticket_value, seed_value, tree_r_cid, tree_d_cid
+		FROM sectors_sdr_pipeline WHERE sp_id = $1 AND sector_number = $2`, task.SpID, task.SectorNumber)
+	if err != nil {
+		return proof.SealVerifyInfo{}, xerrors.Errorf("getting sector seal info: %w", err)
+	}
+	if len(info) != 1 {
+		return proof.SealVerifyInfo{}, xerrors.Errorf("expected 1 sector, got %d", len(info))
+	}
+
+	sealed, err := cid.Parse(info[0].SealedCID)
+	if err != nil {
+		return proof.SealVerifyInfo{}, xerrors.Errorf("parsing sealed cid: %w", err)
+	}
+	unsealed, err := cid.Parse(info[0].UnsealedCID)
+	if err != nil {
+		return proof.SealVerifyInfo{}, xerrors.Errorf("parsing unsealed cid: %w", err)
+	}
+
+	return proof.SealVerifyInfo{
+		SealProof: abi.RegisteredSealProof(task.RegSealProof),
+		SectorID: abi.SectorID{
+			Miner:  abi.ActorID(task.SpID),
+			Number: abi.SectorNumber(task.SectorNumber),
+		},
+		Randomness:            info[0].TicketValue,
+		InteractiveRandomness: info[0].SeedValue,
+		Proof:                 task.PoRepProof,
+		SealedCID:             sealed,
+		UnsealedCID:           unsealed,
+	}, nil
+}
+
+func (s *SealPoller) failCommitProof(ctx context.Context, task pollTask) error {
+	const reason = "porep proof failed local verification"
+
+	s.errorw("porep proof is invalid, failing sector", "sp", task.SpID, "sector", task.SectorNumber)
+
+	failed, err := s.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		n, err := tx.Exec(`UPDATE sectors_sdr_pipeline
+			SET failed = TRUE, failed_at = NOW(), failed_reason = 'porep_verify_failed', failed_reason_msg = $1
+			WHERE sp_id = $2 AND sector_number = $3 AND task_id_commit_msg IS NULL AND failed = FALSE`,
+			reason, task.SpID, task.SectorNumber)
+		if err != nil {
+			return false, xerrors.Errorf("update sectors_sdr_pipeline: %w", err)
+		}
+		if n == 0 {
+			return false, nil
+		}
+
+		if err := recordSectorEvent(tx, task.SpID, task.SectorNumber, pollerStages[pollerCommitMsg], sectorEventFailed, reason); err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}, harmonydb.OptionRetry())
+	if failed {
+		s.cycleFailed()
+	}
+	return err
+}
@@ -0,0 +1,61 @@
+package seal
+
+import (
+	"time"
+)
+
+// pollCycleStats counts what the poller did during a poll cycle, reported in
+// the poll summary log line
+type pollCycleStats struct {
+	// started counts started tasks, by poller
+	started [numPollers]int
+	// landed counts recorded message landings
+	landed int
+	// failed counts sectors marked as failed
+	failed int
+}
+
+func (s *SealPoller) resetCycle() {
+	s.cycleLk.Lock()
+	defer s.cycleLk.Unlock()
+
+	s.cycle = pollCycleStats{}
+}
+
+func (s *SealPoller) cycleStarted(poller int) {
+	s.cycleLk.Lock()
+	defer s.cycleLk.Unlock()
+
+	s.cycle.started[poller]++
+}
+
+func (s *SealPoller) cycleLanded() {
+	s.cycleLk.Lock()
+	defer s.cycleLk.Unlock()
+
+	s.cycle.landed++
+}
+
+func (s *SealPoller) cycleFailed() {
+	s.cycleLk.Lock()
+	defer s.cycleLk.Unlock()
+
+	s.cycle.failed++
+}
+
+// logPollSummary logs a single line summarizing the poll cycle which examined
+// the given number of sectors
+func (s *SealPoller) logPollSummary(sectors int, took time.Duration) {
+	s.cycleLk.Lock()
+	c := s.cycle
+	s.cycleLk.Unlock()
+
+	started := map[string]int{}
+	for i, n := range c.started {
+		if n > 0 {
+			started[pollerStages[i]] = n
+		}
+	}
+
+	s.infow("seal poll cycle done", "sectors", sectors, "started", started, "landed", c.landed, "failed", c.failed, "took", took)
+}
@@ -0,0 +1,68 @@
+package seal
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+)
+
+// SectorEntry is a sector to be added to the sealing pipeline
+type SectorEntry struct {
+	SectorNumber abi.SectorNumber
+	RegSealProof abi.RegisteredSealProof
+}
+
+// EnqueueSectors adds the sectors of the miner to the sealing pipeline in a
+// single transaction, for the poller to start sealing them. Sector numbers
+// should be allocated with AllocateSectorNumbers first.
+//
+// Either all sectors are added or none: when any of them is invalid, listed
+// twice or already in the pipeline, an error is returned and nothing is added.
+func (s *SealPoller) EnqueueSectors(ctx context.Context, spID int64, sectors []SectorEntry) error {
+	if spID <= 0 {
+		return xerrors.Errorf("invalid miner id %d", spID)
+	}
+
+	seen := make(map[abi.SectorNumber]struct{}, len(sectors))
+	for _, sector := range sectors {
+		if sector.SectorNumber > abi.MaxSectorNumber {
+			return xerrors.Errorf("sector number %d above the maximum", sector.SectorNumber)
+		}
+		if _, err := sector.RegSealProof.SectorSize(); err != nil {
+			return xerrors.Errorf("sector %d: invalid seal proof %d: %w", sector.SectorNumber, sector.RegSealProof, err)
+		}
+		if _, ok := seen[sector.SectorNumber]; ok {
+			return xerrors.Errorf("sector %d listed more than once", sector.SectorNumber)
+		}
+		seen[sector.SectorNumber] = struct{}{}
+	}
+
+	if len(sectors) == 0 {
+		return nil
+	}
+
+	_, err := s.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		for _, sector := range sectors {
+			n, err := tx.Exec(`INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof) VALUES ($1, $2, $3)
+				ON CONFLICT (sp_id, sector_number) DO NOTHING`, spID, sector.SectorNumber, sector.RegSealProof)
+			if err != nil {
+				return false, xerrors.Errorf("inserting into sectors_sdr_pipeline: %w", err)
+			}
+			if n == 0 {
+				return false, xerrors.Errorf("sector %d of miner %d already in the pipeline", sector.SectorNumber, spID)
+			}
+		}
+
+		return true, nil
+	}, harmonydb.OptionRetry())
+	if err != nil {
+		return err
+	}
+
+	s.infow("enqueued sectors", "sp", spID, "count", len(sectors))
+	return nil
+}
@@ -0,0 +1,110 @@
+package seal
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// defaultStageTimes are the expected times of sector event stages used for
+// sector ETAs when neither event history nor StageTimeDefaults cover a stage.
+// The precommit_msg stage lasts until PoRep starts, so it includes the wait
+// for the interactive seed.
+var defaultStageTimes = map[string]time.Duration{
+	"sdr":           4 * time.Hour,
+	"trees":         time.Hour,
+	"tree_d":        10 * time.Minute,
+	"tree_rc":       50 * time.Minute,
+	"precommit_msg": 90 * time.Minute,
+	"porep":         30 * time.Minute,
+	"finalize":      5 * time.Minute,
+	"move_storage":  15 * time.Minute,
+	"commit_msg":    30 * time.Minute,
+}
+
+// SectorETA is the estimated time until a sector goes through the pipeline
+type SectorETA struct {
+	SpID         int64
+	SectorNumber int64
+
+	// Stage is the first pipeline stage the sector didn't complete
+	Stage string
+
+	// Remaining is the expected time the sector needs to complete Stage and
+	// the stages after it
+	Remaining time.Duration
+}
+
+// SectorETAs returns ETAs of in-flight sectors of the miner, or of all miners if
+// spID is 0, based on average stage times recorded in sector events. Failed and
+// completed sectors are left out.
+func (s *SealPoller) SectorETAs(ctx context.Context, spID int64) ([]SectorETA, error) {
+	sectors, err := s.ListSectors(ctx, spID)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := s.PipelineStats(ctx, spID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting stage times: %w", err)
+	}
+
+	var out []SectorETA
+	for _, sector := range sectors {
+		if sector.Failed || sector.Stage == stageDone {
+			continue
+		}
+
+		out = append(out, SectorETA{
+			SpID:         sector.SpID,
+			SectorNumber: sector.SectorNumber,
+			Stage:        sector.Stage,
+			Remaining:    s.remainingTime(sector.Stage, stats.AvgStageTime),
+		})
+	}
+
+	return out, nil
+}
+
+// remainingTime estimates the time a sector needs to complete the pipeline
+// stage and the stages after it, using avg stage times by sector event stage.
+// The stage is counted in full, regardless of how long the sector is in it.
+func (s *SealPoller) remainingTime(stage string, avg map[string]time.Duration) time.Duration {
+	var remaining time.Duration
+	started := false
+	for _, st := range forceAdvanceStages {
+		if st.name == stage {
+			started = true
+		}
+		if !started {
+			continue
+		}
+
+		for _, ev := range s.etaEventStages(st.name) {
+			if d, ok := avg[ev]; ok && d > 0 {
+				remaining += d
+			} else {
+				remaining += s.stageTimeDefaults[ev]
+			}
+		}
+	}
+
+	return remaining
+}
+
+// etaEventStages returns the sector event stages whose times make up the time
+// of a pipeline stage. Message landing is part of the message event stages.
+func (s *SealPoller) etaEventStages(stage string) []string {
+	switch stage {
+	case "trees":
+		if s.splitTrees {
+			return []string{"tree_d", "tree_rc"}
+		}
+		return []string{"trees"}
+	case "precommit_msg_success", "commit_msg_success":
+		return nil
+	default:
+		return []string{stage}
+	}
+}
@@ -0,0 +1,113 @@
+package seal
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+)
+
+// eventStreamBatch is the number of events read from the event log at a time
+const eventStreamBatch = 500
+
+// PipelineEvent is a sector event delivered on the poller event stream, see
+// StreamEvents. Action is one of "task_started", "landed", "retry", "skipped",
+// "failed" or "replaced".
+type PipelineEvent struct {
+	// ID is the position of the event in the event log, increasing with each
+	// event. It can be passed to StreamEvents to resume a stream after it.
+	ID           int64 `db:"id"`
+	SpID         int64 `db:"sp_id"`
+	SectorNumber int64 `db:"sector_number"`
+
+	SectorPipelineEvent
+}
+
+// StreamEvents returns a channel receiving sector events of the sectors the
+// poller services as they are recorded in the event log, starting after the
+// event with the given ID; 0 replays the whole log and a negative ID starts
+// with new events. Events are sent after each poll cycle, so they also include
+// events recorded by pipeline tasks. When the consumer falls more than size
+// events behind, the oldest buffered events are dropped instead of blocking the
+// poller, and counted in PollerHealth.DroppedEvents. Only one stream is kept,
+// calling StreamEvents again closes the channel of the previous one.
+func (s *SealPoller) StreamEvents(ctx context.Context, size int, after int64) (<-chan PipelineEvent, error) {
+	if size < 1 {
+		size = 1
+	}
+
+	if after < 0 {
+		if err := s.db.QueryRow(ctx, `SELECT COALESCE(MAX(id), 0) FROM sector_pipeline_events`).Scan(&after); err != nil {
+			return nil, xerrors.Errorf("getting latest sector event: %w", err)
+		}
+	}
+
+	s.streamLk.Lock()
+	defer s.streamLk.Unlock()
+
+	if s.stream != nil {
+		close(s.stream)
+	}
+
+	s.stream = make(chan PipelineEvent, size)
+	s.streamAfter = after
+	return s.stream, nil
+}
+
+// StopEvents closes the event stream channel, if there is one
+func (s *SealPoller) StopEvents() {
+	s.streamLk.Lock()
+	defer s.streamLk.Unlock()
+
+	if s.stream != nil {
+		close(s.stream)
+		s.stream = nil
+	}
+}
+
+// streamEvents sends events recorded since the last call to the event stream
+func (s *SealPoller) streamEvents(ctx context.Context) error {
+	s.streamLk.Lock()
+	defer s.streamLk.Unlock()
+
+	if s.stream == nil {
+		return nil
+	}
+
+	for {
+		var events []PipelineEvent
+		err := s.db.Select(ctx, &events, `SELECT id, sp_id, sector_number, stage, action, event_time, detail
+			FROM sector_pipeline_events WHERE id > $1 ORDER BY id LIMIT $2`, s.streamAfter, eventStreamBatch)
+		if err != nil {
+			return xerrors.Errorf("getting sector events: %w", err)
+		}
+
+		for _, ev := range events {
+			s.streamAfter = ev.ID
+			if s.servicesSp(ev.SpID) {
+				s.publishEvent(ev)
+			}
+		}
+
+		if len(events) < eventStreamBatch {
+			return nil
+		}
+	}
+}
+
+// publishEvent sends an event to the stream, dropping the oldest buffered event
+// if the stream is full. Must be called with streamLk held.
+func (s *SealPoller) publishEvent(ev PipelineEvent) {
+	for {
+		select {
+		case s.stream <- ev:
+			return
+		default:
+		}
+
+		select {
+		case <-s.stream:
+			s.streamDropped++
+		default:
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package seal
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+)
+
+// sector event actions recorded in sector_pipeline_events
+const (
+	sectorEventTaskStarted  = "task_started"
+	sectorEventLanded       = "landed"
+	sectorEventRetry        = "retry"
+	sectorEventSkipped      = "skipped"
+	sectorEventFailed       = "failed"
+	sectorEventReplaced     = "replaced"
+	sectorEventReconciled   = "reconciled"
+	sectorEventWaitEnrolled = "wait_enrolled"
+)
+
+// pollerStages are the pipeline stage names of each poller, as used in sector
+// events and attempt limit errors
+var pollerStages = [numPollers]string{
+	pollerSDR:          "sdr",
+	pollerTrees:        "trees",
+	pollerTreeD:        "tree_d",
+	pollerTreeRC:       "tree_rc",
+	pollerPrecommitMsg: "precommit_msg",
+	pollerPoRep:        "porep",
+	pollerCommitMsg:    "commit_msg",
+	pollerFinalize:     "finalize",
+	pollerMoveStorage:  "move_storage",
+}
+
+// SectorPipelineEvent is a single decision the seal poller made for a sector
+type SectorPipelineEvent struct {
+	Stage  string    `db:"stage"`
+	Action string    `db:"action"`
+	Time   time.Time `db:"event_time"`
+	Detail string    `db:"detail"`
+}
+
+// recordSectorEvent appends a sector event within the transaction changing
+// the pipeline state
+func recordSectorEvent(tx *harmonydb.Tx, spID, sectorNumber int64, stage, action, detail string) error {
+	_, err := tx.Exec(`INSERT INTO sector_pipeline_events (sp_id, sector_number, stage, action, detail) VALUES ($1, $2, $3, $4, $5)`,
+		spID, sectorNumber, stage, action, detail)
+	if err != nil {
+		return xerrors.Errorf("insert sector_pipeline_events: %w", err)
+	}
+	return nil
+}
+
+// SectorEvents returns the events recorded for a sector, oldest first
+func (s *SealPoller) SectorEvents(ctx context.Context, spID, sectorNumber int64) ([]SectorPipelineEvent, error) {
+	var events []SectorPipelineEvent
+	err := s.db.Select(ctx, &events, `SELECT stage, action, event_time, detail FROM sector_pipeline_events
+		WHERE sp_id = $1 AND sector_number = $2 ORDER BY id`, spID, sectorNumber)
+	if err != nil {
+		return nil, xerrors.Errorf("getting sector events: %w", err)
+	}
+	return events, nil
+}
@@ -0,0 +1,108 @@
+package seal
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/exitcode"
+
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+)
+
+// msgExitDisposition is what the poller does with a sector whose precommit or
+// commit message landed with a non-zero exit code
+type msgExitDisposition int
+
+const (
+	// msgExitTerminal fails the sector
+	msgExitTerminal msgExitDisposition = iota
+	// msgExitRetry resets the message stage so that a new message is sent, up
+	// to MaxTaskAttempts
+	msgExitRetry
+)
+
+// defaultMsgExitDispositions are the exit codes retried unless
+// MsgExitCodeDispositions says otherwise. Other exit codes are terminal.
+var defaultMsgExitDispositions = map[exitcode.ExitCode]msgExitDisposition{
+	exitcode.SysErrInsufficientFunds: msgExitRetry,
+	exitcode.SysErrOutOfGas:          msgExitRetry,
+}
+
+// setMsgExitDispositions applies MsgExitCodeDispositions over the defaults
+func (s *SealPoller) setMsgExitDispositions(dispositions map[string]string) {
+	s.msgExitDispositions = make(map[exitcode.ExitCode]msgExitDisposition, len(defaultMsgExitDispositions)+len(dispositions))
+	for code, d := range defaultMsgExitDispositions {
+		s.msgExitDispositions[code] = d
+	}
+
+	for codeStr, dStr := range dispositions {
+		code, err := strconv.ParseInt(codeStr, 10, 64)
+		if err != nil || code == int64(exitcode.Ok) {
+			s.warnw("ignoring disposition of invalid message exit code", "code", codeStr)
+			continue
+		}
+
+		switch dStr {
+		case "retry":
+			s.msgExitDispositions[exitcode.ExitCode(code)] = msgExitRetry
+		case "terminal":
+			s.msgExitDispositions[exitcode.ExitCode(code)] = msgExitTerminal
+		default:
+			s.warnw("ignoring unknown message exit code disposition", "code", codeStr, "disposition", dStr)
+		}
+	}
+}
+
+// msgExitDisposition returns what to do with a failed message's exit code
+func (s *SealPoller) msgExitDisposition(code exitcode.ExitCode) msgExitDisposition {
+	if s.msgExitDispositions == nil {
+		return defaultMsgExitDispositions[code]
+	}
+	return s.msgExitDispositions[code]
+}
+
+// failMsgExitCode fails a sector whose precommit or commit message landed with
+// a terminal exit code
+func (s *SealPoller) failMsgExitCode(ctx context.Context, task pollTask, poller int, execResult dbExecResult) error {
+	code := exitcode.ExitCode(execResult.ExecutedRcptExitCode)
+	reason := fmt.Sprintf("%s message %s failed with exit code %s", pollerStages[poller], execResult.ExecutedMsgCID, code)
+
+	s.errorw("message failed with terminal exit code, failing sector", "sp", task.SpID, "sector", task.SectorNumber, "stage", pollerStages[poller], "exitcode", code)
+
+	failed, err := s.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		var n int
+		switch poller {
+		case pollerPrecommitMsg:
+			n, err = tx.Exec(`UPDATE sectors_sdr_pipeline
+				SET failed = TRUE, failed_at = NOW(), failed_reason = 'msg_exit_code', failed_reason_msg = $1
+				WHERE sp_id = $2 AND sector_number = $3 AND after_precommit_msg_success = FALSE AND failed = FALSE`,
+				reason, task.SpID, task.SectorNumber)
+		case pollerCommitMsg:
+			n, err = tx.Exec(`UPDATE sectors_sdr_pipeline
+				SET failed = TRUE, failed_at = NOW(), failed_reason = 'msg_exit_code', failed_reason_msg = $1
+				WHERE sp_id = $2 AND sector_number = $3 AND after_commit_msg_success = FALSE AND failed = FALSE`,
+				reason, task.SpID, task.SectorNumber)
+		default:
+			return false, xerrors.Errorf("stage %s doesn't send messages", pollerStages[poller])
+		}
+		if err != nil {
+			return false, xerrors.Errorf("update sectors_sdr_pipeline: %w", err)
+		}
+		if n == 0 {
+			return false, nil
+		}
+
+		if err := recordSectorEvent(tx, task.SpID, task.SectorNumber, pollerStages[poller], sectorEventFailed, reason); err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}, harmonydb.OptionRetry())
+	if failed {
+		s.cycleFailed()
+	}
+	return err
+}
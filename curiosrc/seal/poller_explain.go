@@ -0,0 +1,185 @@
+package seal
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+)
+
+// StageDecision is what the poller would do for a pipeline stage of a sector in
+// a poll cycle
+type StageDecision struct {
+	// Stage is the stage name, as in sector events
+	Stage string
+	// Act is set if the poller would start a task for the stage, fail the
+	// sector in it, or check whether its message landed
+	Act    bool
+	Reason string
+}
+
+// ExplainSector runs the poller stage checks for a sector in dry-run, as of the
+// given chain head, and returns what each stage would do and why, in the order
+// poll visits them. Nothing is written to the database, and the chain isn't
+// queried: checks against chain state are reported as such. All task adders are
+// assumed to be registered.
+func (s *SealPoller) ExplainSector(ctx context.Context, spID, sectorNumber int64, head abi.ChainEpoch) ([]StageDecision, error) {
+	// message in-flight limits are counted over the whole pipeline
+	var tasks []pollTask
+	err := s.db.Select(ctx, &tasks, `SELECT `+pollTaskColumns+`
+    FROM sectors_sdr_pipeline WHERE after_commit_msg_success != TRUE OR after_move_storage != TRUE OR (sp_id = $1 AND sector_number = $2)`,
+		spID, sectorNumber)
+	if err != nil {
+		return nil, xerrors.Errorf("getting pipeline sectors: %w", err)
+	}
+
+	for _, task := range tasks {
+		if task.SpID == spID && task.SectorNumber == sectorNumber {
+			return s.explainTask(task, head, countMsgInFlight(tasks)), nil
+		}
+	}
+
+	return nil, xerrors.Errorf("sector %d of miner %d not in the pipeline", sectorNumber, spID)
+}
+
+// stageCond is a condition for the poller to act on a stage, with the reason
+// the poller doesn't act when it's not met
+type stageCond struct {
+	ok     bool
+	reason string
+}
+
+func cond(ok bool, reason string, args ...any) stageCond {
+	return stageCond{ok: ok, reason: fmt.Sprintf(reason, args...)}
+}
+
+// explainTask mirrors the conditions of the poll stage functions, see
+// ExplainSector
+func (s *SealPoller) explainTask(task pollTask, head abi.ChainEpoch, inFlight msgInFlight) []StageDecision {
+	if task.Failed {
+		return []StageDecision{{Stage: "all", Reason: fmt.Sprintf("sector failed: %s", task.FailedReason)}}
+	}
+
+	var out []StageDecision
+
+	start := func(poller int, attempts int, conds ...stageCond) {
+		stage := pollerStages[poller]
+
+		conds = append(conds,
+			cond(!task.Paused, "sector paused"),
+			cond(!s.stageDisabled[poller].Load(), "stage disabled"))
+		for _, c := range conds {
+			if !c.ok {
+				out = append(out, StageDecision{Stage: stage, Reason: c.reason})
+				return
+			}
+		}
+
+		if attemptsExceeded(s.maxTaskAttempts, attempts) {
+			out = append(out, StageDecision{Stage: stage, Act: true, Reason: fmt.Sprintf("would fail sector, stage attempted %d times", attempts)})
+			return
+		}
+		out = append(out, StageDecision{Stage: stage, Act: true, Reason: "would start task"})
+	}
+	landed := func(poller int, conds ...stageCond) {
+		stage := pollerStages[poller] + "_landed"
+		for _, c := range conds {
+			if !c.ok {
+				out = append(out, StageDecision{Stage: stage, Reason: c.reason})
+				return
+			}
+		}
+		out = append(out, StageDecision{Stage: stage, Act: true, Reason: "would check message_waits for the message landing"})
+	}
+	// actNote adds a note about a chain check to the last decision if it acts
+	actNote := func(note string) {
+		if d := &out[len(out)-1]; d.Act {
+			d.Reason += note
+		}
+	}
+	taskNotSet := func(id *int64) stageCond {
+		if id == nil {
+			return stageCond{ok: true}
+		}
+		return cond(false, "task %d running or queued", *id)
+	}
+
+	start(pollerSDR, task.AttemptsSDR,
+		cond(!task.AfterSDR, "done"),
+		taskNotSet(task.TaskSDR))
+
+	if !s.splitTrees {
+		start(pollerTrees, task.AttemptsTrees,
+			cond(!task.AfterTreeD && !task.AfterTreeC && !task.AfterTreeR, "done"),
+			taskNotSet(task.TaskTreeD), taskNotSet(task.TaskTreeC), taskNotSet(task.TaskTreeR),
+			cond(task.AfterSDR, "waiting for sdr"))
+	} else {
+		start(pollerTreeD, task.AttemptsTrees,
+			cond(!task.AfterTreeD, "done"),
+			taskNotSet(task.TaskTreeD),
+			cond(task.AfterSDR, "waiting for sdr"))
+		start(pollerTreeRC, task.AttemptsTreeRC,
+			cond(!task.AfterTreeC && !task.AfterTreeR, "done"),
+			taskNotSet(task.TaskTreeC), taskNotSet(task.TaskTreeR),
+			cond(task.AfterTreeD && task.AfterSDR, "waiting for tree_d"))
+	}
+
+	start(pollerPrecommitMsg, task.AttemptsPrecommitMsg,
+		cond(!task.AfterPrecommitMsg, "done"),
+		taskNotSet(task.TaskPrecommitMsg),
+		cond(task.afterTrees(), "waiting for trees"),
+		cond(msgStageAllowed(s.maxPrecommitMsgInFlight, inFlight.precommit), "%d precommit messages in flight", inFlight.precommit),
+		cond(msgStageAllowed(s.maxMinerMsgInFlight, inFlight.miner[task.SpID]), "%d messages of the miner in flight", inFlight.miner[task.SpID]))
+	if s.skipExistingPrecommit {
+		actNote(", unless the sector is already precommitted on chain")
+	}
+
+	landed(pollerPrecommitMsg,
+		cond(task.AfterPrecommitMsg, "no precommit message sent"),
+		cond(!task.AfterPrecommitMsgSuccess, "done"))
+
+	porepConds := []stageCond{
+		cond(!task.AfterPoRep, "done"),
+		taskNotSet(task.TaskPoRep),
+		cond(task.AfterTreeR, "waiting for tree_r"),
+		cond(task.afterPrecommitMsgSuccess() && task.SeedEpoch != nil, "waiting for precommit message to land"),
+	}
+	if task.SeedEpoch != nil {
+		eligible := abi.ChainEpoch(*task.SeedEpoch + seedEpochConfidence)
+		porepConds = append(porepConds, cond(head >= eligible, "waiting for seed, %d epochs left", eligible-head))
+	}
+	start(pollerPoRep, task.AttemptsPoRep, porepConds...)
+	if s.checkSeedRandomness {
+		actNote(", if the chain node serves the seed randomness")
+	}
+
+	start(pollerFinalize, task.AttemptsFinalize,
+		cond(!task.AfterFinalize, "done"),
+		taskNotSet(task.TaskFinalize),
+		cond(task.afterPoRep(), "waiting for porep"))
+
+	start(pollerMoveStorage, task.AttemptsMoveStorage,
+		cond(!task.AfterMoveStorage, "done"),
+		taskNotSet(task.TaskMoveStorage),
+		cond(task.afterFinalize(), "waiting for finalize"))
+
+	commitAllowed := msgStageAllowed(s.maxCommitMsgInFlight, inFlight.commit) && msgStageAllowed(s.maxMinerMsgInFlight, inFlight.miner[task.SpID])
+	if !commitAllowed && s.commitMsgUrgentEpochs > 0 && task.SeedEpoch != nil {
+		if expiry, err := precommitExpiry(task); err == nil && expiry-head <= abi.ChainEpoch(s.commitMsgUrgentEpochs) {
+			commitAllowed = true
+		}
+	}
+	start(pollerCommitMsg, task.AttemptsCommitMsg,
+		cond(!task.AfterCommitMsg, "done"),
+		taskNotSet(task.TaskCommitMsg),
+		cond(task.afterPoRep() && len(task.PoRepProof) > 0, "waiting for porep"),
+		cond(commitAllowed, "%d commit messages in flight", inFlight.commit))
+
+	landed(pollerCommitMsg,
+		cond(task.AfterCommitMsg, "no commit message sent"),
+		cond(!task.AfterCommitMsgSuccess, "done"))
+
+	return out
+}
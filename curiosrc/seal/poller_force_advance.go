@@ -0,0 +1,146 @@
+package seal
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+)
+
+// forceAdvanceStage is a pipeline stage which ForceAdvance can mark as done
+type forceAdvanceStage struct {
+	name string
+	done func(t pollTask) bool
+	// advance marks the stage as done, clearing task ids which would block
+	// the stage
+	advance func(ctx context.Context, db *harmonydb.DB, spID, sectorNumber int64) (int, error)
+}
+
+const forceAdvanceWhere = ` WHERE sp_id = $1 AND sector_number = $2 AND failed = FALSE`
+
+// forceAdvanceStages lists pipeline stages in the order ForceAdvance walks
+// them. Stages which normally run in parallel (finalize and commit message)
+// are advanced one after another.
+var forceAdvanceStages = []forceAdvanceStage{
+	{
+		name: "sdr",
+		done: func(t pollTask) bool { return t.AfterSDR },
+		advance: func(ctx context.Context, db *harmonydb.DB, spID, sectorNumber int64) (int, error) {
+			return db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET task_id_sdr = NULL, after_sdr = TRUE`+forceAdvanceWhere, spID, sectorNumber)
+		},
+	},
+	{
+		name: "trees",
+		done: func(t pollTask) bool { return t.AfterTreeD && t.AfterTreeC && t.AfterTreeR },
+		advance: func(ctx context.Context, db *harmonydb.DB, spID, sectorNumber int64) (int, error) {
+			return db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET task_id_tree_d = NULL, task_id_tree_c = NULL, task_id_tree_r = NULL, after_tree_d = TRUE, after_tree_c = TRUE, after_tree_r = TRUE`+forceAdvanceWhere, spID, sectorNumber)
+		},
+	},
+	{
+		name: "precommit_msg",
+		done: func(t pollTask) bool { return t.AfterPrecommitMsg },
+		advance: func(ctx context.Context, db *harmonydb.DB, spID, sectorNumber int64) (int, error) {
+			return db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET task_id_precommit_msg = NULL, after_precommit_msg = TRUE`+forceAdvanceWhere, spID, sectorNumber)
+		},
+	},
+	{
+		name: "precommit_msg_success",
+		done: func(t pollTask) bool { return t.AfterPrecommitMsgSuccess },
+		advance: func(ctx context.Context, db *harmonydb.DB, spID, sectorNumber int64) (int, error) {
+			return db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET after_precommit_msg_success = TRUE`+forceAdvanceWhere, spID, sectorNumber)
+		},
+	},
+	{
+		name: "porep",
+		done: func(t pollTask) bool { return t.AfterPoRep },
+		advance: func(ctx context.Context, db *harmonydb.DB, spID, sectorNumber int64) (int, error) {
+			return db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET task_id_porep = NULL, after_porep = TRUE`+forceAdvanceWhere, spID, sectorNumber)
+		},
+	},
+	{
+		name: "finalize",
+		done: func(t pollTask) bool { return t.AfterFinalize },
+		advance: func(ctx context.Context, db *harmonydb.DB, spID, sectorNumber int64) (int, error) {
+			return db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET task_id_finalize = NULL, after_finalize = TRUE`+forceAdvanceWhere, spID, sectorNumber)
+		},
+	},
+	{
+		name: "move_storage",
+		done: func(t pollTask) bool { return t.AfterMoveStorage },
+		advance: func(ctx context.Context, db *harmonydb.DB, spID, sectorNumber int64) (int, error) {
+			return db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET task_id_move_storage = NULL, after_move_storage = TRUE`+forceAdvanceWhere, spID, sectorNumber)
+		},
+	},
+	{
+		name: "commit_msg",
+		done: func(t pollTask) bool { return t.AfterCommitMsg },
+		advance: func(ctx context.Context, db *harmonydb.DB, spID, sectorNumber int64) (int, error) {
+			return db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET task_id_commit_msg = NULL, after_commit_msg = TRUE`+forceAdvanceWhere, spID, sectorNumber)
+		},
+	},
+	{
+		name: "commit_msg_success",
+		done: func(t pollTask) bool { return t.AfterCommitMsgSuccess },
+		advance: func(ctx context.Context, db *harmonydb.DB, spID, sectorNumber int64) (int, error) {
+			return db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET after_commit_msg_success = TRUE`+forceAdvanceWhere, spID, sectorNumber)
+		},
+	},
+}
+
+// nextForceAdvanceStage returns the first pipeline stage the sector didn't
+// complete yet, or nil if the sector went through the whole pipeline
+func nextForceAdvanceStage(task pollTask) *forceAdvanceStage {
+	for i := range forceAdvanceStages {
+		if !forceAdvanceStages[i].done(task) {
+			return &forceAdvanceStages[i]
+		}
+	}
+	return nil
+}
+
+// SetUnsafeForceAdvance enables ForceAdvance. Only meant for tests and manual
+// recovery, advancing a sector doesn't do any of the actual stage work.
+func (s *SealPoller) SetUnsafeForceAdvance(enable bool) {
+	s.unsafeForceAdvance = enable
+}
+
+// ForceAdvance marks the current pipeline stage of a sector as done, clearing
+// the stage task id, so that the poller moves the sector to the next stage.
+func (s *SealPoller) ForceAdvance(ctx context.Context, spID, sectorNumber int64) error {
+	if !s.unsafeForceAdvance {
+		return xerrors.Errorf("force advance is disabled, it must be enabled explicitly with SetUnsafeForceAdvance")
+	}
+
+	var tasks []pollTask
+	err := s.db.Select(ctx, &tasks, `SELECT `+pollTaskColumns+`
+    FROM sectors_sdr_pipeline WHERE sp_id = $1 AND sector_number = $2`, spID, sectorNumber)
+	if err != nil {
+		return xerrors.Errorf("getting sector pipeline state: %w", err)
+	}
+	if len(tasks) == 0 {
+		return xerrors.Errorf("sector %d of sp %d not found in the pipeline", sectorNumber, spID)
+	}
+
+	task := tasks[0]
+	if task.Failed {
+		return xerrors.Errorf("sector %d of sp %d failed: %s", sectorNumber, spID, task.FailedReason)
+	}
+
+	stage := nextForceAdvanceStage(task)
+	if stage == nil {
+		return xerrors.Errorf("sector %d of sp %d already completed the pipeline", sectorNumber, spID)
+	}
+
+	s.warnw("force advancing sector", "sp", spID, "sector", sectorNumber, "stage", stage.name)
+
+	n, err := stage.advance(ctx, s.db, spID, sectorNumber)
+	if err != nil {
+		return xerrors.Errorf("update sectors_sdr_pipeline: %w", err)
+	}
+	if n != 1 {
+		return xerrors.Errorf("expected to update 1 row, updated %d", n)
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package seal
+
+import (
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+)
+
+const (
+	// unsetPollerWarnAfter is how long sectors must have been eligible for a
+	// stage without a registered task adder before the poller warns about it
+	unsetPollerWarnAfter = 5 * time.Minute
+	// unsetPollerWarnInterval is the minimum time between warnings about the
+	// same stage
+	unsetPollerWarnInterval = 30 * time.Minute
+)
+
+// PollerHealth reports whether the seal poller is alive and making progress
+type PollerHealth struct {
+	// LastPoll is the time the last poll cycle finished successfully, zero if
+	// none did yet
+	LastPoll time.Time
+	// Sectors is the number of pipeline sectors seen by the last successful poll
+	Sectors int
+	// ConsecutiveErrors is the number of poll cycles which failed since the
+	// last successful one
+	ConsecutiveErrors int
+	// UnsetPollers lists pipeline stages without a registered task adder; the
+	// poller won't start tasks for these stages. Stages the poller doesn't use
+	// with its trees configuration aren't listed.
+	UnsetPollers []string
+	// DisabledStages lists pipeline stages disabled with SetStageEnabled
+	DisabledStages []string
+	// DroppedEvents is the number of events dropped from the event stream
+	// because its consumer fell behind
+	DroppedEvents int64
+}
+
+// Health returns the current health of the poller
+func (s *SealPoller) Health() PollerHealth {
+	s.healthLk.Lock()
+	h := s.health
+	s.healthLk.Unlock()
+
+	h.UnsetPollers = nil
+	for i := range s.pollers {
+		if s.pollerUsed(i) && !s.pollers[i].IsSet() {
+			h.UnsetPollers = append(h.UnsetPollers, pollerStages[i])
+		}
+	}
+
+	h.DisabledStages = nil
+	for i := range s.stageDisabled {
+		if s.stageDisabled[i].Load() {
+			h.DisabledStages = append(h.DisabledStages, pollerStages[i])
+		}
+	}
+
+	s.streamLk.Lock()
+	h.DroppedEvents = s.streamDropped
+	s.streamLk.Unlock()
+
+	return h
+}
+
+// pollerUsed returns false for the trees pollers the poller doesn't start tasks
+// for, depending on whether trees are split
+func (s *SealPoller) pollerUsed(poller int) bool {
+	switch poller {
+	case pollerTrees:
+		return !s.splitTrees
+	case pollerTreeD, pollerTreeRC:
+		return s.splitTrees
+	}
+	return true
+}
+
+// recordPoll updates the poller health at the end of a poll cycle
+func (s *SealPoller) recordPoll(sectors int, err error) {
+	s.healthLk.Lock()
+	defer s.healthLk.Unlock()
+
+	if err != nil {
+		s.health.ConsecutiveErrors++
+		return
+	}
+
+	s.health.LastPoll = time.Now()
+	s.health.Sectors = sectors
+	s.health.ConsecutiveErrors = 0
+}
+
+// checkUnsetPollers warns about stages sectors are eligible for, but which have
+// no registered task adder, so that a task missing from the node configuration
+// doesn't stall the pipeline silently. Called by poll with its cycle's tasks.
+func (s *SealPoller) checkUnsetPollers(tasks []pollTask, head abi.ChainEpoch, inFlight msgInFlight, now time.Time) {
+	var unset []int
+	for i := range s.pollers {
+		if s.pollerUsed(i) && !s.pollers[i].IsSet() {
+			unset = append(unset, i)
+		} else {
+			s.unsetEligibleSince[i] = time.Time{}
+		}
+	}
+	if len(unset) == 0 {
+		return
+	}
+
+	var eligible [numPollers]int
+	for _, task := range tasks {
+		decisions := s.explainTask(task, head, inFlight)
+		for _, i := range unset {
+			for _, d := range decisions {
+				if d.Stage == pollerStages[i] && d.Act {
+					eligible[i]++
+				}
+			}
+		}
+	}
+
+	for _, i := range unset {
+		if eligible[i] == 0 {
+			s.unsetEligibleSince[i] = time.Time{}
+			continue
+		}
+		if s.unsetEligibleSince[i].IsZero() {
+			s.unsetEligibleSince[i] = now
+		}
+
+		if now.Sub(s.unsetEligibleSince[i]) < unsetPollerWarnAfter || now.Sub(s.unsetWarnedAt[i]) < unsetPollerWarnInterval {
+			continue
+		}
+		s.unsetWarnedAt[i] = now
+
+		s.warnw("sectors are waiting for a stage without a registered task adder, is the task enabled on any node?",
+			"stage", pollerStages[i],
+			"sectors", eligible[i],
+			"since", s.unsetEligibleSince[i])
+	}
+}
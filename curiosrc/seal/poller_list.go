@@ -0,0 +1,108 @@
+package seal
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// stageDone is the Stage of sectors which went through the whole pipeline
+const stageDone = "done"
+
+// SectorPipelineState is the pipeline progress of a sector
+type SectorPipelineState struct {
+	SpID         int64
+	SectorNumber int64
+
+	// Stage is the first pipeline stage the sector didn't complete, or "done"
+	Stage string
+
+	Failed          bool
+	FailedReason    string `json:",omitempty"`
+	FailedReasonMsg string `json:",omitempty"`
+}
+
+// ListSectors returns the pipeline state of all sectors of the miner, or of all
+// miners if spID is 0, ordered by miner and sector number
+func (s *SealPoller) ListSectors(ctx context.Context, spID int64) ([]SectorPipelineState, error) {
+	var rows []struct {
+		pollTask
+		FailedReasonMsg string `db:"failed_reason_msg"`
+	}
+
+	err := s.db.Select(ctx, &rows, `SELECT `+pollTaskColumns+`, failed_reason_msg
+    FROM sectors_sdr_pipeline WHERE $1 = 0 OR sp_id = $1 ORDER BY sp_id, sector_number`, spID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting sector pipeline state: %w", err)
+	}
+
+	out := make([]SectorPipelineState, len(rows))
+	for i, row := range rows {
+		stage := stageDone
+		if next := nextForceAdvanceStage(row.pollTask); next != nil {
+			stage = next.name
+		}
+
+		out[i] = SectorPipelineState{
+			SpID:            row.SpID,
+			SectorNumber:    row.SectorNumber,
+			Stage:           stage,
+			Failed:          row.Failed,
+			FailedReason:    row.FailedReason,
+			FailedReasonMsg: row.FailedReasonMsg,
+		}
+	}
+
+	return out, nil
+}
+
+// FailedSector is a sector which failed in the pipeline
+type FailedSector struct {
+	SpID         int64
+	SectorNumber int64
+
+	// Stage is the pipeline stage the sector failed in
+	Stage string
+
+	// FailedAt is nil for sectors failed without a recorded time
+	FailedAt        *time.Time
+	FailedReason    string
+	FailedReasonMsg string
+}
+
+// ListFailedSectors returns the failed sectors of the miner, or of all miners if
+// spID is 0, most recently failed first
+func (s *SealPoller) ListFailedSectors(ctx context.Context, spID int64) ([]FailedSector, error) {
+	var rows []struct {
+		pollTask
+		FailedAt        *time.Time `db:"failed_at"`
+		FailedReasonMsg string     `db:"failed_reason_msg"`
+	}
+
+	err := s.db.Select(ctx, &rows, `SELECT `+pollTaskColumns+`, failed_at, failed_reason_msg
+    FROM sectors_sdr_pipeline WHERE failed = TRUE AND ($1 = 0 OR sp_id = $1)
+    ORDER BY failed_at DESC NULLS LAST, sp_id, sector_number`, spID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting failed sectors: %w", err)
+	}
+
+	out := make([]FailedSector, len(rows))
+	for i, row := range rows {
+		stage := stageDone
+		if next := nextForceAdvanceStage(row.pollTask); next != nil {
+			stage = next.name
+		}
+
+		out[i] = FailedSector{
+			SpID:            row.SpID,
+			SectorNumber:    row.SectorNumber,
+			Stage:           stage,
+			FailedAt:        row.FailedAt,
+			FailedReason:    row.FailedReason,
+			FailedReasonMsg: row.FailedReasonMsg,
+		}
+	}
+
+	return out, nil
+}
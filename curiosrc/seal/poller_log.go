@@ -0,0 +1,45 @@
+package seal
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// LogHook receives the events logged by the seal poller, with the level name
+// ("debug", "info", "warn" or "error") and the key-value pairs passed to the
+// logger
+type LogHook func(level, msg string, kv ...any)
+
+// SetLogHook makes the poller pass every event it logs to hook, in addition to
+// the logger, so that embedders can forward them to their own sink. Must be
+// called before RunPoller.
+func (s *SealPoller) SetLogHook(hook LogHook) {
+	s.logHook = hook
+}
+
+// pollerLog is log, reporting the callers of the SealPoller log helpers
+var pollerLog = log.Desugar().WithOptions(zap.AddCallerSkip(2)).Sugar()
+
+func (s *SealPoller) logw(level zapcore.Level, msg string, kv ...any) {
+	pollerLog.Logw(level, msg, kv...)
+
+	if s.logHook != nil {
+		s.logHook(level.String(), msg, kv...)
+	}
+}
+
+func (s *SealPoller) debugw(msg string, kv ...any) {
+	s.logw(zapcore.DebugLevel, msg, kv...)
+}
+
+func (s *SealPoller) infow(msg string, kv ...any) {
+	s.logw(zapcore.InfoLevel, msg, kv...)
+}
+
+func (s *SealPoller) warnw(msg string, kv ...any) {
+	s.logw(zapcore.WarnLevel, msg, kv...)
+}
+
+func (s *SealPoller) errorw(msg string, kv ...any) {
+	s.logw(zapcore.ErrorLevel, msg, kv...)
+}
@@ -0,0 +1,103 @@
+package seal
+
+import (
+	"context"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+)
+
+// MsgReplacer replaces a sent message which didn't land with a message with
+// the same nonce and a higher fee, see message.Sender.Replace
+type MsgReplacer interface {
+	Replace(ctx context.Context, signedCid cid.Cid, mss *api.MessageSendSpec, reason string) error
+}
+
+// SetMsgReplacer makes the poller replace precommit and commit messages which
+// didn't land within Seal.MsgReplaceTimeout, with fee limits of
+// maxPrecommitFee and maxCommitFee. Must be called before RunPoller.
+func (s *SealPoller) SetMsgReplacer(r MsgReplacer, maxPrecommitFee, maxCommitFee types.FIL) {
+	s.msgReplacer = r
+	s.msgReplaceMaxFee = map[int]types.FIL{
+		pollerPrecommitMsg: maxPrecommitFee,
+		pollerCommitMsg:    maxCommitFee,
+	}
+}
+
+// stuckPrecommitMsgQuery returns the precommit message of a sector when it
+// didn't land, and the message last sent at its nonce, either the message
+// itself or its latest replacement, was sent more than $3 seconds ago
+const stuckPrecommitMsgQuery = `SELECT orig.signed_cid FROM sectors_sdr_pipeline sp
+    JOIN message_sends orig ON orig.signed_cid = sp.precommit_msg_cid
+    JOIN message_waits mw ON mw.signed_message_cid = orig.signed_cid
+    JOIN message_sends cur ON cur.from_key = orig.from_key AND cur.nonce = orig.nonce
+    WHERE sp.sp_id = $1 AND sp.sector_number = $2 AND sp.after_precommit_msg_success = FALSE
+        AND mw.executed_tsk_epoch IS NULL
+        AND cur.replaced_by_task_id IS NULL AND cur.send_success = TRUE
+        AND cur.send_time < NOW() - $3::float8 * INTERVAL '1 second'`
+
+// stuckCommitMsgQuery is stuckPrecommitMsgQuery for commit messages
+const stuckCommitMsgQuery = `SELECT orig.signed_cid FROM sectors_sdr_pipeline sp
+    JOIN message_sends orig ON orig.signed_cid = sp.commit_msg_cid
+    JOIN message_waits mw ON mw.signed_message_cid = orig.signed_cid
+    JOIN message_sends cur ON cur.from_key = orig.from_key AND cur.nonce = orig.nonce
+    WHERE sp.sp_id = $1 AND sp.sector_number = $2 AND sp.after_commit_msg_success = FALSE
+        AND mw.executed_tsk_epoch IS NULL
+        AND cur.replaced_by_task_id IS NULL AND cur.send_success = TRUE
+        AND cur.send_time < NOW() - $3::float8 * INTERVAL '1 second'`
+
+// replaceStuckMsg replaces the message of the precommit or commit message
+// stage of a sector when it didn't land within msgReplaceTimeout of being
+// sent, or of its last replacement being sent. The replacement has the same
+// nonce, so it lands instead of the stuck message, and the stage completes on
+// the stuck message's message_waits row, which the message watcher resolves
+// to the replacement.
+func (s *SealPoller) replaceStuckMsg(ctx context.Context, task pollTask, poller int) error {
+	if s.msgReplaceTimeout <= 0 || s.msgReplacer == nil {
+		return nil
+	}
+
+	query := stuckPrecommitMsgQuery
+	if poller == pollerCommitMsg {
+		query = stuckCommitMsgQuery
+	}
+
+	var stuck []struct {
+		Cid string `db:"signed_cid"`
+	}
+	if err := s.db.Select(ctx, &stuck, query, task.SpID, task.SectorNumber, s.msgReplaceTimeout.Seconds()); err != nil {
+		return xerrors.Errorf("select stuck message: %w", err)
+	}
+	if len(stuck) == 0 {
+		return nil
+	}
+
+	mc, err := cid.Parse(stuck[0].Cid)
+	if err != nil {
+		return xerrors.Errorf("parse message cid: %w", err)
+	}
+
+	mss := &api.MessageSendSpec{
+		MaxFee: abi.TokenAmount(s.msgReplaceMaxFee[poller]),
+	}
+	if err := s.msgReplacer.Replace(ctx, mc, mss, pollerStages[poller]+" replace"); err != nil {
+		s.warnw("replacing stuck message failed", "sp", task.SpID, "sector", task.SectorNumber, "stage", pollerStages[poller], "msg", mc, "error", err)
+		return nil
+	}
+
+	s.warnw("message didn't land in time, replacing it", "sp", task.SpID, "sector", task.SectorNumber, "stage", pollerStages[poller], "msg", mc, "timeout", s.msgReplaceTimeout)
+
+	_, err = s.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		if err := recordSectorEvent(tx, task.SpID, task.SectorNumber, pollerStages[poller], sectorEventReplaced, mc.String()); err != nil {
+			return false, err
+		}
+		return true, nil
+	}, harmonydb.OptionRetry())
+	return err
+}
@@ -0,0 +1,124 @@
+package seal
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+)
+
+// retryUnsentPrecommitMsgQuery clears the precommit message task $3 of a
+// sector when the task is gone without having sent a message, and it was
+// started more than $4 seconds ago
+const retryUnsentPrecommitMsgQuery = `UPDATE sectors_sdr_pipeline sp SET task_id_precommit_msg = NULL
+    WHERE sp.sp_id = $1 AND sp.sector_number = $2 AND sp.task_id_precommit_msg = $3
+        AND sp.precommit_msg_cid IS NULL AND sp.after_precommit_msg = FALSE
+        AND NOT EXISTS (SELECT 1 FROM harmony_task ht WHERE ht.id = sp.task_id_precommit_msg)
+        AND EXISTS (SELECT 1 FROM sector_pipeline_events e
+            WHERE e.sp_id = sp.sp_id AND e.sector_number = sp.sector_number AND e.stage = 'precommit_msg'
+                AND e.action = 'task_started' AND e.detail = sp.task_id_precommit_msg::text
+                AND e.event_time < NOW() - $4::float8 * INTERVAL '1 second')`
+
+// retryUnsentCommitMsgQuery is retryUnsentPrecommitMsgQuery for commit messages
+const retryUnsentCommitMsgQuery = `UPDATE sectors_sdr_pipeline sp SET task_id_commit_msg = NULL
+    WHERE sp.sp_id = $1 AND sp.sector_number = $2 AND sp.task_id_commit_msg = $3
+        AND sp.commit_msg_cid IS NULL AND sp.after_commit_msg = FALSE
+        AND NOT EXISTS (SELECT 1 FROM harmony_task ht WHERE ht.id = sp.task_id_commit_msg)
+        AND EXISTS (SELECT 1 FROM sector_pipeline_events e
+            WHERE e.sp_id = sp.sp_id AND e.sector_number = sp.sector_number AND e.stage = 'commit_msg'
+                AND e.action = 'task_started' AND e.detail = sp.task_id_commit_msg::text
+                AND e.event_time < NOW() - $4::float8 * INTERVAL '1 second')`
+
+// retryUnsentMsg clears the task of the precommit or commit message stage of a
+// sector when the task was started more than msgSendTimeout ago and ended
+// without recording a message, e.g. after it failed too many times before
+// sending. The next poll starts a new message task, or fails the sector once
+// the stage ran out of attempts. Tasks which are still queued or running are
+// left alone, as they may still send the message.
+func (s *SealPoller) retryUnsentMsg(ctx context.Context, task pollTask, poller int) error {
+	if s.msgSendTimeout <= 0 {
+		return nil
+	}
+
+	query, taskID := retryUnsentPrecommitMsgQuery, task.TaskPrecommitMsg
+	if poller == pollerCommitMsg {
+		query, taskID = retryUnsentCommitMsgQuery, task.TaskCommitMsg
+	}
+	if taskID == nil {
+		return nil
+	}
+
+	_, err := s.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		n, err := tx.Exec(query, task.SpID, task.SectorNumber, *taskID, s.msgSendTimeout.Seconds())
+		if err != nil {
+			return false, xerrors.Errorf("update sectors_sdr_pipeline to retry unsent message: %w", err)
+		}
+		if n == 0 {
+			return false, nil
+		}
+
+		s.warnw("message task ended without sending a message, retrying", "sp", task.SpID, "sector", task.SectorNumber, "stage", pollerStages[poller], "task", *taskID, "timeout", s.msgSendTimeout)
+
+		if err := recordSectorEvent(tx, task.SpID, task.SectorNumber, pollerStages[poller], sectorEventRetry, fmt.Sprintf("task %d sent no message", *taskID)); err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}, harmonydb.OptionRetry())
+	return err
+}
+
+// enrollPrecommitMsgWaitQuery adds the message_waits row of the precommit
+// message of a sector whose message task ended without adding it, returning
+// the message cid if it was added
+const enrollPrecommitMsgWaitQuery = `INSERT INTO message_waits (signed_message_cid)
+    SELECT precommit_msg_cid FROM sectors_sdr_pipeline
+    WHERE sp_id = $1 AND sector_number = $2 AND precommit_msg_cid IS NOT NULL
+        AND task_id_precommit_msg IS NULL AND after_precommit_msg_success = FALSE
+    ON CONFLICT (signed_message_cid) DO NOTHING
+    RETURNING signed_message_cid`
+
+// enrollCommitMsgWaitQuery is enrollPrecommitMsgWaitQuery for commit messages
+const enrollCommitMsgWaitQuery = `INSERT INTO message_waits (signed_message_cid)
+    SELECT commit_msg_cid FROM sectors_sdr_pipeline
+    WHERE sp_id = $1 AND sector_number = $2 AND commit_msg_cid IS NOT NULL
+        AND task_id_commit_msg IS NULL AND after_commit_msg_success = FALSE
+    ON CONFLICT (signed_message_cid) DO NOTHING
+    RETURNING signed_message_cid`
+
+// enrollMsgWait makes sure the message of the precommit or commit message
+// stage of a sector is watched for landing. Message tasks add the message to
+// message_waits after sending it, which doesn't happen if the task dies in
+// between; without the row the stage would never see the message land. Only
+// messages of finished tasks are enrolled, so that the poller doesn't race the
+// task adding the row.
+func (s *SealPoller) enrollMsgWait(ctx context.Context, task pollTask, poller int) error {
+	query := enrollPrecommitMsgWaitQuery
+	if poller == pollerCommitMsg {
+		query = enrollCommitMsgWaitQuery
+	}
+
+	_, err := s.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		var enrolled []struct {
+			Cid string `db:"signed_message_cid"`
+		}
+		if err := tx.Select(&enrolled, query, task.SpID, task.SectorNumber); err != nil {
+			return false, xerrors.Errorf("insert into message_waits: %w", err)
+		}
+		if len(enrolled) == 0 {
+			return false, nil
+		}
+
+		s.warnw("message wasn't watched for landing, adding it to message_waits",
+			"sp", task.SpID, "sector", task.SectorNumber, "stage", pollerStages[poller], "msg", enrolled[0].Cid)
+
+		if err := recordSectorEvent(tx, task.SpID, task.SectorNumber, pollerStages[poller], sectorEventWaitEnrolled, enrolled[0].Cid); err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}, harmonydb.OptionRetry())
+	return err
+}
@@ -0,0 +1,33 @@
+package seal
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+)
+
+// PauseSector holds a sector in the pipeline without failing it. The poller
+// doesn't start new tasks for a paused sector, while tasks already running
+// finish and messages already sent are still watched until they land.
+func (s *SealPoller) PauseSector(ctx context.Context, spID, sectorNumber int64) error {
+	return s.setPaused(ctx, spID, sectorNumber, true)
+}
+
+// ResumeSector lets the poller start tasks for a sector paused with
+// PauseSector again.
+func (s *SealPoller) ResumeSector(ctx context.Context, spID, sectorNumber int64) error {
+	return s.setPaused(ctx, spID, sectorNumber, false)
+}
+
+func (s *SealPoller) setPaused(ctx context.Context, spID, sectorNumber int64, paused bool) error {
+	n, err := s.db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET paused = $3 WHERE sp_id = $1 AND sector_number = $2`, spID, sectorNumber, paused)
+	if err != nil {
+		return xerrors.Errorf("update sectors_sdr_pipeline: %w", err)
+	}
+	if n != 1 {
+		return xerrors.Errorf("sector %d of sp %d not found in the pipeline", sectorNumber, spID)
+	}
+
+	s.infow("sector pause changed", "sp", spID, "sector", sectorNumber, "paused", paused)
+	return nil
+}
@@ -0,0 +1,146 @@
+package seal
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonytask"
+)
+
+// poRepBatchable returns true if PoRep of sectors with the seal proof can be
+// computed in a batch task. Synthetic PoRep sectors are proven one at a time.
+func poRepBatchable(spt abi.RegisteredSealProof) bool {
+	switch spt {
+	case abi.RegisteredSealProof_StackedDrg2KiBV1_1,
+		abi.RegisteredSealProof_StackedDrg8MiBV1_1,
+		abi.RegisteredSealProof_StackedDrg512MiBV1_1,
+		abi.RegisteredSealProof_StackedDrg32GiBV1_1,
+		abi.RegisteredSealProof_StackedDrg64GiBV1_1:
+		return true
+	default:
+		return false
+	}
+}
+
+type poRepBatchKey struct {
+	spID int64
+	spt  int64
+}
+
+// poRepBatches collects the sectors ready for PoRep in a poll cycle, by miner
+// and seal proof, see startPoRepBatches
+type poRepBatches struct {
+	keys   []poRepBatchKey
+	groups map[poRepBatchKey][]pollTask
+}
+
+func (b *poRepBatches) add(task pollTask) {
+	key := poRepBatchKey{spID: task.SpID, spt: task.RegSealProof}
+	if _, ok := b.groups[key]; !ok {
+		b.keys = append(b.keys, key)
+	}
+	b.groups[key] = append(b.groups[key], task)
+}
+
+// startPoRepBatches starts a PoRep task for each poRepBatchSize sectors of the
+// same miner and seal proof collected in the poll cycle, and one for the rest
+// of each group, so that sectors don't wait for a batch to fill up
+func (s *SealPoller) startPoRepBatches(ctx context.Context) {
+	b := s.poRepBatch
+	s.poRepBatch = nil
+	if b == nil {
+		return
+	}
+
+	for _, key := range b.keys {
+		tasks := b.groups[key]
+		for len(tasks) > 0 {
+			n := s.poRepBatchSize
+			if n > len(tasks) {
+				n = len(tasks)
+			}
+			s.startPoRepTask(ctx, tasks[:n])
+			tasks = tasks[n:]
+		}
+	}
+}
+
+// startPoRepTask starts a single PoRep task for the sectors
+func (s *SealPoller) startPoRepTask(ctx context.Context, tasks []pollTask) {
+	numbers := make([]int64, len(tasks))
+	for i, task := range tasks {
+		numbers[i] = task.SectorNumber
+	}
+
+	s.addTasks(ctx, pollerPoRep, tasks, func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+		n, err := tx.Exec(`UPDATE sectors_sdr_pipeline SET task_id_porep = $1, attempts_porep = attempts_porep + 1 WHERE sp_id = $2 AND sector_number = ANY($3) AND task_id_porep IS NULL AND after_tree_r = TRUE`, id, tasks[0].SpID, numbers)
+		if err != nil {
+			return false, xerrors.Errorf("update sectors_sdr_pipeline: %w", err)
+		}
+		if n != len(tasks) {
+			return false, xerrors.Errorf("expected to update %d rows, updated %d", len(tasks), n)
+		}
+
+		return true, nil
+	})
+}
+
+// recoverPoRepBatches rolls back PoRep tasks which are gone without having
+// proven all of their sectors, e.g. when the node crashed while running a batch
+// and the task then ran out of retries, or was removed. Sectors the task did
+// prove keep their proof; the others are released to be batched again by the
+// next cycles. Run once by the first poll cycle of the poller.
+func (s *SealPoller) recoverPoRepBatches(ctx context.Context) error {
+	var released int
+	_, err := s.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		released = 0
+
+		var orphaned []struct {
+			SpID         int64 `db:"sp_id"`
+			SectorNumber int64 `db:"sector_number"`
+			TaskID       int64 `db:"task_id_porep"`
+		}
+		err = tx.Select(&orphaned, `SELECT sp_id, sector_number, task_id_porep FROM sectors_sdr_pipeline sp
+			WHERE task_id_porep IS NOT NULL AND after_porep = FALSE AND failed = FALSE
+				AND NOT EXISTS (SELECT 1 FROM harmony_task ht WHERE ht.id = sp.task_id_porep)
+			FOR UPDATE`)
+		if err != nil {
+			return false, xerrors.Errorf("getting sectors of gone porep tasks: %w", err)
+		}
+
+		for _, o := range orphaned {
+			if !s.servicesSp(o.SpID) {
+				continue
+			}
+
+			n, err := tx.Exec(`UPDATE sectors_sdr_pipeline SET task_id_porep = NULL
+				WHERE sp_id = $1 AND sector_number = $2 AND task_id_porep = $3 AND after_porep = FALSE`, o.SpID, o.SectorNumber, o.TaskID)
+			if err != nil {
+				return false, xerrors.Errorf("update sectors_sdr_pipeline: %w", err)
+			}
+			if n == 0 {
+				continue
+			}
+
+			if err := recordSectorEvent(tx, o.SpID, o.SectorNumber, pollerStages[pollerPoRep], sectorEventRetry, fmt.Sprintf("task %d gone before proving the sector", o.TaskID)); err != nil {
+				return false, err
+			}
+			released++
+		}
+
+		return released > 0, nil
+	}, harmonydb.OptionRetry())
+	if err != nil {
+		return err
+	}
+
+	if released > 0 {
+		s.warnw("released sectors of porep tasks which are gone", "sectors", released)
+	}
+	return nil
+}
@@ -1,24 +1,36 @@
 package seal
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 
+	"github.com/ipfs/go-cid"
 	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin"
 	"github.com/filecoin-project/go-state-types/exitcode"
 
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
 	"github.com/filecoin-project/lotus/chain/actors/policy"
 	"github.com/filecoin-project/lotus/chain/types"
 	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
 	"github.com/filecoin-project/lotus/lib/harmony/harmonytask"
 )
 
-func (s *SealPoller) pollStartPrecommitMsg(ctx context.Context, task pollTask) {
-	if task.TaskPrecommitMsg == nil && !task.AfterPrecommitMsg && task.afterTrees() && s.pollers[pollerPrecommitMsg].IsSet() {
-		s.pollers[pollerPrecommitMsg].Val(ctx)(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
-			n, err := tx.Exec(`UPDATE sectors_sdr_pipeline SET task_id_precommit_msg = $1 WHERE sp_id = $2 AND sector_number = $3 AND task_id_precommit_msg IS NULL AND after_tree_r = TRUE AND after_tree_d = TRUE`, id, task.SpID, task.SectorNumber)
+func (s *SealPoller) pollStartPrecommitMsg(ctx context.Context, task pollTask, inFlight *msgInFlight) {
+	if task.TaskPrecommitMsg == nil && !task.AfterPrecommitMsg && task.afterTrees() && s.canStart(pollerPrecommitMsg, task) &&
+		!s.precommitOnChain(ctx, task) &&
+		msgStageAllowed(s.maxPrecommitMsgInFlight, inFlight.precommit) &&
+		msgStageAllowed(s.maxMinerMsgInFlight, inFlight.miner[task.SpID]) &&
+		s.checkAttempts(ctx, task, "precommit_msg", task.AttemptsPrecommitMsg) {
+		inFlight.precommit++
+		inFlight.addMiner(task.SpID)
+
+		s.addTask(ctx, pollerPrecommitMsg, task, func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
+			n, err := tx.Exec(`UPDATE sectors_sdr_pipeline SET task_id_precommit_msg = $1, attempts_precommit_msg = attempts_precommit_msg + 1 WHERE sp_id = $2 AND sector_number = $3 AND task_id_precommit_msg IS NULL AND after_tree_r = TRUE AND after_tree_d = TRUE`, id, task.SpID, task.SectorNumber)
 			if err != nil {
 				return false, xerrors.Errorf("update sectors_sdr_pipeline: %w", err)
 			}
@@ -31,6 +43,78 @@ func (s *SealPoller) pollStartPrecommitMsg(ctx context.Context, task pollTask) {
 	}
 }
 
+// precommitOnChain returns true if the sector is already precommitted on chain,
+// in which case the pipeline skips sending the PreCommit message and moves on
+// to waiting for the seed of the existing precommit. Only sectors which never
+// sent a PreCommit message are checked. If the on-chain precommit is for a
+// different sealed CID than the sector's tree_r_cid, the sector is failed.
+func (s *SealPoller) precommitOnChain(ctx context.Context, task pollTask) bool {
+	if !s.skipExistingPrecommit {
+		return false
+	}
+
+	var sealed []struct {
+		TreeRCid *string `db:"tree_r_cid"`
+	}
+	err := s.db.Select(ctx, &sealed, `SELECT tree_r_cid FROM sectors_sdr_pipeline
+		WHERE sp_id = $1 AND sector_number = $2 AND precommit_msg_cid IS NULL`, task.SpID, task.SectorNumber)
+	if err != nil {
+		s.mustPoll(xerrors.Errorf("select tree_r_cid: %w", err))
+		return false
+	}
+	if len(sealed) == 0 || sealed[0].TreeRCid == nil {
+		return false
+	}
+
+	maddr, err := address.NewIDAddress(uint64(task.SpID))
+	if err != nil {
+		s.mustPoll(err)
+		return false
+	}
+
+	pci, err := s.api.StateSectorPreCommitInfo(ctx, maddr, abi.SectorNumber(task.SectorNumber), types.EmptyTSK)
+	if err != nil {
+		s.mustPoll(xerrors.Errorf("get precommit info: %w", err))
+		return false
+	}
+	if pci == nil {
+		return false
+	}
+
+	if pci.Info.SealedCID.String() != *sealed[0].TreeRCid {
+		reason := fmt.Sprintf("on-chain precommit has sealed CID %s, sector has %s", pci.Info.SealedCID, *sealed[0].TreeRCid)
+		s.mustPoll(s.failPrecommitMsgMismatch(ctx, task, reason))
+		return true
+	}
+
+	seedEpoch := pci.PreCommitEpoch + policy.GetPreCommitChallengeDelay()
+
+	s.infow("sector already precommitted on chain, skipping precommit message", "sp", task.SpID, "sector", task.SectorNumber, "precommit_epoch", pci.PreCommitEpoch)
+
+	_, err = s.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		n, err := tx.Exec(`UPDATE sectors_sdr_pipeline SET
+                                seed_epoch = $1, after_precommit_msg = TRUE, after_precommit_msg_success = TRUE
+                            WHERE sp_id = $2 AND sector_number = $3 AND task_id_precommit_msg IS NULL AND after_precommit_msg = FALSE
+                                AND precommit_msg_cid IS NULL`,
+			seedEpoch, task.SpID, task.SectorNumber)
+		if err != nil {
+			return false, xerrors.Errorf("update sectors_sdr_pipeline: %w", err)
+		}
+		if n == 0 {
+			return false, nil
+		}
+
+		if err := recordSectorEvent(tx, task.SpID, task.SectorNumber, pollerStages[pollerPrecommitMsg], sectorEventSkipped, "precommit already on chain"); err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}, harmonydb.OptionRetry())
+	s.mustPoll(err)
+
+	return true
+}
+
 type dbExecResult struct {
 	PrecommitMsgCID *string `db:"precommit_msg_cid"`
 	CommitMsgCID    *string `db:"commit_msg_cid"`
@@ -52,7 +136,16 @@ func (s *SealPoller) pollPrecommitMsgLanded(ctx context.Context, task pollTask)
 					JOIN message_waits ON spipeline.precommit_msg_cid = message_waits.signed_message_cid
 					WHERE sp_id = $1 AND sector_number = $2 AND executed_tsk_epoch IS NOT NULL`, task.SpID, task.SectorNumber)
 		if err != nil {
-			log.Errorw("failed to query message_waits", "error", err)
+			s.errorw("failed to query message_waits", "error", err)
+		}
+
+		if err == nil && len(execResult) == 0 {
+			if task.TaskPrecommitMsg == nil {
+				if err := s.enrollMsgWait(ctx, task, pollerPrecommitMsg); err != nil {
+					return err
+				}
+			}
+			return s.replaceStuckMsg(ctx, task, pollerPrecommitMsg)
 		}
 
 		if len(execResult) > 0 {
@@ -65,6 +158,16 @@ func (s *SealPoller) pollPrecommitMsgLanded(ctx context.Context, task pollTask)
 				return err
 			}
 
+			if s.verifyPrecommitMsg {
+				mismatch, err := s.precommitMsgMismatch(ctx, maddr, task, execResult[0].ExecutedMsgCID)
+				if err != nil {
+					return err
+				}
+				if mismatch != "" {
+					return s.failPrecommitMsgMismatch(ctx, task, mismatch)
+				}
+			}
+
 			pci, err := s.api.StateSectorPreCommitInfo(ctx, maddr, abi.SectorNumber(task.SectorNumber), types.EmptyTSK)
 			if err != nil {
 				return xerrors.Errorf("get precommit info: %w", err)
@@ -73,12 +176,30 @@ func (s *SealPoller) pollPrecommitMsgLanded(ctx context.Context, task pollTask)
 			if pci != nil {
 				randHeight := pci.PreCommitEpoch + policy.GetPreCommitChallengeDelay()
 
-				_, err := s.db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET 
-                                seed_epoch = $1, precommit_msg_tsk = $2, after_precommit_msg_success = TRUE 
-                            WHERE sp_id = $3 AND sector_number = $4 AND seed_epoch IS NULL`,
-					randHeight, execResult[0].ExecutedTskCID, task.SpID, task.SectorNumber)
+				landed, err := s.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+					n, err := tx.Exec(`UPDATE sectors_sdr_pipeline SET 
+                                seed_epoch = $1, precommit_msg_tsk = $2, precommit_msg_gas_used = $3, after_precommit_msg_success = TRUE 
+                            WHERE sp_id = $4 AND sector_number = $5 AND seed_epoch IS NULL`,
+						randHeight, execResult[0].ExecutedTskCID, execResult[0].ExecutedRcptGasUsed, task.SpID, task.SectorNumber)
+					if err != nil {
+						return false, xerrors.Errorf("update sectors_sdr_pipeline: %w", err)
+					}
+					if n == 0 {
+						return false, nil
+					}
+
+					if err := recordSectorEvent(tx, task.SpID, task.SectorNumber, pollerStages[pollerPrecommitMsg], sectorEventLanded, execResult[0].ExecutedTskCID); err != nil {
+						return false, err
+					}
+
+					return true, nil
+				}, harmonydb.OptionRetry())
 				if err != nil {
-					return xerrors.Errorf("update sectors_sdr_pipeline: %w", err)
+					return err
+				}
+				if landed {
+					s.cycleLanded()
+					s.debugw("precommit message landed", "sp", task.SpID, "sector", task.SectorNumber, "seed_epoch", randHeight)
 				}
 			} // todo handle missing precommit info (eg expired precommit)
 
@@ -88,16 +209,72 @@ func (s *SealPoller) pollPrecommitMsgLanded(ctx context.Context, task pollTask)
 	return nil
 }
 
+// precommitMsgMismatch fetches the landed precommit message and returns why it
+// doesn't precommit the sector, or an empty string if it does
+func (s *SealPoller) precommitMsgMismatch(ctx context.Context, maddr address.Address, task pollTask, msgCid string) (string, error) {
+	mc, err := cid.Parse(msgCid)
+	if err != nil {
+		return "", xerrors.Errorf("parse executed precommit msg cid: %w", err)
+	}
+
+	msg, err := s.api.ChainGetMessage(ctx, mc)
+	if err != nil {
+		return "", xerrors.Errorf("get precommit message: %w", err)
+	}
+
+	if msg.To != maddr {
+		return fmt.Sprintf("message %s sent to %s, not %s", mc, msg.To, maddr), nil
+	}
+	if msg.Method != builtin.MethodsMiner.PreCommitSectorBatch2 {
+		return fmt.Sprintf("message %s calls method %d, not PreCommitSectorBatch2", mc, msg.Method), nil
+	}
+
+	var params miner.PreCommitSectorBatchParams2
+	if err := params.UnmarshalCBOR(bytes.NewReader(msg.Params)); err != nil {
+		return fmt.Sprintf("message %s params not PreCommitSectorBatchParams2: %s", mc, err), nil
+	}
+
+	for _, sector := range params.Sectors {
+		if sector.SectorNumber == abi.SectorNumber(task.SectorNumber) {
+			return "", nil
+		}
+	}
+
+	return fmt.Sprintf("message %s doesn't precommit sector %d", mc, task.SectorNumber), nil
+}
+
+func (s *SealPoller) failPrecommitMsgMismatch(ctx context.Context, task pollTask, reason string) error {
+	s.errorw("on-chain precommit doesn't match sector, failing sector", "sp", task.SpID, "sector", task.SectorNumber, "reason", reason)
+
+	failed, err := s.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		n, err := tx.Exec(`UPDATE sectors_sdr_pipeline
+			SET failed = TRUE, failed_at = NOW(), failed_reason = 'precommit_mismatch', failed_reason_msg = $1
+			WHERE sp_id = $2 AND sector_number = $3 AND after_precommit_msg_success = FALSE AND failed = FALSE`,
+			reason, task.SpID, task.SectorNumber)
+		if err != nil {
+			return false, xerrors.Errorf("update sectors_sdr_pipeline: %w", err)
+		}
+		if n == 0 {
+			return false, nil
+		}
+
+		if err := recordSectorEvent(tx, task.SpID, task.SectorNumber, pollerStages[pollerPrecommitMsg], sectorEventFailed, reason); err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}, harmonydb.OptionRetry())
+	if failed {
+		s.cycleFailed()
+	}
+	return err
+}
+
 func (s *SealPoller) pollPrecommitMsgFail(ctx context.Context, task pollTask, execResult dbExecResult) error {
-	switch exitcode.ExitCode(execResult.ExecutedRcptExitCode) {
-	case exitcode.SysErrInsufficientFunds:
-		fallthrough
-	case exitcode.SysErrOutOfGas:
-		// just retry
+	if s.msgExitDisposition(exitcode.ExitCode(execResult.ExecutedRcptExitCode)) == msgExitRetry {
 		return s.pollRetryPrecommitMsgSend(ctx, task, execResult)
-	default:
-		return xerrors.Errorf("precommit message failed with exit code %s", exitcode.ExitCode(execResult.ExecutedRcptExitCode))
 	}
+	return s.failMsgExitCode(ctx, task, pollerPrecommitMsg, execResult)
 }
 
 func (s *SealPoller) pollRetryPrecommitMsgSend(ctx context.Context, task pollTask, execResult dbExecResult) error {
@@ -107,13 +284,23 @@ func (s *SealPoller) pollRetryPrecommitMsgSend(ctx context.Context, task pollTas
 
 	// make the pipeline entry seem like precommit send didn't happen, next poll loop will retry
 
-	_, err := s.db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET
+	_, err := s.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		n, err := tx.Exec(`UPDATE sectors_sdr_pipeline SET
                                 precommit_msg_cid = NULL, task_id_precommit_msg = NULL, after_precommit_msg = FALSE
                             	WHERE precommit_msg_cid = $1 AND sp_id = $2 AND sector_number = $3 AND after_precommit_msg_success = FALSE`,
-		*execResult.PrecommitMsgCID, task.SpID, task.SectorNumber)
-	if err != nil {
-		return xerrors.Errorf("update sectors_sdr_pipeline to retry precommit msg send: %w", err)
-	}
+			*execResult.PrecommitMsgCID, task.SpID, task.SectorNumber)
+		if err != nil {
+			return false, xerrors.Errorf("update sectors_sdr_pipeline to retry precommit msg send: %w", err)
+		}
+		if n == 0 {
+			return false, nil
+		}
 
-	return nil
+		if err := recordSectorEvent(tx, task.SpID, task.SectorNumber, pollerStages[pollerPrecommitMsg], sectorEventRetry, exitcode.ExitCode(execResult.ExecutedRcptExitCode).String()); err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}, harmonydb.OptionRetry())
+	return err
 }
@@ -0,0 +1,160 @@
+package seal
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/actors/policy"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+)
+
+// chainSectorsAPI lists the sectors of a miner, used by ReconcileFromChain
+type chainSectorsAPI interface {
+	StateMinerAllocated(context.Context, address.Address, types.TipSetKey) (*bitfield.BitField, error)
+	StateMinerSectors(context.Context, address.Address, *bitfield.BitField, types.TipSetKey) ([]*miner.SectorOnChainInfo, error)
+}
+
+// reconcileCommittedQuery inserts or completes the pipeline row of a sector
+// committed on chain
+const reconcileCommittedQuery = `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof, tree_r_cid,
+        after_sdr, after_tree_d, after_tree_c, after_tree_r, after_precommit_msg, after_precommit_msg_success,
+        after_porep, after_finalize, after_move_storage, after_commit_msg, after_commit_msg_success)
+    VALUES ($1, $2, $3, $4, TRUE, TRUE, TRUE, TRUE, TRUE, TRUE, TRUE, TRUE, TRUE, TRUE, TRUE)
+    ON CONFLICT (sp_id, sector_number) DO UPDATE SET
+        after_sdr = TRUE, after_tree_d = TRUE, after_tree_c = TRUE, after_tree_r = TRUE,
+        after_precommit_msg = TRUE, after_precommit_msg_success = TRUE, after_porep = TRUE,
+        after_finalize = TRUE, after_move_storage = TRUE, after_commit_msg = TRUE, after_commit_msg_success = TRUE
+    WHERE NOT (sectors_sdr_pipeline.after_sdr AND sectors_sdr_pipeline.after_tree_d AND sectors_sdr_pipeline.after_tree_c
+        AND sectors_sdr_pipeline.after_tree_r AND sectors_sdr_pipeline.after_precommit_msg
+        AND sectors_sdr_pipeline.after_precommit_msg_success AND sectors_sdr_pipeline.after_porep
+        AND sectors_sdr_pipeline.after_finalize AND sectors_sdr_pipeline.after_move_storage
+        AND sectors_sdr_pipeline.after_commit_msg AND sectors_sdr_pipeline.after_commit_msg_success)`
+
+// reconcilePrecommittedQuery inserts or advances the pipeline row of a sector
+// precommitted on chain to waiting for PoRep, with the seed epoch of the
+// on-chain precommit
+const reconcilePrecommittedQuery = `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof,
+        ticket_epoch, tree_d_cid, tree_r_cid, seed_epoch,
+        after_sdr, after_tree_d, after_tree_c, after_tree_r, after_precommit_msg, after_precommit_msg_success)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, TRUE, TRUE, TRUE, TRUE, TRUE, TRUE)
+    ON CONFLICT (sp_id, sector_number) DO UPDATE SET
+        seed_epoch = EXCLUDED.seed_epoch,
+        after_sdr = TRUE, after_tree_d = TRUE, after_tree_c = TRUE, after_tree_r = TRUE,
+        after_precommit_msg = TRUE, after_precommit_msg_success = TRUE
+    WHERE sectors_sdr_pipeline.seed_epoch IS DISTINCT FROM EXCLUDED.seed_epoch
+        OR NOT (sectors_sdr_pipeline.after_sdr AND sectors_sdr_pipeline.after_tree_d AND sectors_sdr_pipeline.after_tree_c
+            AND sectors_sdr_pipeline.after_tree_r AND sectors_sdr_pipeline.after_precommit_msg
+            AND sectors_sdr_pipeline.after_precommit_msg_success)`
+
+// ReconcileFromChain backfills and corrects pipeline rows of the miner from its
+// on-chain state, e.g. after the database was lost. Sectors committed on chain
+// are marked as having gone through the whole pipeline. Sectors precommitted on
+// chain are moved to waiting for PoRep, with the seed epoch of the precommit.
+// Allocated sector numbers which are neither are left alone.
+//
+// Rows are only written when they don't match the chain yet, so reconciling
+// again changes nothing. Failed sectors stay failed. Rows inserted for
+// precommitted sectors don't have the sector ticket or pieces, which PoRep and
+// later stages need, so these have to be restored separately, as does the
+// sector data on storage.
+func (s *SealPoller) ReconcileFromChain(ctx context.Context, spID int64) error {
+	if s.chainSectors == nil {
+		return xerrors.Errorf("the chain API doesn't support listing miner sectors")
+	}
+
+	maddr, err := address.NewIDAddress(uint64(spID))
+	if err != nil {
+		return err
+	}
+
+	ts, err := s.api.ChainHead(ctx)
+	if err != nil {
+		return xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	committed, err := s.chainSectors.StateMinerSectors(ctx, maddr, nil, ts.Key())
+	if err != nil {
+		return xerrors.Errorf("getting miner sectors: %w", err)
+	}
+
+	allocated, err := s.chainSectors.StateMinerAllocated(ctx, maddr, ts.Key())
+	if err != nil {
+		return xerrors.Errorf("getting allocated sectors: %w", err)
+	}
+
+	isCommitted := map[abi.SectorNumber]struct{}{}
+	for _, si := range committed {
+		isCommitted[si.SectorNumber] = struct{}{}
+
+		if err := s.reconcileSector(ctx, spID, si.SectorNumber, pollerStages[pollerCommitMsg], "sector committed on chain", reconcileCommittedQuery,
+			spID, int64(si.SectorNumber), int64(si.SealProof), si.SealedCID.String()); err != nil {
+			return err
+		}
+	}
+
+	var reconciledPrecommits int
+	err = allocated.ForEach(func(n uint64) error {
+		if _, ok := isCommitted[abi.SectorNumber(n)]; ok {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		pci, err := s.api.StateSectorPreCommitInfo(ctx, maddr, abi.SectorNumber(n), ts.Key())
+		if err != nil {
+			return xerrors.Errorf("getting precommit info of sector %d: %w", n, err)
+		}
+		if pci == nil {
+			return nil
+		}
+
+		var unsealed *string
+		if pci.Info.UnsealedCid != nil {
+			c := pci.Info.UnsealedCid.String()
+			unsealed = &c
+		}
+		seedEpoch := pci.PreCommitEpoch + policy.GetPreCommitChallengeDelay()
+
+		reconciledPrecommits++
+		return s.reconcileSector(ctx, spID, abi.SectorNumber(n), pollerStages[pollerPrecommitMsg], "sector precommitted on chain", reconcilePrecommittedQuery,
+			spID, int64(n), int64(pci.Info.SealProof), int64(pci.Info.SealRandEpoch), unsealed, pci.Info.SealedCID.String(), int64(seedEpoch))
+	})
+	if err != nil {
+		return err
+	}
+
+	s.infow("reconciled pipeline from chain", "sp", spID, "committed", len(committed), "precommitted", reconciledPrecommits)
+	return nil
+}
+
+// reconcileSector runs a reconcile query for a sector, recording a sector event
+// if it changed the pipeline row
+func (s *SealPoller) reconcileSector(ctx context.Context, spID int64, sector abi.SectorNumber, stage, detail, query string, args ...any) error {
+	_, err := s.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		n, err := tx.Exec(query, args...)
+		if err != nil {
+			return false, xerrors.Errorf("update sectors_sdr_pipeline: %w", err)
+		}
+		if n == 0 {
+			return false, nil
+		}
+
+		if err := recordSectorEvent(tx, spID, int64(sector), stage, sectorEventReconciled, detail); err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}, harmonydb.OptionRetry())
+	if err != nil {
+		return xerrors.Errorf("reconciling sector %d: %w", sector, err)
+	}
+	return nil
+}
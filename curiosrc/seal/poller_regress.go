@@ -0,0 +1,72 @@
+package seal
+
+import (
+	"github.com/filecoin-project/go-state-types/abi"
+)
+
+// stageFlags are the after_* columns of a pipeline row, in the order of
+// stageFlagNames
+type stageFlags uint16
+
+var stageFlagNames = []string{
+	"after_sdr", "after_tree_d", "after_tree_c", "after_tree_r",
+	"after_precommit_msg", "after_precommit_msg_success", "after_porep",
+	"after_finalize", "after_move_storage", "after_commit_msg", "after_commit_msg_success",
+}
+
+const (
+	flagPrecommitMsg        = 4
+	flagPrecommitMsgSuccess = 5
+	flagCommitMsg           = 9
+	flagCommitMsgSuccess    = 10
+)
+
+func (t pollTask) stageFlags() stageFlags {
+	var f stageFlags
+	for i, set := range []bool{
+		t.AfterSDR, t.AfterTreeD, t.AfterTreeC, t.AfterTreeR,
+		t.AfterPrecommitMsg, t.AfterPrecommitMsgSuccess, t.AfterPoRep,
+		t.AfterFinalize, t.AfterMoveStorage, t.AfterCommitMsg, t.AfterCommitMsgSuccess,
+	} {
+		if set {
+			f |= 1 << i
+		}
+	}
+	return f
+}
+
+// checkStageRegressions warns about after_* flags of sectors which were set in
+// the previous poll cycle and aren't anymore, which the pipeline never does on
+// its own, except for the message stages: these are reset to resend messages
+// which failed, see pollRetryPrecommitMsgSend. Only sectors still in the
+// pipeline are remembered, so the memory used is bounded by the number of
+// sectors in flight.
+func (s *SealPoller) checkStageRegressions(tasks []pollTask) {
+	prev := s.prevStageFlags
+	s.prevStageFlags = make(map[abi.SectorID]stageFlags, len(tasks))
+
+	for _, task := range tasks {
+		id := abi.SectorID{Miner: abi.ActorID(task.SpID), Number: abi.SectorNumber(task.SectorNumber)}
+		cur := task.stageFlags()
+		s.prevStageFlags[id] = cur
+
+		was, ok := prev[id]
+		if !ok {
+			continue
+		}
+
+		lost := was &^ cur
+		if cur&(1<<flagPrecommitMsgSuccess) == 0 {
+			lost &^= 1 << flagPrecommitMsg
+		}
+		if cur&(1<<flagCommitMsgSuccess) == 0 {
+			lost &^= 1 << flagCommitMsg
+		}
+
+		for i, name := range stageFlagNames {
+			if lost&(1<<i) != 0 {
+				s.warnw("pipeline stage flag regressed since the last poll", "sp", task.SpID, "sector", task.SectorNumber, "flag", name)
+			}
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package seal
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// pipelineSchema maps the sectors_sdr_pipeline columns the poller reads or
+// writes to their postgres type names (information_schema udt_name)
+var pipelineSchema = map[string]string{
+	"sp_id":          "int8",
+	"sector_number":  "int8",
+	"create_time":    "timestamp",
+	"reg_seal_proof": "int4",
+	"ticket_epoch":   "int8",
+
+	"task_id_sdr":           "int8",
+	"task_id_tree_d":        "int8",
+	"task_id_tree_c":        "int8",
+	"task_id_tree_r":        "int8",
+	"task_id_precommit_msg": "int8",
+	"task_id_porep":         "int8",
+	"task_id_finalize":      "int8",
+	"task_id_move_storage":  "int8",
+	"task_id_commit_msg":    "int8",
+
+	"after_sdr":                   "bool",
+	"after_tree_d":                "bool",
+	"after_tree_c":                "bool",
+	"after_tree_r":                "bool",
+	"after_precommit_msg":         "bool",
+	"after_precommit_msg_success": "bool",
+	"after_porep":                 "bool",
+	"after_finalize":              "bool",
+	"after_move_storage":          "bool",
+	"after_commit_msg":            "bool",
+	"after_commit_msg_success":    "bool",
+
+	"tree_d_cid":             "text",
+	"tree_r_cid":             "text",
+	"precommit_msg_cid":      "text",
+	"precommit_msg_tsk":      "bytea",
+	"precommit_msg_gas_used": "int8",
+	"seed_epoch":             "int8",
+	"porep_proof":            "bytea",
+	"commit_msg_cid":         "text",
+	"commit_msg_tsk":         "bytea",
+	"commit_msg_gas_used":    "int8",
+
+	"failed":            "bool",
+	"failed_at":         "timestamp",
+	"failed_reason":     "varchar",
+	"failed_reason_msg": "text",
+	"paused":            "bool",
+	"trace_id":          "text",
+
+	"attempts_sdr":           "int4",
+	"attempts_trees":         "int4",
+	"attempts_tree_rc":       "int4",
+	"attempts_precommit_msg": "int4",
+	"attempts_porep":         "int4",
+	"attempts_finalize":      "int4",
+	"attempts_move_storage":  "int4",
+	"attempts_commit_msg":    "int4",
+}
+
+// ValidateSchema checks that the sectors_sdr_pipeline table has every column
+// the poller uses, with the expected type. The returned error lists all
+// missing and mismatched columns.
+func (s *SealPoller) ValidateSchema(ctx context.Context) error {
+	var columns []struct {
+		Name string `db:"column_name"`
+		Type string `db:"udt_name"`
+	}
+	err := s.db.Select(ctx, &columns, `SELECT column_name, udt_name FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = 'sectors_sdr_pipeline'`)
+	if err != nil {
+		return xerrors.Errorf("getting sectors_sdr_pipeline columns: %w", err)
+	}
+
+	have := make(map[string]string, len(columns))
+	for _, c := range columns {
+		have[c.Name] = c.Type
+	}
+
+	return checkPipelineSchema(have)
+}
+
+// checkPipelineSchema compares table columns, by name, with pipelineSchema
+func checkPipelineSchema(have map[string]string) error {
+	var problems []string
+	for name, want := range pipelineSchema {
+		got, ok := have[name]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("missing column %s (%s)", name, want))
+		case got != want:
+			problems = append(problems, fmt.Sprintf("column %s has type %s, expected %s", name, got, want))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+
+	sort.Strings(problems)
+	return xerrors.Errorf("sectors_sdr_pipeline schema doesn't match the seal poller: %s", strings.Join(problems, "; "))
+}
@@ -0,0 +1,67 @@
+package seal
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+)
+
+// SeedWait is a sector with a landed precommit which is waiting for its seed
+// epoch before PoRep can start
+type SeedWait struct {
+	SpID         int64
+	SectorNumber int64
+
+	SeedEpoch abi.ChainEpoch
+	Head      abi.ChainEpoch
+
+	// Remaining is the number of epochs until the sector is eligible for PoRep,
+	// including the seed confidence
+	Remaining abi.ChainEpoch
+}
+
+// ListAwaitingSeed returns the sectors of the miner, or of all miners if spID
+// is 0, which are blocked waiting on the seed epoch, soonest eligible first
+func (s *SealPoller) ListAwaitingSeed(ctx context.Context, spID int64) ([]SeedWait, error) {
+	ts, err := s.api.ChainHead(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	var rows []struct {
+		SpID         int64 `db:"sp_id"`
+		SectorNumber int64 `db:"sector_number"`
+		SeedEpoch    int64 `db:"seed_epoch"`
+	}
+
+	err = s.db.Select(ctx, &rows, `SELECT sp_id, sector_number, seed_epoch
+    FROM sectors_sdr_pipeline
+    WHERE after_precommit_msg_success = TRUE AND seed_epoch IS NOT NULL AND after_porep = FALSE AND failed = FALSE
+      AND ($1 = 0 OR sp_id = $1)
+    ORDER BY seed_epoch, sp_id, sector_number`, spID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting sectors awaiting seed: %w", err)
+	}
+
+	head := ts.Height()
+
+	var out []SeedWait
+	for _, row := range rows {
+		eligible := abi.ChainEpoch(row.SeedEpoch + seedEpochConfidence)
+		if head >= eligible {
+			continue
+		}
+
+		out = append(out, SeedWait{
+			SpID:         row.SpID,
+			SectorNumber: row.SectorNumber,
+			SeedEpoch:    abi.ChainEpoch(row.SeedEpoch),
+			Head:         head,
+			Remaining:    eligible - head,
+		})
+	}
+
+	return out, nil
+}
@@ -0,0 +1,77 @@
+package seal
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/chain/actors/policy"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+)
+
+// seedEpochMissing is true for sectors with a landed precommit but no seed
+// epoch, e.g. after a partially failed update. PoRep never starts for them.
+func (t pollTask) seedEpochMissing() bool {
+	return t.AfterPrecommitMsgSuccess && t.SeedEpoch == nil && !t.AfterPoRep
+}
+
+// pollMissingSeedEpoch warns about sectors with a landed precommit and no seed
+// epoch. With recoverSeedEpoch set, the seed epoch is derived again from the
+// on-chain precommit info.
+func (s *SealPoller) pollMissingSeedEpoch(ctx context.Context, task pollTask) error {
+	if !task.seedEpochMissing() {
+		return nil
+	}
+
+	s.warnw("sector precommit landed but seed epoch is missing, porep can't start", "sp", task.SpID, "sector", task.SectorNumber, "recover", s.recoverSeedEpoch)
+	if !s.recoverSeedEpoch {
+		return nil
+	}
+
+	maddr, err := address.NewIDAddress(uint64(task.SpID))
+	if err != nil {
+		return err
+	}
+
+	pci, err := s.api.StateSectorPreCommitInfo(ctx, maddr, abi.SectorNumber(task.SectorNumber), types.EmptyTSK)
+	if err != nil {
+		return xerrors.Errorf("get precommit info: %w", err)
+	}
+	if pci == nil {
+		s.warnw("no on-chain precommit to recover seed epoch from", "sp", task.SpID, "sector", task.SectorNumber)
+		return nil
+	}
+
+	seedEpoch := pci.PreCommitEpoch + policy.GetPreCommitChallengeDelay()
+
+	recovered, err := s.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		n, err := tx.Exec(`UPDATE sectors_sdr_pipeline SET seed_epoch = $1
+                            WHERE sp_id = $2 AND sector_number = $3 AND seed_epoch IS NULL AND after_precommit_msg_success = TRUE`,
+			seedEpoch, task.SpID, task.SectorNumber)
+		if err != nil {
+			return false, xerrors.Errorf("update sectors_sdr_pipeline: %w", err)
+		}
+		if n == 0 {
+			return false, nil
+		}
+
+		if err := recordSectorEvent(tx, task.SpID, task.SectorNumber, pollerStages[pollerPrecommitMsg], sectorEventReconciled, fmt.Sprintf("seed epoch %d recovered from precommit", seedEpoch)); err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}, harmonydb.OptionRetry())
+	if err != nil {
+		return err
+	}
+	if recovered {
+		s.infow("recovered missing seed epoch", "sp", task.SpID, "sector", task.SectorNumber, "seed_epoch", seedEpoch)
+	}
+
+	return nil
+}
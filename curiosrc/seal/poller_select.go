@@ -0,0 +1,86 @@
+package seal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"golang.org/x/xerrors"
+)
+
+const (
+	// pollSelectAttempts is the number of times the pipeline select of a poll
+	// cycle is tried on transient database errors
+	pollSelectAttempts = 3
+
+	// pollSelectBackoff is the wait before the first retry of the pipeline
+	// select, doubled for each further retry
+	pollSelectBackoff = 250 * time.Millisecond
+)
+
+// selectPollTasks loads the pipeline sectors the poller services
+func (s *SealPoller) selectPollTasks(ctx context.Context, tasks *[]pollTask) error {
+	if len(s.spIDs) == 0 {
+		return s.db.Select(ctx, tasks, `SELECT `+pollTaskColumns+`
+    FROM sectors_sdr_pipeline WHERE after_commit_msg_success != TRUE OR after_move_storage != TRUE`)
+	}
+	return s.db.Select(ctx, tasks, `SELECT `+pollTaskColumns+`
+    FROM sectors_sdr_pipeline WHERE (after_commit_msg_success != TRUE OR after_move_storage != TRUE) AND sp_id = ANY($1)`, s.spIDs)
+}
+
+// selectPollTasksRetry runs s.selectTasks, retrying it with a backoff on
+// transient database errors, so that a momentary database blip doesn't skip
+// a whole poll cycle. Other errors, and ctx being cancelled, end it at once.
+func (s *SealPoller) selectPollTasksRetry(ctx context.Context) ([]pollTask, error) {
+	backoff := pollSelectBackoff
+
+	for attempt := 1; ; attempt++ {
+		var tasks []pollTask
+		err := s.selectTasks(ctx, &tasks)
+		if err == nil {
+			return tasks, nil
+		}
+		if ctx.Err() != nil || !isTransientDBError(err) || attempt >= pollSelectAttempts {
+			return nil, err
+		}
+
+		s.warnw("selecting pipeline sectors failed, retrying", "attempt", attempt, "backoff", backoff, "error", err)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, xerrors.Errorf("selecting pipeline sectors: %w", ctx.Err())
+		}
+		backoff *= 2
+	}
+}
+
+// isTransientDBError reports whether a database error is likely to go away
+// when the query is retried: lost or refused connections, timeouts, and
+// serialization failures. Query errors, e.g. a missing column, are not.
+func isTransientDBError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// serialization failures and deadlocks are transaction rollbacks
+		return pgerrcode.IsConnectionException(pgErr.Code) ||
+			pgerrcode.IsTransactionRollback(pgErr.Code) ||
+			pgErr.Code == pgerrcode.AdminShutdown ||
+			pgErr.Code == pgerrcode.CannotConnectNow ||
+			pgErr.Code == pgerrcode.TooManyConnections
+	}
+
+	if pgconn.SafeToRetry(err) || pgconn.Timeout(err) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
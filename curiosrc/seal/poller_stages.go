@@ -0,0 +1,69 @@
+package seal
+
+import (
+	"golang.org/x/xerrors"
+)
+
+// SetStageEnabled enables or disables starting tasks for a pipeline stage,
+// named as in sector events, e.g. "precommit_msg" or "commit_msg". Sectors
+// wait before a disabled stage while other stages keep running. Tasks already
+// started, and landing checks of messages already sent, aren't affected. All
+// stages are enabled by default; can be called while the poller runs.
+func (s *SealPoller) SetStageEnabled(stage string, enabled bool) error {
+	poller, ok := stagePoller(stage)
+	if !ok {
+		return xerrors.Errorf("unknown pipeline stage %q", stage)
+	}
+
+	s.stageDisabled[poller].Store(!enabled)
+	return nil
+}
+
+// stagePoller returns the poller of a stage named as in sector events
+func stagePoller(stage string) (int, bool) {
+	for i, name := range pollerStages {
+		if name == stage {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// maxStagePollPeriod bounds StagePollPeriods, so that no stage waits more
+// than this many poll cycles for its start checks
+const maxStagePollPeriod = 10
+
+// setStagePollPeriods sets how often the start checks of stages run, see
+// StagePollPeriods
+func (s *SealPoller) setStagePollPeriods(periods map[string]int) {
+	for stage, period := range periods {
+		poller, ok := stagePoller(stage)
+		if !ok {
+			s.warnw("ignoring poll period of unknown pipeline stage", "stage", stage)
+			continue
+		}
+		if period > maxStagePollPeriod {
+			s.warnw("stage poll period too long, capping it", "stage", stage, "period", period, "max", maxStagePollPeriod)
+			period = maxStagePollPeriod
+		}
+
+		s.stagePeriods[poller] = period
+	}
+}
+
+// planStages decides which stages run their start checks in the next poll
+// cycle. A stage with period n runs every n-th cycle, offset by its poller
+// index, so that stages with the same period take turns instead of all
+// running in the same cycles.
+func (s *SealPoller) planStages() {
+	for i, period := range s.stagePeriods {
+		s.stageSkipped[i] = period > 1 && (s.pollCycles+i)%period != 0
+	}
+	s.pollCycles++
+}
+
+// canStart returns true if the poller can start a task for the stage of the
+// sector
+func (s *SealPoller) canStart(poller int, task pollTask) bool {
+	return !task.Paused && s.pollers[poller].IsSet() && !s.stageDisabled[poller].Load() && !s.stageSkipped[poller]
+}
@@ -0,0 +1,99 @@
+package seal
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// PipelineStats summarizes the sealing pipeline
+type PipelineStats struct {
+	// Stages is the number of sectors which aren't failed in each pipeline
+	// stage, keyed by SectorPipelineState stage names, including "done"
+	Stages map[string]int64
+	// Failed is the number of failed sectors
+	Failed int64
+
+	// OldestInFlight is the age of the oldest sector which isn't failed and
+	// didn't go through the whole pipeline, 0 if there are none
+	OldestInFlight time.Duration
+
+	// AvgStageTime is the average time sectors spent in a stage, keyed by
+	// sector event stage names. A sector spends time in a stage from the first
+	// event recorded for the stage until the first event of a following stage,
+	// so only completed stages are counted.
+	AvgStageTime map[string]time.Duration
+}
+
+// pipelineStatsQuery computes PipelineStats in the database, one row per
+// stage count, the failed count, the oldest in-flight sector age in seconds
+// and the average time per stage in seconds. The stage of a sector mirrors
+// nextForceAdvanceStage.
+const pipelineStatsQuery = `WITH sectors AS (
+    SELECT failed, create_time, CASE
+        WHEN NOT after_sdr THEN 'sdr'
+        WHEN NOT (after_tree_d AND after_tree_c AND after_tree_r) THEN 'trees'
+        WHEN NOT after_precommit_msg THEN 'precommit_msg'
+        WHEN NOT after_precommit_msg_success THEN 'precommit_msg_success'
+        WHEN NOT after_porep THEN 'porep'
+        WHEN NOT after_finalize THEN 'finalize'
+        WHEN NOT after_move_storage THEN 'move_storage'
+        WHEN NOT after_commit_msg THEN 'commit_msg'
+        WHEN NOT after_commit_msg_success THEN 'commit_msg_success'
+        ELSE '` + stageDone + `' END AS stage
+    FROM sectors_sdr_pipeline WHERE $1 = 0 OR sp_id = $1
+), stage_runs AS (
+    SELECT sp_id, sector_number, id, stage, event_time FROM (
+        SELECT sp_id, sector_number, id, stage, event_time,
+            LAG(stage) OVER (PARTITION BY sp_id, sector_number ORDER BY id) AS prev_stage
+        FROM sector_pipeline_events WHERE $1 = 0 OR sp_id = $1
+    ) e WHERE prev_stage IS DISTINCT FROM stage
+), stage_times AS (
+    SELECT stage, LEAD(event_time) OVER (PARTITION BY sp_id, sector_number ORDER BY id) - event_time AS took
+    FROM stage_runs
+)
+SELECT 'stage' AS kind, stage, COUNT(*) AS n, 0::float8 AS seconds FROM sectors WHERE NOT failed GROUP BY stage
+UNION ALL
+SELECT 'failed', '', COUNT(*), 0 FROM sectors WHERE failed
+UNION ALL
+SELECT 'oldest', '', COUNT(*), COALESCE(EXTRACT(EPOCH FROM NOW() - MIN(create_time)), 0)::float8
+    FROM sectors WHERE NOT failed AND stage != '` + stageDone + `'
+UNION ALL
+SELECT 'stage_time', stage, COUNT(*), EXTRACT(EPOCH FROM AVG(took))::float8
+    FROM stage_times WHERE took IS NOT NULL GROUP BY stage`
+
+// PipelineStats returns pipeline stats of the miner, or of all miners if spID
+// is 0, aggregated in the database
+func (s *SealPoller) PipelineStats(ctx context.Context, spID int64) (PipelineStats, error) {
+	var rows []struct {
+		Kind    string  `db:"kind"`
+		Stage   string  `db:"stage"`
+		N       int64   `db:"n"`
+		Seconds float64 `db:"seconds"`
+	}
+
+	if err := s.db.Select(ctx, &rows, pipelineStatsQuery, spID); err != nil {
+		return PipelineStats{}, xerrors.Errorf("getting pipeline stats: %w", err)
+	}
+
+	out := PipelineStats{
+		Stages:       map[string]int64{},
+		AvgStageTime: map[string]time.Duration{},
+	}
+
+	for _, row := range rows {
+		switch row.Kind {
+		case "stage":
+			out.Stages[row.Stage] = row.N
+		case "failed":
+			out.Failed = row.N
+		case "oldest":
+			out.OldestInFlight = time.Duration(row.Seconds * float64(time.Second))
+		case "stage_time":
+			out.AvgStageTime[row.Stage] = time.Duration(row.Seconds * float64(time.Second))
+		}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,2745 @@
+package seal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/filecoin-project/go-state-types/network"
+	"github.com/filecoin-project/go-state-types/proof"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/actors/policy"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/mock"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonytask"
+	"github.com/filecoin-project/lotus/node/config"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+func TestAttemptsExceeded(t *testing.T) {
+	ctx := context.Background()
+
+	const polls = 20
+	const sp, sector = 1000, 1
+
+	for _, tc := range []struct {
+		maxAttempts int
+		starts      int
+	}{
+		{maxAttempts: 3, starts: 3},
+		{maxAttempts: 1, starts: 1},
+		{maxAttempts: 0, starts: polls}, // unlimited
+	} {
+		t.Run(fmt.Sprintf("max-%d", tc.maxAttempts), func(t *testing.T) {
+			db := testPollerDB(t)
+
+			s := NewPoller(db, &countingPollerAPI{}, config.CurioSealConfig{MaxTaskAttempts: tc.maxAttempts})
+			for i := range s.pollers {
+				s.pollers[i].Set(dbTaskAdder(ctx, t, db))
+			}
+
+			_, err := db.Exec(ctx, `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof) VALUES ($1, $2, 0)`, sp, sector)
+			require.NoError(t, err)
+
+			load := func() pollTask {
+				var tasks []pollTask
+				require.NoError(t, s.selectTasks(ctx, &tasks))
+				require.Len(t, tasks, 1)
+				return tasks[0]
+			}
+
+			// the SDR task fails every time, and the task engine drops it, which
+			// clears its task id, so each poll starts it again until the sector
+			// is failed
+			for i := 0; i < polls; i++ {
+				task := load()
+				if task.Failed {
+					break
+				}
+
+				s.pollStartSDR(ctx, task)
+
+				started := load()
+				if started.Failed {
+					require.Nil(t, started.TaskSDR)
+					break
+				}
+				require.NotNil(t, started.TaskSDR)
+				require.Equal(t, task.AttemptsSDR+1, started.AttemptsSDR)
+
+				_, err := db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET task_id_sdr = NULL WHERE sp_id = $1 AND sector_number = $2`, sp, sector)
+				require.NoError(t, err)
+				_, err = db.Exec(ctx, `DELETE FROM harmony_task WHERE id = $1`, *started.TaskSDR)
+				require.NoError(t, err)
+			}
+
+			task := load()
+			require.Equal(t, tc.starts, task.AttemptsSDR)
+			require.Equal(t, tc.maxAttempts > 0, task.Failed)
+			if tc.maxAttempts > 0 {
+				require.Equal(t, "max_retries_exceeded", task.FailedReason)
+			}
+		})
+	}
+}
+
+type countingPollerAPI struct {
+	head       *types.TipSet
+	heads      int
+	precommits int
+
+	// precommitSealed is the sealed CID of reported precommits
+	precommitSealed cid.Cid
+
+	randomnessErr error
+	headErr       error
+
+	msgs map[cid.Cid]*types.Message
+
+	nv  network.Version
+	nvs int
+
+	// onChain makes StateSectorGetInfo find all sectors
+	onChain bool
+
+	// invalidSeal makes VerifySeal reject proofs, verified counts its calls
+	invalidSeal bool
+	verified    int
+}
+
+func (c *countingPollerAPI) StateSectorPreCommitInfo(context.Context, address.Address, abi.SectorNumber, types.TipSetKey) (*miner.SectorPreCommitOnChainInfo, error) {
+	c.precommits++
+	return &miner.SectorPreCommitOnChainInfo{
+		Info:           miner.SectorPreCommitInfo{SealedCID: c.precommitSealed},
+		PreCommitEpoch: 10,
+	}, nil
+}
+
+func (c *countingPollerAPI) StateSectorGetInfo(_ context.Context, _ address.Address, n abi.SectorNumber, _ types.TipSetKey) (*miner.SectorOnChainInfo, error) {
+	if !c.onChain {
+		return nil, nil
+	}
+	return &miner.SectorOnChainInfo{SectorNumber: n}, nil
+}
+
+func (c *countingPollerAPI) ChainHead(context.Context) (*types.TipSet, error) {
+	c.heads++
+	if c.headErr != nil {
+		return nil, c.headErr
+	}
+	return c.head, nil
+}
+
+func (c *countingPollerAPI) StateGetRandomnessDigestFromBeacon(context.Context, abi.ChainEpoch, types.TipSetKey) (abi.Randomness, error) {
+	if c.randomnessErr != nil {
+		return nil, c.randomnessErr
+	}
+	return make(abi.Randomness, 32), nil
+}
+
+func (c *countingPollerAPI) ChainGetMessage(_ context.Context, mc cid.Cid) (*types.Message, error) {
+	msg, ok := c.msgs[mc]
+	if !ok {
+		return nil, fmt.Errorf("message %s not found", mc)
+	}
+	return msg, nil
+}
+
+func (c *countingPollerAPI) StateNetworkVersion(context.Context, types.TipSetKey) (network.Version, error) {
+	c.nvs++
+	return c.nv, nil
+}
+
+func (c *countingPollerAPI) VerifySeal(proof.SealVerifyInfo) (bool, error) {
+	c.verified++
+	return !c.invalidSeal, nil
+}
+
+func TestCachedPollerAPI(t *testing.T) {
+	ctx := context.Background()
+
+	inner := &countingPollerAPI{head: mock.TipSet(mock.MkBlock(nil, 1, 1))}
+	api := newCachedPollerAPI(inner, time.Hour)
+
+	maddr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+
+	cycle := func() {
+		api.reset()
+
+		// many sectors waiting for their precommit to land
+		for i := 0; i < 10; i++ {
+			ts, err := api.ChainHead(ctx)
+			require.NoError(t, err)
+			require.Equal(t, inner.head, ts)
+
+			pci, err := api.StateSectorPreCommitInfo(ctx, maddr, 1, types.EmptyTSK)
+			require.NoError(t, err)
+			require.Equal(t, abi.ChainEpoch(10), pci.PreCommitEpoch)
+		}
+	}
+
+	cycle()
+	require.Equal(t, 1, inner.heads)
+	require.Equal(t, 1, inner.precommits)
+
+	cycle()
+	require.Equal(t, 2, inner.heads)
+	require.Equal(t, 2, inner.precommits)
+
+	// expired values are fetched again within a cycle
+	api.ttl = 0
+	_, err = api.ChainHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 3, inner.heads)
+}
+
+func mustIDAddr(t *testing.T, id uint64) address.Address {
+	a, err := address.NewIDAddress(id)
+	require.NoError(t, err)
+	return a
+}
+
+func TestMsgInFlightLimits(t *testing.T) {
+	ctx := context.Background()
+
+	s := NewPoller(nil, &countingPollerAPI{}, config.CurioSealConfig{
+		MaxPrecommitMsgInFlight: 2,
+		MaxCommitMsgInFlight:    1,
+	})
+
+	var started []int64
+	addTask := func(extraInfo func(harmonytask.TaskID, *harmonydb.Tx) (bool, error)) {
+		started = append(started, 0)
+	}
+	s.pollers[pollerPrecommitMsg].Set(addTask)
+	s.pollers[pollerCommitMsg].Set(addTask)
+
+	taskID := int64(1)
+
+	// five sectors ready for precommit, two ready for commit
+	var tasks []pollTask
+	for i := 0; i < 7; i++ {
+		task := pollTask{SectorNumber: int64(i), AfterSDR: true, AfterTreeD: true, AfterTreeC: true, AfterTreeR: true}
+		if i >= 5 {
+			task.AfterPrecommitMsg, task.AfterPrecommitMsgSuccess, task.AfterPoRep = true, true, true
+			task.PoRepProof = []byte{1}
+		}
+		tasks = append(tasks, task)
+	}
+
+	// cycle runs the message stages of the poller, recording started tasks
+	cycle := func() (precommits, commits []int64) {
+		inFlight := countMsgInFlight(tasks)
+		for i := range tasks {
+			started = nil
+			s.pollStartPrecommitMsg(ctx, tasks[i], &inFlight)
+			if len(started) > 0 {
+				tasks[i].TaskPrecommitMsg = &taskID
+				precommits = append(precommits, tasks[i].SectorNumber)
+			}
+
+			started = nil
+			s.pollStartCommitMsg(ctx, tasks[i], headAt(100), &inFlight)
+			if len(started) > 0 {
+				tasks[i].TaskCommitMsg = &taskID
+				commits = append(commits, tasks[i].SectorNumber)
+			}
+		}
+		return precommits, commits
+	}
+
+	pc, c := cycle()
+	require.Equal(t, []int64{0, 1}, pc)
+	require.Equal(t, []int64{5}, c)
+
+	// nothing landed, nothing new is started
+	pc, c = cycle()
+	require.Empty(t, pc)
+	require.Empty(t, c)
+
+	// one precommit is sent and waiting, the other one landed
+	tasks[0].TaskPrecommitMsg, tasks[0].AfterPrecommitMsg = nil, true
+	tasks[1].TaskPrecommitMsg, tasks[1].AfterPrecommitMsg, tasks[1].AfterPrecommitMsgSuccess = nil, true, true
+	// the commit landed
+	tasks[5].TaskCommitMsg, tasks[5].AfterCommitMsg, tasks[5].AfterCommitMsgSuccess = nil, true, true
+
+	pc, c = cycle()
+	require.Equal(t, []int64{2}, pc)
+	require.Equal(t, []int64{6}, c)
+}
+
+func TestMinerMsgInFlight(t *testing.T) {
+	ctx := context.Background()
+
+	s := NewPoller(nil, &countingPollerAPI{}, config.CurioSealConfig{
+		MaxMinerMsgInFlight: 3,
+	})
+
+	var started int
+	addTask := func(func(harmonytask.TaskID, *harmonydb.Tx) (bool, error)) {
+		started++
+	}
+	s.pollers[pollerPrecommitMsg].Set(addTask)
+	s.pollers[pollerCommitMsg].Set(addTask)
+
+	taskID := int64(1)
+	treesDone := pollTask{AfterSDR: true, AfterTreeD: true, AfterTreeC: true, AfterTreeR: true}
+	withSector := func(spID, sector int64, task pollTask) pollTask {
+		task.SpID, task.SectorNumber = spID, sector
+		return task
+	}
+	precommitReady := treesDone
+	commitReady := treesDone
+	commitReady.AfterPrecommitMsg, commitReady.AfterPrecommitMsgSuccess, commitReady.AfterPoRep = true, true, true
+	commitReady.PoRepProof = []byte{1}
+
+	precommitSending := treesDone
+	precommitSending.TaskPrecommitMsg = &taskID
+	commitWaiting := commitReady
+	commitWaiting.AfterCommitMsg = true
+
+	// miner 1000 has one precommit and one commit message in flight, miner
+	// 2000 has none
+	tasks := []pollTask{
+		withSector(1000, 1, precommitSending),
+		withSector(1000, 2, commitWaiting),
+		withSector(1000, 3, precommitReady),
+		withSector(1000, 4, commitReady),
+		withSector(2000, 1, precommitReady),
+		withSector(2000, 2, commitReady),
+	}
+
+	inFlight := countMsgInFlight(tasks)
+	require.Equal(t, 2, inFlight.miner[1000])
+
+	// one more message of either stage fits under the cap of miner 1000
+	s.pollStartPrecommitMsg(ctx, tasks[2], &inFlight)
+	require.Equal(t, 1, started)
+	s.pollStartCommitMsg(ctx, tasks[3], headAt(100), &inFlight)
+	require.Equal(t, 1, started, "combined in-flight cap must defer the commit message")
+
+	// other miners are not affected
+	s.pollStartPrecommitMsg(ctx, tasks[4], &inFlight)
+	s.pollStartCommitMsg(ctx, tasks[5], headAt(100), &inFlight)
+	require.Equal(t, 3, started)
+	require.Equal(t, 3, inFlight.miner[1000])
+	require.Equal(t, 2, inFlight.miner[2000])
+}
+
+func TestCommitMsgUrgent(t *testing.T) {
+	ctx := context.Background()
+
+	s := NewPoller(nil, &countingPollerAPI{}, config.CurioSealConfig{
+		MaxCommitMsgInFlight:  1,
+		CommitMsgUrgentEpochs: 100,
+	})
+
+	var started int
+	s.pollers[pollerCommitMsg].Set(func(func(harmonytask.TaskID, *harmonydb.Tx) (bool, error)) {
+		started++
+	})
+
+	ready := func(sector, seed int64) pollTask {
+		return pollTask{
+			SpID: 1000, SectorNumber: sector,
+			AfterSDR: true, AfterTreeD: true, AfterTreeC: true, AfterTreeR: true,
+			AfterPrecommitMsg: true, AfterPrecommitMsgSuccess: true, SeedEpoch: &seed,
+			AfterPoRep: true, PoRepProof: []byte{1},
+		}
+	}
+	urgent, relaxed := ready(1, 1000), ready(2, 5000)
+
+	expiry, err := precommitExpiry(urgent)
+	require.NoError(t, err)
+	head := headAt(expiry - 50)
+
+	// another commit message fills the in-flight limit
+	inFlight := msgInFlight{commit: 1}
+
+	s.pollStartCommitMsg(ctx, relaxed, head, &inFlight)
+	require.Zero(t, started, "sector with time left must wait for the in-flight limit")
+
+	s.pollStartCommitMsg(ctx, urgent, head, &inFlight)
+	require.Equal(t, 1, started, "sector close to precommit expiry must bypass the in-flight limit")
+
+	// outside the urgency window the limit applies
+	s.pollStartCommitMsg(ctx, urgent, headAt(expiry-500), &inFlight)
+	require.Equal(t, 1, started)
+}
+
+// testPollerDB connects to a harmonydb instance with a fresh itest schema,
+// skipping the test if no database is available
+func testPollerDB(t *testing.T) *harmonydb.DB {
+	db, err := harmonydb.NewFromConfigWithITestID(config.DefaultStorageMiner().HarmonyDB)(harmonydb.ITestNewID())
+	if err != nil {
+		t.Skipf("harmonydb not available: %s", err)
+	}
+	t.Cleanup(db.ITestDeleteAll)
+	return db
+}
+
+// dbTaskAdder returns a task adder which adds a harmony_task and calls
+// extraInfo in the same transaction, like the task engine does
+func dbTaskAdder(ctx context.Context, t *testing.T, db *harmonydb.DB) harmonytask.AddTaskFunc {
+	return func(extraInfo func(harmonytask.TaskID, *harmonydb.Tx) (bool, error)) {
+		_, err := db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (bool, error) {
+			var id harmonytask.TaskID
+			if err := tx.QueryRow(`INSERT INTO harmony_task (name, added_by, posted_time) VALUES ('test', 1, CURRENT_TIMESTAMP) RETURNING id`).Scan(&id); err != nil {
+				return false, err
+			}
+			return extraInfo(id, tx)
+		})
+		require.NoError(t, err)
+	}
+}
+
+func TestLandedMsgGasUsed(t *testing.T) {
+	ctx := context.Background()
+
+	db := testPollerDB(t)
+
+	s := NewPoller(db, &countingPollerAPI{onChain: true}, config.CurioSealConfig{})
+	s.pollers[pollerPrecommitMsg].Set(func(func(harmonytask.TaskID, *harmonydb.Tx) (bool, error)) {})
+	s.pollers[pollerCommitMsg].Set(func(func(harmonytask.TaskID, *harmonydb.Tx) (bool, error)) {})
+
+	const sp, sector = 1000, 1
+
+	_, err := db.Exec(ctx, `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof, precommit_msg_cid, after_precommit_msg, commit_msg_cid, after_commit_msg)
+		VALUES ($1, $2, 0, 'pcmsg', TRUE, 'cmsg', TRUE)`, sp, sector)
+	require.NoError(t, err)
+
+	_, err = db.Exec(ctx, `INSERT INTO message_waits (signed_message_cid, executed_tsk_cid, executed_tsk_epoch, executed_msg_cid, executed_rcpt_exitcode, executed_rcpt_gas_used)
+		VALUES ('pcmsg', 'tsk1', 10, 'pcmsg', 0, 123456), ('cmsg', 'tsk2', 20, 'cmsg', 0, 654321)`)
+	require.NoError(t, err)
+
+	task := pollTask{SpID: sp, SectorNumber: sector, AfterPrecommitMsg: true, AfterCommitMsg: true}
+	require.NoError(t, s.pollPrecommitMsgLanded(ctx, task))
+	require.NoError(t, s.pollCommitMsgLanded(ctx, task, headAt(100)))
+
+	var gas []struct {
+		PrecommitSuccess bool   `db:"after_precommit_msg_success"`
+		PrecommitGasUsed *int64 `db:"precommit_msg_gas_used"`
+		CommitSuccess    bool   `db:"after_commit_msg_success"`
+		CommitGasUsed    *int64 `db:"commit_msg_gas_used"`
+	}
+	err = db.Select(ctx, &gas, `SELECT after_precommit_msg_success, precommit_msg_gas_used, after_commit_msg_success, commit_msg_gas_used
+		FROM sectors_sdr_pipeline WHERE sp_id = $1 AND sector_number = $2`, sp, sector)
+	require.NoError(t, err)
+	require.Len(t, gas, 1)
+
+	require.True(t, gas[0].PrecommitSuccess)
+	require.NotNil(t, gas[0].PrecommitGasUsed)
+	require.EqualValues(t, 123456, *gas[0].PrecommitGasUsed)
+
+	require.True(t, gas[0].CommitSuccess)
+	require.NotNil(t, gas[0].CommitGasUsed)
+	require.EqualValues(t, 654321, *gas[0].CommitGasUsed)
+}
+
+func TestMsgExitCodeDispositions(t *testing.T) {
+	ctx := context.Background()
+
+	db := testPollerDB(t)
+
+	s := NewPoller(db, &countingPollerAPI{}, config.CurioSealConfig{MsgExitCodeDispositions: map[string]string{
+		"16": "retry",
+		"7":  "terminal",
+	}})
+	s.pollers[pollerPrecommitMsg].Set(func(func(harmonytask.TaskID, *harmonydb.Tx) (bool, error)) {})
+
+	require.Equal(t, msgExitRetry, s.msgExitDisposition(exitcode.ErrIllegalArgument))
+	require.Equal(t, msgExitRetry, s.msgExitDisposition(exitcode.SysErrInsufficientFunds))
+	require.Equal(t, msgExitTerminal, s.msgExitDisposition(exitcode.SysErrOutOfGas))
+	require.Equal(t, msgExitTerminal, s.msgExitDisposition(exitcode.ErrForbidden))
+
+	const sp = 1000
+
+	_, err := db.Exec(ctx, `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof, precommit_msg_cid, after_precommit_msg)
+		VALUES ($1, 1, 0, 'pcmsg-retry', TRUE), ($1, 2, 0, 'pcmsg-terminal', TRUE)`, sp)
+	require.NoError(t, err)
+
+	_, err = db.Exec(ctx, `INSERT INTO message_waits (signed_message_cid, executed_tsk_cid, executed_tsk_epoch, executed_msg_cid, executed_rcpt_exitcode, executed_rcpt_gas_used)
+		VALUES ('pcmsg-retry', 'tsk1', 10, 'pcmsg-retry', $1, 1), ('pcmsg-terminal', 'tsk1', 10, 'pcmsg-terminal', $2, 1)`,
+		int64(exitcode.ErrIllegalArgument), int64(exitcode.ErrForbidden))
+	require.NoError(t, err)
+
+	for sector := int64(1); sector <= 2; sector++ {
+		require.NoError(t, s.pollPrecommitMsgLanded(ctx, pollTask{SpID: sp, SectorNumber: sector, AfterPrecommitMsg: true}))
+	}
+
+	var state []struct {
+		SectorNumber int64   `db:"sector_number"`
+		MsgCid       *string `db:"precommit_msg_cid"`
+		Sent         bool    `db:"after_precommit_msg"`
+		Failed       bool    `db:"failed"`
+		Reason       *string `db:"failed_reason"`
+	}
+	err = db.Select(ctx, &state, `SELECT sector_number, precommit_msg_cid, after_precommit_msg, failed, failed_reason
+		FROM sectors_sdr_pipeline WHERE sp_id = $1 ORDER BY sector_number`, sp)
+	require.NoError(t, err)
+	require.Len(t, state, 2)
+
+	// the retryable exit code resets the stage for a new message
+	require.Nil(t, state[0].MsgCid)
+	require.False(t, state[0].Sent)
+	require.False(t, state[0].Failed)
+
+	// the terminal one fails the sector
+	require.True(t, state[1].Failed)
+	require.Equal(t, "msg_exit_code", *state[1].Reason)
+
+	events, err := s.SectorEvents(ctx, sp, 2)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, sectorEventFailed, events[0].Action)
+}
+
+func TestRetryUnsentMsg(t *testing.T) {
+	ctx := context.Background()
+
+	db := testPollerDB(t)
+
+	s := NewPoller(db, &countingPollerAPI{head: headAt(100)}, config.CurioSealConfig{
+		MaxTaskAttempts: 2,
+		MsgSendTimeout:  config.Duration(10 * time.Minute),
+	})
+	for i := range s.pollers {
+		s.pollers[i].Set(dbTaskAdder(ctx, t, db))
+	}
+
+	const sp, sector = 1000, 1
+
+	_, err := db.Exec(ctx, `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof, after_sdr, after_tree_d, after_tree_c, after_tree_r)
+		VALUES ($1, $2, 0, TRUE, TRUE, TRUE, TRUE)`, sp, sector)
+	require.NoError(t, err)
+
+	type sectorState struct {
+		Task     *int64 `db:"task_id_precommit_msg"`
+		Attempts int    `db:"attempts_precommit_msg"`
+		Failed   bool   `db:"failed"`
+	}
+	get := func() sectorState {
+		var out []sectorState
+		require.NoError(t, db.Select(ctx, &out, `SELECT task_id_precommit_msg, attempts_precommit_msg, failed
+			FROM sectors_sdr_pipeline WHERE sp_id = $1 AND sector_number = $2`, sp, sector))
+		require.Len(t, out, 1)
+		return out[0]
+	}
+
+	// neverSent simulates the message task ending without sending a message,
+	// started the given time ago
+	neverSent := func(id int64, ago time.Duration) {
+		_, err := db.Exec(ctx, `DELETE FROM harmony_task WHERE id = $1`, id)
+		require.NoError(t, err)
+		_, err = db.Exec(ctx, `UPDATE sector_pipeline_events SET event_time = NOW() - $1::float8 * INTERVAL '1 second'
+			WHERE sp_id = $2 AND sector_number = $3 AND stage = 'precommit_msg' AND detail = CAST($4::bigint AS text)`, ago.Seconds(), sp, sector, id)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, s.poll(ctx))
+	st := get()
+	require.NotNil(t, st.Task)
+	require.Equal(t, 1, st.Attempts)
+
+	// a task which is still queued or running isn't touched
+	_, err = db.Exec(ctx, `UPDATE sector_pipeline_events SET event_time = NOW() - INTERVAL '1 hour' WHERE sp_id = $1 AND sector_number = $2`, sp, sector)
+	require.NoError(t, err)
+	require.NoError(t, s.poll(ctx))
+	require.Equal(t, st.Task, get().Task)
+
+	// neither is one which ended without a message within the timeout
+	neverSent(*st.Task, time.Minute)
+	require.NoError(t, s.poll(ctx))
+	require.Equal(t, st.Task, get().Task)
+
+	// past the timeout the task is cleared, and the next poll retries the stage
+	neverSent(*st.Task, time.Hour)
+	require.NoError(t, s.poll(ctx))
+	require.Nil(t, get().Task)
+
+	require.NoError(t, s.poll(ctx))
+	st = get()
+	require.NotNil(t, st.Task)
+	require.Equal(t, 2, st.Attempts)
+
+	// once out of attempts the sector fails
+	neverSent(*st.Task, time.Hour)
+	require.NoError(t, s.poll(ctx))
+	require.NoError(t, s.poll(ctx))
+	st = get()
+	require.Nil(t, st.Task)
+	require.True(t, st.Failed)
+
+	events, err := s.SectorEvents(ctx, sp, sector)
+	require.NoError(t, err)
+	var retries int
+	for _, e := range events {
+		if e.Stage == "precommit_msg" && e.Action == sectorEventRetry {
+			retries++
+		}
+	}
+	require.Equal(t, 2, retries)
+}
+
+// fakeMsgReplacer records replaced messages, and marks them replaced by a
+// pending send task like message.Sender.Replace
+type fakeMsgReplacer struct {
+	t  *testing.T
+	db *harmonydb.DB
+
+	nextTask int64
+	replaced []cid.Cid
+}
+
+func (r *fakeMsgReplacer) Replace(ctx context.Context, signedCid cid.Cid, mss *api.MessageSendSpec, reason string) error {
+	r.nextTask++
+	r.replaced = append(r.replaced, signedCid)
+
+	_, err := r.db.Exec(ctx, `UPDATE message_sends SET replaced_by_task_id = $1
+		WHERE replaced_by_task_id IS NULL AND (from_key, nonce) IN (SELECT from_key, nonce FROM message_sends WHERE signed_cid = $2)`, r.nextTask, signedCid.String())
+	require.NoError(r.t, err)
+	_, err = r.db.Exec(ctx, `INSERT INTO message_sends (from_key, to_addr, send_reason, send_task_id, unsigned_data, unsigned_cid, nonce)
+		VALUES ('f01000', 'f01000', $1, $2, '\x00', 'bafy-unsigned', 5)`, reason, r.nextTask)
+	require.NoError(r.t, err)
+	return nil
+}
+
+func TestReplaceStuckMsg(t *testing.T) {
+	ctx := context.Background()
+
+	db := testPollerDB(t)
+
+	s := NewPoller(db, &countingPollerAPI{head: headAt(100)}, config.CurioSealConfig{
+		MsgReplaceTimeout: config.Duration(10 * time.Minute),
+	})
+	for i := range s.pollers {
+		s.pollers[i].Set(dbTaskAdder(ctx, t, db))
+	}
+	replacer := &fakeMsgReplacer{t: t, db: db, nextTask: 9000}
+	s.SetMsgReplacer(replacer, types.MustParseFIL("0.05"), types.MustParseFIL("0.05"))
+
+	const sp, sector = 1000, 1
+	msg := mock.MkBlock(nil, 1, 1).Cid()
+
+	_, err := db.Exec(ctx, `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof, after_sdr, after_tree_d, after_tree_c, after_tree_r,
+			precommit_msg_cid, after_precommit_msg)
+		VALUES ($1, $2, 8, TRUE, TRUE, TRUE, TRUE, $3, TRUE)`, sp, sector, msg.String())
+	require.NoError(t, err)
+	_, err = db.Exec(ctx, `INSERT INTO message_sends (from_key, to_addr, send_reason, send_task_id, unsigned_data, unsigned_cid, nonce, signed_cid, send_time, send_success)
+		VALUES ('f01000', 'f01000', 'precommit', 8000, '\x00', 'bafy-unsigned', 5, $1, NOW() - INTERVAL '1 minute', TRUE)`, msg.String())
+	require.NoError(t, err)
+	_, err = db.Exec(ctx, `INSERT INTO message_waits (signed_message_cid) VALUES ($1)`, msg.String())
+	require.NoError(t, err)
+
+	sentAgo := func(task int64, ago time.Duration) {
+		_, err := db.Exec(ctx, `UPDATE message_sends SET send_success = TRUE, signed_cid = COALESCE(signed_cid, 'bafy-replacement'),
+			send_time = NOW() - $1::float8 * INTERVAL '1 second' WHERE send_task_id = $2`, ago.Seconds(), task)
+		require.NoError(t, err)
+	}
+
+	// the message was sent within the timeout
+	require.NoError(t, s.poll(ctx))
+	require.Empty(t, replacer.replaced)
+
+	// past the timeout it's replaced
+	sentAgo(8000, time.Hour)
+	require.NoError(t, s.poll(ctx))
+	require.Equal(t, []cid.Cid{msg}, replacer.replaced)
+
+	// not again while the replacement is pending, or within the timeout of
+	// the replacement being sent
+	require.NoError(t, s.poll(ctx))
+	sentAgo(9001, time.Minute)
+	require.NoError(t, s.poll(ctx))
+	require.Len(t, replacer.replaced, 1)
+
+	// a replacement which doesn't land either is replaced again
+	sentAgo(9001, time.Hour)
+	require.NoError(t, s.poll(ctx))
+	require.Equal(t, []cid.Cid{msg, msg}, replacer.replaced)
+
+	events, err := s.SectorEvents(ctx, sp, sector)
+	require.NoError(t, err)
+	var replaced int
+	for _, e := range events {
+		if e.Stage == "precommit_msg" && e.Action == sectorEventReplaced {
+			replaced++
+		}
+	}
+	require.Equal(t, 2, replaced)
+}
+
+func TestVerifyPrecommitMsg(t *testing.T) {
+	ctx := context.Background()
+
+	const sp = 1000
+
+	maddr := mustIDAddr(t, sp)
+
+	sealed, err := cid.Parse("bagboea4b5abcatlxechwbp7kjpjguna6r6q7ejrhe6mdp3lf34pmswn27pkkiekz")
+	require.NoError(t, err)
+
+	precommitMsg := func(sector abi.SectorNumber) *types.Message {
+		params := miner.PreCommitSectorBatchParams2{
+			Sectors: []miner.SectorPreCommitInfo{{SectorNumber: sector, SealedCID: sealed}},
+		}
+		var buf bytes.Buffer
+		require.NoError(t, params.MarshalCBOR(&buf))
+
+		return &types.Message{
+			To:     maddr,
+			From:   maddr,
+			Method: builtin.MethodsMiner.PreCommitSectorBatch2,
+			Params: buf.Bytes(),
+		}
+	}
+
+	run := func(t *testing.T, sector int64, msg *types.Message) (success, failed bool, failedReason, reason string) {
+		db := testPollerDB(t)
+
+		api := &countingPollerAPI{msgs: map[cid.Cid]*types.Message{msg.Cid(): msg}}
+		s := NewPoller(db, api, config.CurioSealConfig{VerifyPrecommitMsg: true})
+
+		_, err := db.Exec(ctx, `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof, precommit_msg_cid, after_precommit_msg)
+			VALUES ($1, $2, 0, $3, TRUE)`, sp, sector, msg.Cid().String())
+		require.NoError(t, err)
+
+		_, err = db.Exec(ctx, `INSERT INTO message_waits (signed_message_cid, executed_tsk_cid, executed_tsk_epoch, executed_msg_cid, executed_rcpt_exitcode, executed_rcpt_gas_used)
+			VALUES ($1, 'tsk', 10, $1, 0, 1)`, msg.Cid().String())
+		require.NoError(t, err)
+
+		task := pollTask{SpID: sp, SectorNumber: sector, AfterPrecommitMsg: true}
+		require.NoError(t, s.pollPrecommitMsgLanded(ctx, task))
+
+		var state []struct {
+			Success      bool    `db:"after_precommit_msg_success"`
+			Failed       bool    `db:"failed"`
+			FailedReason string  `db:"failed_reason"`
+			Reason       *string `db:"failed_reason_msg"`
+		}
+		err = db.Select(ctx, &state, `SELECT after_precommit_msg_success, failed, failed_reason, failed_reason_msg
+			FROM sectors_sdr_pipeline WHERE sp_id = $1 AND sector_number = $2`, sp, sector)
+		require.NoError(t, err)
+		require.Len(t, state, 1)
+
+		if state[0].Reason != nil {
+			reason = *state[0].Reason
+		}
+		return state[0].Success, state[0].Failed, state[0].FailedReason, reason
+	}
+
+	t.Run("match", func(t *testing.T) {
+		success, failed, _, _ := run(t, 1, precommitMsg(1))
+		require.True(t, success)
+		require.False(t, failed)
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		success, failed, failedReason, reason := run(t, 1, precommitMsg(2))
+		require.False(t, success)
+		require.True(t, failed)
+		require.Equal(t, "precommit_mismatch", failedReason)
+		require.Contains(t, reason, "doesn't precommit sector 1")
+	})
+}
+
+func TestVerifyCommitProof(t *testing.T) {
+	ctx := context.Background()
+
+	const sp = 1000
+
+	run := func(t *testing.T, invalid bool) (api *countingPollerAPI, taskID *int64, failed bool, reason *string) {
+		db := testPollerDB(t)
+
+		api = &countingPollerAPI{invalidSeal: invalid}
+		s := NewPoller(db, api, config.CurioSealConfig{VerifyCommitProof: true})
+		s.pollers[pollerCommitMsg].Set(dbTaskAdder(ctx, t, db))
+
+		_, err := db.Exec(ctx, `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof, ticket_value, seed_value,
+				tree_r_cid, tree_d_cid, after_precommit_msg_success, seed_epoch, porep_proof, after_porep)
+			VALUES ($1, 1, 0, '\x01', '\x02', 'bagboea4b5abcatlxechwbp7kjpjguna6r6q7ejrhe6mdp3lf34pmswn27pkkiekz',
+				'baga6ea4seaqao7s73y24kcutaosvacpdjgfe5pw76ooefnyqw4ynr3d2y6x2mpq', TRUE, 10, '\x03', TRUE)`, sp)
+		require.NoError(t, err)
+
+		seed := int64(10)
+		task := pollTask{SpID: sp, SectorNumber: 1, AfterSDR: true, AfterTreeD: true, AfterTreeC: true, AfterTreeR: true,
+			AfterPrecommitMsg: true, AfterPrecommitMsgSuccess: true, SeedEpoch: &seed, AfterPoRep: true, PoRepProof: []byte{3}}
+
+		var inFlight msgInFlight
+		s.pollStartCommitMsg(ctx, task, headAt(100), &inFlight)
+
+		var state []struct {
+			TaskID *int64  `db:"task_id_commit_msg"`
+			Failed bool    `db:"failed"`
+			Reason *string `db:"failed_reason"`
+		}
+		err = db.Select(ctx, &state, `SELECT task_id_commit_msg, failed, failed_reason
+			FROM sectors_sdr_pipeline WHERE sp_id = $1 AND sector_number = 1`, sp)
+		require.NoError(t, err)
+		require.Len(t, state, 1)
+
+		return api, state[0].TaskID, state[0].Failed, state[0].Reason
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		api, taskID, failed, _ := run(t, false)
+		require.Equal(t, 1, api.verified)
+		require.NotNil(t, taskID)
+		require.False(t, failed)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		api, taskID, failed, reason := run(t, true)
+		require.Equal(t, 1, api.verified)
+		require.Nil(t, taskID, "commit task must not be assigned for an invalid proof")
+		require.True(t, failed)
+		require.Equal(t, "porep_verify_failed", *reason)
+	})
+}
+
+func TestForceAdvance(t *testing.T) {
+	ctx := context.Background()
+
+	s := NewPoller(nil, &countingPollerAPI{}, config.CurioSealConfig{})
+	require.Error(t, s.ForceAdvance(ctx, 1000, 1), "force advance must be enabled explicitly")
+
+	db := testPollerDB(t)
+	s = NewPoller(db, &countingPollerAPI{}, config.CurioSealConfig{})
+	s.SetUnsafeForceAdvance(true)
+
+	const sp, sector, failedSector = 1000, 1, 2
+
+	_, err := db.Exec(ctx, `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof) VALUES ($1, $2, 0)`, sp, sector)
+	require.NoError(t, err)
+	_, err = db.Exec(ctx, `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof, failed, failed_reason) VALUES ($1, $2, 0, TRUE, 'test')`, sp, failedSector)
+	require.NoError(t, err)
+
+	load := func() pollTask {
+		var tasks []pollTask
+		require.NoError(t, db.Select(ctx, &tasks, `SELECT `+pollTaskColumns+` FROM sectors_sdr_pipeline WHERE sp_id = $1 AND sector_number = $2`, sp, sector))
+		require.Len(t, tasks, 1)
+		return tasks[0]
+	}
+
+	for i, stage := range forceAdvanceStages {
+		require.False(t, stage.done(load()), stage.name)
+
+		require.NoError(t, s.ForceAdvance(ctx, sp, sector))
+
+		task := load()
+		require.True(t, stage.done(task), stage.name)
+		if i+1 < len(forceAdvanceStages) {
+			require.False(t, forceAdvanceStages[i+1].done(task), "only %s should be advanced", stage.name)
+		}
+	}
+
+	require.Nil(t, nextForceAdvanceStage(load()))
+	require.Error(t, s.ForceAdvance(ctx, sp, sector), "sector already completed")
+	require.Error(t, s.ForceAdvance(ctx, sp, failedSector), "sector failed")
+	require.Error(t, s.ForceAdvance(ctx, sp, 3), "sector not in the pipeline")
+}
+
+func TestAddTaskContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := NewPoller(nil, &countingPollerAPI{}, config.CurioSealConfig{})
+
+	var added bool
+	extraInfo := func(harmonytask.TaskID, *harmonydb.Tx) (bool, error) {
+		added = true
+		return true, nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.addTask(ctx, pollerSDR, pollTask{SpID: 1000, SectorNumber: 1}, extraInfo)
+
+		// waiting on the task adder must not make the stage look ready
+		s.pollStartSDR(ctx, pollTask{SpID: 1000, SectorNumber: 1})
+	}()
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("adding task didn't return after context was cancelled")
+	}
+
+	require.False(t, added)
+	require.False(t, s.pollers[pollerSDR].IsSet())
+}
+
+// headAt returns a chain head tipset at the given height
+func headAt(h abi.ChainEpoch) *types.TipSet {
+	blk := mock.MkBlock(nil, 1, 1)
+	blk.Height = h
+	return mock.TipSet(blk)
+}
+
+func TestCommitLandConfidence(t *testing.T) {
+	ctx := context.Background()
+
+	db := testPollerDB(t)
+
+	s := NewPoller(db, &countingPollerAPI{onChain: true}, config.CurioSealConfig{CommitLandConfidence: 5})
+	s.pollers[pollerCommitMsg].Set(func(func(harmonytask.TaskID, *harmonydb.Tx) (bool, error)) {})
+
+	const sp, sector = 1000, 1
+
+	_, err := db.Exec(ctx, `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof, commit_msg_cid, after_commit_msg)
+		VALUES ($1, $2, 0, 'cmsg', TRUE)`, sp, sector)
+	require.NoError(t, err)
+
+	_, err = db.Exec(ctx, `INSERT INTO message_waits (signed_message_cid, executed_tsk_cid, executed_tsk_epoch, executed_msg_cid, executed_rcpt_exitcode, executed_rcpt_gas_used)
+		VALUES ('cmsg', 'tsk', 20, 'cmsg', 0, 1)`)
+	require.NoError(t, err)
+
+	task := pollTask{SpID: sp, SectorNumber: sector, AfterCommitMsg: true}
+
+	committed := func() bool {
+		var success []bool
+		require.NoError(t, db.Select(ctx, &success, `SELECT after_commit_msg_success FROM sectors_sdr_pipeline WHERE sp_id = $1 AND sector_number = $2`, sp, sector))
+		require.Len(t, success, 1)
+		return success[0]
+	}
+
+	for h := abi.ChainEpoch(20); h < 25; h++ {
+		require.NoError(t, s.pollCommitMsgLanded(ctx, task, headAt(h)))
+		require.False(t, committed(), "committed at height %d", h)
+	}
+
+	require.NoError(t, s.pollCommitMsgLanded(ctx, task, headAt(25)))
+	require.True(t, committed())
+}
+
+// execTipSetPollerAPI resolves tipsets by height and records the tipset keys
+// sector info is looked up in
+type execTipSetPollerAPI struct {
+	countingPollerAPI
+
+	byHeight map[abi.ChainEpoch]*types.TipSet
+	infoTsks []types.TipSetKey
+}
+
+func (e *execTipSetPollerAPI) ChainGetTipSetByHeight(_ context.Context, h abi.ChainEpoch, _ types.TipSetKey) (*types.TipSet, error) {
+	ts, ok := e.byHeight[h]
+	if !ok {
+		return nil, fmt.Errorf("no tipset at height %d", h)
+	}
+	return ts, nil
+}
+
+func (e *execTipSetPollerAPI) StateSectorGetInfo(_ context.Context, _ address.Address, n abi.SectorNumber, tsk types.TipSetKey) (*miner.SectorOnChainInfo, error) {
+	e.infoTsks = append(e.infoTsks, tsk)
+	return &miner.SectorOnChainInfo{SectorNumber: n}, nil
+}
+
+func TestCommitMsgLandedExecTipSet(t *testing.T) {
+	ctx := context.Background()
+
+	db := testPollerDB(t)
+
+	execTs := headAt(20)
+	execTskCid, err := execTs.Key().Cid()
+	require.NoError(t, err)
+
+	papi := &execTipSetPollerAPI{byHeight: map[abi.ChainEpoch]*types.TipSet{20: execTs, 21: headAt(21)}}
+	papi.nv = network.Version22
+	s := NewPoller(db, papi, config.CurioSealConfig{})
+	s.pollers[pollerCommitMsg].Set(func(func(harmonytask.TaskID, *harmonydb.Tx) (bool, error)) {})
+
+	// sector 1 landed in execTs, sector 2 in a tipset which is no longer on
+	// the chain
+	_, err = db.Exec(ctx, `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof, commit_msg_cid, after_commit_msg)
+		VALUES (1000, 1, 0, 'cmsg1', TRUE), (1000, 2, 0, 'cmsg2', TRUE)`)
+	require.NoError(t, err)
+	_, err = db.Exec(ctx, `INSERT INTO message_waits (signed_message_cid, executed_tsk_cid, executed_tsk_epoch, executed_msg_cid, executed_rcpt_exitcode, executed_rcpt_gas_used)
+		VALUES ('cmsg1', $1, 20, 'cmsg1', 0, 1), ('cmsg2', 'reorged', 21, 'cmsg2', 0, 1)`, execTskCid.String())
+	require.NoError(t, err)
+
+	require.NoError(t, s.pollCommitMsgLanded(ctx, pollTask{SpID: 1000, SectorNumber: 1, AfterCommitMsg: true}, headAt(30)))
+	require.Equal(t, []types.TipSetKey{execTs.Key()}, papi.infoTsks)
+
+	require.NoError(t, s.pollCommitMsgLanded(ctx, pollTask{SpID: 1000, SectorNumber: 2, AfterCommitMsg: true}, headAt(30)))
+	require.Equal(t, []types.TipSetKey{execTs.Key(), types.EmptyTSK}, papi.infoTsks, "sector whose executed tipset isn't on chain is looked up at head")
+
+	var success []bool
+	require.NoError(t, db.Select(ctx, &success, `SELECT after_commit_msg_success FROM sectors_sdr_pipeline ORDER BY sector_number`))
+	require.Equal(t, []bool{true, true}, success)
+}
+
+func TestCommitMsgLandedNetworkVersion(t *testing.T) {
+	ctx := context.Background()
+
+	db := testPollerDB(t)
+
+	execTs := headAt(20)
+	execTskCid, err := execTs.Key().Cid()
+	require.NoError(t, err)
+
+	_, err = db.Exec(ctx, `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof, commit_msg_cid, after_commit_msg)
+		VALUES (1000, 1, 0, 'cmsg1', TRUE), (1000, 2, 0, 'cmsg2', TRUE)`)
+	require.NoError(t, err)
+	_, err = db.Exec(ctx, `INSERT INTO message_waits (signed_message_cid, executed_tsk_cid, executed_tsk_epoch, executed_msg_cid, executed_rcpt_exitcode, executed_rcpt_gas_used)
+		VALUES ('cmsg1', $1, 20, 'cmsg1', 0, 1), ('cmsg2', $1, 20, 'cmsg2', 0, 1)`, execTskCid.String())
+	require.NoError(t, err)
+
+	for sector, tc := range map[int64]struct {
+		nv  network.Version
+		tsk types.TipSetKey
+	}{
+		// cron activated ProveCommitSector, the sector is read at head
+		1: {nv: network.Version21, tsk: types.EmptyTSK},
+		// activated as the message executes
+		2: {nv: network.Version22, tsk: execTs.Key()},
+	} {
+		papi := &execTipSetPollerAPI{byHeight: map[abi.ChainEpoch]*types.TipSet{20: execTs}}
+		papi.nv = tc.nv
+		s := NewPoller(db, papi, config.CurioSealConfig{})
+		s.pollers[pollerCommitMsg].Set(func(func(harmonytask.TaskID, *harmonydb.Tx) (bool, error)) {})
+
+		require.NoError(t, s.pollCommitMsgLanded(ctx, pollTask{SpID: 1000, SectorNumber: sector, AfterCommitMsg: true}, headAt(30)))
+		require.Equal(t, []types.TipSetKey{tc.tsk}, papi.infoTsks, "network version %d", tc.nv)
+	}
+
+	var success []bool
+	require.NoError(t, db.Select(ctx, &success, `SELECT after_commit_msg_success FROM sectors_sdr_pipeline ORDER BY sector_number`))
+	require.Equal(t, []bool{true, true}, success)
+}
+
+func TestCommitMsgLandedNetworkVersionCached(t *testing.T) {
+	ctx := context.Background()
+
+	db := testPollerDB(t)
+
+	execTs := headAt(20)
+	execTskCid, err := execTs.Key().Cid()
+	require.NoError(t, err)
+
+	papi := &execTipSetPollerAPI{byHeight: map[abi.ChainEpoch]*types.TipSet{20: execTs}}
+	papi.head = headAt(30)
+	papi.nv = network.Version22
+	s := NewPoller(db, papi, config.CurioSealConfig{})
+	s.pollers[pollerCommitMsg].Set(func(func(harmonytask.TaskID, *harmonydb.Tx) (bool, error)) {})
+
+	_, err = db.Exec(ctx, `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof, commit_msg_cid, after_commit_msg)
+		VALUES (1000, 1, 0, 'cmsg1', TRUE), (1000, 2, 0, 'cmsg2', TRUE)`)
+	require.NoError(t, err)
+	_, err = db.Exec(ctx, `INSERT INTO message_waits (signed_message_cid, executed_tsk_cid, executed_tsk_epoch, executed_msg_cid, executed_rcpt_exitcode, executed_rcpt_gas_used)
+		VALUES ('cmsg1', $1, 20, 'cmsg1', 0, 1), ('cmsg2', $1, 20, 'cmsg2', 0, 1)`, execTskCid.String())
+	require.NoError(t, err)
+
+	// both sectors landed in the same tipset, its network version is looked
+	// up once
+	require.NoError(t, s.poll(ctx))
+	require.Equal(t, 1, papi.nvs)
+	require.Equal(t, []types.TipSetKey{execTs.Key(), execTs.Key()}, papi.infoTsks)
+
+	// and again in the next cycle
+	_, err = db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET after_commit_msg_success = FALSE`)
+	require.NoError(t, err)
+	require.NoError(t, s.poll(ctx))
+	require.Equal(t, 2, papi.nvs)
+}
+
+func TestSectorEvents(t *testing.T) {
+	ctx := context.Background()
+
+	db := testPollerDB(t)
+
+	const sp, sector = 1000, 2
+
+	api := &countingPollerAPI{head: headAt(10000), onChain: true}
+
+	s := NewPoller(db, api, config.CurioSealConfig{})
+
+	for i := range s.pollers {
+		s.pollers[i].Set(dbTaskAdder(ctx, t, db))
+	}
+
+	// the stream receives the events of the whole progression as they happen
+	stream, err := s.StreamEvents(ctx, 100, -1)
+	require.NoError(t, err)
+
+	_, err = db.Exec(ctx, `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof) VALUES ($1, $2, 0)`, sp, sector)
+	require.NoError(t, err)
+
+	// finish checks a stage task completion was simulated, and runs a poll cycle
+	finish := func(n int, err error) {
+		t.Helper()
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+		require.NoError(t, s.poll(ctx))
+	}
+
+	require.NoError(t, s.poll(ctx))
+	finish(db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET task_id_sdr = NULL, after_sdr = TRUE
+		WHERE sp_id = $1 AND sector_number = $2`, sp, sector))
+	finish(db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET task_id_tree_d = NULL, task_id_tree_c = NULL, task_id_tree_r = NULL,
+		after_tree_d = TRUE, after_tree_c = TRUE, after_tree_r = TRUE
+		WHERE sp_id = $1 AND sector_number = $2`, sp, sector))
+
+	_, err = db.Exec(ctx, `INSERT INTO message_waits (signed_message_cid, executed_tsk_cid, executed_tsk_epoch, executed_msg_cid, executed_rcpt_exitcode, executed_rcpt_gas_used)
+		VALUES ('pcmsg', 'pctsk', 10, 'pcmsg', 0, 1), ('cmsg', 'ctsk', 500, 'cmsg', 0, 1)`)
+	require.NoError(t, err)
+
+	// precommit lands in the first cycle, porep starts in the next one
+	finish(db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET task_id_precommit_msg = NULL, precommit_msg_cid = 'pcmsg', after_precommit_msg = TRUE
+		WHERE sp_id = $1 AND sector_number = $2`, sp, sector))
+	require.NoError(t, s.poll(ctx))
+
+	finish(db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET task_id_porep = NULL, porep_proof = '\x01', after_porep = TRUE
+		WHERE sp_id = $1 AND sector_number = $2`, sp, sector))
+	finish(db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET task_id_finalize = NULL, after_finalize = TRUE
+		WHERE sp_id = $1 AND sector_number = $2`, sp, sector))
+	finish(db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET task_id_move_storage = NULL, after_move_storage = TRUE,
+		task_id_commit_msg = NULL, commit_msg_cid = 'cmsg', after_commit_msg = TRUE
+		WHERE sp_id = $1 AND sector_number = $2`, sp, sector))
+
+	events, err := s.SectorEvents(ctx, sp, sector)
+	require.NoError(t, err)
+
+	type stageAction struct{ stage, action string }
+	var got []stageAction
+	for _, e := range events {
+		got = append(got, stageAction{e.Stage, e.Action})
+	}
+
+	require.Equal(t, []stageAction{
+		{"sdr", sectorEventTaskStarted},
+		{"trees", sectorEventTaskStarted},
+		{"precommit_msg", sectorEventTaskStarted},
+		{"precommit_msg", sectorEventLanded},
+		{"porep", sectorEventTaskStarted},
+		{"finalize", sectorEventTaskStarted},
+		{"commit_msg", sectorEventTaskStarted},
+		{"move_storage", sectorEventTaskStarted},
+		{"commit_msg", sectorEventLanded},
+	}, got)
+
+	require.Equal(t, "pctsk", events[3].Detail)
+	require.Equal(t, "ctsk", events[8].Detail)
+
+	s.StopEvents()
+
+	var streamed []SectorPipelineEvent
+	for ev := range stream {
+		require.Equal(t, int64(sp), ev.SpID)
+		require.Equal(t, int64(sector), ev.SectorNumber)
+		streamed = append(streamed, ev.SectorPipelineEvent)
+	}
+	require.Equal(t, events, streamed)
+	require.Zero(t, s.Health().DroppedEvents)
+}
+
+func TestEventStreamDropsOldest(t *testing.T) {
+	ctx := context.Background()
+
+	s := NewPoller(nil, &countingPollerAPI{}, config.CurioSealConfig{})
+
+	stream, err := s.StreamEvents(ctx, 2, 0)
+	require.NoError(t, err)
+	for id := int64(1); id <= 5; id++ {
+		s.publishEvent(PipelineEvent{ID: id})
+	}
+
+	require.Equal(t, int64(3), s.Health().DroppedEvents)
+
+	s.StopEvents()
+
+	var ids []int64
+	for ev := range stream {
+		ids = append(ids, ev.ID)
+	}
+	require.Equal(t, []int64{4, 5}, ids)
+
+	// subscribing again closes the previous stream
+	first, err := s.StreamEvents(ctx, 1, 0)
+	require.NoError(t, err)
+	_, err = s.StreamEvents(ctx, 1, 0)
+	require.NoError(t, err)
+	_, open := <-first
+	require.False(t, open)
+}
+
+func TestPollerSpIDs(t *testing.T) {
+	ctx := context.Background()
+
+	s := NewPoller(nil, &countingPollerAPI{}, config.CurioSealConfig{})
+	require.True(t, s.servicesSp(1000))
+
+	db := testPollerDB(t)
+
+	api := &countingPollerAPI{head: headAt(100)}
+	s = NewPoller(db, api, config.CurioSealConfig{PollerSpIDs: []int64{1000}})
+	require.True(t, s.servicesSp(1000))
+	require.False(t, s.servicesSp(2000))
+
+	s.pollers[pollerSDR].Set(dbTaskAdder(ctx, t, db))
+
+	for _, sp := range []int64{1000, 2000} {
+		_, err := db.Exec(ctx, `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof) VALUES ($1, 1, 0)`, sp)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, s.poll(ctx))
+
+	var sectors []struct {
+		SpID    int64  `db:"sp_id"`
+		TaskSDR *int64 `db:"task_id_sdr"`
+	}
+	require.NoError(t, db.Select(ctx, &sectors, `SELECT sp_id, task_id_sdr FROM sectors_sdr_pipeline ORDER BY sp_id`))
+	require.Len(t, sectors, 2)
+
+	require.EqualValues(t, 1000, sectors[0].SpID)
+	require.NotNil(t, sectors[0].TaskSDR)
+
+	require.EqualValues(t, 2000, sectors[1].SpID)
+	require.Nil(t, sectors[1].TaskSDR, "sectors of other miners must not be processed")
+}
+
+func TestListFailedSectors(t *testing.T) {
+	ctx := context.Background()
+
+	db := testPollerDB(t)
+	s := NewPoller(db, &countingPollerAPI{}, config.CurioSealConfig{})
+
+	_, err := db.Exec(ctx, `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof, after_sdr) VALUES
+		(1000, 1, 0, FALSE),
+		(1000, 2, 0, TRUE),
+		(1000, 3, 0, FALSE),
+		(1001, 1, 0, FALSE)`)
+	require.NoError(t, err)
+
+	_, err = db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET failed = TRUE, failed_at = NOW() - INTERVAL '1 hour',
+		failed_reason = 'precommit_check', failed_reason_msg = 'bad ticket' WHERE sp_id = 1000 AND sector_number = 1`)
+	require.NoError(t, err)
+	_, err = db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET failed = TRUE, failed_at = NOW(),
+		failed_reason = 'alloc_failed', failed_reason_msg = 'no space' WHERE sp_id = 1000 AND sector_number = 2`)
+	require.NoError(t, err)
+	_, err = db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET failed = TRUE, failed_at = NOW() - INTERVAL '2 hours',
+		failed_reason = 'sdr_failed' WHERE sp_id = 1001 AND sector_number = 1`)
+	require.NoError(t, err)
+
+	failed, err := s.ListFailedSectors(ctx, 0)
+	require.NoError(t, err)
+	require.Len(t, failed, 3, "healthy sectors must not be listed")
+
+	for i, want := range []struct {
+		spID, sector int64
+		stage        string
+		reason, msg  string
+	}{
+		{1000, 2, "trees", "alloc_failed", "no space"},
+		{1000, 1, "sdr", "precommit_check", "bad ticket"},
+		{1001, 1, "sdr", "sdr_failed", ""},
+	} {
+		require.Equal(t, want.spID, failed[i].SpID)
+		require.Equal(t, want.sector, failed[i].SectorNumber)
+		require.Equal(t, want.stage, failed[i].Stage)
+		require.Equal(t, want.reason, failed[i].FailedReason)
+		require.Equal(t, want.msg, failed[i].FailedReasonMsg)
+		require.NotNil(t, failed[i].FailedAt)
+	}
+
+	failed, err = s.ListFailedSectors(ctx, 1001)
+	require.NoError(t, err)
+	require.Len(t, failed, 1)
+	require.EqualValues(t, 1001, failed[0].SpID)
+}
+
+func TestListAwaitingSeed(t *testing.T) {
+	ctx := context.Background()
+
+	db := testPollerDB(t)
+	s := NewPoller(db, &countingPollerAPI{head: headAt(100)}, config.CurioSealConfig{})
+
+	_, err := db.Exec(ctx, `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof,
+		after_precommit_msg, after_precommit_msg_success, seed_epoch, after_porep, failed) VALUES
+		(1000, 1, 0, TRUE, TRUE, 90, FALSE, FALSE),
+		(1000, 2, 0, TRUE, TRUE, 97, FALSE, FALSE),
+		(1000, 3, 0, TRUE, TRUE, 98, FALSE, FALSE),
+		(1000, 4, 0, TRUE, TRUE, 110, FALSE, FALSE),
+		(1000, 5, 0, TRUE, TRUE, 105, FALSE, TRUE),
+		(1000, 6, 0, TRUE, TRUE, 105, TRUE, FALSE),
+		(1000, 7, 0, TRUE, FALSE, NULL, FALSE, FALSE),
+		(1001, 1, 0, TRUE, TRUE, 120, FALSE, FALSE)`)
+	require.NoError(t, err)
+
+	waits, err := s.ListAwaitingSeed(ctx, 1000)
+	require.NoError(t, err)
+	require.Len(t, waits, 2, "only sectors before seed confidence must be listed")
+
+	for i, want := range []struct {
+		sector    int64
+		seed      abi.ChainEpoch
+		remaining abi.ChainEpoch
+	}{
+		{3, 98, 1},
+		{4, 110, 13},
+	} {
+		require.EqualValues(t, 1000, waits[i].SpID)
+		require.Equal(t, want.sector, waits[i].SectorNumber)
+		require.Equal(t, want.seed, waits[i].SeedEpoch)
+		require.Equal(t, abi.ChainEpoch(100), waits[i].Head)
+		require.Equal(t, want.remaining, waits[i].Remaining)
+	}
+
+	waits, err = s.ListAwaitingSeed(ctx, 0)
+	require.NoError(t, err)
+	require.Len(t, waits, 3)
+	require.EqualValues(t, 1001, waits[2].SpID)
+	require.Equal(t, abi.ChainEpoch(23), waits[2].Remaining)
+}
+
+func TestAPIBreaker(t *testing.T) {
+	ctx := context.Background()
+
+	api := &countingPollerAPI{head: headAt(100), headErr: fmt.Errorf("connection refused")}
+	s := NewPoller(nil, api, config.CurioSealConfig{
+		PollerBreakerThreshold: 3,
+		PollerBreakerCooldown:  config.Duration(time.Minute),
+	})
+
+	now := time.Now()
+	s.apiBreaker.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		require.False(t, s.apiDegraded())
+		_, err := s.api.ChainHead(ctx)
+		require.ErrorContains(t, err, "connection refused")
+	}
+	require.True(t, s.apiDegraded(), "breaker must open after consecutive failures")
+
+	for i := 0; i < 5; i++ {
+		_, err := s.api.ChainHead(ctx)
+		require.ErrorIs(t, err, errAPIBreakerOpen)
+	}
+	require.Equal(t, 3, api.heads, "calls must be suppressed while the breaker is open")
+
+	// a failed trial call after the cooldown opens the breaker again
+	now = now.Add(time.Minute)
+	_, err := s.api.ChainHead(ctx)
+	require.ErrorContains(t, err, "connection refused")
+	require.Equal(t, 4, api.heads)
+	require.True(t, s.apiDegraded())
+
+	_, err = s.api.ChainHead(ctx)
+	require.ErrorIs(t, err, errAPIBreakerOpen)
+	require.Equal(t, 4, api.heads)
+
+	// a successful trial call closes it
+	now = now.Add(time.Minute)
+	api.headErr = nil
+	ts, err := s.api.ChainHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, abi.ChainEpoch(100), ts.Height())
+	require.False(t, s.apiDegraded())
+
+	// without a threshold the breaker is disabled
+	require.Nil(t, NewPoller(nil, api, config.CurioSealConfig{}).apiBreaker)
+}
+
+func TestPollInterval(t *testing.T) {
+	s := NewPoller(nil, &countingPollerAPI{}, config.CurioSealConfig{})
+	require.Equal(t, sealPollerInterval, s.pollInterval())
+
+	s = NewPoller(nil, &countingPollerAPI{}, config.CurioSealConfig{PollerJitter: 0.2})
+
+	lo := time.Duration(float64(sealPollerInterval) * 0.8)
+	hi := time.Duration(float64(sealPollerInterval) * 1.2)
+
+	seen := map[time.Duration]struct{}{}
+	for i := 0; i < 100; i++ {
+		iv := s.pollInterval()
+		require.GreaterOrEqual(t, iv, lo)
+		require.LessOrEqual(t, iv, hi)
+		seen[iv] = struct{}{}
+	}
+	require.Greater(t, len(seen), 1, "intervals must vary")
+
+	s = NewPoller(nil, &countingPollerAPI{}, config.CurioSealConfig{PollerJitter: 1.5})
+	require.Equal(t, sealPollerInterval, s.pollInterval(), "invalid jitter must be ignored")
+}
+
+func TestPollerLogHook(t *testing.T) {
+	ctx := context.Background()
+
+	type event struct {
+		level, msg string
+		kv         []any
+	}
+
+	var events []event
+	hook := func(level, msg string, kv ...any) {
+		events = append(events, event{level: level, msg: msg, kv: kv})
+	}
+
+	s := NewPoller(nil, &countingPollerAPI{}, config.CurioSealConfig{})
+	s.SetLogHook(hook)
+
+	pollErr := fmt.Errorf("boom")
+	s.mustPoll(pollErr)
+	require.Equal(t, []event{{level: "error", msg: "poller operation failed", kv: []any{"error", pollErr}}}, events)
+
+	// sector failures are reported with their fields
+	db := testPollerDB(t)
+	events = nil
+
+	s = NewPoller(db, &countingPollerAPI{}, config.CurioSealConfig{MaxTaskAttempts: 1})
+	s.SetLogHook(hook)
+
+	_, err := db.Exec(ctx, `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof) VALUES (1000, 1, 0)`)
+	require.NoError(t, err)
+
+	require.False(t, s.checkAttempts(ctx, pollTask{SpID: 1000, SectorNumber: 1}, "sdr", 1))
+	require.Len(t, events, 1)
+	require.Equal(t, "error", events[0].level)
+	require.Equal(t, "pipeline stage attempted too many times, failing sector", events[0].msg)
+	require.Equal(t, []any{"sp", int64(1000), "sector", int64(1), "stage", "sdr", "attempts", 1}, events[0].kv)
+}
+
+func TestPollSummary(t *testing.T) {
+	ctx := context.Background()
+
+	db := testPollerDB(t)
+	s := NewPoller(db, &countingPollerAPI{head: headAt(100)}, config.CurioSealConfig{MaxTaskAttempts: 1})
+	s.pollers[pollerSDR].Set(dbTaskAdder(ctx, t, db))
+	s.pollers[pollerPrecommitMsg].Set(func(func(harmonytask.TaskID, *harmonydb.Tx) (bool, error)) {})
+
+	var summaries [][]any
+	s.SetLogHook(func(level, msg string, kv ...any) {
+		if msg == "seal poll cycle done" {
+			require.Equal(t, "info", level)
+			summaries = append(summaries, kv)
+		}
+	})
+
+	// sector 1 starts SDR, sector 2 is out of SDR attempts and fails, the
+	// precommit message of sector 3 lands
+	_, err := db.Exec(ctx, `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof, attempts_sdr,
+		after_sdr, after_tree_d, after_tree_c, after_tree_r, precommit_msg_cid, after_precommit_msg) VALUES
+		(1000, 1, 0, 0, FALSE, FALSE, FALSE, FALSE, NULL, FALSE),
+		(1000, 2, 0, 1, FALSE, FALSE, FALSE, FALSE, NULL, FALSE),
+		(1000, 3, 0, 1, TRUE, TRUE, TRUE, TRUE, 'pcmsg', TRUE)`)
+	require.NoError(t, err)
+	_, err = db.Exec(ctx, `INSERT INTO message_waits (signed_message_cid, executed_tsk_cid, executed_tsk_epoch, executed_msg_cid, executed_rcpt_exitcode, executed_rcpt_gas_used)
+		VALUES ('pcmsg', 'tsk1', 10, 'pcmsg', 0, 1000)`)
+	require.NoError(t, err)
+
+	require.NoError(t, s.poll(ctx))
+	require.Len(t, summaries, 1)
+
+	kv := map[string]any{}
+	for i := 0; i+1 < len(summaries[0]); i += 2 {
+		kv[summaries[0][i].(string)] = summaries[0][i+1]
+	}
+	require.Equal(t, 3, kv["sectors"])
+	require.Equal(t, map[string]int{"sdr": 1}, kv["started"])
+	require.Equal(t, 1, kv["landed"])
+	require.Equal(t, 1, kv["failed"])
+	require.IsType(t, time.Duration(0), kv["took"])
+
+	// the next cycle starts counting from zero
+	require.NoError(t, s.poll(ctx))
+	require.Len(t, summaries, 2)
+	require.Equal(t, []any{"sectors", 3, "started", map[string]int{}, "landed", 0, "failed", 0}, summaries[1][:8])
+}
+
+func TestPipelineStats(t *testing.T) {
+	ctx := context.Background()
+
+	db := testPollerDB(t)
+	s := NewPoller(db, &countingPollerAPI{}, config.CurioSealConfig{})
+
+	_, err := db.Exec(ctx, `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof, create_time) VALUES
+		(1000, 1, 0, NOW() - INTERVAL '2 hours'),
+		(1000, 2, 0, NOW() - INTERVAL '1 hour'),
+		(1000, 3, 0, NOW() - INTERVAL '3 hours'),
+		(1000, 4, 0, NOW() - INTERVAL '5 hours'),
+		(1001, 1, 0, NOW() - INTERVAL '9 hours')`)
+	require.NoError(t, err)
+
+	// sector 2 is in trees, 3 failed in sdr, 4 went through the whole pipeline
+	_, err = db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET after_sdr = TRUE WHERE sp_id = 1000 AND sector_number = 2`)
+	require.NoError(t, err)
+	_, err = db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET failed = TRUE, failed_at = NOW() WHERE sp_id = 1000 AND sector_number = 3`)
+	require.NoError(t, err)
+	_, err = db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET after_sdr = TRUE, after_tree_d = TRUE, after_tree_c = TRUE, after_tree_r = TRUE,
+		after_precommit_msg = TRUE, after_precommit_msg_success = TRUE, after_porep = TRUE, after_finalize = TRUE,
+		after_move_storage = TRUE, after_commit_msg = TRUE, after_commit_msg_success = TRUE
+		WHERE sp_id = 1000 AND sector_number = 4`)
+	require.NoError(t, err)
+
+	// sdr takes 60s for sector 2 and 120s (with a retry) for sector 4, trees
+	// take 300s for sector 4; the last stage of each sector isn't completed
+	_, err = db.Exec(ctx, `INSERT INTO sector_pipeline_events (sp_id, sector_number, stage, action, event_time) VALUES
+		(1000, 2, 'sdr', 'task_started', '2024-01-01 00:00:00'),
+		(1000, 2, 'trees', 'task_started', '2024-01-01 00:01:00'),
+		(1000, 4, 'sdr', 'task_started', '2024-01-01 00:00:00'),
+		(1000, 4, 'sdr', 'retry', '2024-01-01 00:01:00'),
+		(1000, 4, 'trees', 'task_started', '2024-01-01 00:02:00'),
+		(1000, 4, 'precommit_msg', 'task_started', '2024-01-01 00:07:00'),
+		(1001, 1, 'sdr', 'task_started', '2024-01-01 00:00:00')`)
+	require.NoError(t, err)
+
+	stats, err := s.PipelineStats(ctx, 1000)
+	require.NoError(t, err)
+
+	require.Equal(t, map[string]int64{"sdr": 1, "trees": 1, stageDone: 1}, stats.Stages)
+	require.EqualValues(t, 1, stats.Failed)
+	require.InDelta(t, float64(2*time.Hour), float64(stats.OldestInFlight), float64(time.Minute))
+	require.Equal(t, map[string]time.Duration{
+		"sdr":   90 * time.Second,
+		"trees": 300 * time.Second,
+	}, stats.AvgStageTime)
+
+	stats, err = s.PipelineStats(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{"sdr": 2, "trees": 1, stageDone: 1}, stats.Stages)
+	require.InDelta(t, float64(9*time.Hour), float64(stats.OldestInFlight), float64(time.Minute))
+}
+
+func TestStageEnabled(t *testing.T) {
+	ctx := context.Background()
+
+	db := testPollerDB(t)
+
+	s := NewPoller(db, &countingPollerAPI{head: headAt(100)}, config.CurioSealConfig{})
+	for i := range s.pollers {
+		s.pollers[i].Set(dbTaskAdder(ctx, t, db))
+	}
+
+	require.Error(t, s.SetStageEnabled("no_such_stage", false))
+	require.NoError(t, s.SetStageEnabled("commit_msg", false))
+	require.Equal(t, []string{"commit_msg"}, s.Health().DisabledStages)
+
+	// sector 1 just finished PoRep, sector 2 is waiting for SDR
+	_, err := db.Exec(ctx, `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof, after_sdr, after_tree_d, after_tree_c, after_tree_r,
+			after_precommit_msg, after_precommit_msg_success, seed_epoch, porep_proof, after_porep)
+		VALUES (1000, 1, 0, TRUE, TRUE, TRUE, TRUE, TRUE, TRUE, 50, '\x01', TRUE), (1000, 2, 0, FALSE, FALSE, FALSE, FALSE, FALSE, FALSE, NULL, NULL, FALSE)`)
+	require.NoError(t, err)
+
+	type sectorTasks struct {
+		SectorNumber int64  `db:"sector_number"`
+		TaskSDR      *int64 `db:"task_id_sdr"`
+		TaskFinalize *int64 `db:"task_id_finalize"`
+		TaskCommit   *int64 `db:"task_id_commit_msg"`
+	}
+	get := func() []sectorTasks {
+		var out []sectorTasks
+		require.NoError(t, db.Select(ctx, &out, `SELECT sector_number, task_id_sdr, task_id_finalize, task_id_commit_msg
+			FROM sectors_sdr_pipeline ORDER BY sector_number`))
+		require.Len(t, out, 2)
+		return out
+	}
+
+	require.NoError(t, s.poll(ctx))
+
+	sectors := get()
+	require.NotNil(t, sectors[0].TaskFinalize, "compute stages must keep running")
+	require.Nil(t, sectors[0].TaskCommit, "disabled stage must not start")
+	require.NotNil(t, sectors[1].TaskSDR)
+
+	require.NoError(t, s.SetStageEnabled("commit_msg", true))
+	require.Empty(t, s.Health().DisabledStages)
+
+	require.NoError(t, s.poll(ctx))
+	require.NotNil(t, get()[0].TaskCommit)
+}
+
+func TestPauseSector(t *testing.T) {
+	ctx := context.Background()
+
+	db := testPollerDB(t)
+
+	s := NewPoller(db, &countingPollerAPI{head: headAt(100)}, config.CurioSealConfig{})
+	for i := range s.pollers {
+		s.pollers[i].Set(dbTaskAdder(ctx, t, db))
+	}
+
+	// sector 1 is waiting for SDR, sector 2 sent its precommit message which
+	// has now landed
+	_, err := db.Exec(ctx, `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof, after_sdr, after_tree_d, after_tree_c, after_tree_r,
+			precommit_msg_cid, after_precommit_msg)
+		VALUES (1000, 1, 0, FALSE, FALSE, FALSE, FALSE, NULL, FALSE), (1000, 2, 0, TRUE, TRUE, TRUE, TRUE, 'pcmsg', TRUE)`)
+	require.NoError(t, err)
+	_, err = db.Exec(ctx, `INSERT INTO message_waits (signed_message_cid, executed_tsk_cid, executed_tsk_epoch, executed_msg_cid, executed_rcpt_exitcode, executed_rcpt_gas_used)
+		VALUES ('pcmsg', 'tsk1', 10, 'pcmsg', 0, 123456)`)
+	require.NoError(t, err)
+
+	require.NoError(t, s.PauseSector(ctx, 1000, 1))
+	require.NoError(t, s.PauseSector(ctx, 1000, 2))
+	require.Error(t, s.PauseSector(ctx, 1000, 3), "sector not in the pipeline")
+
+	type sectorState struct {
+		SectorNumber     int64  `db:"sector_number"`
+		TaskSDR          *int64 `db:"task_id_sdr"`
+		PrecommitSuccess bool   `db:"after_precommit_msg_success"`
+		Paused           bool   `db:"paused"`
+	}
+	get := func() []sectorState {
+		var out []sectorState
+		require.NoError(t, db.Select(ctx, &out, `SELECT sector_number, task_id_sdr, after_precommit_msg_success, paused
+			FROM sectors_sdr_pipeline ORDER BY sector_number`))
+		require.Len(t, out, 2)
+		return out
+	}
+
+	require.NoError(t, s.poll(ctx))
+
+	sectors := get()
+	require.True(t, sectors[0].Paused)
+	require.Nil(t, sectors[0].TaskSDR, "paused sector must not get new tasks")
+	require.True(t, sectors[1].PrecommitSuccess, "landing checks must run for paused sectors")
+
+	require.NoError(t, s.ResumeSector(ctx, 1000, 1))
+	require.NoError(t, s.poll(ctx))
+
+	sectors = get()
+	require.False(t, sectors[0].Paused)
+	require.NotNil(t, sectors[0].TaskSDR)
+}
+
+func TestPollerLeaderElection(t *testing.T) {
+	ctx := context.Background()
+
+	db := testPollerDB(t)
+
+	cfg := config.CurioSealConfig{PollerLeaderElection: true}
+	first := NewPoller(db, &countingPollerAPI{}, cfg)
+	second := NewPoller(db, &countingPollerAPI{}, cfg)
+	defer first.releaseLeadership()
+	defer second.releaseLeadership()
+
+	require.True(t, first.isLeader(ctx))
+	require.False(t, second.isLeader(ctx))
+
+	// leadership is kept across cycles
+	require.True(t, first.isLeader(ctx))
+	require.False(t, second.isLeader(ctx))
+
+	// the leader going away lets the other instance take over
+	first.releaseLeadership()
+	require.True(t, second.isLeader(ctx))
+	require.False(t, first.isLeader(ctx))
+
+	// so does the leader's lease expiring, the old leader notices on renewal
+	_, err := db.Exec(ctx, `UPDATE harmony_leases SET expires_at = NOW() - INTERVAL '1 second' WHERE name = $1`, sealPollerLease)
+	require.NoError(t, err)
+	require.True(t, first.isLeader(ctx))
+	require.False(t, second.isLeader(ctx))
+	require.Nil(t, second.leaderLease)
+
+	// without leader election every poller polls
+	require.True(t, NewPoller(db, &countingPollerAPI{}, config.CurioSealConfig{}).isLeader(ctx))
+}
+
+func TestPoRepWaitsForSeedRandomness(t *testing.T) {
+	ctx := context.Background()
+
+	seed := int64(10)
+	task := pollTask{
+		SpID: 1000, SectorNumber: 1,
+		AfterSDR: true, AfterTreeD: true, AfterTreeC: true, AfterTreeR: true,
+		AfterPrecommitMsg: true, AfterPrecommitMsgSuccess: true,
+		SeedEpoch: &seed,
+	}
+	head := headAt(100)
+
+	var added bool
+	newPoller := func(api SealPollerAPI, cfg config.CurioSealConfig) *SealPoller {
+		s := NewPoller(nil, api, cfg)
+		s.pollers[pollerPoRep].Set(func(func(harmonytask.TaskID, *harmonydb.Tx) (bool, error)) {
+			added = true
+		})
+		return s
+	}
+	started := func(s *SealPoller) bool {
+		added = false
+		s.pollStartPoRep(ctx, task, head)
+		return added
+	}
+
+	api := &countingPollerAPI{randomnessErr: fmt.Errorf("beacon entry for epoch 10 not found")}
+
+	// the check is opt-in
+	require.True(t, started(newPoller(api, config.CurioSealConfig{})))
+
+	s := newPoller(api, config.CurioSealConfig{CheckSeedRandomness: true})
+	require.False(t, started(s), "porep must wait for seed randomness")
+
+	api.randomnessErr = nil
+	require.True(t, started(s))
+}
+
+func TestPoRepRequiresTreeR(t *testing.T) {
+	ctx := context.Background()
+
+	seed := int64(10)
+	task := pollTask{
+		SpID: 1000, SectorNumber: 1,
+		AfterSDR: true, AfterTreeD: true, AfterTreeC: true, AfterTreeR: true,
+		AfterPrecommitMsg: true, AfterPrecommitMsgSuccess: true,
+		SeedEpoch: &seed,
+	}
+
+	var added bool
+	s := NewPoller(nil, &countingPollerAPI{}, config.CurioSealConfig{})
+	s.pollers[pollerPoRep].Set(func(func(harmonytask.TaskID, *harmonydb.Tx) (bool, error)) {
+		added = true
+	})
+
+	// precommit success recorded, but tree_r rolled back
+	task.AfterTreeR = false
+	s.pollStartPoRep(ctx, task, headAt(100))
+	require.False(t, added, "porep must not start without tree_r")
+
+	task.AfterTreeR = true
+	s.pollStartPoRep(ctx, task, headAt(100))
+	require.True(t, added)
+}
+
+func TestSkipExistingPrecommit(t *testing.T) {
+	ctx := context.Background()
+
+	task := pollTask{SpID: 1000, SectorNumber: 1, AfterSDR: true, AfterTreeD: true, AfterTreeC: true, AfterTreeR: true}
+
+	var added bool
+	adder := func(func(harmonytask.TaskID, *harmonydb.Tx) (bool, error)) {
+		added = true
+	}
+
+	// without the option the precommit message is always sent
+	s := NewPoller(nil, &countingPollerAPI{}, config.CurioSealConfig{})
+	s.pollers[pollerPrecommitMsg].Set(adder)
+	s.pollStartPrecommitMsg(ctx, task, &msgInFlight{})
+	require.True(t, added)
+
+	db := testPollerDB(t)
+
+	sealed := mock.MkBlock(nil, 1, 1).Cid()
+	other := mock.MkBlock(nil, 2, 2).Cid()
+
+	// sector 1 matches the on-chain precommit, sector 2 was sealed with other
+	// data, sector 3 already sent a precommit message
+	_, err := db.Exec(ctx, `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof, after_sdr, after_tree_d, after_tree_c, after_tree_r, tree_r_cid, precommit_msg_cid)
+		VALUES ($1, 1, 0, TRUE, TRUE, TRUE, TRUE, $2, NULL), ($1, 2, 0, TRUE, TRUE, TRUE, TRUE, $3, NULL), ($1, 3, 0, TRUE, TRUE, TRUE, TRUE, $2, 'pcmsg')`,
+		task.SpID, sealed.String(), other.String())
+	require.NoError(t, err)
+
+	// countingPollerAPI reports a precommit at epoch 10
+	api := &countingPollerAPI{precommitSealed: sealed}
+	s = NewPoller(db, api, config.CurioSealConfig{SkipExistingPrecommit: true})
+	s.pollers[pollerPrecommitMsg].Set(adder)
+
+	added = false
+	s.pollStartPrecommitMsg(ctx, task, &msgInFlight{})
+	require.False(t, added, "precommit message must not be sent")
+	require.Equal(t, 1, api.precommits)
+
+	added = false
+	task.SectorNumber = 2
+	s.pollStartPrecommitMsg(ctx, task, &msgInFlight{})
+	require.False(t, added, "precommit message must not be sent")
+	require.Equal(t, 2, api.precommits)
+
+	added = false
+	task.SectorNumber = 3
+	s.pollStartPrecommitMsg(ctx, task, &msgInFlight{})
+	require.True(t, added)
+	require.Equal(t, 2, api.precommits, "sectors which sent a precommit message aren't checked")
+
+	var sectors []struct {
+		AfterPrecommitMsg        bool   `db:"after_precommit_msg"`
+		AfterPrecommitMsgSuccess bool   `db:"after_precommit_msg_success"`
+		SeedEpoch                *int64 `db:"seed_epoch"`
+		Failed                   bool   `db:"failed"`
+		FailedReason             string `db:"failed_reason"`
+	}
+	require.NoError(t, db.Select(ctx, &sectors, `SELECT after_precommit_msg, after_precommit_msg_success, seed_epoch, failed, COALESCE(failed_reason, '') AS failed_reason
+		FROM sectors_sdr_pipeline ORDER BY sector_number`))
+	require.Len(t, sectors, 3)
+	require.True(t, sectors[0].AfterPrecommitMsg)
+	require.True(t, sectors[0].AfterPrecommitMsgSuccess)
+	require.NotNil(t, sectors[0].SeedEpoch)
+	require.EqualValues(t, 10+policy.GetPreCommitChallengeDelay(), *sectors[0].SeedEpoch)
+	require.False(t, sectors[0].Failed)
+
+	require.False(t, sectors[1].AfterPrecommitMsg)
+	require.True(t, sectors[1].Failed)
+	require.Equal(t, "precommit_mismatch", sectors[1].FailedReason)
+
+	require.False(t, sectors[2].AfterPrecommitMsgSuccess)
+	require.False(t, sectors[2].Failed)
+}
+
+func TestPollerHealth(t *testing.T) {
+	s := NewPoller(nil, &countingPollerAPI{}, config.CurioSealConfig{})
+
+	// split trees pollers aren't used by default
+	h := s.Health()
+	require.True(t, h.LastPoll.IsZero())
+	require.Len(t, h.UnsetPollers, numPollers-2)
+	require.NotContains(t, h.UnsetPollers, "tree_d")
+
+	s.pollers[pollerSDR].Set(func(func(harmonytask.TaskID, *harmonydb.Tx) (bool, error)) {})
+
+	// failing cycles
+	s.recordPoll(0, fmt.Errorf("db down"))
+	s.recordPoll(0, fmt.Errorf("db down"))
+
+	h = s.Health()
+	require.True(t, h.LastPoll.IsZero())
+	require.Equal(t, 2, h.ConsecutiveErrors)
+	require.Len(t, h.UnsetPollers, numPollers-3)
+	require.NotContains(t, h.UnsetPollers, "sdr")
+
+	before := time.Now()
+	s.recordPoll(5, nil)
+
+	h = s.Health()
+	require.False(t, h.LastPoll.Before(before))
+	require.Equal(t, 5, h.Sectors)
+	require.Zero(t, h.ConsecutiveErrors)
+
+	// a failed cycle keeps the last successful poll info
+	s.recordPoll(0, fmt.Errorf("db down"))
+
+	h = s.Health()
+	require.Equal(t, 5, h.Sectors)
+	require.Equal(t, 1, h.ConsecutiveErrors)
+}
+
+func TestUnsetPollerWarning(t *testing.T) {
+	s := NewPoller(nil, &countingPollerAPI{}, config.CurioSealConfig{})
+
+	var warned []string
+	s.SetLogHook(func(level, msg string, kv ...any) {
+		if level == "warn" {
+			warned = append(warned, kv[1].(string))
+		}
+	})
+
+	// a fresh sector is only eligible for sdr
+	tasks := []pollTask{{SpID: 1000, SectorNumber: 1}}
+	check := func(at time.Time) {
+		s.checkUnsetPollers(tasks, 100, countMsgInFlight(tasks), at)
+	}
+
+	t0 := time.Now()
+	check(t0)
+	require.Empty(t, warned, "must not warn before the threshold")
+
+	check(t0.Add(unsetPollerWarnAfter))
+	require.Equal(t, []string{"sdr"}, warned)
+
+	// rate limited
+	check(t0.Add(unsetPollerWarnAfter + time.Minute))
+	require.Len(t, warned, 1)
+
+	check(t0.Add(unsetPollerWarnAfter + unsetPollerWarnInterval))
+	require.Equal(t, []string{"sdr", "sdr"}, warned)
+
+	// no warnings once the task adder is registered, or without eligible sectors
+	s.pollers[pollerSDR].Set(func(func(harmonytask.TaskID, *harmonydb.Tx) (bool, error)) {})
+	check(t0.Add(time.Hour))
+	require.Len(t, warned, 2)
+
+	tasks[0].Paused = true
+	s2 := NewPoller(nil, &countingPollerAPI{}, config.CurioSealConfig{})
+	s2.SetLogHook(s.logHook)
+	s2.checkUnsetPollers(tasks, 100, countMsgInFlight(tasks), t0)
+	s2.checkUnsetPollers(tasks, 100, countMsgInFlight(tasks), t0.Add(time.Hour))
+	require.Len(t, warned, 2)
+}
+
+func TestTreesProgression(t *testing.T) {
+	ctx := context.Background()
+
+	type treeTasks struct {
+		TaskTreeD      *int64 `db:"task_id_tree_d"`
+		TaskTreeC      *int64 `db:"task_id_tree_c"`
+		TaskTreeR      *int64 `db:"task_id_tree_r"`
+		AttemptsTrees  int    `db:"attempts_trees"`
+		AttemptsTreeRC int    `db:"attempts_tree_rc"`
+	}
+
+	setup := func(t *testing.T, split bool) (*SealPoller, *harmonydb.DB, func() treeTasks) {
+		db := testPollerDB(t)
+
+		s := NewPoller(db, &countingPollerAPI{head: headAt(100)}, config.CurioSealConfig{SplitTrees: split})
+		for _, p := range []int{pollerTrees, pollerTreeD, pollerTreeRC} {
+			s.pollers[p].Set(dbTaskAdder(ctx, t, db))
+		}
+
+		_, err := db.Exec(ctx, `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof, after_sdr) VALUES (1000, 1, 0, TRUE)`)
+		require.NoError(t, err)
+
+		get := func() treeTasks {
+			var tt []treeTasks
+			require.NoError(t, db.Select(ctx, &tt, `SELECT task_id_tree_d, task_id_tree_c, task_id_tree_r, attempts_trees, attempts_tree_rc FROM sectors_sdr_pipeline`))
+			require.Len(t, tt, 1)
+			return tt[0]
+		}
+
+		return s, db, get
+	}
+
+	t.Run("combined", func(t *testing.T) {
+		s, _, get := setup(t, false)
+
+		require.NoError(t, s.poll(ctx))
+
+		tt := get()
+		require.NotNil(t, tt.TaskTreeD)
+		require.Equal(t, tt.TaskTreeD, tt.TaskTreeC)
+		require.Equal(t, tt.TaskTreeD, tt.TaskTreeR)
+		require.Equal(t, 1, tt.AttemptsTrees)
+		require.Zero(t, tt.AttemptsTreeRC)
+	})
+
+	t.Run("split", func(t *testing.T) {
+		s, db, get := setup(t, true)
+
+		require.NoError(t, s.poll(ctx))
+
+		tt := get()
+		require.NotNil(t, tt.TaskTreeD)
+		require.Nil(t, tt.TaskTreeC, "TreeRC must wait for TreeD")
+		require.Nil(t, tt.TaskTreeR)
+		require.Equal(t, 1, tt.AttemptsTrees)
+
+		// nothing changes until TreeD is done
+		require.NoError(t, s.poll(ctx))
+		require.Equal(t, tt, get())
+
+		treeD := *tt.TaskTreeD
+		_, err := db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET task_id_tree_d = NULL, after_tree_d = TRUE, tree_d_cid = 'treed' WHERE sp_id = 1000 AND sector_number = 1`)
+		require.NoError(t, err)
+
+		require.NoError(t, s.poll(ctx))
+
+		tt = get()
+		require.Nil(t, tt.TaskTreeD)
+		require.NotNil(t, tt.TaskTreeC)
+		require.Equal(t, tt.TaskTreeC, tt.TaskTreeR)
+		require.NotEqual(t, treeD, *tt.TaskTreeC)
+		require.Equal(t, 1, tt.AttemptsTrees)
+		require.Equal(t, 1, tt.AttemptsTreeRC)
+	})
+}
+
+func TestRunPollSkipsOverlap(t *testing.T) {
+	ctx := context.Background()
+
+	var skipped []string
+	s := &SealPoller{}
+	s.SetLogHook(func(level, msg string, kv ...any) {
+		skipped = append(skipped, msg)
+	})
+
+	started, release := make(chan struct{}), make(chan struct{})
+	var polls int
+	slowPoll := func(context.Context) error {
+		polls++
+		close(started)
+		<-release
+		return nil
+	}
+
+	done := make(chan bool)
+	go func() {
+		done <- s.runPoll(ctx, slowPoll)
+	}()
+	<-started
+
+	// ticks while the slow poll runs are skipped
+	require.False(t, s.runPoll(ctx, slowPoll))
+	require.False(t, s.runPoll(ctx, slowPoll))
+	require.Equal(t, []string{"poll still running, skipping tick", "poll still running, skipping tick"}, skipped)
+
+	close(release)
+	require.True(t, <-done)
+	require.Equal(t, 1, polls)
+
+	// once it finished the next tick polls again
+	require.True(t, s.runPoll(ctx, func(context.Context) error {
+		polls++
+		return nil
+	}))
+	require.Equal(t, 2, polls)
+}
+
+func TestSectorETARemaining(t *testing.T) {
+	for _, split := range []bool{false, true} {
+		s := NewPoller(nil, nil, config.CurioSealConfig{
+			SplitTrees: split,
+			StageTimeDefaults: map[string]config.Duration{
+				"porep": config.Duration(time.Hour),
+			},
+		})
+
+		// cold start, configured and built-in defaults only
+		require.Equal(t, time.Hour+defaultStageTimes["finalize"]+defaultStageTimes["move_storage"]+defaultStageTimes["commit_msg"],
+			s.remainingTime("porep", nil))
+
+		avg := map[string]time.Duration{
+			"sdr":   2 * time.Hour,
+			"porep": 20 * time.Minute,
+		}
+		require.Equal(t, 20*time.Minute+defaultStageTimes["finalize"]+defaultStageTimes["move_storage"]+defaultStageTimes["commit_msg"],
+			s.remainingTime("porep", avg))
+
+		for _, hist := range []map[string]time.Duration{nil, avg} {
+			prev := s.remainingTime(forceAdvanceStages[0].name, hist)
+			for _, stage := range forceAdvanceStages[1:] {
+				cur := s.remainingTime(stage.name, hist)
+				require.LessOrEqual(t, cur, prev, stage.name)
+				prev = cur
+			}
+			require.Less(t, s.remainingTime("porep", hist), s.remainingTime("trees", hist))
+			require.Less(t, s.remainingTime("trees", hist), s.remainingTime("sdr", hist))
+		}
+
+		require.Zero(t, s.remainingTime(stageDone, avg))
+	}
+}
+
+// reconcilePollerAPI serves a miner with committed and precommitted sectors
+type reconcilePollerAPI struct {
+	*countingPollerAPI
+
+	committed  []*miner.SectorOnChainInfo
+	precommits map[abi.SectorNumber]*miner.SectorPreCommitOnChainInfo
+	allocated  []uint64
+}
+
+func (r *reconcilePollerAPI) StateSectorPreCommitInfo(_ context.Context, _ address.Address, n abi.SectorNumber, _ types.TipSetKey) (*miner.SectorPreCommitOnChainInfo, error) {
+	return r.precommits[n], nil
+}
+
+func (r *reconcilePollerAPI) StateMinerAllocated(context.Context, address.Address, types.TipSetKey) (*bitfield.BitField, error) {
+	bf := bitfield.NewFromSet(r.allocated)
+	return &bf, nil
+}
+
+func (r *reconcilePollerAPI) StateMinerSectors(context.Context, address.Address, *bitfield.BitField, types.TipSetKey) ([]*miner.SectorOnChainInfo, error) {
+	return r.committed, nil
+}
+
+func TestReconcileFromChain(t *testing.T) {
+	ctx := context.Background()
+
+	db := testPollerDB(t)
+
+	const sp = 1000
+	sealed := mock.MkBlock(nil, 1, 1).Cid()
+	unsealed := mock.MkBlock(nil, 1, 2).Cid()
+
+	precommit := func(n abi.SectorNumber) *miner.SectorPreCommitOnChainInfo {
+		return &miner.SectorPreCommitOnChainInfo{
+			Info: miner.SectorPreCommitInfo{
+				SealProof:     abi.RegisteredSealProof_StackedDrg32GiBV1_1,
+				SectorNumber:  n,
+				SealedCID:     sealed,
+				SealRandEpoch: 50,
+				UnsealedCid:   &unsealed,
+			},
+			PreCommitEpoch: 100,
+		}
+	}
+
+	api := &reconcilePollerAPI{
+		countingPollerAPI: &countingPollerAPI{head: headAt(200)},
+		committed: []*miner.SectorOnChainInfo{
+			{SectorNumber: 1, SealProof: abi.RegisteredSealProof_StackedDrg32GiBV1_1, SealedCID: sealed},
+			{SectorNumber: 2, SealProof: abi.RegisteredSealProof_StackedDrg32GiBV1_1, SealedCID: sealed},
+		},
+		precommits: map[abi.SectorNumber]*miner.SectorPreCommitOnChainInfo{
+			3: precommit(3),
+			4: precommit(4),
+		},
+		// 5 is allocated, but neither precommitted nor committed
+		allocated: []uint64{1, 2, 3, 4, 5},
+	}
+	s := NewPoller(db, api, config.CurioSealConfig{})
+
+	// sector 2 is stuck before porep, sector 4 waits for its precommit message
+	_, err := db.Exec(ctx, `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof, after_sdr, after_tree_d, after_tree_c, after_tree_r, after_precommit_msg)
+		VALUES ($1, 2, 8, TRUE, TRUE, TRUE, TRUE, TRUE), ($1, 4, 8, TRUE, TRUE, TRUE, TRUE, FALSE)`, sp)
+	require.NoError(t, err)
+
+	list := func() map[int64]string {
+		sectors, err := s.ListSectors(ctx, sp)
+		require.NoError(t, err)
+		out := map[int64]string{}
+		for _, sector := range sectors {
+			out[sector.SectorNumber] = sector.Stage
+		}
+		return out
+	}
+	seedEpochs := func() map[int64]int64 {
+		var rows []struct {
+			SectorNumber int64 `db:"sector_number"`
+			SeedEpoch    int64 `db:"seed_epoch"`
+		}
+		require.NoError(t, db.Select(ctx, &rows, `SELECT sector_number, seed_epoch FROM sectors_sdr_pipeline WHERE sp_id = $1 AND seed_epoch IS NOT NULL`, sp))
+		out := map[int64]int64{}
+		for _, row := range rows {
+			out[row.SectorNumber] = row.SeedEpoch
+		}
+		return out
+	}
+	events := func() int {
+		var n []int
+		require.NoError(t, db.Select(ctx, &n, `SELECT COUNT(*) FROM sector_pipeline_events WHERE sp_id = $1 AND action = 'reconciled'`, sp))
+		return n[0]
+	}
+
+	require.NoError(t, s.ReconcileFromChain(ctx, sp))
+
+	require.Equal(t, map[int64]string{
+		1: stageDone,
+		2: stageDone,
+		3: "porep",
+		4: "porep",
+	}, list())
+
+	seed := int64(100 + policy.GetPreCommitChallengeDelay())
+	require.Equal(t, map[int64]int64{3: seed, 4: seed}, seedEpochs())
+	require.Equal(t, 4, events())
+
+	// reconciling again changes nothing
+	require.NoError(t, s.ReconcileFromChain(ctx, sp))
+	require.Equal(t, 4, events())
+
+	// a different on-chain precommit epoch is corrected
+	api.precommits[4].PreCommitEpoch = 120
+	require.NoError(t, s.ReconcileFromChain(ctx, sp))
+	require.Equal(t, map[int64]int64{3: seed, 4: seed + 20}, seedEpochs())
+	require.Equal(t, 5, events())
+
+	// the poller doesn't support reconciling without a sector listing API
+	require.Error(t, NewPoller(db, api.countingPollerAPI, config.CurioSealConfig{}).ReconcileFromChain(ctx, sp))
+}
+
+func TestEnrollMsgWait(t *testing.T) {
+	ctx := context.Background()
+
+	db := testPollerDB(t)
+
+	s := NewPoller(db, &countingPollerAPI{head: headAt(100)}, config.CurioSealConfig{})
+	for i := range s.pollers {
+		s.pollers[i].Set(dbTaskAdder(ctx, t, db))
+	}
+
+	const sp = 1000
+	msg := mock.MkBlock(nil, 1, 1).Cid().String()
+
+	// sector 1 sent its precommit message, but the task died before adding
+	// the message wait. Sector 2 still has a message task running.
+	_, err := db.Exec(ctx, `INSERT INTO harmony_task (id, name, added_by, posted_time) VALUES (7001, 'test', 1, CURRENT_TIMESTAMP)`)
+	require.NoError(t, err)
+	_, err = db.Exec(ctx, `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof, after_sdr, after_tree_d, after_tree_c, after_tree_r,
+			precommit_msg_cid, after_precommit_msg, task_id_precommit_msg)
+		VALUES ($1, 1, 8, TRUE, TRUE, TRUE, TRUE, $2, TRUE, NULL), ($1, 2, 8, TRUE, TRUE, TRUE, TRUE, 'bafy-running', TRUE, 7001)`, sp, msg)
+	require.NoError(t, err)
+
+	waits := func() []string {
+		var out []string
+		require.NoError(t, db.Select(ctx, &out, `SELECT signed_message_cid FROM message_waits ORDER BY signed_message_cid`))
+		return out
+	}
+	enrolled := func() int {
+		var n []int
+		require.NoError(t, db.Select(ctx, &n, `SELECT COUNT(*) FROM sector_pipeline_events WHERE sp_id = $1 AND action = $2`, sp, sectorEventWaitEnrolled))
+		return n[0]
+	}
+
+	require.NoError(t, s.poll(ctx))
+	require.Equal(t, []string{msg}, waits())
+	require.Equal(t, 1, enrolled())
+
+	// the message is watched now, nothing changes
+	require.NoError(t, s.poll(ctx))
+	require.Equal(t, []string{msg}, waits())
+	require.Equal(t, 1, enrolled())
+
+	// once the message lands the sector moves on
+	_, err = db.Exec(ctx, `UPDATE message_waits SET executed_tsk_cid = $1, executed_tsk_epoch = 90, executed_msg_cid = $1, executed_rcpt_exitcode = 0, executed_rcpt_gas_used = 1
+		WHERE signed_message_cid = $1`, msg)
+	require.NoError(t, err)
+	require.NoError(t, s.poll(ctx))
+
+	var landed []bool
+	require.NoError(t, db.Select(ctx, &landed, `SELECT after_precommit_msg_success FROM sectors_sdr_pipeline WHERE sp_id = $1 AND sector_number = 1`, sp))
+	require.Equal(t, []bool{true}, landed)
+}
+
+func TestValidateSchema(t *testing.T) {
+	good := map[string]string{}
+	for name, typ := range pipelineSchema {
+		good[name] = typ
+	}
+	require.NoError(t, checkPipelineSchema(good))
+
+	good["seed_epoch"] = "int4"
+	delete(good, "porep_proof")
+	err := checkPipelineSchema(good)
+	require.ErrorContains(t, err, "missing column porep_proof")
+	require.ErrorContains(t, err, "column seed_epoch has type int4, expected int8")
+
+	ctx := context.Background()
+	db := testPollerDB(t)
+	s := NewPoller(db, &countingPollerAPI{}, config.CurioSealConfig{})
+
+	require.NoError(t, s.ValidateSchema(ctx))
+
+	_, err = db.Exec(ctx, `ALTER TABLE sectors_sdr_pipeline DROP COLUMN attempts_porep`)
+	require.NoError(t, err)
+	require.ErrorContains(t, s.ValidateSchema(ctx), "attempts_porep")
+}
+
+func TestExplainSector(t *testing.T) {
+	s := NewPoller(nil, &countingPollerAPI{}, config.CurioSealConfig{MaxTaskAttempts: 3})
+
+	seed := int64(90)
+	task := pollTask{
+		SpID: 1000, SectorNumber: 5,
+		AfterSDR: true, AfterTreeD: true, AfterTreeC: true, AfterTreeR: true,
+		AfterPrecommitMsg: true, AfterPrecommitMsgSuccess: true, SeedEpoch: &seed,
+	}
+
+	decision := func(ds []StageDecision, stage string) StageDecision {
+		for _, d := range ds {
+			if d.Stage == stage {
+				return d
+			}
+		}
+		t.Fatalf("no decision for stage %s", stage)
+		return StageDecision{}
+	}
+
+	ds := s.explainTask(task, 91, msgInFlight{})
+	require.Equal(t, StageDecision{Stage: "sdr", Reason: "done"}, decision(ds, "sdr"))
+	require.Equal(t, StageDecision{Stage: "precommit_msg_landed", Reason: "done"}, decision(ds, "precommit_msg_landed"))
+	require.Equal(t, StageDecision{Stage: "porep", Reason: "waiting for seed, 2 epochs left"}, decision(ds, "porep"))
+	require.Equal(t, StageDecision{Stage: "commit_msg", Reason: "waiting for porep"}, decision(ds, "commit_msg"))
+
+	// the seed is final, porep is the next action and nothing else acts
+	ds = s.explainTask(task, 93, msgInFlight{})
+	for _, d := range ds {
+		require.Equal(t, d.Stage == "porep", d.Act, d.Stage)
+	}
+	require.Equal(t, "would start task", decision(ds, "porep").Reason)
+
+	porep := int64(42)
+	task.TaskPoRep = &porep
+	require.Equal(t, StageDecision{Stage: "porep", Reason: "task 42 running or queued"}, decision(s.explainTask(task, 93, msgInFlight{}), "porep"))
+
+	task.TaskPoRep = nil
+	task.AttemptsPoRep = 3
+	require.Equal(t, StageDecision{Stage: "porep", Act: true, Reason: "would fail sector, stage attempted 3 times"}, decision(s.explainTask(task, 93, msgInFlight{}), "porep"))
+
+	task.Failed, task.FailedReason = true, "porep failed"
+	require.Equal(t, []StageDecision{{Stage: "all", Reason: "sector failed: porep failed"}}, s.explainTask(task, 93, msgInFlight{}))
+}
+
+func TestFailNeverStarted(t *testing.T) {
+	ctx := context.Background()
+
+	db := testPollerDB(t)
+
+	s := NewPoller(db, &countingPollerAPI{head: headAt(100)}, config.CurioSealConfig{
+		MaxUnstartedAge: config.Duration(time.Hour),
+	})
+
+	const sp = 1000
+	const oldSector, newSector, pausedSector = 1, 2, 3
+
+	_, err := db.Exec(ctx, `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof, create_time, paused)
+		VALUES ($1, $2, 0, NOW() - INTERVAL '2 hours', FALSE), ($1, $3, 0, NOW(), FALSE), ($1, $4, 0, NOW() - INTERVAL '2 hours', TRUE)`,
+		sp, oldSector, newSector, pausedSector)
+	require.NoError(t, err)
+
+	// no SDR task adder is registered, so nothing is ever started
+	require.NoError(t, s.poll(ctx))
+
+	var rows []struct {
+		SectorNumber int64   `db:"sector_number"`
+		Failed       bool    `db:"failed"`
+		Reason       *string `db:"failed_reason"`
+	}
+	require.NoError(t, db.Select(ctx, &rows, `SELECT sector_number, failed, failed_reason FROM sectors_sdr_pipeline WHERE sp_id = $1 ORDER BY sector_number`, sp))
+	require.Len(t, rows, 3)
+
+	require.True(t, rows[0].Failed)
+	require.NotNil(t, rows[0].Reason)
+	require.Equal(t, "never_started", *rows[0].Reason)
+	require.False(t, rows[1].Failed)
+	require.False(t, rows[2].Failed)
+
+	events, err := s.SectorEvents(ctx, sp, oldSector)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, sectorEventFailed, events[0].Action)
+}
+
+func TestEnqueueSectors(t *testing.T) {
+	ctx := context.Background()
+
+	db := testPollerDB(t)
+
+	s := NewPoller(db, &countingPollerAPI{head: headAt(100)}, config.CurioSealConfig{})
+	s.pollers[pollerSDR].Set(dbTaskAdder(ctx, t, db))
+
+	const sp = 1000
+	spt := abi.RegisteredSealProof_StackedDrg32GiBV1_1
+
+	require.NoError(t, s.EnqueueSectors(ctx, sp, []SectorEntry{{1, spt}, {2, spt}}))
+
+	// invalid batches add nothing
+	require.Error(t, s.EnqueueSectors(ctx, sp, []SectorEntry{{3, spt}, {3, spt}}))
+	require.Error(t, s.EnqueueSectors(ctx, sp, []SectorEntry{{3, spt}, {4, abi.RegisteredSealProof(-1)}}))
+	require.Error(t, s.EnqueueSectors(ctx, sp, []SectorEntry{{3, spt}, {1, spt}}))
+
+	require.NoError(t, s.poll(ctx))
+
+	var rows []struct {
+		SectorNumber int64  `db:"sector_number"`
+		TaskSDR      *int64 `db:"task_id_sdr"`
+	}
+	require.NoError(t, db.Select(ctx, &rows, `SELECT sector_number, task_id_sdr FROM sectors_sdr_pipeline WHERE sp_id = $1 ORDER BY sector_number`, sp))
+	require.Len(t, rows, 2)
+	for i, row := range rows {
+		require.EqualValues(t, i+1, row.SectorNumber)
+		require.NotNil(t, row.TaskSDR)
+	}
+}
+
+func TestStageRegressions(t *testing.T) {
+	s := NewPoller(nil, &countingPollerAPI{}, config.CurioSealConfig{WarnStageRegressions: true})
+
+	var warned []string
+	s.SetLogHook(func(level, msg string, kv ...any) {
+		if level != "warn" {
+			return
+		}
+		for i := 0; i+1 < len(kv); i += 2 {
+			if kv[i] == "flag" {
+				warned = append(warned, kv[i+1].(string))
+			}
+		}
+	})
+
+	task := pollTask{SpID: 1000, SectorNumber: 1, AfterSDR: true, AfterTreeD: true, AfterTreeC: true, AfterTreeR: true, AfterPrecommitMsg: true}
+	s.checkStageRegressions([]pollTask{task})
+	require.Empty(t, warned)
+
+	// resetting an unlanded message for a resend is expected
+	task.AfterPrecommitMsg = false
+	s.checkStageRegressions([]pollTask{task})
+	require.Empty(t, warned)
+
+	task.AfterTreeR = false
+	s.checkStageRegressions([]pollTask{task})
+	require.Equal(t, []string{"after_tree_r"}, warned)
+
+	// sectors which left the pipeline are forgotten
+	s.checkStageRegressions(nil)
+	require.Empty(t, s.prevStageFlags)
+}
+
+type poRepBatchTestAPI struct {
+	head *types.TipSet
+}
+
+func (a *poRepBatchTestAPI) ChainHead(context.Context) (*types.TipSet, error) {
+	return a.head, nil
+}
+
+func (a *poRepBatchTestAPI) StateGetRandomnessFromBeacon(_ context.Context, _ crypto.DomainSeparationTag, epoch abi.ChainEpoch, _ []byte, _ types.TipSetKey) (abi.Randomness, error) {
+	return abi.Randomness(fmt.Sprintf("seed-%d", epoch)), nil
+}
+
+type fakePoRepProver struct{}
+
+func (fakePoRepProver) PoRepSnark(_ context.Context, sn storiface.SectorRef, _, _ cid.Cid, _ abi.SealRandomness, _ abi.InteractiveSealRandomness) ([]byte, error) {
+	return []byte(fmt.Sprintf("proof-%d", sn.ID.Number)), nil
+}
+
+func TestPoRepBatch(t *testing.T) {
+	ctx := context.Background()
+
+	require.True(t, poRepBatchable(abi.RegisteredSealProof_StackedDrg32GiBV1_1))
+	require.False(t, poRepBatchable(abi.RegisteredSealProof_StackedDrg32GiBV1_1_Feat_SyntheticPoRep))
+
+	db := testPollerDB(t)
+
+	s := NewPoller(db, &countingPollerAPI{head: headAt(100)}, config.CurioSealConfig{PoRepBatchSize: 2})
+	s.pollers[pollerPoRep].Set(dbTaskAdder(ctx, t, db))
+
+	const sp = 1000
+	sealed := mock.MkBlock(nil, 1, 1).Cid().String()
+	unsealed := mock.MkBlock(nil, 1, 2).Cid().String()
+
+	// three batchable sectors and a synthetic PoRep one, with the seed landed
+	for n, spt := range map[int64]abi.RegisteredSealProof{
+		1: abi.RegisteredSealProof_StackedDrg32GiBV1_1,
+		2: abi.RegisteredSealProof_StackedDrg32GiBV1_1,
+		3: abi.RegisteredSealProof_StackedDrg32GiBV1_1,
+		4: abi.RegisteredSealProof_StackedDrg32GiBV1_1_Feat_SyntheticPoRep,
+	} {
+		_, err := db.Exec(ctx, `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof, ticket_epoch, ticket_value,
+				after_sdr, after_tree_d, after_tree_c, after_tree_r, tree_r_cid, tree_d_cid,
+				after_precommit_msg, after_precommit_msg_success, seed_epoch)
+			VALUES ($1, $2, $3, 1, $4, TRUE, TRUE, TRUE, TRUE, $5, $6, TRUE, TRUE, $7)`,
+			sp, n, spt, []byte("ticket"), sealed, unsealed, 10+n)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, s.poll(ctx))
+
+	var rows []struct {
+		SectorNumber int64  `db:"sector_number"`
+		TaskPoRep    *int64 `db:"task_id_porep"`
+	}
+	require.NoError(t, db.Select(ctx, &rows, `SELECT sector_number, task_id_porep FROM sectors_sdr_pipeline WHERE sp_id = $1 ORDER BY sector_number`, sp))
+	require.Len(t, rows, 4)
+	for _, row := range rows {
+		require.NotNil(t, row.TaskPoRep, "sector %d", row.SectorNumber)
+	}
+
+	// sectors 1 and 2 fill a batch, 3 is the rest of the group, and the
+	// synthetic PoRep sector is proven alone
+	require.Equal(t, *rows[0].TaskPoRep, *rows[1].TaskPoRep)
+	require.NotEqual(t, *rows[0].TaskPoRep, *rows[2].TaskPoRep)
+	require.NotEqual(t, *rows[2].TaskPoRep, *rows[3].TaskPoRep)
+	require.NotEqual(t, *rows[0].TaskPoRep, *rows[3].TaskPoRep)
+
+	pt := &PoRepTask{db: db, api: &poRepBatchTestAPI{head: headAt(100)}, sp: s, sc: fakePoRepProver{}}
+	done, err := pt.Do(harmonytask.TaskID(*rows[0].TaskPoRep), func() bool { return true })
+	require.NoError(t, err)
+	require.True(t, done)
+
+	var proofs []struct {
+		SectorNumber int64  `db:"sector_number"`
+		AfterPoRep   bool   `db:"after_porep"`
+		SeedValue    []byte `db:"seed_value"`
+		Proof        []byte `db:"porep_proof"`
+	}
+	require.NoError(t, db.Select(ctx, &proofs, `SELECT sector_number, after_porep, seed_value, porep_proof FROM sectors_sdr_pipeline WHERE sp_id = $1 ORDER BY sector_number`, sp))
+	require.Len(t, proofs, 4)
+	for _, p := range proofs[:2] {
+		require.True(t, p.AfterPoRep)
+		require.Equal(t, fmt.Sprintf("seed-%d", 10+p.SectorNumber), string(p.SeedValue))
+		require.Equal(t, fmt.Sprintf("proof-%d", p.SectorNumber), string(p.Proof))
+	}
+	for _, p := range proofs[2:] {
+		require.False(t, p.AfterPoRep)
+		require.Nil(t, p.Proof)
+	}
+}
+
+func TestMissingSeedEpoch(t *testing.T) {
+	ctx := context.Background()
+
+	task := pollTask{
+		SpID: 1000, SectorNumber: 1,
+		AfterSDR: true, AfterTreeD: true, AfterTreeC: true, AfterTreeR: true,
+		AfterPrecommitMsg: true, AfterPrecommitMsgSuccess: true,
+	}
+
+	var warnings []string
+	hook := func(level, msg string, kv ...any) {
+		if level == "warn" {
+			warnings = append(warnings, msg)
+		}
+	}
+
+	// without recovery the sector is only reported
+	api := &countingPollerAPI{head: headAt(100)}
+	s := NewPoller(nil, api, config.CurioSealConfig{})
+	s.SetLogHook(hook)
+	require.NoError(t, s.pollMissingSeedEpoch(ctx, task))
+	require.Equal(t, []string{"sector precommit landed but seed epoch is missing, porep can't start"}, warnings)
+	require.Zero(t, api.precommits)
+
+	seed := int64(10)
+	warnings = nil
+	withSeed := task
+	withSeed.SeedEpoch = &seed
+	require.NoError(t, s.pollMissingSeedEpoch(ctx, withSeed))
+	require.Empty(t, warnings)
+
+	db := testPollerDB(t)
+
+	s = NewPoller(db, api, config.CurioSealConfig{RecoverMissingSeedEpoch: true})
+	s.SetLogHook(hook)
+
+	_, err := db.Exec(ctx, `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof,
+			after_sdr, after_tree_d, after_tree_c, after_tree_r, after_precommit_msg, after_precommit_msg_success)
+		VALUES ($1, $2, 0, TRUE, TRUE, TRUE, TRUE, TRUE, TRUE)`, task.SpID, task.SectorNumber)
+	require.NoError(t, err)
+
+	warnings = nil
+	require.NoError(t, s.poll(ctx))
+	require.Contains(t, warnings, "sector precommit landed but seed epoch is missing, porep can't start")
+	require.Equal(t, 1, api.precommits)
+
+	var seedEpoch *int64
+	require.NoError(t, db.QueryRow(ctx, `SELECT seed_epoch FROM sectors_sdr_pipeline WHERE sp_id = $1 AND sector_number = $2`, task.SpID, task.SectorNumber).Scan(&seedEpoch))
+	require.NotNil(t, seedEpoch)
+	require.EqualValues(t, 10+policy.GetPreCommitChallengeDelay(), *seedEpoch)
+
+	events, err := s.SectorEvents(ctx, task.SpID, task.SectorNumber)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, sectorEventReconciled, events[0].Action)
+}
+
+func TestRecoverPoRepBatches(t *testing.T) {
+	ctx := context.Background()
+
+	db := testPollerDB(t)
+	s := NewPoller(db, &countingPollerAPI{head: headAt(100)}, config.CurioSealConfig{PoRepBatchSize: 4})
+
+	var liveTask int64
+	require.NoError(t, db.QueryRow(ctx, `INSERT INTO harmony_task (name, added_by, posted_time) VALUES ('PoRep', 1, CURRENT_TIMESTAMP) RETURNING id`).Scan(&liveTask))
+	goneTask := liveTask + 1000
+
+	// the node crashed while task goneTask proved a batch of sectors 1-3: it
+	// proved sector 1, then ran out of retries. Sector 4 is in a live batch.
+	_, err := db.Exec(ctx, `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof, after_tree_r, task_id_porep, after_porep)
+		VALUES (1000, 1, 8, TRUE, $1, TRUE), (1000, 2, 8, TRUE, $1, FALSE), (1000, 3, 8, TRUE, $1, FALSE), (1000, 4, 8, TRUE, $2, FALSE)`, goneTask, liveTask)
+	require.NoError(t, err)
+
+	poRepTask := func(sector int64) *int64 {
+		var id *int64
+		require.NoError(t, db.QueryRow(ctx, `SELECT task_id_porep FROM sectors_sdr_pipeline WHERE sp_id = 1000 AND sector_number = $1`, sector).Scan(&id))
+		return id
+	}
+
+	require.NoError(t, s.recoverPoRepBatches(ctx))
+
+	require.Equal(t, goneTask, *poRepTask(1), "proven sectors keep their task")
+	require.Nil(t, poRepTask(2))
+	require.Nil(t, poRepTask(3))
+	require.Equal(t, liveTask, *poRepTask(4), "sectors of live tasks are left alone")
+
+	events, err := s.SectorEvents(ctx, 1000, 2)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, sectorEventRetry, events[0].Action)
+
+	// recovery is idempotent
+	require.NoError(t, s.recoverPoRepBatches(ctx))
+	events, err = s.SectorEvents(ctx, 1000, 2)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+
+	// the released sectors can be batched again
+	s.pollers[pollerPoRep].Set(dbTaskAdder(ctx, t, db))
+	s.poRepBatch = &poRepBatches{groups: map[poRepBatchKey][]pollTask{}}
+	s.poRepBatch.add(pollTask{SpID: 1000, SectorNumber: 2, RegSealProof: 8})
+	s.poRepBatch.add(pollTask{SpID: 1000, SectorNumber: 3, RegSealProof: 8})
+	s.startPoRepBatches(ctx)
+
+	require.NotNil(t, poRepTask(2))
+	require.Equal(t, *poRepTask(2), *poRepTask(3))
+}
+
+func TestPollSelectRetry(t *testing.T) {
+	ctx := context.Background()
+
+	newPoller := func(errs ...error) (*SealPoller, *int, *bool) {
+		s := NewPoller(nil, &countingPollerAPI{head: headAt(100)}, config.CurioSealConfig{})
+		s.poRepRecovered = true // no database to recover from
+
+		selects := 0
+		s.selectTasks = func(_ context.Context, tasks *[]pollTask) error {
+			selects++
+			if selects <= len(errs) {
+				return errs[selects-1]
+			}
+			*tasks = []pollTask{{SpID: 1000, SectorNumber: 1}}
+			return nil
+		}
+
+		started := false
+		s.pollers[pollerSDR].Set(func(func(harmonytask.TaskID, *harmonydb.Tx) (bool, error)) {
+			started = true
+		})
+		return s, &selects, &started
+	}
+
+	connLost := &pgconn.PgError{Code: pgerrcode.ConnectionFailure}
+
+	// a select failing once with a transient error doesn't skip the cycle
+	s, selects, started := newPoller(connLost)
+	require.NoError(t, s.poll(ctx))
+	require.Equal(t, 2, *selects)
+	require.True(t, *started)
+
+	// other errors end the cycle at once
+	s, selects, started = newPoller(&pgconn.PgError{Code: pgerrcode.UndefinedColumn})
+	require.Error(t, s.poll(ctx))
+	require.Equal(t, 1, *selects)
+	require.False(t, *started)
+
+	// retries are bounded
+	s, selects, _ = newPoller(connLost, connLost, connLost, connLost)
+	require.Error(t, s.poll(ctx))
+	require.Equal(t, pollSelectAttempts, *selects)
+
+	// cancelling the context stops the backoff
+	cctx, cancel := context.WithCancel(ctx)
+	s, selects, _ = newPoller(connLost, connLost)
+	s.SetLogHook(func(level, msg string, kv ...any) {
+		if level == "warn" {
+			cancel()
+		}
+	})
+	require.ErrorIs(t, s.poll(cctx), context.Canceled)
+	require.Equal(t, 1, *selects)
+
+	require.True(t, isTransientDBError(xerrors.Errorf("select: %w", io.ErrUnexpectedEOF)))
+	require.False(t, isTransientDBError(context.Canceled))
+}
+
+func TestStageSpans(t *testing.T) {
+	ctx := context.Background()
+
+	db := testPollerDB(t)
+
+	const sp, sector = 1000, 1
+
+	_, err := db.Exec(ctx, `INSERT INTO sectors_sdr_pipeline (sp_id, sector_number, reg_seal_proof) VALUES ($1, $2, 0)`, sp, sector)
+	require.NoError(t, err)
+
+	load := func(s *SealPoller) pollTask {
+		var tasks []pollTask
+		require.NoError(t, s.selectTasks(ctx, &tasks))
+		require.Len(t, tasks, 1)
+		return tasks[0]
+	}
+
+	// without a tracer no trace ID is assigned
+	s := NewPoller(db, &countingPollerAPI{}, config.CurioSealConfig{})
+	for i := range s.pollers {
+		s.pollers[i].Set(dbTaskAdder(ctx, t, db))
+	}
+	s.pollStartSDR(ctx, load(s))
+	require.Nil(t, load(s).TraceID)
+
+	_, err = db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET task_id_sdr = NULL, after_sdr = FALSE WHERE sp_id = $1 AND sector_number = $2`, sp, sector)
+	require.NoError(t, err)
+
+	exporter := tracetest.NewInMemoryExporter()
+	s.SetTracerProvider(tracesdk.NewTracerProvider(tracesdk.WithSyncer(exporter)))
+
+	s.pollStartSDR(ctx, load(s))
+
+	_, err = db.Exec(ctx, `UPDATE sectors_sdr_pipeline SET task_id_sdr = NULL, after_sdr = TRUE WHERE sp_id = $1 AND sector_number = $2`, sp, sector)
+	require.NoError(t, err)
+
+	// a new poller, e.g. after a restart, continues the sector's trace
+	s2 := NewPoller(db, &countingPollerAPI{}, config.CurioSealConfig{})
+	for i := range s2.pollers {
+		s2.pollers[i].Set(dbTaskAdder(ctx, t, db))
+	}
+	s2.SetTracerProvider(tracesdk.NewTracerProvider(tracesdk.WithSyncer(exporter)))
+	s2.pollStartSDRTrees(ctx, load(s2))
+
+	task := load(s2)
+	require.NotNil(t, task.TraceID)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+	for i, stage := range []string{"sdr", "trees"} {
+		span := spans[i]
+		require.Equal(t, "seal/"+stage, span.Name)
+		require.Equal(t, *task.TraceID, span.SpanContext.TraceID().String())
+		require.Subset(t, span.Attributes, []attribute.KeyValue{
+			attribute.Int64("sp_id", sp),
+			attribute.Int64("sector_number", sector),
+			attribute.String("stage", stage),
+		})
+	}
+	require.Equal(t, spans[0].Parent.SpanID(), spans[1].Parent.SpanID())
+}
+
+func TestStagePollPeriods(t *testing.T) {
+	s := NewPoller(nil, &countingPollerAPI{}, config.CurioSealConfig{
+		StagePollPeriods: map[string]int{
+			"sdr":        2,
+			"trees":      2,
+			"porep":      3,
+			"commit_msg": 50, // capped
+			"unknown":    2,
+		},
+	})
+	for i := range s.pollers {
+		s.pollers[i].Set(func(func(harmonytask.TaskID, *harmonydb.Tx) (bool, error)) {})
+	}
+
+	periods := map[int]int{pollerSDR: 2, pollerTrees: 2, pollerPoRep: 3, pollerCommitMsg: maxStagePollPeriod}
+
+	const cycles = 40
+	last := [numPollers]int{}
+	for i := range last {
+		last[i] = -1
+	}
+	for cycle := 0; cycle < cycles; cycle++ {
+		s.planStages()
+
+		for poller := 0; poller < numPollers; poller++ {
+			if !s.canStart(poller, pollTask{}) {
+				continue
+			}
+
+			period, ok := periods[poller]
+			if !ok {
+				period = 1
+			}
+			require.LessOrEqual(t, cycle-last[poller], period, "stage %s waited too long", pollerStages[poller])
+			last[poller] = cycle
+		}
+
+		// stages with the same period take turns
+		require.NotEqual(t, s.canStart(pollerSDR, pollTask{}), s.canStart(pollerTrees, pollTask{}))
+	}
+
+	for poller := 0; poller < numPollers; poller++ {
+		period, ok := periods[poller]
+		if !ok {
+			period = 1
+		}
+		require.Greater(t, last[poller], cycles-1-period, "stage %s starved", pollerStages[poller])
+	}
+}
@@ -0,0 +1,70 @@
+package seal
+
+import (
+	"context"
+	"crypto/rand"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonytask"
+)
+
+const pollerTracerName = "github.com/filecoin-project/lotus/curiosrc/seal"
+
+// SetTracerProvider makes the poller emit an OpenTelemetry span each time a
+// sector enters a pipeline stage. The spans of a sector share a trace ID kept
+// in its pipeline row, so that its whole way through the pipeline shows up as
+// one trace, even across pollers. Must be called before RunPoller.
+func (s *SealPoller) SetTracerProvider(tp trace.TracerProvider) {
+	s.tracer = tp.Tracer(pollerTracerName)
+}
+
+// sectorTraceID returns the trace ID of a sector's stage spans, assigning a
+// new one to the sector if it has none yet
+func sectorTraceID(tx *harmonydb.Tx, task pollTask) (trace.TraceID, error) {
+	var tid trace.TraceID
+	if _, err := rand.Read(tid[:]); err != nil {
+		return trace.TraceID{}, xerrors.Errorf("generating trace id: %w", err)
+	}
+
+	var stored string
+	err := tx.QueryRow(`UPDATE sectors_sdr_pipeline SET trace_id = COALESCE(trace_id, $3)
+		WHERE sp_id = $1 AND sector_number = $2 RETURNING trace_id`, task.SpID, task.SectorNumber, tid.String()).Scan(&stored)
+	if err != nil {
+		return trace.TraceID{}, xerrors.Errorf("setting sector trace id: %w", err)
+	}
+
+	tid, err = trace.TraceIDFromHex(stored)
+	if err != nil {
+		return trace.TraceID{}, xerrors.Errorf("parsing sector trace id %q: %w", stored, err)
+	}
+	return tid, nil
+}
+
+// traceStage emits the span of a sector entering the stage of a poller with
+// the given task
+func (s *SealPoller) traceStage(ctx context.Context, poller int, task pollTask, tid trace.TraceID, id harmonytask.TaskID) {
+	// stage spans of a sector are siblings under a parent derived from the
+	// trace ID, which is never emitted itself
+	var parent trace.SpanID
+	copy(parent[:], tid[8:])
+	parent[0] |= 1
+
+	ctx = trace.ContextWithRemoteSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    tid,
+		SpanID:     parent,
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	}))
+
+	_, span := s.tracer.Start(ctx, "seal/"+pollerStages[poller], trace.WithAttributes(
+		attribute.Int64("sp_id", task.SpID),
+		attribute.Int64("sector_number", task.SectorNumber),
+		attribute.String("stage", pollerStages[poller]),
+		attribute.Int64("task_id", int64(id)),
+	))
+	span.End()
+}
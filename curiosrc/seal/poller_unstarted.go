@@ -0,0 +1,54 @@
+package seal
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+)
+
+// failNeverStartedQuery fails a sector whose SDR task was never started, when
+// the pipeline row was created more than $3 seconds ago
+const failNeverStartedQuery = `UPDATE sectors_sdr_pipeline
+	SET failed = TRUE, failed_at = NOW(), failed_reason = 'never_started', failed_reason_msg = $4
+	WHERE sp_id = $1 AND sector_number = $2 AND failed = FALSE AND paused = FALSE
+		AND task_id_sdr IS NULL AND after_sdr = FALSE AND attempts_sdr = 0
+		AND create_time < NOW() - $3::float8 * INTERVAL '1 second'`
+
+// failNeverStarted marks a sector failed when no SDR task was started for it
+// within maxUnstartedAge of it entering the pipeline, e.g. because no node
+// runs SDR tasks, so that stuck onboarding shows up as failed sectors. Paused
+// sectors are left alone. Returns true if the sector was failed.
+func (s *SealPoller) failNeverStarted(ctx context.Context, task pollTask) bool {
+	if s.maxUnstartedAge <= 0 || task.AfterSDR || task.TaskSDR != nil || task.AttemptsSDR > 0 || task.Paused {
+		return false
+	}
+
+	reason := fmt.Sprintf("sdr not started within %s", s.maxUnstartedAge)
+
+	failed, err := s.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		n, err := tx.Exec(failNeverStartedQuery, task.SpID, task.SectorNumber, s.maxUnstartedAge.Seconds(), reason)
+		if err != nil {
+			return false, xerrors.Errorf("update sectors_sdr_pipeline to fail unstarted sector: %w", err)
+		}
+		if n == 0 {
+			return false, nil
+		}
+
+		if err := recordSectorEvent(tx, task.SpID, task.SectorNumber, pollerStages[pollerSDR], sectorEventFailed, reason); err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}, harmonydb.OptionRetry())
+	s.mustPoll(err)
+	if !failed {
+		return false
+	}
+
+	s.errorw("sector never started sealing, failing it", "sp", task.SpID, "sector", task.SectorNumber, "max_age", s.maxUnstartedAge)
+	s.cycleFailed()
+	return true
+}
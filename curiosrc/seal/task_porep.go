@@ -24,11 +24,16 @@ type PoRepAPI interface {
 	StateGetRandomnessFromBeacon(context.Context, crypto.DomainSeparationTag, abi.ChainEpoch, []byte, types.TipSetKey) (abi.Randomness, error)
 }
 
+// poRepProver computes PoRep proofs, implemented by *ffi.SealCalls
+type poRepProver interface {
+	PoRepSnark(ctx context.Context, sn storiface.SectorRef, sealed, unsealed cid.Cid, ticket abi.SealRandomness, seed abi.InteractiveSealRandomness) ([]byte, error)
+}
+
 type PoRepTask struct {
 	db  *harmonydb.DB
 	api PoRepAPI
 	sp  *SealPoller
-	sc  *ffi.SealCalls
+	sc  poRepProver
 
 	max int
 }
@@ -46,57 +51,78 @@ func NewPoRepTask(db *harmonydb.DB, api PoRepAPI, sp *SealPoller, sc *ffi.SealCa
 func (p *PoRepTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
 	ctx := context.Background()
 
-	var sectorParamsArr []struct {
-		SpID         int64                   `db:"sp_id"`
-		SectorNumber int64                   `db:"sector_number"`
-		RegSealProof abi.RegisteredSealProof `db:"reg_seal_proof"`
-		TicketEpoch  abi.ChainEpoch          `db:"ticket_epoch"`
-		TicketValue  []byte                  `db:"ticket_value"`
-		SeedEpoch    abi.ChainEpoch          `db:"seed_epoch"`
-		SealedCID    string                  `db:"tree_r_cid"`
-		UnsealedCID  string                  `db:"tree_d_cid"`
-	}
+	var sectorParamsArr []poRepSectorParams
 
 	err = p.db.Select(ctx, &sectorParamsArr, `
-		SELECT sp_id, sector_number, reg_seal_proof, ticket_epoch, ticket_value, seed_epoch, tree_r_cid, tree_d_cid
+		SELECT sp_id, sector_number, reg_seal_proof, ticket_epoch, ticket_value, seed_epoch, tree_r_cid, tree_d_cid, after_porep
 		FROM sectors_sdr_pipeline
-		WHERE task_id_porep = $1`, taskID)
+		WHERE task_id_porep = $1
+		ORDER BY sp_id, sector_number`, taskID)
 	if err != nil {
 		return false, err
 	}
-	if len(sectorParamsArr) != 1 {
-		return false, xerrors.Errorf("expected 1 sector params, got %d", len(sectorParamsArr))
+	if len(sectorParamsArr) == 0 {
+		return false, xerrors.Errorf("expected at least 1 sector params, got 0")
 	}
-	sectorParams := sectorParamsArr[0]
 
-	sealed, err := cid.Parse(sectorParams.SealedCID)
+	ts, err := p.api.ChainHead(ctx)
 	if err != nil {
-		return false, xerrors.Errorf("failed to parse sealed cid: %w", err)
+		return false, xerrors.Errorf("failed to get chain head: %w", err)
 	}
 
-	unsealed, err := cid.Parse(sectorParams.UnsealedCID)
+	// a batch task proves each of its sectors in turn, sectors proven before a
+	// retry of the task are skipped
+	for _, sectorParams := range sectorParamsArr {
+		if sectorParams.AfterPoRep {
+			continue
+		}
+
+		if err := p.proveSector(ctx, ts, sectorParams); err != nil {
+			return false, xerrors.Errorf("sector %d: %w", sectorParams.SectorNumber, err)
+		}
+	}
+
+	return true, nil
+}
+
+type poRepSectorParams struct {
+	SpID         int64                   `db:"sp_id"`
+	SectorNumber int64                   `db:"sector_number"`
+	RegSealProof abi.RegisteredSealProof `db:"reg_seal_proof"`
+	TicketEpoch  abi.ChainEpoch          `db:"ticket_epoch"`
+	TicketValue  []byte                  `db:"ticket_value"`
+	SeedEpoch    abi.ChainEpoch          `db:"seed_epoch"`
+	SealedCID    string                  `db:"tree_r_cid"`
+	UnsealedCID  string                  `db:"tree_d_cid"`
+	AfterPoRep   bool                    `db:"after_porep"`
+}
+
+// proveSector computes the PoRep of a single sector and stores the seed and
+// the proof
+func (p *PoRepTask) proveSector(ctx context.Context, ts *types.TipSet, sectorParams poRepSectorParams) error {
+	sealed, err := cid.Parse(sectorParams.SealedCID)
 	if err != nil {
-		return false, xerrors.Errorf("failed to parse unsealed cid: %w", err)
+		return xerrors.Errorf("failed to parse sealed cid: %w", err)
 	}
 
-	ts, err := p.api.ChainHead(ctx)
+	unsealed, err := cid.Parse(sectorParams.UnsealedCID)
 	if err != nil {
-		return false, xerrors.Errorf("failed to get chain head: %w", err)
+		return xerrors.Errorf("failed to parse unsealed cid: %w", err)
 	}
 
 	maddr, err := address.NewIDAddress(uint64(sectorParams.SpID))
 	if err != nil {
-		return false, xerrors.Errorf("failed to create miner address: %w", err)
+		return xerrors.Errorf("failed to create miner address: %w", err)
 	}
 
 	buf := new(bytes.Buffer)
 	if err := maddr.MarshalCBOR(buf); err != nil {
-		return false, xerrors.Errorf("failed to marshal miner address: %w", err)
+		return xerrors.Errorf("failed to marshal miner address: %w", err)
 	}
 
 	rand, err := p.api.StateGetRandomnessFromBeacon(ctx, crypto.DomainSeparationTag_InteractiveSealChallengeSeed, sectorParams.SeedEpoch, buf.Bytes(), ts.Key())
 	if err != nil {
-		return false, xerrors.Errorf("failed to get randomness for computing seal proof: %w", err)
+		return xerrors.Errorf("failed to get randomness for computing seal proof: %w", err)
 	}
 
 	sr := storiface.SectorRef{
@@ -111,7 +137,7 @@ func (p *PoRepTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done
 
 	proof, err := p.sc.PoRepSnark(ctx, sr, sealed, unsealed, sectorParams.TicketValue, abi.InteractiveSealRandomness(rand))
 	if err != nil {
-		return false, xerrors.Errorf("failed to compute seal proof: %w", err)
+		return xerrors.Errorf("failed to compute seal proof: %w", err)
 	}
 
 	// store success!
@@ -120,13 +146,13 @@ func (p *PoRepTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done
 		WHERE sp_id = $1 AND sector_number = $2`,
 		sectorParams.SpID, sectorParams.SectorNumber, []byte(rand), proof)
 	if err != nil {
-		return false, xerrors.Errorf("store sdr success: updating pipeline: %w", err)
+		return xerrors.Errorf("store sdr success: updating pipeline: %w", err)
 	}
 	if n != 1 {
-		return false, xerrors.Errorf("store sdr success: updated %d rows", n)
+		return xerrors.Errorf("store sdr success: updated %d rows", n)
 	}
 
-	return true, nil
+	return nil
 }
 
 func (p *PoRepTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
@@ -23,12 +23,25 @@ import (
 	"github.com/filecoin-project/lotus/storage/sealer/storiface"
 )
 
+// treesMode selects which trees a TreesTask builds
+type treesMode int
+
+const (
+	// treesCombined builds TreeD, TreeC and TreeR in one task
+	treesCombined treesMode = iota
+	// treesD builds only TreeD, with split trees
+	treesD
+	// treesRC builds TreeC and TreeR after a treesD task, with split trees
+	treesRC
+)
+
 type TreesTask struct {
 	sp *SealPoller
 	db *harmonydb.DB
 	sc *ffi.SealCalls
 
-	max int
+	mode treesMode
+	max  int
 }
 
 func NewTreesTask(sp *SealPoller, db *harmonydb.DB, sc *ffi.SealCalls, maxTrees int) *TreesTask {
@@ -37,23 +50,56 @@ func NewTreesTask(sp *SealPoller, db *harmonydb.DB, sc *ffi.SealCalls, maxTrees
 		db: db,
 		sc: sc,
 
-		max: maxTrees,
+		mode: treesCombined,
+		max:  maxTrees,
 	}
 }
 
+// NewTreeDTask returns the TreeD task of split trees, see
+// CurioSealConfig.SplitTrees
+func NewTreeDTask(sp *SealPoller, db *harmonydb.DB, sc *ffi.SealCalls, maxTrees int) *TreesTask {
+	t := NewTreesTask(sp, db, sc, maxTrees)
+	t.mode = treesD
+	return t
+}
+
+// NewTreeRCTask returns the TreeRC task of split trees, see
+// CurioSealConfig.SplitTrees
+func NewTreeRCTask(sp *SealPoller, db *harmonydb.DB, sc *ffi.SealCalls, maxTrees int) *TreesTask {
+	t := NewTreesTask(sp, db, sc, maxTrees)
+	t.mode = treesRC
+	return t
+}
+
+type treesSectorParams struct {
+	SpID         int64                   `db:"sp_id"`
+	SectorNumber int64                   `db:"sector_number"`
+	RegSealProof abi.RegisteredSealProof `db:"reg_seal_proof"`
+	TreeDCid     *string                 `db:"tree_d_cid"`
+}
+
 func (t *TreesTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
 	ctx := context.Background()
 
-	var sectorParamsArr []struct {
-		SpID         int64                   `db:"sp_id"`
-		SectorNumber int64                   `db:"sector_number"`
-		RegSealProof abi.RegisteredSealProof `db:"reg_seal_proof"`
-	}
+	var sectorParamsArr []treesSectorParams
 
-	err = t.db.Select(ctx, &sectorParamsArr, `
-		SELECT sp_id, sector_number, reg_seal_proof
+	switch t.mode {
+	case treesD:
+		err = t.db.Select(ctx, &sectorParamsArr, `
+		SELECT sp_id, sector_number, reg_seal_proof, tree_d_cid
+		FROM sectors_sdr_pipeline
+		WHERE task_id_tree_d = $1`, taskID)
+	case treesRC:
+		err = t.db.Select(ctx, &sectorParamsArr, `
+		SELECT sp_id, sector_number, reg_seal_proof, tree_d_cid
+		FROM sectors_sdr_pipeline
+		WHERE task_id_tree_r = $1 AND task_id_tree_c = $1`, taskID)
+	default:
+		err = t.db.Select(ctx, &sectorParamsArr, `
+		SELECT sp_id, sector_number, reg_seal_proof, tree_d_cid
 		FROM sectors_sdr_pipeline
 		WHERE task_id_tree_r = $1 AND task_id_tree_c = $1 AND task_id_tree_d = $1`, taskID)
+	}
 	if err != nil {
 		return false, xerrors.Errorf("getting sector params: %w", err)
 	}
@@ -63,6 +109,10 @@ func (t *TreesTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done
 	}
 	sectorParams := sectorParamsArr[0]
 
+	if t.mode == treesRC {
+		return t.doTreeRC(ctx, sectorParams)
+	}
+
 	var pieces []struct {
 		PieceIndex int64  `db:"piece_index"`
 		PieceCID   string `db:"piece_cid"`
@@ -178,12 +228,26 @@ func (t *TreesTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done
 		unpaddedData = false // nullreader includes fr32 zero bits
 	}
 
-	sref := storiface.SectorRef{
-		ID: abi.SectorID{
-			Miner:  abi.ActorID(sectorParams.SpID),
-			Number: abi.SectorNumber(sectorParams.SectorNumber),
-		},
-		ProofType: sectorParams.RegSealProof,
+	sref := sectorParams.ref()
+
+	if t.mode == treesD {
+		unsealed, err := t.sc.TreeD(ctx, sref, commd, abi.PaddedPieceSize(ssize), dataReader, unpaddedData)
+		if err != nil {
+			return false, xerrors.Errorf("computing tree d: %w", err)
+		}
+
+		n, err := t.db.Exec(ctx, `UPDATE sectors_sdr_pipeline
+			SET after_tree_d = true, tree_d_cid = $3
+			WHERE sp_id = $1 AND sector_number = $2`,
+			sectorParams.SpID, sectorParams.SectorNumber, unsealed)
+		if err != nil {
+			return false, xerrors.Errorf("store tree-d success: updating pipeline: %w", err)
+		}
+		if n != 1 {
+			return false, xerrors.Errorf("store tree-d success: updated %d rows", n)
+		}
+
+		return true, nil
 	}
 
 	// D / R / C
@@ -210,6 +274,46 @@ func (t *TreesTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done
 	return true, nil
 }
 
+// doTreeRC builds TreeC and TreeR from the TreeD built by a treesD task
+func (t *TreesTask) doTreeRC(ctx context.Context, sectorParams treesSectorParams) (bool, error) {
+	if sectorParams.TreeDCid == nil {
+		return false, xerrors.Errorf("sector has no tree-d cid")
+	}
+
+	unsealed, err := cid.Parse(*sectorParams.TreeDCid)
+	if err != nil {
+		return false, xerrors.Errorf("parsing tree-d cid: %w", err)
+	}
+
+	sealed, _, err := t.sc.TreeRC(ctx, sectorParams.ref(), unsealed)
+	if err != nil {
+		return false, xerrors.Errorf("computing tree r and c: %w", err)
+	}
+
+	n, err := t.db.Exec(ctx, `UPDATE sectors_sdr_pipeline
+		SET after_tree_r = true, after_tree_c = true, tree_r_cid = $3
+		WHERE sp_id = $1 AND sector_number = $2`,
+		sectorParams.SpID, sectorParams.SectorNumber, sealed)
+	if err != nil {
+		return false, xerrors.Errorf("store tree-rc success: updating pipeline: %w", err)
+	}
+	if n != 1 {
+		return false, xerrors.Errorf("store tree-rc success: updated %d rows", n)
+	}
+
+	return true, nil
+}
+
+func (p treesSectorParams) ref() storiface.SectorRef {
+	return storiface.SectorRef{
+		ID: abi.SectorID{
+			Miner:  abi.ActorID(p.SpID),
+			Number: abi.SectorNumber(p.SectorNumber),
+		},
+		ProofType: p.RegSealProof,
+	}
+}
+
 func (t *TreesTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
 	// todo reserve storage
 
@@ -223,7 +327,7 @@ func (t *TreesTask) TypeDetails() harmonytask.TaskTypeDetails {
 		ssize = abi.SectorSize(2 << 20)
 	}
 
-	return harmonytask.TaskTypeDetails{
+	res := harmonytask.TaskTypeDetails{
 		Max:  t.max,
 		Name: "SDRTrees",
 		Cost: resources.Resources{
@@ -235,16 +339,41 @@ func (t *TreesTask) TypeDetails() harmonytask.TaskTypeDetails {
 		MaxFailures: 3,
 		Follows:     nil,
 	}
+
+	switch t.mode {
+	case treesD:
+		// TreeD only hashes the sector data, it doesn't need a GPU
+		res.Name = "TreeD"
+		res.Cost.Gpu = 0
+	case treesRC:
+		// TreeRC works on the files TreeD created, no new files are allocated
+		res.Name = "TreeRC"
+		res.Cost.Storage = nil
+	}
+
+	return res
 }
 
 func (t *TreesTask) Adder(taskFunc harmonytask.AddTaskFunc) {
-	t.sp.pollers[pollerTrees].Set(taskFunc)
+	switch t.mode {
+	case treesD:
+		t.sp.pollers[pollerTreeD].Set(taskFunc)
+	case treesRC:
+		t.sp.pollers[pollerTreeRC].Set(taskFunc)
+	default:
+		t.sp.pollers[pollerTrees].Set(taskFunc)
+	}
 }
 
 func (t *TreesTask) taskToSector(id harmonytask.TaskID) (ffi.SectorRef, error) {
 	var refs []ffi.SectorRef
 
-	err := t.db.Select(context.Background(), &refs, `SELECT sp_id, sector_number, reg_seal_proof FROM sectors_sdr_pipeline WHERE task_id_tree_r = $1`, id)
+	var err error
+	if t.mode == treesD {
+		err = t.db.Select(context.Background(), &refs, `SELECT sp_id, sector_number, reg_seal_proof FROM sectors_sdr_pipeline WHERE task_id_tree_d = $1`, id)
+	} else {
+		err = t.db.Select(context.Background(), &refs, `SELECT sp_id, sector_number, reg_seal_proof FROM sectors_sdr_pipeline WHERE task_id_tree_r = $1`, id)
+	}
 	if err != nil {
 		return ffi.SectorRef{}, xerrors.Errorf("getting sector ref: %w", err)
 	}
@@ -45,6 +45,7 @@ func (a *app) pipelinePorepSectors(w http.ResponseWriter, r *http.Request) {
 		AfterPrecommitMsg bool   `db:"after_precommit_msg"`
 
 		AfterPrecommitMsgSuccess bool   `db:"after_precommit_msg_success"`
+		PrecommitMsgGasUsed      *int64 `db:"precommit_msg_gas_used"`
 		SeedEpoch                *int64 `db:"seed_epoch"`
 
 		TaskPoRep  *int64 `db:"task_id_porep"`
@@ -60,7 +61,8 @@ func (a *app) pipelinePorepSectors(w http.ResponseWriter, r *http.Request) {
 		TaskCommitMsg  *int64 `db:"task_id_commit_msg"`
 		AfterCommitMsg bool   `db:"after_commit_msg"`
 
-		AfterCommitMsgSuccess bool `db:"after_commit_msg_success"`
+		AfterCommitMsgSuccess bool   `db:"after_commit_msg_success"`
+		CommitMsgGasUsed      *int64 `db:"commit_msg_gas_used"`
 
 		Failed       bool   `db:"failed"`
 		FailedReason string `db:"failed_reason"`
@@ -76,12 +78,12 @@ func (a *app) pipelinePorepSectors(w http.ResponseWriter, r *http.Request) {
        task_id_tree_c, after_tree_c,
        task_id_tree_r, after_tree_r,
        task_id_precommit_msg, after_precommit_msg,
-       after_precommit_msg_success, seed_epoch,
+       after_precommit_msg_success, precommit_msg_gas_used, seed_epoch,
        task_id_porep, porep_proof, after_porep,
        task_id_finalize, after_finalize,
        task_id_move_storage, after_move_storage,
        task_id_commit_msg, after_commit_msg,
-       after_commit_msg_success,
+       after_commit_msg_success, commit_msg_gas_used,
        failed, failed_reason
     FROM sectors_sdr_pipeline order by sp_id, sector_number`) // todo where constrain list
 	if err != nil {
@@ -0,0 +1,62 @@
+package harmonydb
+
+import (
+	"context"
+	"time"
+)
+
+// Lease is a named lease in the harmony_leases table. A lease is held by one
+// owner until it expires or is released; the owner keeps it by renewing it
+// within the TTL. Lease times come from the database clock, so the clocks of
+// the nodes competing for a lease don't need to agree.
+type Lease struct {
+	db    *DB
+	name  string
+	owner string
+	ttl   time.Duration
+}
+
+// TryLease tries to claim the named lease for owner for ttl, without waiting.
+// The lease is claimed when nobody holds it, when the previous owner let it
+// expire, or when owner already holds it. It returns nil if another owner
+// holds the lease.
+func (db *DB) TryLease(ctx context.Context, name, owner string, ttl time.Duration) (*Lease, error) {
+	n, err := db.Exec(ctx, `INSERT INTO harmony_leases (name, owner, heartbeat, expires_at)
+		VALUES ($1, $2, NOW(), NOW() + $3::float8 * INTERVAL '1 second')
+		ON CONFLICT (name) DO NOTHING`, name, owner, ttl.Seconds())
+	if err != nil {
+		return nil, err
+	}
+
+	if n == 0 {
+		n, err = db.Exec(ctx, `UPDATE harmony_leases
+			SET owner = $2, heartbeat = NOW(), expires_at = NOW() + $3::float8 * INTERVAL '1 second'
+			WHERE name = $1 AND (expires_at < NOW() OR owner = $2)`, name, owner, ttl.Seconds())
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, nil
+		}
+	}
+
+	return &Lease{db: db, name: name, owner: owner, ttl: ttl}, nil
+}
+
+// Renew extends the lease by its TTL. It returns false if the lease expired
+// and was claimed by another owner in the meantime.
+func (l *Lease) Renew(ctx context.Context) (bool, error) {
+	n, err := l.db.Exec(ctx, `UPDATE harmony_leases
+		SET heartbeat = NOW(), expires_at = NOW() + $3::float8 * INTERVAL '1 second'
+		WHERE name = $1 AND owner = $2`, l.name, l.owner, l.ttl.Seconds())
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// Release gives up the lease, so that another owner can claim it at once.
+func (l *Lease) Release(ctx context.Context) error {
+	_, err := l.db.Exec(ctx, `DELETE FROM harmony_leases WHERE name = $1 AND owner = $2`, l.name, l.owner)
+	return err
+}
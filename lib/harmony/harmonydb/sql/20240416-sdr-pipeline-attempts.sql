@@ -0,0 +1,22 @@
+-- number of times the poller assigned a task for each pipeline stage; the
+-- poller fails the sector when a stage exceeds the configured attempt limit
+ALTER TABLE sectors_sdr_pipeline
+    ADD COLUMN attempts_sdr INT NOT NULL DEFAULT 0;
+
+ALTER TABLE sectors_sdr_pipeline
+    ADD COLUMN attempts_trees INT NOT NULL DEFAULT 0;
+
+ALTER TABLE sectors_sdr_pipeline
+    ADD COLUMN attempts_precommit_msg INT NOT NULL DEFAULT 0;
+
+ALTER TABLE sectors_sdr_pipeline
+    ADD COLUMN attempts_porep INT NOT NULL DEFAULT 0;
+
+ALTER TABLE sectors_sdr_pipeline
+    ADD COLUMN attempts_finalize INT NOT NULL DEFAULT 0;
+
+ALTER TABLE sectors_sdr_pipeline
+    ADD COLUMN attempts_move_storage INT NOT NULL DEFAULT 0;
+
+ALTER TABLE sectors_sdr_pipeline
+    ADD COLUMN attempts_commit_msg INT NOT NULL DEFAULT 0;
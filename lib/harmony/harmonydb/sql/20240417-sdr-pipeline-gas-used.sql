@@ -0,0 +1,7 @@
+-- gas used by the landed precommit and commit messages, as reported in the
+-- message receipt; recorded by the poller together with the success flag
+ALTER TABLE sectors_sdr_pipeline
+    ADD COLUMN precommit_msg_gas_used BIGINT;
+
+ALTER TABLE sectors_sdr_pipeline
+    ADD COLUMN commit_msg_gas_used BIGINT;
@@ -0,0 +1,15 @@
+-- append-only log of seal poller decisions for each sector, written in the
+-- same transaction as the pipeline state change
+CREATE TABLE sector_pipeline_events (
+    id BIGSERIAL PRIMARY KEY,
+
+    sp_id BIGINT NOT NULL,
+    sector_number BIGINT NOT NULL,
+
+    stage TEXT NOT NULL,
+    action TEXT NOT NULL,
+    event_time TIMESTAMP NOT NULL DEFAULT current_timestamp,
+    detail TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX sector_pipeline_events_sector ON sector_pipeline_events (sp_id, sector_number, id);
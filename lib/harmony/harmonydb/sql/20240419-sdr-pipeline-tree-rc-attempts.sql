@@ -0,0 +1,4 @@
+-- with split trees the poller assigns TreeD and TreeRC tasks separately;
+-- attempts_trees counts TreeD tasks, attempts_tree_rc counts TreeRC tasks
+ALTER TABLE sectors_sdr_pipeline
+    ADD COLUMN attempts_tree_rc INT NOT NULL DEFAULT 0;
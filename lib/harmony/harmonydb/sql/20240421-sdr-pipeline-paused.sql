@@ -0,0 +1,4 @@
+-- sectors held by the operator; the seal poller doesn't start new tasks for
+-- paused sectors, but still checks messages which were already sent
+ALTER TABLE sectors_sdr_pipeline
+    ADD COLUMN paused BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,4 @@
+-- OpenTelemetry trace ID shared by the stage spans the seal poller emits for
+-- a sector, set when the first stage task is started with tracing enabled
+ALTER TABLE sectors_sdr_pipeline
+    ADD COLUMN trace_id TEXT;
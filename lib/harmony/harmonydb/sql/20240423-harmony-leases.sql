@@ -0,0 +1,10 @@
+-- named leases held by one owner at a time, used for leader election. The
+-- owner renews a lease before it expires; once it has expired any other owner
+-- can claim it.
+CREATE TABLE harmony_leases (
+    name TEXT PRIMARY KEY,
+
+    owner TEXT NOT NULL,
+    heartbeat TIMESTAMP NOT NULL DEFAULT current_timestamp,
+    expires_at TIMESTAMP NOT NULL
+);
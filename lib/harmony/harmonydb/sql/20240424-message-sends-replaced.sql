@@ -0,0 +1,13 @@
+-- messages replaced at the same nonce with a higher fee keep their row, which
+-- points at the send task of the replacement. Only the last message sent at a
+-- nonce counts towards sender/nonce uniqueness.
+ALTER TABLE message_sends
+    ADD COLUMN replaced_by_task_id BIGINT;
+
+DROP INDEX message_sends_success_index;
+
+CREATE UNIQUE INDEX message_sends_success_index
+    ON message_sends (from_key, nonce)
+    WHERE send_success IS NOT FALSE AND replaced_by_task_id IS NULL;
+
+COMMENT ON COLUMN message_sends.replaced_by_task_id IS 'send task id of the message replacing this one at the same nonce, null if not replaced';
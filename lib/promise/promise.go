@@ -7,6 +7,7 @@ import (
 
 type Promise[T any] struct {
 	val  T
+	set  bool
 	done chan struct{}
 	mu   sync.Mutex
 }
@@ -17,6 +18,7 @@ func (p *Promise[T]) Set(val T) {
 
 	// Set value
 	p.val = val
+	p.set = true
 
 	// Initialize the done channel if it hasn't been initialized
 	if p.done == nil {
@@ -27,6 +29,8 @@ func (p *Promise[T]) Set(val T) {
 	close(p.done)
 }
 
+// Val waits for the promise to be set and returns its value. If ctx is done
+// before that, Val returns the zero value of T.
 func (p *Promise[T]) Val(ctx context.Context) T {
 	p.mu.Lock()
 	// Initialize the done channel if it hasn't been initialized
@@ -49,5 +53,6 @@ func (p *Promise[T]) Val(ctx context.Context) T {
 func (p *Promise[T]) IsSet() bool {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	return p.done != nil
+	// done is also created by Val waiting for the value
+	return p.set
 }
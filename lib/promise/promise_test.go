@@ -63,3 +63,19 @@ func TestPromiseValContextCancel(t *testing.T) {
 		t.Fatalf("expected zero-value, got %v", val)
 	}
 }
+
+func TestPromiseIsSetAfterCancelledVal(t *testing.T) {
+	p := &Promise[int]{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_ = p.Val(ctx)
+	if p.IsSet() {
+		t.Fatal("expected promise not to be set")
+	}
+
+	p.Set(42)
+	if !p.IsSet() {
+		t.Fatal("expected promise to be set")
+	}
+}
@@ -50,6 +50,10 @@ var (
 	StorageID, _      = tag.NewKey("storage_id")
 	SectorState, _    = tag.NewKey("sector_state")
 
+	SchedRejectReason, _ = tag.NewKey("reject_reason")
+	SchedViolation, _    = tag.NewKey("violation")
+	SealProofType, _     = tag.NewKey("seal_proof_type")
+
 	PathSeal, _    = tag.NewKey("path_seal")
 	PathStorage, _ = tag.NewKey("path_storage")
 
@@ -158,6 +162,13 @@ var (
 	SchedAssignerSubmitDuration          = stats.Float64("sched/assigner_cycle_submit_ms", "Duration of scheduler window submit step", stats.UnitMilliseconds)
 	SchedCycleOpenWindows                = stats.Int64("sched/assigner_cycle_open_window", "Number of open windows in scheduling cycles", stats.UnitDimensionless)
 	SchedCycleQueueSize                  = stats.Int64("sched/assigner_cycle_task_queue_entry", "Number of task queue entries in scheduling cycles", stats.UnitDimensionless)
+	SchedQueueLength                     = stats.Int64("sched/assigner_queue_length", "Number of task queue entries in the latest scheduling cycle", stats.UnitDimensionless)
+	SchedAssignedTasks                   = stats.Int64("sched/assigner_assigned_tasks", "Number of tasks assigned to worker windows", stats.UnitDimensionless)
+	SchedNoWindowSkips                   = stats.Int64("sched/assigner_no_window_skips", "Number of times a task was skipped because no acceptable window could fit it", stats.UnitDimensionless)
+	SchedWindowRejections                = stats.Int64("sched/assigner_window_rejections", "Number of open windows found unacceptable for tasks in scheduling cycles, by reason", stats.UnitDimensionless)
+	SchedAssignerViolations              = stats.Int64("sched/assigner_violations", "Number of assignments breaking assigner invariants in scheduling cycles, by violation", stats.UnitDimensionless)
+	SchedUnmetTasks                      = stats.Int64("sched/assigner_unmet_tasks", "Number of tasks left unassigned by the latest scheduling cycle, by task and proof type", stats.UnitDimensionless)
+	SchedUnmetMemory                     = stats.Int64("sched/assigner_unmet_memory_bytes", "Maximum memory needed by the tasks left unassigned by the latest scheduling cycle, by task and proof type", stats.UnitBytes)
 
 	DagStorePRInitCount      = stats.Int64("dagstore/pr_init_count", "PieceReader init count", stats.UnitDimensionless)
 	DagStorePRBytesRequested = stats.Int64("dagstore/pr_requested_bytes", "PieceReader requested bytes", stats.UnitBytes)
@@ -500,6 +511,40 @@ var (
 		Measure:     SchedCycleQueueSize,
 		Aggregation: queueSizeDistribution,
 	}
+	SchedQueueLengthView = &view.View{
+		Measure:     SchedQueueLength,
+		Aggregation: view.LastValue(),
+	}
+	SchedAssignedTasksView = &view.View{
+		Measure:     SchedAssignedTasks,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{TaskType, WorkerHostname},
+	}
+	SchedNoWindowSkipsView = &view.View{
+		Measure:     SchedNoWindowSkips,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{TaskType},
+	}
+	SchedWindowRejectionsView = &view.View{
+		Measure:     SchedWindowRejections,
+		Aggregation: view.Sum(),
+		TagKeys:     []tag.Key{TaskType, SchedRejectReason},
+	}
+	SchedAssignerViolationsView = &view.View{
+		Measure:     SchedAssignerViolations,
+		Aggregation: view.Sum(),
+		TagKeys:     []tag.Key{TaskType, SchedViolation},
+	}
+	SchedUnmetTasksView = &view.View{
+		Measure:     SchedUnmetTasks,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{TaskType, SealProofType},
+	}
+	SchedUnmetMemoryView = &view.View{
+		Measure:     SchedUnmetMemory,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{TaskType, SealProofType},
+	}
 
 	DagStorePRInitCountView = &view.View{
 		Measure:     DagStorePRInitCount,
@@ -826,6 +871,13 @@ var MinerNodeViews = append([]*view.View{
 	SchedAssignerSubmitDurationView,
 	SchedCycleOpenWindowsView,
 	SchedCycleQueueSizeView,
+	SchedQueueLengthView,
+	SchedAssignedTasksView,
+	SchedNoWindowSkipsView,
+	SchedWindowRejectionsView,
+	SchedAssignerViolationsView,
+	SchedUnmetTasksView,
+	SchedUnmetMemoryView,
 
 	DagStorePRInitCountView,
 	DagStorePRBytesRequestedView,
@@ -154,6 +154,11 @@ func DefaultStorageMiner() *StorageMiner {
 			CommitBatchWait:  Duration(24 * time.Hour),    // this can be up to 30 days
 			CommitBatchSlack: Duration(1 * time.Hour),     // time buffer for forceful batch submission before sectors/deals in batch would start expiring, higher value will lower the chances for message fail due to expiration
 
+			// only used with BatchFlushOnGasSavings
+			BatchGasBase:         30_000_000,
+			BatchGasGrowth:       3_000_000,
+			BatchFlushMinSavings: 10_000_000,
+
 			BatchPreCommitAboveBaseFee: types.FIL(types.BigMul(types.PicoFil, types.NewInt(320))), // 0.32 nFIL
 			AggregateAboveBaseFee:      types.FIL(types.BigMul(types.PicoFil, types.NewInt(320))), // 0.32 nFIL
 
@@ -361,5 +366,12 @@ func DefaultCurioConfig() *CurioConfig {
 			PartitionCheckTimeout: Duration(20 * time.Minute),
 			SingleCheckTimeout:    Duration(10 * time.Minute),
 		},
+		Seal: CurioSealConfig{
+			MaxTaskAttempts:       10,
+			CommitMsgUrgentEpochs: 2880,
+			PollerCacheTTL:        Duration(5 * time.Second),
+			PollerJitter:          0.1,
+			PollerBreakerCooldown: Duration(time.Minute),
+		},
 	}
 }
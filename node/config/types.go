@@ -74,6 +74,7 @@ type CurioConfig struct {
 	// Addresses of wallets per MinerAddress (one of the fields).
 	Addresses []CurioAddresses
 	Proving   CurioProvingConfig
+	Seal      CurioSealConfig
 	Journal   JournalConfig
 	Apis      ApisConfig
 }
@@ -159,6 +160,17 @@ type CurioSubsystemsConfig struct {
 	// also be bounded by resources available on the machine.
 	SealSDRTreesMaxTasks int
 
+	// EnableSealTreeD enables the TreeD task, used instead of SDRTrees when
+	// Seal.SplitTrees is set. With split trees, EnableSealSDRTrees enables both
+	// TreeD and TreeRC tasks. Nodes with TreeD enabled also answer to Finalize
+	// tasks. The number of tasks is limited by SealSDRTreesMaxTasks.
+	EnableSealTreeD bool
+
+	// EnableSealTreeRC enables the TreeRC task, building TreeC and TreeR after
+	// TreeD when Seal.SplitTrees is set. Nodes with TreeRC enabled also answer
+	// to Finalize tasks. The number of tasks is limited by SealSDRTreesMaxTasks.
+	EnableSealTreeRC bool
+
 	// FinalizeMaxTasks is the maximum amount of finalize tasks that can run simultaneously.
 	// The finalize task is enabled on all machines which also handle SDRTrees tasks. Finalize ALWAYS runs on whichever
 	// machine holds sector cache files, as it removes unneeded tree data after PoRep is computed.
@@ -569,6 +581,26 @@ type SealingConfig struct {
 	CommitBatchWait Duration
 	// time buffer for forceful batch submission before sectors/deals in batch would start expiring
 	CommitBatchSlack Duration
+	// maximum number of epochs between seed epochs of sectors in one commit aggregate, sectors with
+	// seeds further apart are aggregated separately (0 = no limit)
+	AggregateSeedWindow uint64
+
+	// send precommit and commit batches once adding another sector to the batch is estimated to save
+	// less than BatchFlushMinSavings gas, instead of waiting for the maximum batch size. Batches are
+	// still sent on timeout and before sectors in them would expire
+	BatchFlushOnGasSavings bool
+
+	// gas overhead of a batch message in the gas model of BatchFlushOnGasSavings, saved for every
+	// sector sent in a batch instead of in its own message
+	BatchGasBase int64
+
+	// superlinear gas growth of a batch message in the gas model of BatchFlushOnGasSavings; a batch of
+	// n sectors is estimated to use BatchGasBase + BatchGasGrowth*n*log2(n) gas on top of its per-sector gas
+	BatchGasGrowth int64
+
+	// minimum gas adding another sector to a batch must save for BatchFlushOnGasSavings to keep
+	// waiting for more sectors
+	BatchFlushMinSavings int64
 
 	// network BaseFee below which to stop doing precommit batching, instead
 	// sending precommit messages to the chain individually. When the basefee is
@@ -643,10 +675,141 @@ type SealerConfig struct {
 	// 'lotus-miner sealing sched-diag' and 'lotus-miner storage find [sector num]'
 	DisallowRemoteFinalize bool
 
+	// AssignerCheckSpace when set to true makes the scheduler skip workers
+	// which don't have local sealing paths with enough free space for the
+	// sector files a task will create (AddPiece, PreCommit1, ReplicaUpdate
+	// and RegenSectorKey tasks). Leave disabled if workers seal to remote or
+	// shared storage paths.
+	AssignerCheckSpace bool
+
+	// AssignerPathHints when set to true makes the scheduler pick the sealing
+	// path in which the sector files a task creates are placed, out of the
+	// local paths of the worker the task is assigned to, instead of leaving the
+	// choice to the worker. Paths are picked by AssignerPathTiers, then by free
+	// space and path weight. Hints are only given to workers running in the
+	// miner process, remote workers pick paths themselves.
+	AssignerPathHints bool
+
+	// AssignerPathTiers lists storage path groups in order of preference for
+	// AssignerPathHints, e.g. ["nvme", "ssd"]. Paths in none of the groups are
+	// picked last.
+	AssignerPathTiers []string
+
+	// AssignerLogSummary when set to true makes the scheduler log a single
+	// summary line per scheduling pass at debug level, instead of a line for
+	// every task it tries to assign. Per-task logs are useful for debugging
+	// scheduling decisions, but slow down busy schedulers.
+	AssignerLogSummary bool
+
+	// AssignerMaxWorkerTasks is the maximum number of tasks a single worker
+	// can hold at once, counting running, preparing and assigned tasks. Workers
+	// at the limit get no new tasks, even if they have resources for them.
+	// 0 (default) disables the limit.
+	AssignerMaxWorkerTasks int
+
+	// AssignerMaxWorkerPassTasks is the maximum number of tasks the "spread"
+	// family of assigners gives a single worker in one scheduling pass, across all
+	// of its open windows. Lower values smooth out bursts of assignments before
+	// workers report back their resource use. 0 (default) disables the limit.
+	AssignerMaxWorkerPassTasks int
+
+	// AssignerTrace when set to true makes the scheduler record, for every
+	// queued task, the windows it considered in the latest scheduling pass and
+	// why each of them was rejected (resources, cap, policy or full). The trace
+	// is included in 'lotus-miner sealing sched-diag' output.
+	AssignerTrace bool
+
 	// ResourceFiltering instructs the system which resource filtering strategy
 	// to use when evaluating tasks against this worker. An empty value defaults
 	// to "hardware".
 	ResourceFiltering ResourceFilteringStrategy
+
+	// AssignerWorkerWeights sets relative weights of workers, by worker
+	// hostname, used by the "spread" family of assigners. A worker with weight
+	// 3 is given three times as many tasks as a worker with weight 1. Workers
+	// not listed have weight 1.
+	AssignerWorkerWeights map[string]float64
+
+	// AssignerResourceOverrides replaces resource requirements of task types
+	// when the scheduler checks which workers can take a task, and in the
+	// "spread" family of assigners. Keys are named like worker resource env
+	// vars, a task type and a field, e.g. "PC2_MIN_MEMORY" = "68719476736".
+	// Fields which aren't overridden come from the worker resource table.
+	AssignerResourceOverrides map[string]string
+
+	// AssignerReservedFraction is the fraction of the memory, CPUs and GPUs
+	// of each worker which the "spread" family of assigners keeps free for the
+	// task types listed in AssignerReservedTasks, so that these tasks can find
+	// a worker even when the scheduler is saturated with other work. Must be
+	// below 1. (0 = no reservation)
+	AssignerReservedFraction float64
+
+	// AssignerReservedTasks lists the task types, by short name (e.g. "C2",
+	// "PC2", "FIN"), which can use the resources reserved with
+	// AssignerReservedFraction.
+	AssignerReservedTasks []string
+
+	// AssignerWarmupPeriod is how long after connecting a worker is considered
+	// cold. When two workers are equally loaded, the "spread" family of
+	// assigners prefers a warm worker, so that heavy tasks don't go to workers
+	// which haven't warmed their caches and storage yet. (0 = all workers warm)
+	AssignerWarmupPeriod Duration
+
+	// AssignerPreferProofMatch when set to true makes the "spread" family of
+	// assigners prefer, among equally loaded workers, workers which recently ran
+	// tasks of the same seal proof type (e.g. 32GiB sectors), so that workers keep
+	// their parameter caches warm for the sector size they specialize in. Warm
+	// workers (see AssignerWarmupPeriod) are still preferred first.
+	AssignerPreferProofMatch bool
+
+	// AssignerReserveStarving when set to true makes assigners reserve a worker
+	// for each task skipped in too many scheduling passes, keeping the windows
+	// of that worker from lower priority tasks until the starving task fits.
+	// This guarantees progress of tasks needing a lot of resources on workers
+	// which are kept busy with smaller tasks.
+	AssignerReserveStarving bool
+
+	// AssignerAntiAffinity lists pairs of task types, by short name joined with
+	// a colon (e.g. "PC2:PC2", "PC2:C2"), which assigners don't put on the same
+	// worker at the same time, even if the worker has the resources for both.
+	AssignerAntiAffinity []string
+
+	// AssignerWorkerDomains sets failure domain labels of workers, by worker
+	// hostname, e.g. a rack or storage cluster name. The
+	// "experiment-spread-domains" assigner balances tasks across domains before
+	// balancing them across workers within a domain. Workers not listed are each
+	// in a domain of their own.
+	AssignerWorkerDomains map[string]string
+
+	// AssignerMaxWorkerTaskTypes limits the number of tasks of a type each
+	// worker can hold at a time, by task type short name (e.g. "PC1" = 2),
+	// counting running, preparing and scheduled tasks. Unlike the worker
+	// MAX_CONCURRENT resource settings it applies to all workers, and is meant
+	// for contention the resource model doesn't capture, like disk I/O.
+	// Task types not listed aren't limited.
+	AssignerMaxWorkerTaskTypes map[string]int
+
+	// AssignerMaintenanceWindows sets maintenance windows of workers, by worker
+	// hostname. Each window is a start and a length joined with a slash, where the
+	// start is either an RFC3339 time for a one-off window (e.g.
+	// "2024-05-01T02:00:00Z/4h"), or a UTC time of day for a window every day (e.g.
+	// "02:00/1h"). Assigners don't give a worker tasks which, going by
+	// AssignerTaskDurations, would still run when its next window starts.
+	AssignerMaintenanceWindows map[string][]string
+
+	// AssignerTaskDurations are estimated run times of task types, by task type
+	// short name (e.g. "PC1" = "4h"), used to keep tasks from running into worker
+	// maintenance windows, see AssignerMaintenanceWindows. Task types not listed
+	// are only kept off workers during their maintenance windows.
+	AssignerTaskDurations map[string]Duration
+
+	// AssignerExternalEndpoint is the gRPC endpoint (host:port) of a placement
+	// service used by the "experiment-external" assigner. In each scheduling pass
+	// the service gets the queued tasks with the windows which can run them, and
+	// returns the assignments to make; messages are JSON encoded (content subtype
+	// "json"). Infeasible assignments are skipped. When the service can't be
+	// reached, tasks are assigned like with the "spread" assigner.
+	AssignerExternalEndpoint string
 }
 
 type BatchFeeConfig struct {
@@ -807,6 +970,169 @@ type CurioProvingConfig struct {
 	SingleRecoveringPartitionPerPostMessage bool
 }
 
+type CurioSealConfig struct {
+	// MaxTaskAttempts is the number of times the seal poller will start a task
+	// for a single pipeline stage of a sector. When a stage fails more times, the
+	// sector is marked as failed with 'max_retries_exceeded' reason. (0 = unlimited)
+	MaxTaskAttempts int
+
+	// PollerCacheTTL is how long the seal poller can reuse chain head and precommit
+	// info lookups within a single poll cycle. Cached values are always dropped
+	// at the start of each cycle. (0 = no caching)
+	PollerCacheTTL Duration
+
+	// MaxPrecommitMsgInFlight is the maximum number of sectors which can have a
+	// PreCommit message being sent or waiting to land on chain at the same time.
+	// Sectors over the limit wait in the pipeline until earlier messages land. (0 = unlimited)
+	MaxPrecommitMsgInFlight int
+
+	// MaxCommitMsgInFlight is the maximum number of sectors which can have a
+	// Commit message being sent or waiting to land on chain at the same time.
+	// Sectors over the limit wait in the pipeline until earlier messages land. (0 = unlimited)
+	MaxCommitMsgInFlight int
+
+	// MaxMinerMsgInFlight is the maximum number of sectors of a single miner which
+	// can have a PreCommit or Commit message being sent or waiting to land on chain
+	// at the same time, counted across both stages. (0 = unlimited)
+	MaxMinerMsgInFlight int
+
+	// CommitMsgUrgentEpochs is how many epochs before the precommit of a sector
+	// expires its Commit message becomes urgent. Urgent sectors start their Commit
+	// message task without waiting for MaxCommitMsgInFlight or MaxMinerMsgInFlight,
+	// so that a full message pipeline can't make them lose their precommit deposit.
+	// (0 = never urgent)
+	CommitMsgUrgentEpochs int
+
+	// CommitLandConfidence is the number of epochs the tipset in which a Commit
+	// message was executed must be buried under the chain head before the sector
+	// is considered committed. (0 = as soon as the message lands)
+	CommitLandConfidence int
+
+	// PollerSpIDs restricts the seal poller of this node to sectors of the listed
+	// miner actor IDs, which allows sharding pipeline polling across nodes by
+	// miner. (empty = all miners)
+	PollerSpIDs []int64
+
+	// PollerLeaderElection makes seal pollers of nodes with this option enabled
+	// elect a single leader holding a lease in the database; only the leader polls
+	// the pipeline, renewing the lease on each cycle. When the leader stops
+	// polling its lease expires after 30 seconds and another node takes over.
+	PollerLeaderElection bool
+
+	// CheckSeedRandomness makes the seal poller check that the chain node can
+	// serve beacon randomness for a sector's seed epoch before starting PoRep,
+	// deferring PoRep while it can't.
+	CheckSeedRandomness bool
+
+	// SkipExistingPrecommit makes the seal poller check for an existing on-chain
+	// precommit before sending a PreCommit message for a sector. When one exists,
+	// e.g. for recovered or re-imported sectors, the sector moves directly to
+	// waiting for the seed. Sectors whose sealed CID doesn't match the on-chain
+	// precommit are failed.
+	SkipExistingPrecommit bool
+
+	// SplitTrees makes the seal poller run the trees stage as two tasks: TreeD,
+	// which only needs CPU, and TreeRC, which builds TreeC and TreeR after TreeD
+	// and can use a GPU. The tasks can run on different machines, see
+	// EnableSealTreeD and EnableSealTreeRC. By default a single SDRTrees task
+	// builds all trees.
+	SplitTrees bool
+
+	// VerifyPrecommitMsg makes the seal poller fetch a landed PreCommit message
+	// and check that it precommits the sector before moving the sector on. A
+	// sector whose recorded message doesn't precommit it is failed.
+	VerifyPrecommitMsg bool
+
+	// VerifyCommitProof makes the seal poller verify the PoRep proof of a
+	// sector before starting its Commit message task, failing sectors with an
+	// invalid proof instead of sending a message which would be rejected.
+	// Verification costs some CPU time per sector.
+	VerifyCommitProof bool
+
+	// PollerJitter randomizes the interval between seal poller cycles by up to
+	// this fraction of the interval in either direction, so that pollers of nodes
+	// started at the same time don't query the chain and database in lockstep.
+	// Must be below 1. (0 = no jitter)
+	PollerJitter float64
+
+	// MsgReplaceTimeout is how long a sent PreCommit or Commit message can wait to
+	// land on chain before the seal poller replaces it with a message with the same
+	// nonce and a higher gas premium, limited by MaxPreCommitGasFee or
+	// MaxCommitGasFee. Replacements are replaced again after the same timeout.
+	// (0 = never replace)
+	MsgReplaceTimeout Duration
+
+	// MsgSendTimeout is how long after a PreCommit or Commit message task was
+	// started the seal poller waits for it to send its message. When the task has
+	// ended by then without sending one, e.g. after exhausting its retries, the
+	// poller starts a new task for the stage, failing the sector once MaxTaskAttempts
+	// is reached. (0 = never retry)
+	MsgSendTimeout Duration
+
+	// StageTimeDefaults is the expected time sectors spend in pipeline stages,
+	// keyed by stage name (sdr, trees, tree_d, tree_rc, precommit_msg, porep,
+	// finalize, move_storage, commit_msg). Sector ETAs use the average time
+	// recorded in sector events for each stage, and these values for stages
+	// without recorded history. Stages not listed use built-in defaults.
+	StageTimeDefaults map[string]Duration
+
+	// StagePollPeriods makes the seal poller check whether to start tasks of
+	// some stages only every few poll cycles instead of every cycle, keyed by
+	// stage name as in StageTimeDefaults, trading pipeline latency for lower
+	// CPU and database load. Stages with the same period take turns between
+	// cycles. Message landing checks run every cycle regardless. Periods are
+	// capped at 10 cycles. (unlisted, 0 or 1 = every cycle)
+	StagePollPeriods map[string]int
+
+	// MsgExitCodeDispositions sets what the seal poller does with sectors whose
+	// PreCommit or Commit message landed with a non-zero exit code, keyed by the
+	// decimal exit code, with values 'retry' to send a new message, up to
+	// MaxTaskAttempts, or 'terminal' to fail the sector. Exit codes 6
+	// (SysErrInsufficientFunds) and 7 (SysErrOutOfGas) are retried unless listed,
+	// other unlisted exit codes are terminal.
+	MsgExitCodeDispositions map[string]string
+
+	// ValidatePollerSchema makes the node check at startup that the
+	// sectors_sdr_pipeline table has all columns the seal poller uses, with
+	// compatible types, refusing to start when it doesn't.
+	ValidatePollerSchema bool
+
+	// PollerBreakerThreshold is the number of consecutive failed chain API calls
+	// after which the seal poller considers the API degraded. While degraded the
+	// poller only runs stages which don't need the chain API, until
+	// PollerBreakerCooldown passes and a trial call succeeds. (0 = disabled)
+	PollerBreakerThreshold int
+
+	// PollerBreakerCooldown is how long the seal poller waits after the chain API
+	// was found degraded before trying it again, see PollerBreakerThreshold.
+	PollerBreakerCooldown Duration
+
+	// MaxUnstartedAge is how long a sector can stay in the sealing pipeline
+	// without an SDR task ever being started for it before the seal poller fails it
+	// with the 'never_started' reason, so that stuck onboarding surfaces. The age is
+	// counted from when the sector was added to the pipeline. Paused sectors are not
+	// failed. (0 = never fail)
+	MaxUnstartedAge Duration
+
+	// WarnStageRegressions makes the seal poller remember the stage flags of
+	// sectors in the pipeline and log a warning when a flag which was set in the
+	// previous poll cycle isn't anymore, e.g. after a manual database edit. Message
+	// stage flags reset to resend a message before it landed aren't reported.
+	WarnStageRegressions bool
+
+	// PoRepBatchSize is the maximum number of sectors whose PoRep is computed in a
+	// single task. Sectors ready for PoRep in a poll cycle are grouped by miner and
+	// seal proof type; synthetic PoRep sectors are never grouped. (0 or 1 = one
+	// sector per task)
+	PoRepBatchSize int
+
+	// RecoverMissingSeedEpoch makes the seal poller derive the seed epoch again
+	// from the on-chain precommit info for sectors whose precommit landed without a
+	// seed epoch being recorded. Such sectors are always logged with a warning, as
+	// PoRep can't start for them.
+	RecoverMissingSeedEpoch bool
+}
+
 // API contains configs for API endpoint
 type API struct {
 	// Binding address for the Lotus API
@@ -1015,6 +1015,11 @@ func NewSetSealConfigFunc(r repo.LockedRepo) (dtypes.SetSealingConfigFunc, error
 				MaxCommitBatch:             cfg.MaxCommitBatch,
 				CommitBatchWait:            config.Duration(cfg.CommitBatchWait),
 				CommitBatchSlack:           config.Duration(cfg.CommitBatchSlack),
+				AggregateSeedWindow:        uint64(cfg.AggregateSeedWindow),
+				BatchFlushOnGasSavings:     cfg.BatchFlushOnGasSavings,
+				BatchGasBase:               cfg.BatchGasBase,
+				BatchGasGrowth:             cfg.BatchGasGrowth,
+				BatchFlushMinSavings:       cfg.BatchFlushMinSavings,
 				AggregateAboveBaseFee:      types.FIL(cfg.AggregateAboveBaseFee),
 				BatchPreCommitAboveBaseFee: types.FIL(cfg.BatchPreCommitAboveBaseFee),
 
@@ -1065,6 +1070,11 @@ func ToSealingConfig(dealmakingCfg config.DealmakingConfig, sealingCfg config.Se
 		MaxCommitBatch:                         sealingCfg.MaxCommitBatch,
 		CommitBatchWait:                        time.Duration(sealingCfg.CommitBatchWait),
 		CommitBatchSlack:                       time.Duration(sealingCfg.CommitBatchSlack),
+		AggregateSeedWindow:                    abi.ChainEpoch(sealingCfg.AggregateSeedWindow),
+		BatchFlushOnGasSavings:                 sealingCfg.BatchFlushOnGasSavings,
+		BatchGasBase:                           sealingCfg.BatchGasBase,
+		BatchGasGrowth:                         sealingCfg.BatchGasGrowth,
+		BatchFlushMinSavings:                   sealingCfg.BatchFlushMinSavings,
 		AggregateAboveBaseFee:                  types.BigInt(sealingCfg.AggregateAboveBaseFee),
 		BatchPreCommitAboveBaseFee:             types.BigInt(sealingCfg.BatchPreCommitAboveBaseFee),
 		MaxSectorProveCommitsSubmittedPerEpoch: sealingCfg.MaxSectorProveCommitsSubmittedPerEpoch,
@@ -561,6 +561,17 @@ func (st *Local) AcquireSector(ctx context.Context, sid storiface.SectorRef, exi
 			return storiface.SectorPaths{}, storiface.SectorPaths{}, xerrors.Errorf("finding best storage for allocating : %w", err)
 		}
 
+		// try the path the scheduler picked first
+		if hint := storiface.PathHint(ctx); hint != "" {
+			for i, si := range sis {
+				if si.ID == hint {
+					copy(sis[1:i+1], sis[:i])
+					sis[0] = si
+					break
+				}
+			}
+		}
+
 		var best string
 		var bestID storiface.ID
 
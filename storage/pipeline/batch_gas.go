@@ -0,0 +1,57 @@
+package sealing
+
+import (
+	"math"
+
+	"github.com/filecoin-project/lotus/storage/pipeline/sealiface"
+)
+
+// batchGasModel estimates the gas used by a batch message of n sectors as
+//
+//	base + perSector*n + growth*n*log2(n)
+//
+// base is the overhead of a message, which is saved for every sector sent in a
+// batch instead of in a message of its own. growth models the proof
+// verification cost growing faster than linearly with the batch size, so each
+// sector added to a batch saves less gas than the one before. perSector
+// cancels out of the savings and is left out.
+type batchGasModel struct {
+	base, growth float64
+}
+
+func (m batchGasModel) gas(n int) float64 {
+	fn := float64(n)
+	return m.base + m.growth*fn*math.Log2(fn)
+}
+
+// marginalSavings returns the gas saved by sending the n-th sector of a batch
+// in the batch rather than in a message of its own
+func (m batchGasModel) marginalSavings(n int) float64 {
+	if n <= 1 {
+		return 0
+	}
+	return m.gas(1) - (m.gas(n) - m.gas(n-1))
+}
+
+// flushSize returns the batch size at which adding another sector would save
+// less than minSavings gas, at most maxBatch
+func (m batchGasModel) flushSize(minSavings float64, maxBatch int) int {
+	n := 1
+	for n < maxBatch && m.marginalSavings(n+1) >= minSavings {
+		n++
+	}
+	return n
+}
+
+// batchFlushSize returns the number of pending sectors at which a batch is
+// sent without waiting for more: maxBatch, or with BatchFlushOnGasSavings the
+// size at which the gas model says more sectors aren't worth waiting for.
+// Batches are still sent on timeout and before sectors expire either way.
+func batchFlushSize(cfg sealiface.Config, maxBatch int) int {
+	if !cfg.BatchFlushOnGasSavings {
+		return maxBatch
+	}
+
+	m := batchGasModel{base: float64(cfg.BatchGasBase), growth: float64(cfg.BatchGasGrowth)}
+	return m.flushSize(float64(cfg.BatchFlushMinSavings), maxBatch)
+}
@@ -0,0 +1,35 @@
+package sealing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/lotus/storage/pipeline/sealiface"
+)
+
+func TestBatchFlushSize(t *testing.T) {
+	m := batchGasModel{base: 30_000_000, growth: 3_000_000}
+
+	// each sector added to a batch saves less than the one before
+	for n := 3; n < 100; n++ {
+		require.Less(t, m.marginalSavings(n), m.marginalSavings(n-1))
+	}
+
+	const minSavings = 10_000_000
+	size := m.flushSize(minSavings, 1000)
+
+	// the batch flushes at the size where the next sector would save less
+	// than the threshold
+	require.Equal(t, 37, size)
+	require.GreaterOrEqual(t, m.marginalSavings(size), float64(minSavings))
+	require.Less(t, m.marginalSavings(size+1), float64(minSavings))
+
+	// the maximum batch size still applies
+	require.Equal(t, 20, m.flushSize(minSavings, 20))
+
+	cfg := sealiface.Config{BatchGasBase: 30_000_000, BatchGasGrowth: 3_000_000, BatchFlushMinSavings: minSavings}
+	require.Equal(t, 256, batchFlushSize(cfg, 256))
+	cfg.BatchFlushOnGasSavings = true
+	require.Equal(t, size, batchFlushSize(cfg, 256))
+}
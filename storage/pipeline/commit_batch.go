@@ -55,9 +55,10 @@ type CommitBatcherApi interface {
 }
 
 type AggregateInput struct {
-	Spt   abi.RegisteredSealProof
-	Info  proof.AggregateSealVerifyInfo
-	Proof []byte
+	Spt       abi.RegisteredSealProof
+	Info      proof.AggregateSealVerifyInfo
+	Proof     []byte
+	SeedEpoch abi.ChainEpoch
 
 	ActivationManifest miner.SectorActivationManifest
 	DealIDPrecommit    bool
@@ -209,7 +210,12 @@ func (b *CommitBatcher) maybeStartBatch(notif bool) ([]sealiface.CommitBatchRes,
 		return nil, xerrors.Errorf("getting config: %w", err)
 	}
 
-	if notif && total < cfg.MaxCommitBatch && cfg.AggregateCommits {
+	flushSize := batchFlushSize(cfg, cfg.MaxCommitBatch)
+	if flushSize < cfg.MinCommitBatch {
+		// smaller batches would be sent without aggregation
+		flushSize = cfg.MinCommitBatch
+	}
+	if notif && total < flushSize && cfg.AggregateCommits {
 		return nil, nil
 	}
 
@@ -249,28 +255,45 @@ func (b *CommitBatcher) maybeStartBatch(notif bool) ([]sealiface.CommitBatchRes,
 
 		var sectors []abi.SectorNumber
 		for sn := range b.todo {
-			sectors = append(sectors, sn)
+			if !b.todo[sn].DealIDPrecommit {
+				sectors = append(sectors, sn)
+			}
 		}
-		res, err = b.processBatchV2(cfg, sectors, nv, !individual)
-		if err != nil {
-			err = xerrors.Errorf("processBatchV2: %w", err)
+
+		// when aggregating, sectors which can't share an aggregate are sent in
+		// separate messages, groups too small to aggregate are sent without
+		// aggregation
+		groups := [][]abi.SectorNumber{sectors}
+		if !individual {
+			groups = aggregateGroups(b.todo, sectors, cfg.AggregateSeedWindow)
 		}
 
-		// Mark sectors as done
-		for _, r := range res {
-			if err != nil {
-				r.Error = err.Error()
+		for _, group := range groups {
+			aggregate := !individual && len(group) >= miner.MinAggregatedSectors
+
+			gres, gerr := b.processBatchV2(cfg, group, nv, aggregate)
+			if gerr != nil {
+				err = xerrors.Errorf("processBatchV2: %w", gerr)
 			}
 
-			for _, sn := range r.Sectors {
-				for _, ch := range b.waiting[sn] {
-					ch <- r // buffered
+			// Mark sectors as done
+			for _, r := range gres {
+				if gerr != nil {
+					r.Error = gerr.Error()
 				}
 
-				delete(b.waiting, sn)
-				delete(b.todo, sn)
-				delete(b.cutoffs, sn)
+				for _, sn := range r.Sectors {
+					for _, ch := range b.waiting[sn] {
+						ch <- r // buffered
+					}
+
+					delete(b.waiting, sn)
+					delete(b.todo, sn)
+					delete(b.cutoffs, sn)
+				}
 			}
+
+			res = append(res, gres...)
 		}
 	}
 
@@ -282,14 +305,49 @@ func (b *CommitBatcher) maybeStartBatch(notif bool) ([]sealiface.CommitBatchRes,
 		return nil, err
 	}
 
+	err = nil
+
+	var sectors []abi.SectorNumber
+	for sn := range b.todo {
+		sectors = append(sectors, sn)
+	}
+
 	if individual {
-		resV1, err = b.processIndividually(cfg)
+		resV1, err = b.processIndividually(cfg, sectors)
 	} else {
-		var sectors []abi.SectorNumber
-		for sn := range b.todo {
-			sectors = append(sectors, sn)
+		// sectors which can't share an aggregate are sent in separate messages,
+		// groups too small to aggregate are sent individually
+		var single []abi.SectorNumber
+		var lastErr error
+		for _, group := range aggregateGroups(b.todo, sectors, cfg.AggregateSeedWindow) {
+			if len(group) < miner.MinAggregatedSectors {
+				single = append(single, group...)
+				continue
+			}
+
+			gres, gerr := b.processBatchV1(cfg, group, nv)
+			if gerr != nil {
+				log.Warnf("CommitBatcher maybeStartBatch processBatch (%d sectors) %v", len(group), gerr)
+				for i := range gres {
+					gres[i].Error = gerr.Error()
+				}
+				lastErr = gerr
+			}
+			resV1 = append(resV1, gres...)
+		}
+
+		if len(single) > 0 {
+			sres, serr := b.processIndividually(cfg, single)
+			if serr != nil {
+				lastErr = serr
+			}
+			resV1 = append(resV1, sres...)
+		}
+
+		// errors of groups are recorded in their results
+		if len(resV1) == 0 {
+			err = lastErr
 		}
-		resV1, err = b.processBatchV1(cfg, sectors, nv)
 	}
 
 	if err != nil {
@@ -322,6 +380,45 @@ func (b *CommitBatcher) maybeStartBatch(notif bool) ([]sealiface.CommitBatchRes,
 	return res, nil
 }
 
+// aggregateGroups splits sectors into groups which can be aggregated together.
+// Sectors in a group have the same seal proof type, and, with a non-zero
+// seedWindow, seed epochs at most seedWindow apart. Groups are ordered by proof
+// type and seed epoch.
+func aggregateGroups(todo map[abi.SectorNumber]AggregateInput, sectors []abi.SectorNumber, seedWindow abi.ChainEpoch) [][]abi.SectorNumber {
+	sorted := append([]abi.SectorNumber{}, sectors...)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := todo[sorted[i]], todo[sorted[j]]
+		if a.Spt != b.Spt {
+			return a.Spt < b.Spt
+		}
+		if a.SeedEpoch != b.SeedEpoch {
+			return a.SeedEpoch < b.SeedEpoch
+		}
+		return sorted[i] < sorted[j]
+	})
+
+	var groups [][]abi.SectorNumber
+	var first AggregateInput // first sector of the current group
+
+	for _, sn := range sorted {
+		in := todo[sn]
+
+		if len(groups) == 0 || in.Spt != first.Spt || (seedWindow > 0 && in.SeedEpoch-first.SeedEpoch > seedWindow) {
+			if len(groups) > 0 {
+				log.Infow("splitting commit aggregate", "group-sectors", len(groups[len(groups)-1]),
+					"spt", first.Spt, "next-spt", in.Spt, "seed", first.SeedEpoch, "next-seed", in.SeedEpoch)
+			}
+
+			groups = append(groups, nil)
+			first = in
+		}
+
+		groups[len(groups)-1] = append(groups[len(groups)-1], sn)
+	}
+
+	return groups
+}
+
 // processBatchV2 processes a batch of sectors after nv22. It will always send
 // ProveCommitSectors3Params which may contain either individual proofs or an
 // aggregate proof depending on SP condition and network conditions.
@@ -617,7 +714,7 @@ func (b *CommitBatcher) processBatchV1(cfg sealiface.Config, sectors []abi.Secto
 	return []sealiface.CommitBatchRes{res}, nil
 }
 
-func (b *CommitBatcher) processIndividually(cfg sealiface.Config) ([]sealiface.CommitBatchRes, error) {
+func (b *CommitBatcher) processIndividually(cfg sealiface.Config, sectors []abi.SectorNumber) ([]sealiface.CommitBatchRes, error) {
 
 	mi, err := b.api.StateMinerInfo(b.mctx, b.maddr, types.EmptyTSK)
 	if err != nil {
@@ -647,7 +744,9 @@ func (b *CommitBatcher) processIndividually(cfg sealiface.Config) ([]sealiface.C
 
 	sectorsProcessed := 0
 
-	for sn, info := range b.todo {
+	for _, sn := range sectors {
+		info := b.todo[sn]
+
 		r := sealiface.CommitBatchRes{
 			Sectors:       []abi.SectorNumber{sn},
 			FailedSectors: map[abi.SectorNumber]string{},
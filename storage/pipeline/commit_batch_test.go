@@ -0,0 +1,43 @@
+package sealing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+)
+
+func TestAggregateGroups(t *testing.T) {
+	const (
+		spt32 = abi.RegisteredSealProof_StackedDrg32GiBV1_1
+		spt64 = abi.RegisteredSealProof_StackedDrg64GiBV1_1
+	)
+
+	todo := map[abi.SectorNumber]AggregateInput{
+		1: {Spt: spt32, SeedEpoch: 100},
+		2: {Spt: spt64, SeedEpoch: 100},
+		3: {Spt: spt32, SeedEpoch: 150},
+		4: {Spt: spt64, SeedEpoch: 110},
+		5: {Spt: spt32, SeedEpoch: 400},
+		6: {Spt: spt32, SeedEpoch: 100},
+	}
+
+	sectors := []abi.SectorNumber{6, 5, 4, 3, 2, 1}
+
+	// without a seed window, only proof types split the batch
+	require.Equal(t, [][]abi.SectorNumber{
+		{1, 6, 3, 5},
+		{2, 4},
+	}, aggregateGroups(todo, sectors, 0))
+
+	// a sector with a seed further than the window from the seed of the first
+	// sector in the group starts a new group
+	require.Equal(t, [][]abi.SectorNumber{
+		{1, 6, 3},
+		{5},
+		{2, 4},
+	}, aggregateGroups(todo, sectors, 100))
+
+	require.Empty(t, aggregateGroups(todo, nil, 100))
+}
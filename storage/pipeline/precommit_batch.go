@@ -203,9 +203,10 @@ func (b *PreCommitBatcher) maybeStartBatch(notif bool) ([]sealiface.PreCommitBat
 		curBasefeeLow = true
 	}
 
-	// if this wasn't an user-forced batch, and we're not at/above the max batch size,
+	// if this wasn't an user-forced batch, and we're not at/above the max batch size
+	// (or the size where batching more saves little gas, see batchFlushSize),
 	// and we're not above the basefee threshold, don't batch yet
-	if notif && total < cfg.MaxPreCommitBatch && !curBasefeeLow {
+	if notif && total < batchFlushSize(cfg, cfg.MaxPreCommitBatch) && !curBasefeeLow {
 		return nil, nil
 	}
 
@@ -52,6 +52,13 @@ type Config struct {
 	CommitBatchWait  time.Duration
 	CommitBatchSlack time.Duration
 
+	AggregateSeedWindow abi.ChainEpoch
+
+	BatchFlushOnGasSavings bool
+	BatchGasBase           int64
+	BatchGasGrowth         int64
+	BatchFlushMinSavings   int64
+
 	AggregateAboveBaseFee      abi.TokenAmount
 	BatchPreCommitAboveBaseFee abi.TokenAmount
 
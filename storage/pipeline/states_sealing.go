@@ -860,8 +860,9 @@ func (m *Sealing) handleSubmitCommitAggregate(ctx statemachine.Context, sector S
 			SealedCID:             *sector.CommR,
 			UnsealedCID:           *sector.CommD,
 		},
-		Proof: sector.Proof,
-		Spt:   sector.SectorType,
+		Proof:     sector.Proof,
+		Spt:       sector.SectorType,
+		SeedEpoch: sector.SeedEpoch,
 
 		ActivationManifest: miner2.SectorActivationManifest{
 			SectorNumber: sector.SectorNumber,
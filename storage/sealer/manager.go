@@ -109,6 +109,52 @@ func New(ctx context.Context, lstor *paths.Local, stor paths.Store, ls paths.Loc
 	if err != nil {
 		return nil, err
 	}
+	if sc.Assigner == ExternalAssignerName {
+		sh.assigner, err = NewExternalAssigner(sc.AssignerExternalEndpoint)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if sc.AssignerCheckSpace {
+		sh.spaceIndex = si
+	}
+	if sc.AssignerPathHints {
+		sh.pathIndex = si
+		sh.pathTiers = sc.AssignerPathTiers
+	}
+	sh.assignLogSummary = sc.AssignerLogSummary
+	sh.maxWorkerTasks = sc.AssignerMaxWorkerTasks
+	sh.maxWorkerPassTasks = sc.AssignerMaxWorkerPassTasks
+	sh.traceAssign = sc.AssignerTrace
+	sh.workerWeights = sc.AssignerWorkerWeights
+	sh.workerDomains = sc.AssignerWorkerDomains
+	sh.warmupPeriod = time.Duration(sc.AssignerWarmupPeriod)
+	sh.preferProofMatch = sc.AssignerPreferProofMatch
+	sh.reserveStarving = sc.AssignerReserveStarving
+	sh.resourceOverrides, err = parseResourceOverrides(sc.AssignerResourceOverrides)
+	if err != nil {
+		return nil, xerrors.Errorf("parsing AssignerResourceOverrides: %w", err)
+	}
+	sh.reservation, err = parseCapacityReservation(sc.AssignerReservedFraction, sc.AssignerReservedTasks)
+	if err != nil {
+		return nil, xerrors.Errorf("parsing AssignerReservedTasks: %w", err)
+	}
+	sh.antiAffinity, err = parseAntiAffinity(sc.AssignerAntiAffinity)
+	if err != nil {
+		return nil, xerrors.Errorf("parsing AssignerAntiAffinity: %w", err)
+	}
+	sh.taskTypeCaps, err = parseTaskTypeCaps(sc.AssignerMaxWorkerTaskTypes)
+	if err != nil {
+		return nil, xerrors.Errorf("parsing AssignerMaxWorkerTaskTypes: %w", err)
+	}
+	sh.maintenance, err = parseMaintenanceSchedules(sc.AssignerMaintenanceWindows)
+	if err != nil {
+		return nil, xerrors.Errorf("parsing AssignerMaintenanceWindows: %w", err)
+	}
+	sh.taskDurations, err = parseTaskDurations(sc.AssignerTaskDurations)
+	if err != nil {
+		return nil, xerrors.Errorf("parsing AssignerTaskDurations: %w", err)
+	}
 
 	m := &Manager{
 		ls:         ls,
@@ -1315,6 +1361,29 @@ func (m *Manager) SchedDiag(ctx context.Context, doSched bool) (interface{}, err
 	return i, nil
 }
 
+// SchedSnapshot returns the current scheduler state, which can be replayed
+// offline with SimulateSnapshot
+func (m *Manager) SchedSnapshot(ctx context.Context) (*SchedSnapshot, error) {
+	return m.sched.Snapshot(ctx)
+}
+
+// SetWorkerDraining sets or clears the draining state of a worker, see
+// Scheduler.SetWorkerDraining
+func (m *Manager) SetWorkerDraining(ctx context.Context, wid storiface.WorkerID, draining bool) error {
+	return m.sched.SetWorkerDraining(wid, draining)
+}
+
+// PinSector makes all tasks of a sector run on the given worker, see
+// Scheduler.PinSector
+func (m *Manager) PinSector(ctx context.Context, sector abi.SectorID, wid storiface.WorkerID) error {
+	return m.sched.PinSector(sector, wid)
+}
+
+// UnpinSector removes the pin of a sector, see Scheduler.UnpinSector
+func (m *Manager) UnpinSector(ctx context.Context, sector abi.SectorID) error {
+	return m.sched.UnpinSector(sector)
+}
+
 func (m *Manager) RemoveSchedRequest(ctx context.Context, schedId uuid.UUID) error {
 	return m.sched.RemoveRequest(ctx, schedId)
 }
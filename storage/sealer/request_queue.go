@@ -12,6 +12,10 @@ func (q RequestQueue) Less(i, j int) bool {
 		return muchLess
 	}
 
+	if q[i].starving != q[j].starving {
+		return q[i].starving
+	}
+
 	if q[i].Priority != q[j].Priority {
 		return q[i].Priority > q[j].Priority
 	}
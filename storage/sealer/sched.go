@@ -11,6 +11,7 @@ import (
 	"github.com/filecoin-project/go-state-types/abi"
 
 	"github.com/filecoin-project/lotus/metrics"
+	"github.com/filecoin-project/lotus/storage/paths"
 	"github.com/filecoin-project/lotus/storage/sealer/sealtasks"
 	"github.com/filecoin-project/lotus/storage/sealer/storiface"
 )
@@ -24,6 +25,11 @@ var InitWait = 3 * time.Second
 
 var (
 	SchedWindows = 2
+
+	// StarvationSkips is the number of consecutive scheduling passes a task can
+	// be skipped for lack of window resources before it's considered starving.
+	// Starving tasks get the first pick of windows in later passes.
+	StarvationSkips = 50
 )
 
 func getPriority(ctx context.Context) int {
@@ -65,6 +71,87 @@ type Scheduler struct {
 	mctx context.Context // metrics context
 
 	assigner Assigner
+	// assignerName is the registry name of assigner, see SchedSnapshot
+	assignerName string
+
+	// spaceIndex, when set, is used to check that workers have local sealing
+	// space for the files a task will allocate
+	spaceIndex paths.SectorIndex
+
+	// pathIndex, when set, is used to pick storage path hints for the files a
+	// task will allocate, preferring paths in the first of pathTiers groups
+	pathIndex paths.SectorIndex
+	pathTiers []storiface.Group
+
+	// assignLogSummary makes assigners log one summary line per scheduling pass
+	// instead of debug lines for every task
+	assignLogSummary bool
+
+	// maxWorkerTasks, when non-zero, is the number of tasks a worker can hold
+	// before assigners stop giving it new ones
+	maxWorkerTasks int
+
+	// maxWorkerPassTasks, when non-zero, is the number of tasks spread
+	// assigners give a worker in a single scheduling pass
+	maxWorkerPassTasks int
+
+	// traceAssign makes assigners keep a trace of the decisions made in the
+	// latest scheduling pass, returned in SchedDiagInfo
+	traceAssign bool
+	lastTrace   []SchedTraceTask // owned by the sh.runSched goroutine
+
+	// lastUnmet is the unmet demand of the latest scheduling pass, see
+	// recordUnmetDemand; owned by the sh.runSched goroutine
+	lastUnmet []SchedUnmetDemand
+
+	// workerWeights are relative worker weights used by spread assigners, by
+	// worker hostname
+	workerWeights map[string]float64
+
+	// resourceOverrides replace worker resource spec fields of task types in
+	// the acceptable windows check and in spread assigners
+	resourceOverrides resourceOverrides
+
+	// reservation, when set, keeps a fraction of worker resources for some
+	// task types in spread assigners
+	reservation *capacityReservation
+
+	// antiAffinity lists task types assigners keep off workers running tasks
+	// of some other types, see antiAffinityConflict
+	antiAffinity antiAffinity
+
+	// taskTypeCaps limit tasks of some types per worker, see taskTypeAtCap
+	taskTypeCaps taskTypeCaps
+
+	// maintenance are worker maintenance windows assigners keep tasks from
+	// running into, using the run time estimates in taskDurations, see
+	// maintenanceConflict
+	maintenance   maintenanceSchedules
+	taskDurations map[sealtasks.TaskType]time.Duration
+
+	// workerDomains are failure domain labels of workers, by worker hostname,
+	// see workerDomain
+	workerDomains map[string]string
+
+	// health tracks recent task failures of workers, see ReportTaskOutcome
+	health workerHealth
+
+	// warmupPeriod is how long after joining workers are cold, see workerWarm
+	warmupPeriod time.Duration
+
+	// proofRecency tracks the seal proof types workers recently ran, used to
+	// break ties with preferProofMatch set, see proofMatch
+	proofRecency     workerProofRecency
+	preferProofMatch bool
+
+	// pins are the workers sectors are pinned to, see PinSector
+	pins sectorPins
+
+	// reserveStarving makes assigners reserve workers for starving tasks,
+	// starvingReservations are the reserved workers, owned by the
+	// sh.runSched goroutine, see reserveStarving
+	reserveStarving      bool
+	starvingReservations map[storiface.WorkerID]*WorkerRequest
 
 	workersLk sync.RWMutex
 
@@ -81,8 +168,9 @@ type Scheduler struct {
 
 	workTracker *workTracker
 
-	info      chan func(interface{})
-	rmRequest chan *rmRequest
+	info        chan func(interface{})
+	snapshotReq chan chan *SchedSnapshot
+	rmRequest   chan *rmRequest
 
 	closing  chan struct{}
 	closed   chan struct{}
@@ -104,6 +192,16 @@ type WorkerHandle struct {
 
 	Enabled bool
 
+	// Draining workers finish tasks already assigned to them, but assigners
+	// don't assign new tasks to them
+	Draining bool
+
+	// joined is when the worker was added to the scheduler
+	joined time.Time
+
+	// local workers run in the miner process, and follow storage path hints
+	local bool
+
 	// for sync manager goroutine closing
 	cleanupStarted bool
 	closedMgr      chan struct{}
@@ -144,6 +242,19 @@ type WorkerRequest struct {
 	IndexHeap int
 	ret       chan<- workerResponse
 	Ctx       context.Context
+
+	// PathHint is the storage path picked by the scheduler for the files the
+	// task allocates, empty if there is none
+	PathHint storiface.ID
+
+	// owned by the sh.runSched goroutine
+	skipped  int  // scheduling passes in which no window could fit the task
+	starving bool // skipped in StarvationSkips passes, sorted before other tasks
+
+	// windowPrefs ranks the acceptable windows of the task in the current
+	// scheduling pass by Sel.Cmp preference, 0 for the most preferred ones;
+	// windows the selector prefers equally have the same rank
+	windowPrefs map[int]int
 }
 
 type workerResponse struct {
@@ -156,27 +267,15 @@ type rmRequest struct {
 }
 
 func newScheduler(ctx context.Context, assigner string) (*Scheduler, error) {
-	var a Assigner
-	switch assigner {
-	case "", "utilization":
-		a = NewLowestUtilizationAssigner()
-	case "spread":
-		a = NewSpreadAssigner(false)
-	case "experiment-spread-qcount":
-		a = NewSpreadAssigner(true)
-	case "experiment-spread-tasks":
-		a = NewSpreadTasksAssigner(false)
-	case "experiment-spread-tasks-qcount":
-		a = NewSpreadTasksAssigner(true)
-	case "experiment-random":
-		a = NewRandomAssigner()
-	default:
-		return nil, xerrors.Errorf("unknown assigner '%s'", assigner)
+	a, err := GetAssigner(assigner)
+	if err != nil {
+		return nil, err
 	}
 
 	return &Scheduler{
-		mctx:     ctx,
-		assigner: a,
+		mctx:         ctx,
+		assigner:     a,
+		assignerName: assigner,
 
 		Workers: map[storiface.WorkerID]*WorkerHandle{},
 
@@ -193,8 +292,9 @@ func newScheduler(ctx context.Context, assigner string) (*Scheduler, error) {
 			prepared: map[uuid.UUID]trackedWork{},
 		},
 
-		info:      make(chan func(interface{})),
-		rmRequest: make(chan *rmRequest),
+		info:        make(chan func(interface{})),
+		snapshotReq: make(chan chan *SchedSnapshot),
+		rmRequest:   make(chan *rmRequest),
 
 		closing: make(chan struct{}),
 		closed:  make(chan struct{}),
@@ -263,11 +363,21 @@ type SchedDiagRequestInfo struct {
 	TaskType sealtasks.TaskType
 	Priority int
 	SchedId  uuid.UUID
+	Skipped  int
+	Starving bool
 }
 
 type SchedDiagInfo struct {
 	Requests    []SchedDiagRequestInfo
 	OpenWindows []string
+
+	// Trace lists the windows considered for each task in the latest
+	// scheduling pass, only collected with AssignerTrace enabled
+	Trace []SchedTraceTask `json:",omitempty"`
+
+	// UnmetDemand is the work the latest scheduling pass found no window for,
+	// by task and proof type
+	UnmetDemand []SchedUnmetDemand `json:",omitempty"`
 }
 
 func (sh *Scheduler) runSched() {
@@ -301,6 +411,8 @@ func (sh *Scheduler) runSched() {
 			doSched = true
 		case ireq := <-sh.info:
 			ireq(sh.diag())
+		case sreq := <-sh.snapshotReq:
+			sreq <- sh.snapshot()
 		case <-iw:
 			initialised = true
 			iw = nil
@@ -370,6 +482,8 @@ func (sh *Scheduler) diag() SchedDiagInfo {
 			TaskType: task.TaskType,
 			Priority: task.Priority,
 			SchedId:  task.SchedId,
+			Skipped:  task.skipped,
+			Starving: task.starving,
 		})
 	}
 
@@ -380,6 +494,9 @@ func (sh *Scheduler) diag() SchedDiagInfo {
 		out.OpenWindows = append(out.OpenWindows, uuid.UUID(window.Worker).String())
 	}
 
+	out.Trace = sh.lastTrace
+	out.UnmetDemand = sh.lastUnmet
+
 	return out
 }
 
@@ -441,6 +558,29 @@ func (sh *Scheduler) removeRequest(rmrequest *rmRequest) {
 	rmrequest.res <- xerrors.New("No request with provided details found")
 }
 
+// SetWorkerDraining sets or clears the draining state of a worker. Assigners
+// don't assign new tasks to draining workers, tasks already assigned to them
+// run to completion.
+func (sh *Scheduler) SetWorkerDraining(wid storiface.WorkerID, draining bool) error {
+	sh.workersLk.Lock()
+	w, ok := sh.Workers[wid]
+	if ok {
+		w.Draining = draining
+	}
+	sh.workersLk.Unlock()
+
+	if !ok {
+		return xerrors.Errorf("worker %s not found", wid)
+	}
+
+	select {
+	case sh.workerChange <- struct{}{}:
+	default: // workerChange is buffered, and scheduling is global, so it's ok if we don't send here
+	}
+
+	return nil
+}
+
 func (sh *Scheduler) RemoveRequest(ctx context.Context, schedId uuid.UUID) error {
 	ret := make(chan error, 1)
 
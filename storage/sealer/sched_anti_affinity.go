@@ -0,0 +1,112 @@
+package sealer
+
+import (
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/storage/sealer/sealtasks"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// antiAffinity maps task types to the task types they shouldn't share a worker
+// with. Rules are symmetric.
+type antiAffinity map[sealtasks.TaskType]map[sealtasks.TaskType]struct{}
+
+// parseAntiAffinity parses anti-affinity rules given as pairs of task type
+// short names, e.g. "PC2:PC2" or "PC2:C2"
+func parseAntiAffinity(rules []string) (antiAffinity, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	taskTypes := shortTaskTypes()
+
+	out := antiAffinity{}
+	add := func(a, b sealtasks.TaskType) {
+		if out[a] == nil {
+			out[a] = map[sealtasks.TaskType]struct{}{}
+		}
+		out[a][b] = struct{}{}
+	}
+
+	for _, rule := range rules {
+		as, bs, ok := strings.Cut(rule, ":")
+		if !ok {
+			return nil, xerrors.Errorf("anti-affinity rule %q must be a pair of task types, like 'PC2:C2'", rule)
+		}
+
+		a, ok := taskTypes[strings.TrimSpace(as)]
+		if !ok {
+			return nil, xerrors.Errorf("unknown task type %q in anti-affinity rule %q", as, rule)
+		}
+		b, ok := taskTypes[strings.TrimSpace(bs)]
+		if !ok {
+			return nil, xerrors.Errorf("unknown task type %q in anti-affinity rule %q", bs, rule)
+		}
+
+		add(a, b)
+		add(b, a)
+	}
+
+	return out, nil
+}
+
+// antiAffinityConflict reports whether assigning the task to the worker would
+// put it next to a task it has an anti-affinity rule with, either a task the
+// worker already runs, prepares or has in scheduled windows, or one assigned
+// to it in the current scheduling pass
+func (sh *Scheduler) antiAffinityConflict(task *WorkerRequest, wid storiface.WorkerID, windows []SchedWindow) bool {
+	avoid := sh.antiAffinity[task.TaskType]
+	if len(avoid) == 0 {
+		return false
+	}
+
+	for wnd := range windows {
+		if sh.OpenWindows[wnd].Worker != wid {
+			continue
+		}
+		for _, todo := range windows[wnd].Todo {
+			if _, ok := avoid[todo.TaskType]; ok {
+				return true
+			}
+		}
+	}
+
+	w, ok := sh.Workers[wid]
+	if !ok {
+		return false
+	}
+	return w.hasTaskTypes(avoid)
+}
+
+// hasTaskTypes reports whether the worker runs, prepares or has in scheduled
+// windows any task of the given types
+func (wh *WorkerHandle) hasTaskTypes(tts map[sealtasks.TaskType]struct{}) bool {
+	found := false
+	check := func(tt sealtasks.SealTaskType, count int) {
+		if _, ok := tts[tt.TaskType]; ok && count > 0 {
+			found = true
+		}
+	}
+
+	wh.lk.Lock()
+	wh.active.taskCounters.ForEach(check)
+	wh.preparing.taskCounters.ForEach(check)
+	wh.lk.Unlock()
+	if found {
+		return true
+	}
+
+	wh.wndLk.Lock()
+	defer wh.wndLk.Unlock()
+	for _, window := range wh.activeWindows {
+		for _, todo := range window.Todo {
+			if _, ok := tts[todo.TaskType]; ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
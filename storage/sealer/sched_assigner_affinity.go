@@ -0,0 +1,186 @@
+package sealer
+
+import (
+	"context"
+	"math"
+
+	"github.com/filecoin-project/lotus/storage/paths"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// affinityFileTypes are the sector files which are expensive to move between
+// workers, and which tasks should preferably be scheduled next to
+const affinityFileTypes = storiface.FTUnsealed | storiface.FTSealed | storiface.FTCache
+
+// NewAffinityAssigner returns an assigner which prefers windows on workers
+// which already store the sector's files, falling back to spreading tasks
+// across workers.
+//
+// affinityWeight is the number of tasks (assigned in the current scheduling
+// pass) a worker holding the sector data may have over other workers before
+// the spread criterion wins. 0 makes the assigner equivalent to plain spread.
+func NewAffinityAssigner(index paths.SectorIndex, affinityWeight float64) Assigner {
+	return &AssignerCommon{
+		WindowSel: AffinityWS(index, affinityWeight),
+	}
+}
+
+func AffinityWS(index paths.SectorIndex, affinityWeight float64) func(sh *Scheduler, queueLen int, acceptableWindows [][]int, windows []SchedWindow) int {
+	return func(sh *Scheduler, queueLen int, acceptableWindows [][]int, windows []SchedWindow) int {
+		scheduled := 0
+		rmQueue := make([]int, 0, queueLen)
+		workerAssigned := map[storiface.WorkerID]int{}
+		workerPaths := map[storiface.WorkerID]map[storiface.ID]struct{}{}
+
+		for sqi := 0; sqi < queueLen; sqi++ {
+			task := (*sh.SchedQueue)[sqi]
+
+			holding := sectorStorageIDs(task, index)
+
+			selectedWindow := -1
+			var needRes storiface.Resources
+			var info storiface.WorkerInfo
+			var bestWid storiface.WorkerID
+			var bestLocal bool
+			bestScore := math.MaxFloat64 // smaller = better
+
+			for i, wnd := range acceptableWindows[task.IndexHeap] {
+				wid := sh.OpenWindows[wnd].Worker
+				w := sh.Workers[wid]
+
+				res := w.Info.Resources.ResourceSpec(task.Sector.ProofType, task.TaskType)
+
+				if !sh.assignLogSummary {
+					log.Debugf("SCHED try assign sqi:%d sector %d to window %d (awi:%d)", sqi, task.Sector.ID.Number, wnd, i)
+				}
+
+				if !windows[wnd].Allocated.CanHandleRequest(task.SchedId, task.SealTask(), res, wid, "schedAssign", w.Info) {
+					continue
+				}
+
+				if sh.workerAtCap(wid, windows) {
+					continue
+				}
+
+				if sh.antiAffinityConflict(task, wid, windows) {
+					continue
+				}
+
+				if sh.taskTypeAtCap(task, wid, windows) {
+					continue
+				}
+
+				if sh.maintenanceConflict(task, wid) {
+					continue
+				}
+
+				local := false
+				if len(holding) > 0 {
+					wp, found := workerPaths[wid]
+					if !found {
+						wp = workerStorageIDs(task.Ctx, w)
+						workerPaths[wid] = wp
+					}
+
+					for id := range holding {
+						if _, ok := wp[id]; ok {
+							local = true
+							break
+						}
+					}
+				}
+
+				score := float64(workerAssigned[wid])
+				if local {
+					score -= affinityWeight
+				}
+				if score >= bestScore {
+					continue
+				}
+
+				info = w.Info
+				needRes = res
+				bestWid = wid
+				bestLocal = local
+				selectedWindow = wnd
+				bestScore = score
+			}
+
+			if selectedWindow < 0 {
+				// all windows full
+				recordNoWindow(sh, task)
+				continue
+			}
+
+			if !sh.assignLogSummary {
+				log.Debugw("SCHED ASSIGNED",
+					"assigner", "affinity",
+					"sqi", sqi,
+					"sector", task.Sector.ID.Number,
+					"task", task.TaskType,
+					"window", selectedWindow,
+					"worker", bestWid,
+					"local", bestLocal,
+					"score", bestScore)
+			}
+
+			workerAssigned[bestWid]++
+			windows[selectedWindow].Allocated.Add(task.SchedId, task.SealTask(), info.Resources, needRes)
+			windows[selectedWindow].Todo = append(windows[selectedWindow].Todo, task)
+
+			rmQueue = append(rmQueue, sqi)
+			scheduled++
+		}
+
+		if len(rmQueue) > 0 {
+			for i := len(rmQueue) - 1; i >= 0; i-- {
+				sh.SchedQueue.Remove(rmQueue[i])
+			}
+		}
+
+		return scheduled
+	}
+}
+
+// sectorStorageIDs returns the IDs of storage paths holding any of the task
+// sector's files
+func sectorStorageIDs(task *WorkerRequest, index paths.SectorIndex) map[storiface.ID]struct{} {
+	ssize, err := task.Sector.ProofType.SectorSize()
+	if err != nil {
+		log.Errorw("getting sector size", "sector", task.Sector.ID, "error", err)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(task.Ctx, SelectorTimeout)
+	defer cancel()
+
+	found, err := index.StorageFindSector(ctx, task.Sector.ID, affinityFileTypes, ssize, false)
+	if err != nil {
+		log.Errorw("finding sector storage", "sector", task.Sector.ID, "error", err)
+		return nil
+	}
+
+	out := make(map[storiface.ID]struct{}, len(found))
+	for _, info := range found {
+		out[info.ID] = struct{}{}
+	}
+	return out
+}
+
+// workerStorageIDs returns the IDs of storage paths local to the worker
+func workerStorageIDs(ctx context.Context, w *WorkerHandle) map[storiface.ID]struct{} {
+	ctx, cancel := context.WithTimeout(ctx, SelectorTimeout)
+	defer cancel()
+
+	wpaths, err := w.workerRpc.Paths(ctx)
+	if err != nil {
+		log.Errorw("getting worker paths", "worker", w.Info.Hostname, "error", err)
+		return nil
+	}
+
+	out := make(map[storiface.ID]struct{}, len(wpaths))
+	for _, p := range wpaths {
+		out[p.ID] = struct{}{}
+	}
+	return out
+}
@@ -0,0 +1,117 @@
+package sealer
+
+import (
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+
+	"github.com/filecoin-project/lotus/metrics"
+)
+
+// assigner invariant violations counted in the SchedAssignerViolations metric
+const (
+	// schedViolationCapacity is a task which doesn't fit in its window next to
+	// the tasks assigned to the window before it
+	schedViolationCapacity = "capacity"
+	// schedViolationInfeasible is a task assigned to a window which isn't one
+	// of its acceptable windows
+	schedViolationInfeasible = "infeasible"
+	// schedViolationDuplicate is a task assigned more than once in a pass
+	schedViolationDuplicate = "duplicate"
+	// schedViolationQueue is an assigned task left in the queue, or a task
+	// removed from the queue without being assigned
+	schedViolationQueue = "queue"
+)
+
+type assignViolation struct {
+	Task   *WorkerRequest
+	Window int // -1 for queue violations of unassigned tasks
+	Kind   string
+}
+
+// checkAssignment checks the result of a window selection pass against the
+// invariants every assigner must uphold. queued is the task queue as it was
+// before the pass, which acceptableWindows is indexed by.
+func checkAssignment(sh *Scheduler, queued []*WorkerRequest, acceptableWindows [][]int, windows []SchedWindow) []assignViolation {
+	var out []assignViolation
+
+	queueIndex := make(map[*WorkerRequest]int, len(queued))
+	for sqi, task := range queued {
+		queueIndex[task] = sqi
+	}
+
+	assigned := map[*WorkerRequest]int{}
+	for wnd, window := range windows {
+		if len(window.Todo) == 0 {
+			continue
+		}
+
+		wid := sh.OpenWindows[wnd].Worker
+		w := sh.Workers[wid]
+
+		// re-account the window from scratch, so that an assigner which
+		// tracked the wrong resources for a task doesn't hide it
+		alloc := NewActiveResources(newTaskCounter())
+
+		for _, task := range window.Todo {
+			assigned[task]++
+			if assigned[task] > 1 {
+				out = append(out, assignViolation{Task: task, Window: wnd, Kind: schedViolationDuplicate})
+				continue
+			}
+
+			sqi, ok := queueIndex[task]
+			if !ok || !containsWindow(acceptableWindows[sqi], wnd) {
+				out = append(out, assignViolation{Task: task, Window: wnd, Kind: schedViolationInfeasible})
+			}
+
+			if w == nil {
+				continue
+			}
+
+			res := sh.resourceSpec(w, task)
+			if !alloc.CanHandleRequest(task.SchedId, task.SealTask(), res, wid, "checkAssignment", w.Info) {
+				out = append(out, assignViolation{Task: task, Window: wnd, Kind: schedViolationCapacity})
+			}
+			alloc.Add(task.SchedId, task.SealTask(), w.Info.Resources, res)
+		}
+	}
+
+	inQueue := make(map[*WorkerRequest]struct{}, sh.SchedQueue.Len())
+	for _, task := range *sh.SchedQueue {
+		inQueue[task] = struct{}{}
+	}
+	for _, task := range queued {
+		if _, left := inQueue[task]; left == (assigned[task] > 0) {
+			out = append(out, assignViolation{Task: task, Window: -1, Kind: schedViolationQueue})
+		}
+	}
+
+	return out
+}
+
+func containsWindow(wnds []int, wnd int) bool {
+	for _, w := range wnds {
+		if w == wnd {
+			return true
+		}
+	}
+	return false
+}
+
+// recordViolations logs and counts assigner invariant violations found by
+// checkAssignment
+func recordViolations(sh *Scheduler, violations []assignViolation) {
+	for _, v := range violations {
+		log.Errorw("assigner broke an assignment invariant",
+			"violation", v.Kind,
+			"sector", v.Task.Sector.ID,
+			"task", v.Task.TaskType,
+			"window", v.Window)
+
+		ctx, _ := tag.New(sh.mctx,
+			tag.Upsert(metrics.TaskType, string(v.Task.TaskType)),
+			tag.Upsert(metrics.SchedViolation, v.Kind),
+		)
+		stats.Record(ctx, metrics.SchedAssignerViolations.M(1))
+	}
+}
@@ -0,0 +1,224 @@
+package sealer
+
+import (
+	"math"
+	"sort"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// NewColocateAssigner returns an assigner which places queued tasks of the same
+// sector together, in one window, when a window accepted by all of them has
+// resources for all of them. Sector groups which don't fit together, and tasks
+// of sectors with a single queued task, are spread like in the spread assigner.
+// All tasks of a sector are placed when its first task in queue order comes up.
+func NewColocateAssigner() Assigner {
+	return &AssignerCommon{
+		WindowSel: ColocateWS,
+	}
+}
+
+func ColocateWS(sh *Scheduler, queueLen int, acceptableWindows [][]int, windows []SchedWindow) int {
+	scheduled := 0
+	rmQueue := make([]int, 0, queueLen)
+	workerAssigned := map[storiface.WorkerID]int{}
+
+	// queue indexes of tasks of each sector, in queue order
+	sectorTasks := map[abi.SectorID][]int{}
+	for sqi := 0; sqi < queueLen; sqi++ {
+		sid := (*sh.SchedQueue)[sqi].Sector.ID
+		sectorTasks[sid] = append(sectorTasks[sid], sqi)
+	}
+
+	assign := func(sqi, wnd int, needRes storiface.Resources) {
+		task := (*sh.SchedQueue)[sqi]
+		wid := sh.OpenWindows[wnd].Worker
+
+		windows[wnd].Allocated.Add(task.SchedId, task.SealTask(), sh.Workers[wid].Info.Resources, needRes)
+		windows[wnd].Todo = append(windows[wnd].Todo, task)
+		workerAssigned[wid]++
+
+		rmQueue = append(rmQueue, sqi)
+		scheduled++
+	}
+
+	// tryGroup assigns all tasks of a sector to the least loaded window which
+	// all of them accept, and which can fit all of them
+	tryGroup := func(group []int) bool {
+		accepted := map[int]int{}
+		for _, sqi := range group {
+			for _, wnd := range acceptableWindows[(*sh.SchedQueue)[sqi].IndexHeap] {
+				accepted[wnd]++
+			}
+		}
+
+		selectedWindow := -1
+		var bestWid storiface.WorkerID
+		var selectedRes []storiface.Resources
+		bestAssigned := math.MaxInt // smaller = better
+
+		for _, wnd := range acceptableWindows[(*sh.SchedQueue)[group[0]].IndexHeap] {
+			if accepted[wnd] != len(group) {
+				continue
+			}
+
+			wid := sh.OpenWindows[wnd].Worker
+			w := sh.Workers[wid]
+
+			wu := workerAssigned[wid]
+			if wu > bestAssigned || (wu == bestAssigned && !spreadTieBreak(wid, wnd, bestWid, selectedWindow)) {
+				continue
+			}
+
+			if sh.maxWorkerTasks > 0 && sh.workerTaskCount(wid, windows)+len(group) > sh.maxWorkerTasks {
+				continue
+			}
+
+			// add the tasks one by one to check that they fit together, then
+			// undo, so that the window is left untouched until one is picked
+			var added []storiface.Resources
+			fits := true
+			for _, sqi := range group {
+				task := (*sh.SchedQueue)[sqi]
+				res := w.Info.Resources.ResourceSpec(task.Sector.ProofType, task.TaskType)
+
+				if !windows[wnd].Allocated.CanHandleRequest(task.SchedId, task.SealTask(), res, wid, "schedAssign", w.Info) {
+					fits = false
+					break
+				}
+
+				windows[wnd].Allocated.Add(task.SchedId, task.SealTask(), w.Info.Resources, res)
+				added = append(added, res)
+			}
+			for i, res := range added {
+				task := (*sh.SchedQueue)[group[i]]
+				windows[wnd].Allocated.Free(task.SchedId, task.SealTask(), w.Info.Resources, res)
+			}
+			if !fits {
+				continue
+			}
+
+			bestWid = wid
+			selectedWindow = wnd
+			selectedRes = added
+			bestAssigned = wu
+		}
+
+		if selectedWindow < 0 {
+			return false
+		}
+
+		if !sh.assignLogSummary {
+			log.Debugw("SCHED ASSIGNED",
+				"assigner", "colocate",
+				"sector", (*sh.SchedQueue)[group[0]].Sector.ID.Number,
+				"tasks", len(group),
+				"window", selectedWindow,
+				"worker", bestWid,
+				"assigned", bestAssigned)
+		}
+
+		for i, sqi := range group {
+			assign(sqi, selectedWindow, selectedRes[i])
+		}
+		return true
+	}
+
+	// tryOne assigns a single task like SpreadWS does
+	tryOne := func(sqi int) {
+		task := (*sh.SchedQueue)[sqi]
+
+		selectedWindow := -1
+		var needRes storiface.Resources
+		var bestWid storiface.WorkerID
+		bestAssigned := math.MaxInt // smaller = better
+
+		for i, wnd := range acceptableWindows[task.IndexHeap] {
+			wid := sh.OpenWindows[wnd].Worker
+			w := sh.Workers[wid]
+
+			res := w.Info.Resources.ResourceSpec(task.Sector.ProofType, task.TaskType)
+
+			if !sh.assignLogSummary {
+				log.Debugf("SCHED try assign sqi:%d sector %d to window %d (awi:%d)", sqi, task.Sector.ID.Number, wnd, i)
+			}
+
+			if !windows[wnd].Allocated.CanHandleRequest(task.SchedId, task.SealTask(), res, wid, "schedAssign", w.Info) {
+				continue
+			}
+
+			if sh.workerAtCap(wid, windows) {
+				continue
+			}
+
+			if sh.antiAffinityConflict(task, wid, windows) {
+				continue
+			}
+
+			if sh.taskTypeAtCap(task, wid, windows) {
+				continue
+			}
+
+			if sh.maintenanceConflict(task, wid) {
+				continue
+			}
+
+			wu := workerAssigned[wid]
+			if wu > bestAssigned || (wu == bestAssigned && !spreadTieBreak(wid, wnd, bestWid, selectedWindow)) {
+				continue
+			}
+
+			needRes = res
+			bestWid = wid
+			selectedWindow = wnd
+			bestAssigned = wu
+		}
+
+		if selectedWindow < 0 {
+			// all windows full
+			recordNoWindow(sh, task)
+			return
+		}
+
+		if !sh.assignLogSummary {
+			log.Debugw("SCHED ASSIGNED",
+				"assigner", "colocate",
+				"sqi", sqi,
+				"sector", task.Sector.ID.Number,
+				"task", task.TaskType,
+				"window", selectedWindow,
+				"worker", bestWid,
+				"assigned", bestAssigned)
+		}
+
+		assign(sqi, selectedWindow, needRes)
+	}
+
+	for sqi := 0; sqi < queueLen; sqi++ {
+		group := sectorTasks[(*sh.SchedQueue)[sqi].Sector.ID]
+		if group[0] != sqi {
+			// handled with the first task of the sector
+			continue
+		}
+
+		if len(group) > 1 && tryGroup(group) {
+			continue
+		}
+
+		for _, gsqi := range group {
+			tryOne(gsqi)
+		}
+	}
+
+	if len(rmQueue) > 0 {
+		// tasks of a group are assigned out of queue order
+		sort.Ints(rmQueue)
+		for i := len(rmQueue) - 1; i >= 0; i-- {
+			sh.SchedQueue.Remove(rmQueue[i])
+		}
+	}
+
+	return scheduled
+}
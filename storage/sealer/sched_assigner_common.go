@@ -7,16 +7,32 @@ import (
 	"sync"
 
 	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"go.uber.org/zap/zapcore"
 
 	"github.com/filecoin-project/lotus/metrics"
+	"github.com/filecoin-project/lotus/storage/sealer/sealtasks"
 	"github.com/filecoin-project/lotus/storage/sealer/storiface"
 )
 
+// WindowSelector assigns queued tasks to windows. sh.SchedQueue is kept sorted
+// with RequestQueue.Less, so visiting it in index order gives higher priority
+// tasks the first pick of windows; selectors which reorder tasks within a pass
+// should keep that order among tasks they treat as equal.
 type WindowSelector func(sh *Scheduler, queueLen int, acceptableWindows [][]int, windows []SchedWindow) int
 
+// AdmissionFunc decides whether a task may be assigned to a worker, on top of
+// the scheduler's resource and policy checks. It's called concurrently for
+// different tasks, so it must be safe for concurrent use.
+type AdmissionFunc func(task *WorkerRequest, wid storiface.WorkerID, info storiface.WorkerInfo) bool
+
 // AssignerCommon is a task assigner with customizable parts
 type AssignerCommon struct {
 	WindowSel WindowSelector
+
+	// Admit, if set, can veto assigning a task to a worker; rejected windows
+	// aren't considered for the task in the scheduling pass
+	Admit AdmissionFunc
 }
 
 var _ Assigner = &AssignerCommon{}
@@ -47,12 +63,16 @@ func (a *AssignerCommon) TrySched(sh *Scheduler) {
 	queueLen := sh.SchedQueue.Len()
 
 	stats.Record(sh.mctx, metrics.SchedCycleOpenWindows.M(int64(windowsLen)))
-	stats.Record(sh.mctx, metrics.SchedCycleQueueSize.M(int64(queueLen)))
+	stats.Record(sh.mctx, metrics.SchedCycleQueueSize.M(int64(queueLen)), metrics.SchedQueueLength.M(int64(queueLen)))
 
 	log.Debugf("SCHED %d queued; %d open windows", queueLen, windowsLen)
 
+	markStarving(sh)
+
 	if windowsLen == 0 || queueLen == 0 {
 		// nothing to schedule on
+		finishSchedTrace(sh, newSchedTrace(sh, queueLen), make([][]int, queueLen), nil)
+		recordUnmetDemand(sh, unmetDemand(*sh.SchedQueue, nil))
 		return
 	}
 
@@ -60,7 +80,10 @@ func (a *AssignerCommon) TrySched(sh *Scheduler) {
 	for i := range windows {
 		windows[i].Allocated = *NewActiveResources(newTaskCounter())
 	}
+	pins := sh.pins.snapshot()
 	acceptableWindows := make([][]int, queueLen) // QueueIndex -> []OpenWindowIndex
+	rejections := make([]map[string]int, queueLen)
+	trace := newSchedTrace(sh, queueLen)
 
 	// Step 1
 	throttle := make(chan struct{}, windowsLen)
@@ -84,6 +107,15 @@ func (a *AssignerCommon) TrySched(sh *Scheduler) {
 
 			task := (*sh.SchedQueue)[sqi]
 			task.IndexHeap = sqi
+			task.windowPrefs = nil
+
+			var tr *SchedTraceTask
+			if trace != nil {
+				tr = &trace[sqi]
+			}
+
+			rejected := map[string]int{}
+			rejections[sqi] = rejected
 
 			var havePreferred bool
 
@@ -95,15 +127,53 @@ func (a *AssignerCommon) TrySched(sh *Scheduler) {
 					continue
 				}
 
+				if pinned, ok := pins[task.Sector.ID]; ok && pinned != windowRequest.Worker {
+					log.Debugw("skipping worker, sector pinned elsewhere", "worker", windowRequest.Worker, "sector", task.Sector.ID, "pinned", pinned)
+					tr.reject(wnd, windowRequest.Worker, SchedRejectPolicy)
+					rejected[schedRejectPolicy]++
+					continue
+				}
+
+				if sh.heldBack(task, windowRequest.Worker) {
+					log.Debugw("skipping worker reserved for a starving task", "worker", windowRequest.Worker, "sector", task.Sector.ID)
+					tr.reject(wnd, windowRequest.Worker, SchedRejectPolicy)
+					rejected[schedRejectPolicy]++
+					continue
+				}
+
 				if !worker.Enabled {
 					log.Debugw("skipping disabled worker", "worker", windowRequest.Worker)
+					tr.reject(wnd, windowRequest.Worker, SchedRejectPolicy)
+					rejected[schedRejectPolicy]++
 					continue
 				}
 
-				needRes := worker.Info.Resources.ResourceSpec(task.Sector.ProofType, task.TaskType)
+				if worker.Draining {
+					log.Debugw("skipping draining worker", "worker", windowRequest.Worker)
+					tr.reject(wnd, windowRequest.Worker, SchedRejectPolicy)
+					rejected[schedRejectPolicy]++
+					continue
+				}
+
+				if a.Admit != nil && !a.Admit(task, windowRequest.Worker, worker.Info) {
+					log.Debugw("worker not admitted", "worker", windowRequest.Worker, "task", task.TaskType)
+					tr.reject(wnd, windowRequest.Worker, SchedRejectPolicy)
+					rejected[schedRejectPolicy]++
+					continue
+				}
+
+				if sh.workerAtCap(windowRequest.Worker, windows) {
+					tr.reject(wnd, windowRequest.Worker, SchedRejectCap)
+					rejected[schedRejectCap]++
+					continue
+				}
+
+				needRes := sh.resourceSpec(worker, task)
 
 				// TODO: allow bigger windows
-				if !windows[wnd].Allocated.CanHandleRequest(task.SchedId, task.SealTask(), needRes, windowRequest.Worker, "schedAcceptable", worker.Info) {
+				if reason := windows[wnd].Allocated.shortage(task.SchedId, task.SealTask(), needRes, windowRequest.Worker, "schedAcceptable", worker.Info); reason != "" {
+					tr.reject(wnd, windowRequest.Worker, SchedRejectResources)
+					rejected[reason]++
 					continue
 				}
 
@@ -112,20 +182,40 @@ func (a *AssignerCommon) TrySched(sh *Scheduler) {
 				cancel()
 				if err != nil {
 					log.Errorf("trySched(1) req.Sel.Ok error: %+v", err)
+					tr.reject(wnd, windowRequest.Worker, SchedRejectPolicy)
+					rejected[schedRejectPolicy]++
+					continue
+				}
+
+				if !ok {
+					tr.reject(wnd, windowRequest.Worker, SchedRejectPolicy)
+					rejected[schedRejectPolicy]++
 					continue
 				}
 
+				rpcCtx, cancel = context.WithTimeout(task.Ctx, SelectorTimeout)
+				ok = sh.hasSealingSpace(rpcCtx, task, worker)
+				cancel()
 				if !ok {
+					log.Debugw("skipping worker without sealing space", "worker", windowRequest.Worker, "task", task.TaskType)
+					tr.reject(wnd, windowRequest.Worker, SchedRejectStorage)
+					rejected[schedRejectStorage]++
 					continue
 				}
 
 				if havePreferred && !preferred {
 					// we have a way better worker for this task
+					tr.reject(wnd, windowRequest.Worker, SchedRejectPolicy)
+					rejected[schedRejectPolicy]++
 					continue
 				}
 
 				if preferred && !havePreferred {
 					// all workers we considered previously are much worse choice
+					for _, pwnd := range acceptableWindows[sqi] {
+						tr.reject(pwnd, sh.OpenWindows[pwnd].Worker, SchedRejectPolicy)
+					}
+					rejected[schedRejectPolicy] += len(acceptableWindows[sqi])
 					acceptableWindows[sqi] = acceptableWindows[sqi][:0]
 					havePreferred = true
 				}
@@ -162,18 +252,62 @@ func (a *AssignerCommon) TrySched(sh *Scheduler) {
 				}
 				return r
 			})
+
+			// remember which windows the selector prefers equally, so that
+			// assigners can order those without losing its preference
+			prefs := make(map[int]int, len(acceptableWindows[sqi]))
+			for i, wnd := range acceptableWindows[sqi] {
+				if i == 0 {
+					prefs[wnd] = 0
+					continue
+				}
+
+				prev := acceptableWindows[sqi][i-1]
+				prefs[wnd] = prefs[prev]
+
+				wpi := sh.OpenWindows[prev].Worker
+				wi := sh.OpenWindows[wnd].Worker
+				if wpi == wi {
+					continue
+				}
+
+				wp, _ := cachedWorkers.Get(wpi)
+				w, _ := cachedWorkers.Get(wi)
+
+				rpcCtx, cancel := context.WithTimeout(task.Ctx, SelectorTimeout)
+				better, err := task.Sel.Cmp(rpcCtx, task.TaskType, wp, w)
+				cancel()
+				if err != nil {
+					log.Errorf("selecting best worker: %s", err)
+				}
+				if better {
+					prefs[wnd]++
+				}
+			}
+			task.windowPrefs = prefs
 		}(i)
 	}
 
 	wg.Wait()
 
+	recordRejections(sh, rejections)
+
 	log.Debugf("SCHED Acceptable win: %+v", acceptableWindows)
 
 	// Step 2
 	partDone()
 	partDone = metrics.Timer(sh.mctx, metrics.SchedAssignerWindowSelectionDuration)
 
+	queued := append([]*WorkerRequest(nil), (*sh.SchedQueue)...)
 	scheduled := a.WindowSel(sh, queueLen, acceptableWindows, windows)
+	recordViolations(sh, checkAssignment(sh, queued, acceptableWindows, windows))
+	recordUnmetDemand(sh, unmetDemand(queued, windows))
+	reserveStarving(sh, queued, windows)
+	finishSchedTrace(sh, trace, acceptableWindows, windows)
+
+	if sh.assignLogSummary {
+		logAssignSummary(sh, queueLen, scheduled, windows)
+	}
 
 	// Step 3
 	partDone()
@@ -183,6 +317,11 @@ func (a *AssignerCommon) TrySched(sh *Scheduler) {
 		return
 	}
 
+	var hints *pathHints
+	if sh.pathIndex != nil {
+		hints = newPathHints(sh, cachedWorkers)
+	}
+
 	scheduledWindows := map[int]struct{}{}
 	for wnd, window := range windows {
 		if len(window.Todo) == 0 {
@@ -192,6 +331,17 @@ func (a *AssignerCommon) TrySched(sh *Scheduler) {
 
 		scheduledWindows[wnd] = struct{}{}
 
+		hostname := sh.OpenWindows[wnd].Worker.String()
+		if w, ok := sh.Workers[sh.OpenWindows[wnd].Worker]; ok {
+			hostname = w.Info.Hostname
+		}
+		for _, task := range window.Todo {
+			if hints != nil {
+				task.PathHint = hints.hint(task, sh.OpenWindows[wnd].Worker)
+			}
+			recordAssigned(sh, task, hostname)
+		}
+
 		window := window // copy
 		select {
 		case sh.OpenWindows[wnd].Done <- &window:
@@ -213,3 +363,127 @@ func (a *AssignerCommon) TrySched(sh *Scheduler) {
 
 	sh.OpenWindows = newOpenWindows
 }
+
+// workerAtCap reports whether a worker holds sh.maxWorkerTasks tasks
+func (sh *Scheduler) workerAtCap(wid storiface.WorkerID, windows []SchedWindow) bool {
+	if sh.maxWorkerTasks <= 0 {
+		return false
+	}
+
+	return sh.workerTaskCount(wid, windows) >= sh.maxWorkerTasks
+}
+
+// workerTaskCount counts tasks a worker runs, prepares or has in scheduled
+// windows, and tasks assigned to it in the current scheduling pass
+func (sh *Scheduler) workerTaskCount(wid storiface.WorkerID, windows []SchedWindow) int {
+	w, ok := sh.Workers[wid]
+	if !ok {
+		return 0
+	}
+
+	n := w.TaskCounts()
+	for wnd := range windows {
+		if sh.OpenWindows[wnd].Worker == wid {
+			n += len(windows[wnd].Todo)
+		}
+	}
+
+	return n
+}
+
+// recordAssigned records a task assignment to a worker window
+func recordAssigned(sh *Scheduler, task *WorkerRequest, hostname string) {
+	ctx, _ := tag.New(sh.mctx,
+		tag.Upsert(metrics.TaskType, string(task.TaskType)),
+		tag.Upsert(metrics.WorkerHostname, hostname),
+	)
+	stats.Record(ctx, metrics.SchedAssignedTasks.M(1))
+}
+
+// recordNoWindow records a task being skipped in a scheduling pass because none
+// of the acceptable windows had resources left for it
+func recordNoWindow(sh *Scheduler, task *WorkerRequest) {
+	task.skipped++
+
+	ctx, _ := tag.New(sh.mctx, tag.Upsert(metrics.TaskType, string(task.TaskType)))
+	stats.Record(ctx, metrics.SchedNoWindowSkips.M(1))
+}
+
+// window rejection reasons counted in the SchedWindowRejections metric
+const (
+	schedRejectCPU     = "cpu"
+	schedRejectGPU     = "gpu"
+	schedRejectRAM     = "ram"
+	schedRejectStorage = "storage"
+	schedRejectCap     = "cap"
+	// schedRejectPolicy covers disabled and draining workers, selector
+	// decisions and per-worker task type limits
+	schedRejectPolicy = "policy"
+)
+
+// recordRejections records the number of windows rejected for each task of a
+// scheduling pass when finding acceptable windows, by reason
+func recordRejections(sh *Scheduler, rejections []map[string]int) {
+	for sqi, rejected := range rejections {
+		task := (*sh.SchedQueue)[sqi]
+		for reason, n := range rejected {
+			ctx, _ := tag.New(sh.mctx,
+				tag.Upsert(metrics.TaskType, string(task.TaskType)),
+				tag.Upsert(metrics.SchedRejectReason, reason),
+			)
+			stats.Record(ctx, metrics.SchedWindowRejections.M(int64(n)))
+		}
+	}
+}
+
+// markStarving flags tasks which were skipped in too many scheduling passes
+// as starving, moving them to the front of the queue (after tasks which are
+// always scheduled first), so they get the first pick of windows from now on.
+func markStarving(sh *Scheduler) {
+	var changed bool
+	for _, task := range *sh.SchedQueue {
+		if task.starving || task.skipped < StarvationSkips {
+			continue
+		}
+
+		log.Warnw("task starving, scheduling it ahead of other tasks",
+			"sector", task.Sector.ID,
+			"task", task.TaskType,
+			"priority", task.Priority,
+			"skipped", task.skipped)
+
+		task.starving = true
+		changed = true
+	}
+
+	if changed {
+		sort.Sort(sh.SchedQueue)
+	}
+}
+
+// logAssignSummary logs the outcome of a scheduling pass, replacing per-task
+// logs in summary logging mode
+func logAssignSummary(sh *Scheduler, queueLen, scheduled int, windows []SchedWindow) {
+	if !log.Desugar().Core().Enabled(zapcore.DebugLevel) {
+		return
+	}
+
+	tasks := map[sealtasks.TaskType]int{}
+	workers := map[storiface.WorkerID]struct{}{}
+	for wnd, window := range windows {
+		for _, task := range window.Todo {
+			tasks[task.TaskType]++
+		}
+		if len(window.Todo) > 0 {
+			workers[sh.OpenWindows[wnd].Worker] = struct{}{}
+		}
+	}
+
+	log.Debugw("SCHED pass",
+		"queued", queueLen,
+		"windows", len(windows),
+		"scheduled", scheduled,
+		"skipped", queueLen-scheduled,
+		"workers", len(workers),
+		"tasks", tasks)
+}
@@ -0,0 +1,173 @@
+package sealer
+
+import (
+	"context"
+	"math"
+
+	"github.com/filecoin-project/lotus/storage/paths"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// TransferCostFunc estimates the cost of running the task on a worker with the
+// given local storage paths, typically the amount of sector data which would
+// have to be fetched from other machines. Lower is better.
+type TransferCostFunc func(ctx context.Context, task *WorkerRequest, workerPaths map[storiface.ID]struct{}) float64
+
+// NewCostAwareAssigner returns an assigner which places each task in the
+// feasible window with the lowest transfer cost, as estimated by costFn,
+// breaking ties by the number of tasks assigned to the worker in the current
+// scheduling pass.
+func NewCostAwareAssigner(costFn TransferCostFunc) Assigner {
+	return &AssignerCommon{
+		WindowSel: CostAwareWS(costFn),
+	}
+}
+
+// TransferBytesCost returns a cost function which counts the bytes of sector
+// files which are stored in the index, but not on any of the worker's paths.
+func TransferBytesCost(index paths.SectorIndex) TransferCostFunc {
+	return func(ctx context.Context, task *WorkerRequest, workerPaths map[storiface.ID]struct{}) float64 {
+		ssize, err := task.Sector.ProofType.SectorSize()
+		if err != nil {
+			log.Errorw("getting sector size", "sector", task.Sector.ID, "error", err)
+			return 0
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, SelectorTimeout)
+		defer cancel()
+
+		var cost float64
+		for _, ft := range affinityFileTypes.AllSet() {
+			found, err := index.StorageFindSector(ctx, task.Sector.ID, ft, ssize, false)
+			if err != nil {
+				log.Errorw("finding sector storage", "sector", task.Sector.ID, "type", ft, "error", err)
+				continue
+			}
+			if len(found) == 0 {
+				continue
+			}
+
+			local := false
+			for _, info := range found {
+				if _, ok := workerPaths[info.ID]; ok {
+					local = true
+					break
+				}
+			}
+			if local {
+				continue
+			}
+
+			size, err := ft.StoreSpaceUse(ssize)
+			if err != nil {
+				log.Errorw("getting file size", "sector", task.Sector.ID, "type", ft, "error", err)
+				continue
+			}
+			cost += float64(size)
+		}
+
+		return cost
+	}
+}
+
+func CostAwareWS(costFn TransferCostFunc) func(sh *Scheduler, queueLen int, acceptableWindows [][]int, windows []SchedWindow) int {
+	return func(sh *Scheduler, queueLen int, acceptableWindows [][]int, windows []SchedWindow) int {
+		scheduled := 0
+		rmQueue := make([]int, 0, queueLen)
+		workerAssigned := map[storiface.WorkerID]int{}
+		workerPaths := map[storiface.WorkerID]map[storiface.ID]struct{}{}
+
+		for sqi := 0; sqi < queueLen; sqi++ {
+			task := (*sh.SchedQueue)[sqi]
+
+			selectedWindow := -1
+			var needRes storiface.Resources
+			var info storiface.WorkerInfo
+			var bestWid storiface.WorkerID
+			bestCost := math.MaxFloat64 // smaller = better
+			bestAssigned := 0
+
+			for i, wnd := range acceptableWindows[task.IndexHeap] {
+				wid := sh.OpenWindows[wnd].Worker
+				w := sh.Workers[wid]
+
+				res := w.Info.Resources.ResourceSpec(task.Sector.ProofType, task.TaskType)
+
+				if !sh.assignLogSummary {
+					log.Debugf("SCHED try assign sqi:%d sector %d to window %d (awi:%d)", sqi, task.Sector.ID.Number, wnd, i)
+				}
+
+				if !windows[wnd].Allocated.CanHandleRequest(task.SchedId, task.SealTask(), res, wid, "schedAssign", w.Info) {
+					continue
+				}
+
+				if sh.workerAtCap(wid, windows) {
+					continue
+				}
+
+				if sh.antiAffinityConflict(task, wid, windows) {
+					continue
+				}
+
+				if sh.taskTypeAtCap(task, wid, windows) {
+					continue
+				}
+
+				if sh.maintenanceConflict(task, wid) {
+					continue
+				}
+
+				wp, found := workerPaths[wid]
+				if !found {
+					wp = workerStorageIDs(task.Ctx, w)
+					workerPaths[wid] = wp
+				}
+
+				cost := costFn(task.Ctx, task, wp)
+				assigned := workerAssigned[wid]
+				if cost > bestCost || (cost == bestCost && assigned >= bestAssigned) {
+					continue
+				}
+
+				info = w.Info
+				needRes = res
+				bestWid = wid
+				selectedWindow = wnd
+				bestCost = cost
+				bestAssigned = assigned
+			}
+
+			if selectedWindow < 0 {
+				// all windows full
+				recordNoWindow(sh, task)
+				continue
+			}
+
+			if !sh.assignLogSummary {
+				log.Debugw("SCHED ASSIGNED",
+					"assigner", "cost-aware",
+					"sqi", sqi,
+					"sector", task.Sector.ID.Number,
+					"task", task.TaskType,
+					"window", selectedWindow,
+					"worker", bestWid,
+					"cost", bestCost)
+			}
+
+			workerAssigned[bestWid]++
+			windows[selectedWindow].Allocated.Add(task.SchedId, task.SealTask(), info.Resources, needRes)
+			windows[selectedWindow].Todo = append(windows[selectedWindow].Todo, task)
+
+			rmQueue = append(rmQueue, sqi)
+			scheduled++
+		}
+
+		if len(rmQueue) > 0 {
+			for i := len(rmQueue) - 1; i >= 0; i-- {
+				sh.SchedQueue.Remove(rmQueue[i])
+			}
+		}
+
+		return scheduled
+	}
+}
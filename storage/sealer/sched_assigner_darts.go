@@ -35,12 +35,30 @@ func RandomWS(sh *Scheduler, queueLen int, acceptableWindows [][]int, windows []
 
 			res := w.Info.Resources.ResourceSpec(task.Sector.ProofType, task.TaskType)
 
-			log.Debugf("SCHED try assign sqi:%d sector %d to window %d (awi:%d)", sqi, task.Sector.ID.Number, wnd, i)
+			if !sh.assignLogSummary {
+				log.Debugf("SCHED try assign sqi:%d sector %d to window %d (awi:%d)", sqi, task.Sector.ID.Number, wnd, i)
+			}
 
 			if !windows[wnd].Allocated.CanHandleRequest(task.SchedId, task.SealTask(), res, wid, "schedAssign", w.Info) {
 				continue
 			}
 
+			if sh.workerAtCap(wid, windows) {
+				continue
+			}
+
+			if sh.antiAffinityConflict(task, wid, windows) {
+				continue
+			}
+
+			if sh.taskTypeAtCap(task, wid, windows) {
+				continue
+			}
+
+			if sh.maintenanceConflict(task, wid) {
+				continue
+			}
+
 			choices = append(choices, choice{
 				selectedWindow: wnd,
 				needRes:        res,
@@ -52,6 +70,7 @@ func RandomWS(sh *Scheduler, queueLen int, acceptableWindows [][]int, windows []
 
 		if len(choices) == 0 {
 			// all windows full
+			recordNoWindow(sh, task)
 			continue
 		}
 
@@ -62,14 +81,16 @@ func RandomWS(sh *Scheduler, queueLen int, acceptableWindows [][]int, windows []
 		info := choices[randIndex].info
 		bestWid := choices[randIndex].bestWid
 
-		log.Debugw("SCHED ASSIGNED",
-			"assigner", "darts",
-			"sqi", sqi,
-			"sector", task.Sector.ID.Number,
-			"task", task.TaskType,
-			"window", selectedWindow,
-			"worker", bestWid,
-			"choices", len(choices))
+		if !sh.assignLogSummary {
+			log.Debugw("SCHED ASSIGNED",
+				"assigner", "darts",
+				"sqi", sqi,
+				"sector", task.Sector.ID.Number,
+				"task", task.TaskType,
+				"window", selectedWindow,
+				"worker", bestWid,
+				"choices", len(choices))
+		}
 
 		windows[selectedWindow].Allocated.Add(task.SchedId, task.SealTask(), info.Resources, needRes)
 		windows[selectedWindow].Todo = append(windows[selectedWindow].Todo, task)
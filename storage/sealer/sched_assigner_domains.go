@@ -0,0 +1,165 @@
+package sealer
+
+import (
+	"math"
+
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// NewDomainSpreadAssigner is like NewSpreadAssigner, but it balances tasks
+// across worker failure domains (see workerDomain) before balancing them
+// across workers within a domain.
+func NewDomainSpreadAssigner(queued bool) Assigner {
+	return &AssignerCommon{
+		WindowSel: DomainSpreadWS(queued),
+	}
+}
+
+// DomainSpreadWS assigns each task to the acceptable worker in the failure
+// domain with the fewest tasks assigned in the current scheduling pass, and
+// within that domain to the worker with the fewest tasks, divided by the worker
+// weight. With queued set, tasks workers already accepted in earlier passes are
+// counted too, for both domains and workers.
+//
+// Domains are balanced by task count, regardless of how many workers they
+// have, so that losing any one domain stalls about the same amount of work.
+func DomainSpreadWS(queued bool) func(sh *Scheduler, queueLen int, acceptableWindows [][]int, windows []SchedWindow) int {
+	return func(sh *Scheduler, queueLen int, acceptableWindows [][]int, windows []SchedWindow) int {
+		scheduled := 0
+		rmQueue := make([]int, 0, queueLen)
+		workerAssigned := map[storiface.WorkerID]int{}
+		domainAssigned := map[string]int{}
+
+		rank := spreadWindowRanks(sh)
+
+		for sqi := 0; sqi < queueLen; sqi++ {
+			task := (*sh.SchedQueue)[sqi]
+
+			aw := spreadScanOrder(task, acceptableWindows[task.IndexHeap], rank)
+
+			selectedWindow := -1
+			var needRes storiface.Resources
+			var info storiface.WorkerInfo
+			var bestWid storiface.WorkerID
+			var bestDomain string
+			bestDomainLoad := math.MaxInt // smaller = better, takes precedence over bestLoad
+			bestLoad := math.MaxFloat64   // smaller = better
+
+			for i, wnd := range aw {
+				wid := sh.OpenWindows[wnd].Worker
+				w := sh.Workers[wid]
+
+				res := sh.resourceSpec(w, task)
+
+				if !sh.assignLogSummary {
+					log.Debugf("SCHED try assign sqi:%d sector %d to window %d (awi:%d)", sqi, task.Sector.ID.Number, wnd, i)
+				}
+
+				if !sh.windowHasRoom(task, &windows[wnd], res, wid, w.Info) {
+					continue
+				}
+
+				if sh.workerAtCap(wid, windows) {
+					continue
+				}
+
+				if sh.antiAffinityConflict(task, wid, windows) {
+					continue
+				}
+
+				if sh.taskTypeAtCap(task, wid, windows) {
+					continue
+				}
+
+				if sh.maintenanceConflict(task, wid) {
+					continue
+				}
+
+				domain := sh.workerDomain(w)
+				du, found := domainAssigned[domain]
+				if !found && queued {
+					du = sh.domainTaskCounts(domain)
+					domainAssigned[domain] = du
+				}
+
+				wu, found := workerAssigned[wid]
+				if !found && queued {
+					wu = w.TaskCounts()
+					workerAssigned[wid] = wu
+				}
+
+				load := float64(wu) / sh.workerWeight(w)
+
+				if du > bestDomainLoad || (du == bestDomainLoad && load >= bestLoad) {
+					// windows are scanned in spreadScanOrder, so earlier ones win ties
+					continue
+				}
+
+				info = w.Info
+				needRes = res
+				bestWid = wid
+				bestDomain = domain
+				selectedWindow = wnd
+				bestDomainLoad = du
+				bestLoad = load
+			}
+
+			if selectedWindow < 0 {
+				// all windows full
+				recordNoWindow(sh, task)
+				continue
+			}
+
+			if !sh.assignLogSummary {
+				log.Debugw("SCHED ASSIGNED",
+					"assigner", "spread-domains",
+					"spread-queued", queued,
+					"sqi", sqi,
+					"sector", task.Sector.ID.Number,
+					"task", task.TaskType,
+					"window", selectedWindow,
+					"worker", bestWid,
+					"domain", bestDomain,
+					"domain-load", bestDomainLoad,
+					"load", bestLoad)
+			}
+
+			workerAssigned[bestWid]++
+			domainAssigned[bestDomain]++
+			windows[selectedWindow].Allocated.Add(task.SchedId, task.SealTask(), info.Resources, needRes)
+			windows[selectedWindow].Todo = append(windows[selectedWindow].Todo, task)
+
+			rmQueue = append(rmQueue, sqi)
+			scheduled++
+		}
+
+		if len(rmQueue) > 0 {
+			for i := len(rmQueue) - 1; i >= 0; i-- {
+				sh.SchedQueue.Remove(rmQueue[i])
+			}
+		}
+
+		return scheduled
+	}
+}
+
+// workerDomain returns the failure domain label of a worker set in
+// sh.workerDomains. Workers without a label are in a domain of their host.
+func (sh *Scheduler) workerDomain(w *WorkerHandle) string {
+	if d, ok := sh.workerDomains[w.Info.Hostname]; ok && d != "" {
+		return d
+	}
+	return "host:" + w.Info.Hostname
+}
+
+// domainTaskCounts returns the number of tasks accepted by workers of the
+// failure domain in earlier scheduling passes
+func (sh *Scheduler) domainTaskCounts(domain string) int {
+	var n int
+	for _, w := range sh.Workers {
+		if sh.workerDomain(w) == domain {
+			n += w.TaskCounts()
+		}
+	}
+	return n
+}
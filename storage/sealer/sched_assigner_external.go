@@ -0,0 +1,302 @@
+package sealer
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"golang.org/x/xerrors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/storage/sealer/sealtasks"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// ExternalAssignerName is the name of the external assigner in the sealer
+// config, see NewExternalAssigner
+const ExternalAssignerName = "experiment-external"
+
+// externalAssignTimeout bounds the placement call, which holds up the
+// scheduling pass
+const externalAssignTimeout = 5 * time.Second
+
+// externalPlacementMethod is the full gRPC method name of the placement call
+const externalPlacementMethod = "/lotus.sealer.v1.Placement/Assign"
+
+// jsonCodec encodes gRPC messages as JSON, so that placement services can be
+// written without shared .proto files. Placement calls are sent with the
+// "json" content subtype (application/grpc+json). The codec isn't registered
+// globally, so that it doesn't change the encoding of other gRPC services in
+// the process; it's set on each placement call, and on Go placement servers
+// with ExternalPlacementServerCodec.
+type jsonCodec struct{}
+
+var _ encoding.Codec = jsonCodec{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+// ExternalAssignRequest lists the queued tasks of a scheduling pass and the
+// open windows they can be assigned to
+type ExternalAssignRequest struct {
+	Tasks   []ExternalTask
+	Windows []ExternalWindow
+}
+
+// ExternalTask is a queued task. Candidates are the indexes of the windows
+// which can run the task, most preferred first.
+type ExternalTask struct {
+	Index      int
+	Sector     abi.SectorID
+	TaskType   sealtasks.TaskType
+	Priority   int
+	Candidates []int
+}
+
+// ExternalWindow is an open scheduling window of a worker. TaskCounts is the
+// number of tasks the worker already accepted.
+type ExternalWindow struct {
+	Index      int
+	Worker     storiface.WorkerID
+	Hostname   string
+	TaskCounts int
+}
+
+// ExternalAssignResponse lists the chosen assignments. Tasks which aren't
+// assigned stay queued for the next scheduling pass.
+type ExternalAssignResponse struct {
+	Assignments []ExternalAssignment
+}
+
+// ExternalAssignment assigns the task with index Task to the window with index
+// Window
+type ExternalAssignment struct {
+	Task   int
+	Window int
+}
+
+// ExternalPlacementServer is implemented by placement services
+type ExternalPlacementServer interface {
+	Assign(context.Context, *ExternalAssignRequest) (*ExternalAssignResponse, error)
+}
+
+// ExternalPlacementServerCodec returns the server option making a gRPC server
+// decode placement calls, see RegisterExternalPlacementServer. The server
+// should only serve the placement service, the option applies to all of its
+// services.
+func ExternalPlacementServerCodec() grpc.ServerOption {
+	return grpc.ForceServerCodec(jsonCodec{})
+}
+
+// RegisterExternalPlacementServer registers a placement service with a gRPC
+// server created with ExternalPlacementServerCodec, for placement services
+// written in Go
+func RegisterExternalPlacementServer(s *grpc.Server, srv ExternalPlacementServer) {
+	s.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "lotus.sealer.v1.Placement",
+		HandlerType: (*ExternalPlacementServer)(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "Assign",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				req := new(ExternalAssignRequest)
+				if err := dec(req); err != nil {
+					return nil, err
+				}
+				if interceptor == nil {
+					return srv.(ExternalPlacementServer).Assign(ctx, req)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: externalPlacementMethod}
+				return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+					return srv.(ExternalPlacementServer).Assign(ctx, req.(*ExternalAssignRequest))
+				})
+			},
+		}},
+	}, srv)
+}
+
+// NewExternalAssigner returns an assigner which defers task placement to a
+// placement service at the given gRPC endpoint. In each scheduling pass the
+// service gets the queued tasks with their candidate windows, and returns the
+// assignments to make. Assignments which the scheduler finds infeasible, e.g.
+// because the worker doesn't have the resources, they are reserved for other
+// task types, or the worker is at a configured limit, are skipped. Like with
+// the "spread" assigner, each GPU of a worker takes at most one GPU task per
+// pass. When the service can't be reached, or no endpoint is set, tasks
+// are assigned like with the "spread" assigner.
+func NewExternalAssigner(endpoint string) (Assigner, error) {
+	var conn *grpc.ClientConn
+	if endpoint != "" {
+		var err error
+		conn, err = grpc.Dial(endpoint,
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithDefaultCallOptions(grpc.ForceCodec(jsonCodec{})))
+		if err != nil {
+			return nil, xerrors.Errorf("connecting to placement service %s: %w", endpoint, err)
+		}
+	}
+
+	return &AssignerCommon{
+		WindowSel: ExternalWS(conn),
+	}, nil
+}
+
+// ExternalWS asks the placement service at conn for assignments, see
+// NewExternalAssigner. The workers lock is released for the duration of the
+// call, so that workers can come and go without waiting for the service;
+// windows of workers which were removed, disabled or set draining meanwhile
+// are dropped from the acceptable windows afterwards. Open windows only change
+// in the scheduler loop, so their indexes stay valid.
+func ExternalWS(conn *grpc.ClientConn) WindowSelector {
+	fallback := SpreadWS(false)
+
+	return func(sh *Scheduler, queueLen int, acceptableWindows [][]int, windows []SchedWindow) int {
+		if conn == nil {
+			return fallback(sh, queueLen, acceptableWindows, windows)
+		}
+
+		req := &ExternalAssignRequest{}
+		for sqi := 0; sqi < queueLen; sqi++ {
+			task := (*sh.SchedQueue)[sqi]
+			req.Tasks = append(req.Tasks, ExternalTask{
+				Index:      sqi,
+				Sector:     task.Sector.ID,
+				TaskType:   task.TaskType,
+				Priority:   task.Priority,
+				Candidates: acceptableWindows[task.IndexHeap],
+			})
+		}
+		for wnd, wr := range sh.OpenWindows {
+			ew := ExternalWindow{Index: wnd, Worker: wr.Worker}
+			if w, ok := sh.Workers[wr.Worker]; ok {
+				ew.Hostname = w.Info.Hostname
+				ew.TaskCounts = w.TaskCounts()
+			}
+			req.Windows = append(req.Windows, ew)
+		}
+
+		ctx, cancel := context.WithTimeout(sh.mctx, externalAssignTimeout)
+		defer cancel()
+
+		var resp ExternalAssignResponse
+		sh.workersLk.RUnlock()
+		err := conn.Invoke(ctx, externalPlacementMethod, req, &resp)
+		sh.workersLk.RLock()
+
+		dropUnavailableWindows(sh, acceptableWindows)
+
+		if err != nil {
+			log.Warnw("placement service call failed, assigning tasks locally", "target", conn.Target(), "error", err)
+			return fallback(sh, queueLen, acceptableWindows, windows)
+		}
+
+		return applyExternalAssignments(sh, queueLen, acceptableWindows, windows, resp.Assignments)
+	}
+}
+
+// dropUnavailableWindows removes windows of workers which were removed,
+// disabled or set draining from acceptableWindows
+func dropUnavailableWindows(sh *Scheduler, acceptableWindows [][]int) {
+	for i, aw := range acceptableWindows {
+		available := aw[:0]
+		for _, wnd := range aw {
+			if w, ok := sh.Workers[sh.OpenWindows[wnd].Worker]; ok && w.Enabled && !w.Draining {
+				available = append(available, wnd)
+			}
+		}
+		acceptableWindows[i] = available
+	}
+}
+
+// applyExternalAssignments makes the assignments returned by a placement
+// service which are feasible, and returns the number of tasks assigned. Tasks
+// the service leaves queued aren't counted as skipped for lack of windows,
+// unless no window could run them, or the window they were assigned to
+// couldn't fit them.
+func applyExternalAssignments(sh *Scheduler, queueLen int, acceptableWindows [][]int, windows []SchedWindow, assignments []ExternalAssignment) int {
+	scheduled := 0
+	assigned := make([]bool, queueLen)
+	infeasible := make([]bool, queueLen)
+	// GPU tasks assigned to each worker, across its windows, see gpusTaken
+	workerGPUTasks := map[storiface.WorkerID]int{}
+
+	for _, a := range assignments {
+		if a.Task < 0 || a.Task >= queueLen || assigned[a.Task] {
+			log.Warnw("placement service returned an invalid task", "task", a.Task, "window", a.Window)
+			continue
+		}
+		task := (*sh.SchedQueue)[a.Task]
+
+		candidate := false
+		for _, wnd := range acceptableWindows[task.IndexHeap] {
+			if wnd == a.Window {
+				candidate = true
+				break
+			}
+		}
+		if !candidate {
+			log.Warnw("placement service assigned a task to a window which can't run it", "sector", task.Sector.ID, "task", task.TaskType, "window", a.Window)
+			continue
+		}
+
+		wid := sh.OpenWindows[a.Window].Worker
+		w := sh.Workers[wid]
+		res := sh.resourceSpec(w, task)
+
+		if !sh.windowHasRoom(task, &windows[a.Window], res, wid, w.Info) ||
+			gpusTaken(res, w.Info, workerGPUTasks[wid]) ||
+			sh.workerAtCap(wid, windows) ||
+			sh.antiAffinityConflict(task, wid, windows) ||
+			sh.taskTypeAtCap(task, wid, windows) ||
+			sh.maintenanceConflict(task, wid) {
+			log.Debugw("skipping infeasible external assignment", "sector", task.Sector.ID, "task", task.TaskType, "window", a.Window, "worker", wid)
+			infeasible[a.Task] = true
+			continue
+		}
+
+		if !sh.assignLogSummary {
+			log.Debugw("SCHED ASSIGNED",
+				"assigner", "external",
+				"sqi", a.Task,
+				"sector", task.Sector.ID.Number,
+				"task", task.TaskType,
+				"window", a.Window,
+				"worker", wid)
+		}
+
+		if res.GPUUtilization > 0 && len(w.Info.Resources.GPUs) > 0 {
+			workerGPUTasks[wid]++
+		}
+		windows[a.Window].Allocated.Add(task.SchedId, task.SealTask(), w.Info.Resources, res)
+		windows[a.Window].Todo = append(windows[a.Window].Todo, task)
+
+		assigned[a.Task] = true
+		scheduled++
+	}
+
+	for sqi := 0; sqi < queueLen; sqi++ {
+		task := (*sh.SchedQueue)[sqi]
+		if !assigned[sqi] && (infeasible[sqi] || len(acceptableWindows[task.IndexHeap]) == 0) {
+			recordNoWindow(sh, task)
+		}
+	}
+	for sqi := queueLen - 1; sqi >= 0; sqi-- {
+		if assigned[sqi] {
+			sh.SchedQueue.Remove(sqi)
+		}
+	}
+
+	return scheduled
+}
@@ -0,0 +1,155 @@
+package sealer
+
+import (
+	"math"
+	"sort"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// NewFairShareAssigner returns a spread assigner which also balances work
+// between storage providers sharing the sealing cluster. Within a scheduling
+// pass tasks are considered round-robin across miner IDs, always picking next
+// from the SP which got the fewest tasks so far, so a burst of tasks from one
+// SP can't take all open windows. Tasks of a single SP are still considered
+// in priority order.
+func NewFairShareAssigner() Assigner {
+	return &AssignerCommon{
+		WindowSel: FairShareWS,
+	}
+}
+
+func FairShareWS(sh *Scheduler, queueLen int, acceptableWindows [][]int, windows []SchedWindow) int {
+	scheduled := 0
+	rmQueue := make([]int, 0, queueLen)
+	workerAssigned := map[storiface.WorkerID]int{}
+	spAssigned := map[abi.ActorID]int{}
+
+	sps, spQueue := fairShareQueues(sh, queueLen)
+
+	for {
+		// take the next task of the SP with the fewest tasks assigned in this pass
+		var sp abi.ActorID
+		var found bool
+		for _, s := range sps {
+			if len(spQueue[s]) == 0 {
+				continue
+			}
+			if !found || spAssigned[s] < spAssigned[sp] {
+				sp, found = s, true
+			}
+		}
+		if !found {
+			break
+		}
+
+		sqi := spQueue[sp][0]
+		spQueue[sp] = spQueue[sp][1:]
+
+		task := (*sh.SchedQueue)[sqi]
+
+		selectedWindow := -1
+		var needRes storiface.Resources
+		var info storiface.WorkerInfo
+		var bestWid storiface.WorkerID
+		bestAssigned := math.MaxInt // smaller = better
+
+		for i, wnd := range acceptableWindows[task.IndexHeap] {
+			wid := sh.OpenWindows[wnd].Worker
+			w := sh.Workers[wid]
+
+			res := w.Info.Resources.ResourceSpec(task.Sector.ProofType, task.TaskType)
+
+			if !sh.assignLogSummary {
+				log.Debugf("SCHED try assign sqi:%d sector %d to window %d (awi:%d)", sqi, task.Sector.ID.Number, wnd, i)
+			}
+
+			if !windows[wnd].Allocated.CanHandleRequest(task.SchedId, task.SealTask(), res, wid, "schedAssign", w.Info) {
+				continue
+			}
+
+			if sh.workerAtCap(wid, windows) {
+				continue
+			}
+
+			if sh.antiAffinityConflict(task, wid, windows) {
+				continue
+			}
+
+			if sh.taskTypeAtCap(task, wid, windows) {
+				continue
+			}
+
+			if sh.maintenanceConflict(task, wid) {
+				continue
+			}
+
+			wu := workerAssigned[wid]
+			if wu > bestAssigned || (wu == bestAssigned && !spreadTieBreak(wid, wnd, bestWid, selectedWindow)) {
+				continue
+			}
+
+			info = w.Info
+			needRes = res
+			bestWid = wid
+			selectedWindow = wnd
+			bestAssigned = wu
+		}
+
+		if selectedWindow < 0 {
+			// all windows full
+			recordNoWindow(sh, task)
+			continue
+		}
+
+		if !sh.assignLogSummary {
+			log.Debugw("SCHED ASSIGNED",
+				"assigner", "fair-share",
+				"sqi", sqi,
+				"sector", task.Sector.ID.Number,
+				"sp", sp,
+				"sp-assigned", spAssigned[sp],
+				"task", task.TaskType,
+				"window", selectedWindow,
+				"worker", bestWid,
+				"assigned", bestAssigned)
+		}
+
+		workerAssigned[bestWid]++
+		spAssigned[sp]++
+		windows[selectedWindow].Allocated.Add(task.SchedId, task.SealTask(), info.Resources, needRes)
+		windows[selectedWindow].Todo = append(windows[selectedWindow].Todo, task)
+
+		rmQueue = append(rmQueue, sqi)
+		scheduled++
+	}
+
+	if len(rmQueue) > 0 {
+		// tasks weren't visited in queue order
+		sort.Ints(rmQueue)
+		for i := len(rmQueue) - 1; i >= 0; i-- {
+			sh.SchedQueue.Remove(rmQueue[i])
+		}
+	}
+
+	return scheduled
+}
+
+// fairShareQueues splits the queue per SP. Each SP's tasks keep their queue
+// (priority) order, and SPs are listed in order of their first queued task.
+func fairShareQueues(sh *Scheduler, queueLen int) ([]abi.ActorID, map[abi.ActorID][]int) {
+	var sps []abi.ActorID
+	spQueue := map[abi.ActorID][]int{}
+
+	for sqi := 0; sqi < queueLen; sqi++ {
+		sp := (*sh.SchedQueue)[sqi].Sector.ID.Miner
+		if _, ok := spQueue[sp]; !ok {
+			sps = append(sps, sp)
+		}
+		spQueue[sp] = append(spQueue[sp], sqi)
+	}
+
+	return sps, spQueue
+}
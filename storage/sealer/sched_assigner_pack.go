@@ -0,0 +1,108 @@
+package sealer
+
+import (
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// NewPackAssigner returns an assigner which is the inverse of spread: tasks are
+// packed onto the most utilized workers which can still fit them, so that work
+// is concentrated on as few workers as possible, and idle workers can be
+// powered down.
+func NewPackAssigner() Assigner {
+	return &AssignerCommon{
+		WindowSel: PackWS,
+	}
+}
+
+func PackWS(sh *Scheduler, queueLen int, acceptableWindows [][]int, windows []SchedWindow) int {
+	scheduled := 0
+	rmQueue := make([]int, 0, queueLen)
+	workerUtil := map[storiface.WorkerID]float64{}
+
+	for sqi := 0; sqi < queueLen; sqi++ {
+		task := (*sh.SchedQueue)[sqi]
+
+		selectedWindow := -1
+		var needRes storiface.Resources
+		var info storiface.WorkerInfo
+		var bestWid storiface.WorkerID
+		bestUtilization := -1.0 // larger = better
+
+		for i, wnd := range acceptableWindows[task.IndexHeap] {
+			wid := sh.OpenWindows[wnd].Worker
+			w := sh.Workers[wid]
+
+			res := w.Info.Resources.ResourceSpec(task.Sector.ProofType, task.TaskType)
+
+			if !sh.assignLogSummary {
+				log.Debugf("SCHED try assign sqi:%d sector %d to window %d (awi:%d)", sqi, task.Sector.ID.Number, wnd, i)
+			}
+
+			if !windows[wnd].Allocated.CanHandleRequest(task.SchedId, task.SealTask(), res, wid, "schedAssign", w.Info) {
+				continue
+			}
+
+			if sh.workerAtCap(wid, windows) {
+				continue
+			}
+
+			if sh.antiAffinityConflict(task, wid, windows) {
+				continue
+			}
+
+			if sh.taskTypeAtCap(task, wid, windows) {
+				continue
+			}
+
+			if sh.maintenanceConflict(task, wid) {
+				continue
+			}
+
+			wu, found := workerUtil[wid]
+			if !found {
+				wu = w.Utilization()
+				workerUtil[wid] = wu
+			}
+			if wu < bestUtilization || (wu == bestUtilization && !spreadTieBreak(wid, wnd, bestWid, selectedWindow)) {
+				continue
+			}
+
+			info = w.Info
+			needRes = res
+			bestWid = wid
+			selectedWindow = wnd
+			bestUtilization = wu
+		}
+
+		if selectedWindow < 0 {
+			// all windows full
+			recordNoWindow(sh, task)
+			continue
+		}
+
+		if !sh.assignLogSummary {
+			log.Debugw("SCHED ASSIGNED",
+				"assigner", "pack",
+				"sqi", sqi,
+				"sector", task.Sector.ID.Number,
+				"task", task.TaskType,
+				"window", selectedWindow,
+				"worker", bestWid,
+				"utilization", bestUtilization)
+		}
+
+		workerUtil[bestWid] += windows[selectedWindow].Allocated.Add(task.SchedId, task.SealTask(), info.Resources, needRes)
+		windows[selectedWindow].Todo = append(windows[selectedWindow].Todo, task)
+
+		rmQueue = append(rmQueue, sqi)
+		scheduled++
+	}
+
+	if len(rmQueue) > 0 {
+		for i := len(rmQueue) - 1; i >= 0; i-- {
+			sh.SchedQueue.Remove(rmQueue[i])
+		}
+	}
+
+	return scheduled
+}
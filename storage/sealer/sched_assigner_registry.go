@@ -0,0 +1,75 @@
+package sealer
+
+import (
+	"sort"
+	"sync"
+
+	"golang.org/x/xerrors"
+)
+
+var (
+	assignersLk sync.RWMutex
+	assigners   = map[string]func() Assigner{}
+)
+
+func init() {
+	RegisterAssigner("utilization", NewLowestUtilizationAssigner)
+	RegisterAssigner("spread", func() Assigner { return NewSpreadAssigner(false) })
+	RegisterAssigner("experiment-spread-qcount", func() Assigner { return NewSpreadAssigner(true) })
+	RegisterAssigner("experiment-spread-tasks", func() Assigner { return NewSpreadTasksAssigner(false) })
+	RegisterAssigner("experiment-spread-tasks-qcount", func() Assigner { return NewSpreadTasksAssigner(true) })
+	RegisterAssigner("experiment-spread-gpu", func() Assigner { return NewSpreadGPUAssigner(false) })
+	RegisterAssigner("experiment-spread-gpu-qcount", func() Assigner { return NewSpreadGPUAssigner(true) })
+	RegisterAssigner("experiment-spread-domains", func() Assigner { return NewDomainSpreadAssigner(false) })
+	RegisterAssigner("experiment-spread-domains-qcount", func() Assigner { return NewDomainSpreadAssigner(true) })
+	RegisterAssigner("experiment-fair-share", NewFairShareAssigner)
+	RegisterAssigner("experiment-colocate", NewColocateAssigner)
+	RegisterAssigner("experiment-pack", NewPackAssigner)
+	RegisterAssigner("experiment-random", NewRandomAssigner)
+	RegisterAssigner("experiment-round-robin", NewRoundRobinAssigner)
+	RegisterAssigner("experiment-session", NewSessionAssigner)
+	RegisterAssigner("experiment-utilization-projected", NewUtilizationAssigner)
+	// configured with an endpoint in New, see AssignerExternalEndpoint
+	RegisterAssigner(ExternalAssignerName, func() Assigner { return &AssignerCommon{WindowSel: ExternalWS(nil)} })
+}
+
+// RegisterAssigner makes an assigner selectable by name in the sealer config.
+// Registering a name twice replaces the previous constructor.
+func RegisterAssigner(name string, ctor func() Assigner) {
+	assignersLk.Lock()
+	defer assignersLk.Unlock()
+
+	assigners[name] = ctor
+}
+
+// GetAssigner constructs the assigner registered under the given name. An
+// empty name selects the default "utilization" assigner.
+func GetAssigner(name string) (Assigner, error) {
+	if name == "" {
+		name = "utilization"
+	}
+
+	assignersLk.RLock()
+	ctor, ok := assigners[name]
+	assignersLk.RUnlock()
+
+	if !ok {
+		return nil, xerrors.Errorf("unknown assigner '%s'", name)
+	}
+
+	return ctor(), nil
+}
+
+// AssignerNames returns the sorted names of all registered assigners.
+func AssignerNames() []string {
+	assignersLk.RLock()
+	defer assignersLk.RUnlock()
+
+	out := make([]string, 0, len(assigners))
+	for name := range assigners {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+
+	return out
+}
@@ -0,0 +1,141 @@
+package sealer
+
+import (
+	"bytes"
+	"math"
+	"sort"
+
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// NewRoundRobinAssigner returns an assigner which rotates over workers, giving
+// each task to the next worker (in worker ID order) after the one which got the
+// previous task, skipping workers which can't handle it. The rotation carries
+// over between scheduling passes. On clusters of identical workers this spreads
+// tasks like the spread assigner, but more predictably.
+func NewRoundRobinAssigner() Assigner {
+	return &AssignerCommon{
+		WindowSel: RoundRobinWS(),
+	}
+}
+
+func RoundRobinWS() WindowSelector {
+	// worker which got the latest task, owned by the sched goroutine
+	var last storiface.WorkerID
+
+	return func(sh *Scheduler, queueLen int, acceptableWindows [][]int, windows []SchedWindow) int {
+		scheduled := 0
+		rmQueue := make([]int, 0, queueLen)
+
+		wids := make([]storiface.WorkerID, 0, len(sh.Workers))
+		for wid := range sh.Workers {
+			wids = append(wids, wid)
+		}
+		sort.Slice(wids, func(i, j int) bool {
+			return bytes.Compare(wids[i][:], wids[j][:]) < 0
+		})
+
+		order := make(map[storiface.WorkerID]int, len(wids))
+		for i, wid := range wids {
+			order[wid] = i
+		}
+
+		// position in wids where the rotation continues
+		cursor := func() int {
+			if i, ok := order[last]; ok {
+				return i + 1
+			}
+			// the last worker went away, continue with the next one
+			return sort.Search(len(wids), func(i int) bool {
+				return bytes.Compare(wids[i][:], last[:]) > 0
+			})
+		}
+
+		for sqi := 0; sqi < queueLen; sqi++ {
+			task := (*sh.SchedQueue)[sqi]
+
+			start := cursor()
+
+			selectedWindow := -1
+			var needRes storiface.Resources
+			var info storiface.WorkerInfo
+			var bestWid storiface.WorkerID
+			bestDist := math.MaxInt // smaller = better
+
+			for i, wnd := range acceptableWindows[task.IndexHeap] {
+				wid := sh.OpenWindows[wnd].Worker
+				w := sh.Workers[wid]
+
+				res := w.Info.Resources.ResourceSpec(task.Sector.ProofType, task.TaskType)
+
+				if !sh.assignLogSummary {
+					log.Debugf("SCHED try assign sqi:%d sector %d to window %d (awi:%d)", sqi, task.Sector.ID.Number, wnd, i)
+				}
+
+				if !windows[wnd].Allocated.CanHandleRequest(task.SchedId, task.SealTask(), res, wid, "schedAssign", w.Info) {
+					continue
+				}
+
+				if sh.workerAtCap(wid, windows) {
+					continue
+				}
+
+				if sh.antiAffinityConflict(task, wid, windows) {
+					continue
+				}
+
+				if sh.taskTypeAtCap(task, wid, windows) {
+					continue
+				}
+
+				if sh.maintenanceConflict(task, wid) {
+					continue
+				}
+
+				// distance from the cursor, in rotation order
+				dist := (order[wid] - start + len(wids)) % len(wids)
+				if dist > bestDist || (dist == bestDist && wnd > selectedWindow) {
+					continue
+				}
+
+				info = w.Info
+				needRes = res
+				bestWid = wid
+				selectedWindow = wnd
+				bestDist = dist
+			}
+
+			if selectedWindow < 0 {
+				// all windows full
+				recordNoWindow(sh, task)
+				continue
+			}
+
+			if !sh.assignLogSummary {
+				log.Debugw("SCHED ASSIGNED",
+					"assigner", "round-robin",
+					"sqi", sqi,
+					"sector", task.Sector.ID.Number,
+					"task", task.TaskType,
+					"window", selectedWindow,
+					"worker", bestWid,
+					"distance", bestDist)
+			}
+
+			last = bestWid
+			windows[selectedWindow].Allocated.Add(task.SchedId, task.SealTask(), info.Resources, needRes)
+			windows[selectedWindow].Todo = append(windows[selectedWindow].Todo, task)
+
+			rmQueue = append(rmQueue, sqi)
+			scheduled++
+		}
+
+		if len(rmQueue) > 0 {
+			for i := len(rmQueue) - 1; i >= 0; i-- {
+				sh.SchedQueue.Remove(rmQueue[i])
+			}
+		}
+
+		return scheduled
+	}
+}
@@ -0,0 +1,165 @@
+package sealer
+
+import (
+	"math"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/storage/sealer/sealtasks"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+type sessionOp int
+
+const (
+	// sessionBind tasks run within the sector's session, and start one on the
+	// worker they get assigned to if the sector doesn't have one yet
+	sessionBind sessionOp = iota + 1
+	// sessionEnd tasks run within the sector's session and close it
+	sessionEnd
+)
+
+// sessionTaskTypes are the tasks which depend on sector state kept on the worker
+// which ran the previous sealing steps. Tasks not listed here (e.g. C2, which
+// only needs the C1 output) are placed like in the spread assigner.
+var sessionTaskTypes = map[sealtasks.TaskType]sessionOp{
+	sealtasks.TTAddPiece:              sessionBind,
+	sealtasks.TTPreCommit1:            sessionBind,
+	sealtasks.TTPreCommit2:            sessionBind,
+	sealtasks.TTCommit1:               sessionBind,
+	sealtasks.TTReplicaUpdate:         sessionBind,
+	sealtasks.TTProveReplicaUpdate1:   sessionBind,
+	sealtasks.TTFinalize:              sessionEnd,
+	sealtasks.TTFinalizeReplicaUpdate: sessionEnd,
+}
+
+// NewSessionAssigner returns an assigner which keeps the sealing tasks of a
+// sector on the worker which started sealing it. Once a sector task was
+// assigned to a worker, following tasks of the sector only go to that worker's
+// windows, waiting for a window to open if needed, until the sector is
+// finalized. The session only moves to another worker if its owner is removed
+// or disabled. Tasks without a session are spread across workers.
+func NewSessionAssigner() Assigner {
+	return &AssignerCommon{
+		WindowSel: SessionWS(),
+	}
+}
+
+func SessionWS() WindowSelector {
+	// worker owning each sector's sealing session, owned by the sched goroutine
+	sessions := map[abi.SectorID]storiface.WorkerID{}
+
+	return func(sh *Scheduler, queueLen int, acceptableWindows [][]int, windows []SchedWindow) int {
+		scheduled := 0
+		rmQueue := make([]int, 0, queueLen)
+		workerAssigned := map[storiface.WorkerID]int{}
+
+		for sqi := 0; sqi < queueLen; sqi++ {
+			task := (*sh.SchedQueue)[sqi]
+
+			op := sessionTaskTypes[task.TaskType]
+
+			owner, bound := sessions[task.Sector.ID]
+			bound = bound && op != 0
+			if bound {
+				if w, ok := sh.Workers[owner]; !ok || !w.Enabled {
+					log.Warnw("sealing session worker unavailable, moving session", "sector", task.Sector.ID, "worker", owner)
+					delete(sessions, task.Sector.ID)
+					bound = false
+				}
+			}
+
+			selectedWindow := -1
+			var needRes storiface.Resources
+			var info storiface.WorkerInfo
+			var bestWid storiface.WorkerID
+			bestAssigned := math.MaxInt // smaller = better
+
+			for i, wnd := range acceptableWindows[task.IndexHeap] {
+				wid := sh.OpenWindows[wnd].Worker
+				w := sh.Workers[wid]
+
+				if bound && wid != owner {
+					continue
+				}
+
+				res := w.Info.Resources.ResourceSpec(task.Sector.ProofType, task.TaskType)
+
+				if !sh.assignLogSummary {
+					log.Debugf("SCHED try assign sqi:%d sector %d to window %d (awi:%d)", sqi, task.Sector.ID.Number, wnd, i)
+				}
+
+				if !windows[wnd].Allocated.CanHandleRequest(task.SchedId, task.SealTask(), res, wid, "schedAssign", w.Info) {
+					continue
+				}
+
+				if sh.workerAtCap(wid, windows) {
+					continue
+				}
+
+				if sh.antiAffinityConflict(task, wid, windows) {
+					continue
+				}
+
+				if sh.taskTypeAtCap(task, wid, windows) {
+					continue
+				}
+
+				if sh.maintenanceConflict(task, wid) {
+					continue
+				}
+
+				wu := workerAssigned[wid]
+				if wu > bestAssigned || (wu == bestAssigned && !spreadTieBreak(wid, wnd, bestWid, selectedWindow)) {
+					continue
+				}
+
+				info = w.Info
+				needRes = res
+				bestWid = wid
+				selectedWindow = wnd
+				bestAssigned = wu
+			}
+
+			if selectedWindow < 0 {
+				// all windows full, or the session owner has no window open
+				recordNoWindow(sh, task)
+				continue
+			}
+
+			if !sh.assignLogSummary {
+				log.Debugw("SCHED ASSIGNED",
+					"assigner", "session",
+					"sqi", sqi,
+					"sector", task.Sector.ID.Number,
+					"task", task.TaskType,
+					"window", selectedWindow,
+					"worker", bestWid,
+					"session", bound,
+					"assigned", bestAssigned)
+			}
+
+			switch op {
+			case sessionBind:
+				sessions[task.Sector.ID] = bestWid
+			case sessionEnd:
+				delete(sessions, task.Sector.ID)
+			}
+
+			workerAssigned[bestWid]++
+			windows[selectedWindow].Allocated.Add(task.SchedId, task.SealTask(), info.Resources, needRes)
+			windows[selectedWindow].Todo = append(windows[selectedWindow].Todo, task)
+
+			rmQueue = append(rmQueue, sqi)
+			scheduled++
+		}
+
+		if len(rmQueue) > 0 {
+			for i := len(rmQueue) - 1; i >= 0; i-- {
+				sh.SchedQueue.Remove(rmQueue[i])
+			}
+		}
+
+		return scheduled
+	}
+}
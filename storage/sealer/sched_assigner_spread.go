@@ -1,8 +1,12 @@
 package sealer
 
 import (
+	"bytes"
 	"math"
+	"sort"
+	"time"
 
+	"github.com/filecoin-project/lotus/storage/sealer/sealtasks"
 	"github.com/filecoin-project/lotus/storage/sealer/storiface"
 )
 
@@ -12,30 +16,131 @@ func NewSpreadAssigner(queued bool) Assigner {
 	}
 }
 
+// NewSpreadGPUAssigner is like NewSpreadAssigner, but it steers GPU tasks to
+// workers with free GPUs, and keeps other tasks off them while it can.
+func NewSpreadGPUAssigner(queued bool) Assigner {
+	return &AssignerCommon{
+		WindowSel: SpreadGPUWS(queued),
+	}
+}
+
+// SpreadWS assigns each task to the acceptable worker with the fewest tasks
+// assigned in the current scheduling pass. With queued set, tasks workers
+// already accepted in earlier passes (running, preparing, or waiting in
+// scheduled windows) are counted too, so workers which are saturated with
+// earlier work aren't picked just because they got nothing in this pass.
+//
+// Recent task failures of a worker (see ReportTaskOutcome) count as extra
+// tasks, so flaky workers are picked after healthy ones until the failures
+// decay. Task counts are divided by the worker weight (see workerWeight), so
+// workers with higher weights get proportionally more tasks. Task resource needs
+// include the scheduler resource overrides (see resourceSpec).
+//
+// Among equally loaded workers, warm workers (see workerWarm) are preferred,
+// then workers which recently ran tasks of the same seal proof type, if enabled
+// (see proofMatch), then workers the task selector prefers. Acceptable windows
+// are scanned in selector preference order, and in tie-break order (see
+// spreadTieBreak) among windows the selector prefers equally, see
+// spreadScanOrder. Later windows lose ties, so the scan for a task stops at
+// the first window of an idle warm (and matching) worker it fits in.
+//
+// Resources reserved for other task types (see intoReserved) count as
+// unavailable.
+//
+// With AssignerMaxWorkerPassTasks a worker gets at most that many tasks in a
+// pass, across its windows, so that bursts are spread out before workers
+// report back their new resource use.
+//
+// Resources are accounted per window, so a worker with several open windows
+// could get a GPU task in each of them. Each GPU of a worker takes at most one
+// GPU task per pass, whichever window it comes through.
 func SpreadWS(queued bool) func(sh *Scheduler, queueLen int, acceptableWindows [][]int, windows []SchedWindow) int {
+	return spreadWS(queued, false)
+}
+
+func SpreadGPUWS(queued bool) func(sh *Scheduler, queueLen int, acceptableWindows [][]int, windows []SchedWindow) int {
+	return spreadWS(queued, true)
+}
+
+func spreadWS(queued, gpuAware bool) func(sh *Scheduler, queueLen int, acceptableWindows [][]int, windows []SchedWindow) int {
 	return func(sh *Scheduler, queueLen int, acceptableWindows [][]int, windows []SchedWindow) int {
 		scheduled := 0
 		rmQueue := make([]int, 0, queueLen)
 		workerAssigned := map[storiface.WorkerID]int{}
+		// tasks assigned to each worker in this pass, for maxWorkerPassTasks;
+		// with queued set workerAssigned also counts earlier tasks
+		workerPassAssigned := map[storiface.WorkerID]int{}
+		workerGPUUsed := map[storiface.WorkerID]float64{}
+		// GPU tasks assigned to each worker in this pass, across its windows
+		workerGPUTasks := map[storiface.WorkerID]int{}
+
+		// windows which didn't have resources for a task type. Windows only
+		// fill up during a pass, so later tasks of that type won't fit either.
+		full := map[spreadFullKey]struct{}{}
+
+		rank := spreadWindowRanks(sh)
+		now := time.Now()
 
 		for sqi := 0; sqi < queueLen; sqi++ {
 			task := (*sh.SchedQueue)[sqi]
 
+			aw := acceptableWindows[task.IndexHeap]
+			if len(aw) == 0 {
+				recordNoWindow(sh, task)
+				continue
+			}
+			aw = spreadScanOrder(task, aw, rank)
+
 			selectedWindow := -1
 			var needRes storiface.Resources
 			var info storiface.WorkerInfo
 			var bestWid storiface.WorkerID
-			bestAssigned := math.MaxInt // smaller = better
+			bestLoad := math.MaxFloat64 // smaller = better
+			bestGPURank := math.MaxInt  // smaller = better, takes precedence over bestLoad
+			bestWarm := false           // breaks bestLoad ties
+			bestMatch := false          // breaks bestLoad ties after bestWarm
+
+			for i, wnd := range aw {
+				fk := spreadFullKey{wnd: wnd, task: task.SealTask()}
+				if _, ok := full[fk]; ok {
+					continue
+				}
 
-			for i, wnd := range acceptableWindows[task.IndexHeap] {
 				wid := sh.OpenWindows[wnd].Worker
 				w := sh.Workers[wid]
 
-				res := w.Info.Resources.ResourceSpec(task.Sector.ProofType, task.TaskType)
+				res := sh.resourceSpec(w, task)
+
+				if !sh.assignLogSummary {
+					log.Debugf("SCHED try assign sqi:%d sector %d to window %d (awi:%d)", sqi, task.Sector.ID.Number, wnd, i)
+				}
 
-				log.Debugf("SCHED try assign sqi:%d sector %d to window %d (awi:%d)", sqi, task.Sector.ID.Number, wnd, i)
+				if !sh.windowHasRoom(task, &windows[wnd], res, wid, w.Info) {
+					full[fk] = struct{}{}
+					continue
+				}
+
+				if sh.workerAtCap(wid, windows) {
+					continue
+				}
+
+				if sh.antiAffinityConflict(task, wid, windows) {
+					continue
+				}
+
+				if sh.taskTypeAtCap(task, wid, windows) {
+					continue
+				}
+
+				if sh.maintenanceConflict(task, wid) {
+					continue
+				}
+
+				if sh.maxWorkerPassTasks > 0 && workerPassAssigned[wid] >= sh.maxWorkerPassTasks {
+					continue
+				}
 
-				if !windows[wnd].Allocated.CanHandleRequest(task.SchedId, task.SealTask(), res, wid, "schedAssign", w.Info) {
+				if gpusTaken(res, w.Info, workerGPUTasks[wid]) {
 					continue
 				}
 
@@ -44,7 +149,30 @@ func SpreadWS(queued bool) func(sh *Scheduler, queueLen int, acceptableWindows [
 					wu = w.TaskCounts()
 					workerAssigned[wid] = wu
 				}
-				if wu >= bestAssigned {
+
+				var gr int
+				if gpuAware {
+					gu, found := workerGPUUsed[wid]
+					if !found {
+						gu = w.gpuUsed()
+						workerGPUUsed[wid] = gu
+					}
+					gr = gpuRank(res, w.Info.Resources, gu)
+				}
+
+				load := (float64(wu) + workerHealthLoad*sh.health.penalty(wid, now)) / sh.workerWeight(w)
+				warm := sh.workerWarm(w, now)
+				match := sh.proofMatch(wid, task)
+
+				if gr > bestGPURank || (gr == bestGPURank && load > bestLoad) {
+					continue
+				}
+				if gr == bestGPURank && load == bestLoad &&
+					((bestWarm && !warm) ||
+						(warm == bestWarm && bestMatch && !match) ||
+						(warm == bestWarm && match == bestMatch)) {
+					// windows are scanned in spreadScanOrder, so earlier ones
+					// win ties
 					continue
 				}
 
@@ -52,25 +180,43 @@ func SpreadWS(queued bool) func(sh *Scheduler, queueLen int, acceptableWindows [
 				needRes = res
 				bestWid = wid
 				selectedWindow = wnd
-				bestAssigned = wu
+				bestLoad = load
+				bestGPURank = gr
+				bestWarm = warm
+				bestMatch = match
+
+				if bestLoad == 0 && bestGPURank == 0 && bestWarm && (bestMatch || !sh.preferProofMatch) {
+					// nothing beats an idle warm worker, and windows later in
+					// the scan lose ties
+					break
+				}
 			}
 
 			if selectedWindow < 0 {
 				// all windows full
+				recordNoWindow(sh, task)
 				continue
 			}
 
-			log.Debugw("SCHED ASSIGNED",
-				"assigner", "spread",
-				"spread-queued", queued,
-				"sqi", sqi,
-				"sector", task.Sector.ID.Number,
-				"task", task.TaskType,
-				"window", selectedWindow,
-				"worker", bestWid,
-				"assigned", bestAssigned)
+			if !sh.assignLogSummary {
+				log.Debugw("SCHED ASSIGNED",
+					"assigner", "spread",
+					"spread-queued", queued,
+					"sqi", sqi,
+					"sector", task.Sector.ID.Number,
+					"task", task.TaskType,
+					"window", selectedWindow,
+					"worker", bestWid,
+					"load", bestLoad,
+					"gpu-rank", bestGPURank)
+			}
 
 			workerAssigned[bestWid]++
+			workerPassAssigned[bestWid]++
+			if needRes.GPUUtilization > 0 && len(info.Resources.GPUs) > 0 {
+				workerGPUUsed[bestWid] += needRes.GPUUtilization
+				workerGPUTasks[bestWid]++
+			}
 			windows[selectedWindow].Allocated.Add(task.SchedId, task.SealTask(), info.Resources, needRes)
 			windows[selectedWindow].Todo = append(windows[selectedWindow].Todo, task)
 
@@ -87,3 +233,90 @@ func SpreadWS(queued bool) func(sh *Scheduler, queueLen int, acceptableWindows [
 		return scheduled
 	}
 }
+
+type spreadFullKey struct {
+	wnd  int
+	task sealtasks.SealTaskType
+}
+
+// gpuRank orders candidate workers for GPU-aware spreading, smaller = better.
+// GPU tasks prefer workers with a free GPU, other tasks prefer workers
+// without one, so that GPU capacity stays available for tasks needing it.
+func gpuRank(needRes storiface.Resources, wr storiface.WorkerResources, gpuUsed float64) int {
+	freeGPU := len(wr.GPUs) > 0 && gpuUsed < float64(len(wr.GPUs))
+
+	if (needRes.GPUUtilization > 0) == freeGPU {
+		return 0
+	}
+	return 1
+}
+
+// gpusTaken reports whether a GPU task can't go to a worker because each of
+// its GPUs already got a GPU task in the current pass
+func gpusTaken(needRes storiface.Resources, info storiface.WorkerInfo, passGPUTasks int) bool {
+	if needRes.GPUUtilization <= 0 || info.IgnoreResources || len(info.Resources.GPUs) == 0 {
+		return false
+	}
+	return passGPUTasks >= len(info.Resources.GPUs)
+}
+
+// workerWeight returns the weight of a worker set in sh.workerWeights, 1 if
+// the worker has no valid weight set
+func (sh *Scheduler) workerWeight(w *WorkerHandle) float64 {
+	if wt, ok := sh.workerWeights[w.Info.Hostname]; ok && wt > 0 {
+		return wt
+	}
+	return 1
+}
+
+// workerWarm reports whether a worker has been connected for at least
+// sh.warmupPeriod
+func (sh *Scheduler) workerWarm(w *WorkerHandle, now time.Time) bool {
+	return sh.warmupPeriod <= 0 || now.Sub(w.joined) >= sh.warmupPeriod
+}
+
+// spreadScanOrder returns the acceptable windows of a task in the order spread
+// assigners scan them: by selector preference (see WorkerRequest.windowPrefs),
+// then by rank (see spreadWindowRanks). The acceptable windows are left as
+// they are, later steps of the scheduling pass use them too.
+func spreadScanOrder(task *WorkerRequest, acceptable []int, rank []int) []int {
+	aw := append([]int(nil), acceptable...)
+	sort.SliceStable(aw, func(i, j int) bool {
+		pi, pj := task.windowPrefs[aw[i]], task.windowPrefs[aw[j]]
+		if pi != pj {
+			return pi < pj
+		}
+		return rank[aw[i]] < rank[aw[j]]
+	})
+	return aw
+}
+
+// spreadWindowRanks returns the position of each open window in tie-break
+// order, see spreadTieBreak
+func spreadWindowRanks(sh *Scheduler) []int {
+	order := make([]int, len(sh.OpenWindows))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		a, b := order[i], order[j]
+		return spreadTieBreak(sh.OpenWindows[a].Worker, a, sh.OpenWindows[b].Worker, b)
+	})
+
+	rank := make([]int, len(order))
+	for r, wnd := range order {
+		rank[wnd] = r
+	}
+	return rank
+}
+
+// spreadTieBreak reports whether a candidate window should replace the selected
+// one when both are equally good by the spread criteria. The lowest worker ID
+// wins, then the lowest window index, so that placement doesn't depend on the
+// (shuffled) order of acceptable windows.
+func spreadTieBreak(wid storiface.WorkerID, wnd int, bestWid storiface.WorkerID, bestWnd int) bool {
+	if c := bytes.Compare(wid[:], bestWid[:]); c != 0 {
+		return c < 0
+	}
+	return wnd < bestWnd
+}
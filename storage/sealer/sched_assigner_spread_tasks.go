@@ -39,12 +39,30 @@ func SpreadTasksWS(queued bool) func(sh *Scheduler, queueLen int, acceptableWind
 
 				res := w.Info.Resources.ResourceSpec(task.Sector.ProofType, task.TaskType)
 
-				log.Debugf("SCHED try assign sqi:%d sector %d to window %d (awi:%d)", sqi, task.Sector.ID.Number, wnd, i)
+				if !sh.assignLogSummary {
+					log.Debugf("SCHED try assign sqi:%d sector %d to window %d (awi:%d)", sqi, task.Sector.ID.Number, wnd, i)
+				}
 
 				if !windows[wnd].Allocated.CanHandleRequest(task.SchedId, task.SealTask(), res, wid, "schedAssign", w.Info) {
 					continue
 				}
 
+				if sh.workerAtCap(wid, windows) {
+					continue
+				}
+
+				if sh.antiAffinityConflict(task, wid, windows) {
+					continue
+				}
+
+				if sh.taskTypeAtCap(task, wid, windows) {
+					continue
+				}
+
+				if sh.maintenanceConflict(task, wid) {
+					continue
+				}
+
 				wt := widTask{wid: wid, tt: task.TaskType}
 
 				wu, found := workerAssigned[wt]
@@ -66,18 +84,21 @@ func SpreadTasksWS(queued bool) func(sh *Scheduler, queueLen int, acceptableWind
 
 			if selectedWindow < 0 {
 				// all windows full
+				recordNoWindow(sh, task)
 				continue
 			}
 
-			log.Debugw("SCHED ASSIGNED",
-				"assigner", "spread-tasks",
-				"spread-queued", queued,
-				"sqi", sqi,
-				"sector", task.Sector.ID.Number,
-				"task", task.TaskType,
-				"window", selectedWindow,
-				"worker", bestWid,
-				"assigned", bestAssigned)
+			if !sh.assignLogSummary {
+				log.Debugw("SCHED ASSIGNED",
+					"assigner", "spread-tasks",
+					"spread-queued", queued,
+					"sqi", sqi,
+					"sector", task.Sector.ID.Number,
+					"task", task.TaskType,
+					"window", selectedWindow,
+					"worker", bestWid,
+					"assigned", bestAssigned)
+			}
 
 			workerAssigned[bestWid]++
 			windows[selectedWindow].Allocated.Add(task.SchedId, task.SealTask(), info.Resources, needRes)
@@ -0,0 +1,2025 @@
+package sealer
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/stats/view"
+	"golang.org/x/xerrors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/metrics"
+	"github.com/filecoin-project/lotus/node/config"
+	"github.com/filecoin-project/lotus/storage/paths"
+	"github.com/filecoin-project/lotus/storage/sealer/fsutil"
+	"github.com/filecoin-project/lotus/storage/sealer/sealtasks"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+const assignerTestSpt = abi.RegisteredSealProof_StackedDrg32GiBV1
+
+func assignerTestWid(i int) storiface.WorkerID {
+	return storiface.WorkerID(uuid.UUID{byte(i + 1)})
+}
+
+// newAssignerTestSched builds a scheduler with one open window per worker and
+// the given tasks queued. Every task is acceptable in every window, in worker
+// order, as if all selectors accepted all workers with equal preference.
+func newAssignerTestSched(t testing.TB, workers []storiface.WorkerResources, tasks ...sealtasks.TaskType) (*Scheduler, [][]int, []SchedWindow) {
+	sh, err := newScheduler(context.Background(), "")
+	require.NoError(t, err)
+
+	for i, res := range workers {
+		wid := assignerTestWid(i)
+
+		sh.Workers[wid] = &WorkerHandle{
+			Info: storiface.WorkerInfo{
+				Hostname:  wid.String(),
+				Resources: res,
+			},
+			Enabled:   true,
+			preparing: NewActiveResources(newTaskCounter()),
+			active:    NewActiveResources(newTaskCounter()),
+		}
+
+		sh.OpenWindows = append(sh.OpenWindows, &SchedWindowRequest{
+			Worker: wid,
+			Done:   make(chan *SchedWindow, 1),
+		})
+	}
+
+	for i, tt := range tasks {
+		sh.SchedQueue.Push(&WorkerRequest{
+			Sector: storiface.SectorRef{
+				ID:        abi.SectorID{Miner: 1000, Number: abi.SectorNumber(i)},
+				ProofType: assignerTestSpt,
+			},
+			TaskType: tt,
+			SchedId:  uuid.New(),
+			Ctx:      context.Background(),
+		})
+	}
+
+	acceptable := make([][]int, len(tasks))
+	for sqi := range acceptable {
+		(*sh.SchedQueue)[sqi].IndexHeap = sqi
+		for wnd := range sh.OpenWindows {
+			acceptable[sqi] = append(acceptable[sqi], wnd)
+		}
+	}
+
+	windows := make([]SchedWindow, len(sh.OpenWindows))
+	for i := range windows {
+		windows[i].Allocated = *NewActiveResources(newTaskCounter())
+	}
+
+	return sh, acceptable, windows
+}
+
+// windowTasks returns the types of the tasks assigned to a window
+func windowTasks(w SchedWindow) []sealtasks.TaskType {
+	var out []sealtasks.TaskType
+	for _, r := range w.Todo {
+		out = append(out, r.TaskType)
+	}
+	return out
+}
+
+func TestSpreadWSUsesWorkerResources(t *testing.T) {
+	// The first worker has plenty of hardware, but its custom resource table
+	// declares that PC1 needs more memory than it has. The spread selector must
+	// check feasibility against each worker's own resource table, not the
+	// default one, so the task has to land on the second worker.
+	overridden := decentWorkerResources
+	overridden.Resources = map[sealtasks.TaskType]map[abi.RegisteredSealProof]storiface.Resources{
+		sealtasks.TTPreCommit1: {
+			assignerTestSpt: {
+				MinMemory:      1 << 40,
+				MaxMemory:      1 << 40,
+				MaxParallelism: 1,
+			},
+		},
+	}
+
+	sh, acceptable, windows := newAssignerTestSched(t,
+		[]storiface.WorkerResources{overridden, decentWorkerResources},
+		sealtasks.TTPreCommit1)
+
+	scheduled := SpreadWS(false)(sh, len(acceptable), acceptable, windows)
+	require.Equal(t, 1, scheduled)
+
+	require.Empty(t, windows[0].Todo)
+	require.Len(t, windows[1].Todo, 1)
+	require.Equal(t, 0, sh.SchedQueue.Len())
+}
+
+// addAssignerTestWindow opens one more window on an existing test worker and
+// makes it acceptable for every queued task.
+func addAssignerTestWindow(sh *Scheduler, acceptable [][]int, windows []SchedWindow, worker int) ([][]int, []SchedWindow) {
+	sh.OpenWindows = append(sh.OpenWindows, &SchedWindowRequest{
+		Worker: assignerTestWid(worker),
+		Done:   make(chan *SchedWindow, 1),
+	})
+
+	wnd := len(sh.OpenWindows) - 1
+	for sqi := range acceptable {
+		acceptable[sqi] = append(acceptable[sqi], wnd)
+	}
+
+	return acceptable, append(windows, SchedWindow{Allocated: *NewActiveResources(newTaskCounter())})
+}
+
+func TestSpreadWSWindowCapacity(t *testing.T) {
+	// room for exactly one 32G PC1 per window
+	oneTask := decentWorkerResources
+	oneTask.MemPhysical = 64 << 30
+
+	sh, acceptable, windows := newAssignerTestSched(t,
+		[]storiface.WorkerResources{oneTask},
+		sealtasks.TTPreCommit1, sealtasks.TTPreCommit1)
+	acceptable, windows = addAssignerTestWindow(sh, acceptable, windows, 0)
+
+	scheduled := SpreadWS(false)(sh, len(acceptable), acceptable, windows)
+	require.Equal(t, 2, scheduled)
+
+	// both windows belong to the same worker, so the spread criterion ties;
+	// the second task must still go to the second window because the first
+	// one is already full
+	require.Len(t, windows[0].Todo, 1)
+	require.Len(t, windows[1].Todo, 1)
+	require.Equal(t, 1, windows[0].Allocated.taskCount(nil))
+	require.Equal(t, 1, windows[1].Allocated.taskCount(nil))
+}
+
+func TestProjectedUtilizationWS(t *testing.T) {
+	small := decentWorkerResources
+	small.MemPhysical = 64 << 30
+	small.CPUs = 16
+
+	workers := []storiface.WorkerResources{small, decentWorkerResources}
+	tasks := []sealtasks.TaskType{sealtasks.TTPreCommit1, sealtasks.TTAddPiece}
+
+	// spread only counts tasks, so the heavy PC1 lands on the first
+	// (small) worker, and the light AP on the big one
+	sh, acceptable, windows := newAssignerTestSched(t, workers, tasks...)
+	require.Equal(t, 2, SpreadWS(false)(sh, len(acceptable), acceptable, windows))
+	require.Equal(t, []sealtasks.TaskType{sealtasks.TTPreCommit1}, windowTasks(windows[0]))
+	require.Equal(t, []sealtasks.TaskType{sealtasks.TTAddPiece}, windowTasks(windows[1]))
+
+	// projected utilization puts PC1 where it uses the smallest fraction of
+	// the worker, leaving the small worker for the light AP
+	sh, acceptable, windows = newAssignerTestSched(t, workers, tasks...)
+	require.Equal(t, 2, ProjectedUtilizationWS(sh, len(acceptable), acceptable, windows))
+	require.Equal(t, []sealtasks.TaskType{sealtasks.TTAddPiece}, windowTasks(windows[0]))
+	require.Equal(t, []sealtasks.TaskType{sealtasks.TTPreCommit1}, windowTasks(windows[1]))
+}
+
+func TestAffinityWS(t *testing.T) {
+	ctx := context.Background()
+
+	index := paths.NewMemIndex(nil)
+	for _, id := range []storiface.ID{"w0-store", "w1-store"} {
+		require.NoError(t, index.StorageAttach(ctx, storiface.StorageInfo{
+			ID:      id,
+			Weight:  1,
+			CanSeal: true,
+		}, fsutil.FsStat{
+			Capacity:    1 << 40,
+			Available:   1 << 40,
+			FSAvailable: 1 << 40,
+		}))
+	}
+
+	setup := func(t *testing.T, workers ...storiface.WorkerResources) (*Scheduler, [][]int, []SchedWindow) {
+		sh, acceptable, windows := newAssignerTestSched(t, workers, sealtasks.TTPreCommit2)
+
+		for i := range workers {
+			sh.Workers[assignerTestWid(i)].workerRpc = &schedTestWorker{
+				paths: []storiface.StoragePath{{ID: storiface.ID(fmt.Sprintf("w%d-store", i)), CanSeal: true}},
+			}
+		}
+
+		return sh, acceptable, windows
+	}
+
+	// sector files are on the second worker
+	require.NoError(t, index.StorageDeclareSector(ctx, "w1-store", abi.SectorID{Miner: 1000, Number: 0}, storiface.FTSealed|storiface.FTCache, true))
+
+	t.Run("prefer-local", func(t *testing.T) {
+		sh, acceptable, windows := setup(t, decentWorkerResources, decentWorkerResources)
+		require.Equal(t, 1, AffinityWS(index, 1)(sh, len(acceptable), acceptable, windows))
+		require.Empty(t, windows[0].Todo)
+		require.Len(t, windows[1].Todo, 1)
+	})
+
+	t.Run("no-weight-is-spread", func(t *testing.T) {
+		sh, acceptable, windows := setup(t, decentWorkerResources, decentWorkerResources)
+		require.Equal(t, 1, AffinityWS(index, 0)(sh, len(acceptable), acceptable, windows))
+		require.Len(t, windows[0].Todo, 1)
+		require.Empty(t, windows[1].Todo)
+	})
+
+	t.Run("local-infeasible", func(t *testing.T) {
+		sh, acceptable, windows := setup(t, decentWorkerResources, constrainedWorkerResources)
+		require.Equal(t, 1, AffinityWS(index, 1)(sh, len(acceptable), acceptable, windows))
+		require.Len(t, windows[0].Todo, 1)
+		require.Empty(t, windows[1].Todo)
+	})
+}
+
+func TestCostAwareWS(t *testing.T) {
+	ctx := context.Background()
+
+	index := paths.NewMemIndex(nil)
+	for _, id := range []storiface.ID{"w0-store", "w1-store", "w2-store"} {
+		require.NoError(t, index.StorageAttach(ctx, storiface.StorageInfo{
+			ID:      id,
+			Weight:  1,
+			CanSeal: true,
+		}, fsutil.FsStat{
+			Capacity:    1 << 40,
+			Available:   1 << 40,
+			FSAvailable: 1 << 40,
+		}))
+	}
+
+	// sector inputs are on the last worker
+	require.NoError(t, index.StorageDeclareSector(ctx, "w2-store", abi.SectorID{Miner: 1000, Number: 0}, storiface.FTSealed|storiface.FTCache, true))
+
+	workers := []storiface.WorkerResources{decentWorkerResources, decentWorkerResources, decentWorkerResources}
+	sh, acceptable, windows := newAssignerTestSched(t, workers, sealtasks.TTPreCommit2, sealtasks.TTPreCommit2)
+	for i := range workers {
+		sh.Workers[assignerTestWid(i)].workerRpc = &schedTestWorker{
+			paths: []storiface.StoragePath{{ID: storiface.ID(fmt.Sprintf("w%d-store", i)), CanSeal: true}},
+		}
+	}
+
+	require.Equal(t, 2, CostAwareWS(TransferBytesCost(index))(sh, len(acceptable), acceptable, windows))
+
+	// sector 0 goes where its data is, sector 1 has no data anywhere so the
+	// least loaded worker wins
+	require.Len(t, windows[0].Todo, 1)
+	require.Equal(t, abi.SectorNumber(1), windows[0].Todo[0].Sector.ID.Number)
+	require.Empty(t, windows[1].Todo)
+	require.Len(t, windows[2].Todo, 1)
+	require.Equal(t, abi.SectorNumber(0), windows[2].Todo[0].Sector.ID.Number)
+}
+
+type countingAssigner struct {
+	calls int
+}
+
+func (c *countingAssigner) TrySched(sh *Scheduler) {
+	c.calls++
+}
+
+func TestAssignerRegistry(t *testing.T) {
+	custom := &countingAssigner{}
+	RegisterAssigner("test-counting", func() Assigner { return custom })
+
+	a, err := GetAssigner("test-counting")
+	require.NoError(t, err)
+	require.Same(t, custom, a)
+	require.Contains(t, AssignerNames(), "test-counting")
+
+	sh, err := newScheduler(context.Background(), "test-counting")
+	require.NoError(t, err)
+	sh.trySched()
+	require.Equal(t, 1, custom.calls)
+
+	for _, name := range []string{"", "utilization", "spread", "experiment-random"} {
+		a, err := GetAssigner(name)
+		require.NoError(t, err, name)
+		require.NotNil(t, a, name)
+	}
+
+	_, err = GetAssigner("no-such-assigner")
+	require.ErrorContains(t, err, "unknown assigner")
+
+	_, err = newScheduler(context.Background(), "no-such-assigner")
+	require.Error(t, err)
+}
+
+func TestSpreadGPUWS(t *testing.T) {
+	gpuWorker := decentWorkerResources
+	gpuWorker.GPUs = []string{"gpu0"}
+
+	workers := []storiface.WorkerResources{decentWorkerResources, gpuWorker}
+	tasks := []sealtasks.TaskType{sealtasks.TTCommit2, sealtasks.TTPreCommit1}
+
+	// plain spread puts C2 on the first (CPU-only) worker, and PC1 on the
+	// GPU worker
+	sh, acceptable, windows := newAssignerTestSched(t, workers, tasks...)
+	require.Equal(t, 2, SpreadWS(false)(sh, len(acceptable), acceptable, windows))
+	require.Equal(t, []sealtasks.TaskType{sealtasks.TTCommit2}, windowTasks(windows[0]))
+	require.Equal(t, []sealtasks.TaskType{sealtasks.TTPreCommit1}, windowTasks(windows[1]))
+
+	// GPU-aware spread sends C2 to the GPU and keeps PC1 on the CPU worker
+	sh, acceptable, windows = newAssignerTestSched(t, workers, tasks...)
+	require.Equal(t, 2, SpreadGPUWS(false)(sh, len(acceptable), acceptable, windows))
+	require.Equal(t, []sealtasks.TaskType{sealtasks.TTPreCommit1}, windowTasks(windows[0]))
+	require.Equal(t, []sealtasks.TaskType{sealtasks.TTCommit2}, windowTasks(windows[1]))
+}
+
+func TestSpreadWSOneGPUTaskPerGPU(t *testing.T) {
+	gpuWorker := decentWorkerResources
+	gpuWorker.GPUs = []string{"gpu0"}
+
+	// each window of the single-GPU worker fits a C2 on its own, but the
+	// worker can only take one of them in a pass
+	sh, acceptable, windows := newAssignerTestSched(t,
+		[]storiface.WorkerResources{gpuWorker},
+		sealtasks.TTCommit2, sealtasks.TTCommit2)
+	acceptable, windows = addAssignerTestWindow(sh, acceptable, windows, 0)
+
+	require.Equal(t, 1, SpreadWS(false)(sh, len(acceptable), acceptable, windows))
+	require.Len(t, windows[0].Todo, 1)
+	require.Empty(t, windows[1].Todo)
+	require.Equal(t, 1, sh.SchedQueue.Len(), "second C2 must wait for a later pass")
+
+	// with a second single-GPU worker the tasks split across workers
+	sh, acceptable, windows = newAssignerTestSched(t,
+		[]storiface.WorkerResources{gpuWorker, gpuWorker},
+		sealtasks.TTCommit2, sealtasks.TTCommit2)
+	acceptable, windows = addAssignerTestWindow(sh, acceptable, windows, 0)
+
+	require.Equal(t, 2, SpreadGPUWS(false)(sh, len(acceptable), acceptable, windows))
+	require.Equal(t, []sealtasks.TaskType{sealtasks.TTCommit2}, windowTasks(windows[0]))
+	require.Equal(t, []sealtasks.TaskType{sealtasks.TTCommit2}, windowTasks(windows[1]))
+	require.Empty(t, windows[2].Todo)
+}
+
+func TestSpreadWSTieBreak(t *testing.T) {
+	reverse := func(acceptable [][]int) {
+		for _, wnds := range acceptable {
+			for i, j := 0, len(wnds)-1; i < j; i, j = i+1, j-1 {
+				wnds[i], wnds[j] = wnds[j], wnds[i]
+			}
+		}
+	}
+
+	// equally loaded workers: the lowest worker ID wins regardless of the
+	// order of acceptable windows
+	sh, acceptable, windows := newAssignerTestSched(t,
+		[]storiface.WorkerResources{decentWorkerResources, decentWorkerResources, decentWorkerResources},
+		sealtasks.TTPreCommit1)
+	reverse(acceptable)
+
+	require.Equal(t, 1, SpreadWS(false)(sh, len(acceptable), acceptable, windows))
+	require.Len(t, windows[0].Todo, 1)
+	require.Empty(t, windows[1].Todo)
+	require.Empty(t, windows[2].Todo)
+
+	// windows of the same worker: the lowest window index wins
+	sh, acceptable, windows = newAssignerTestSched(t,
+		[]storiface.WorkerResources{decentWorkerResources},
+		sealtasks.TTPreCommit1)
+	acceptable, windows = addAssignerTestWindow(sh, acceptable, windows, 0)
+	reverse(acceptable)
+
+	require.Equal(t, 1, SpreadWS(false)(sh, len(acceptable), acceptable, windows))
+	require.Len(t, windows[0].Todo, 1)
+	require.Empty(t, windows[1].Todo)
+}
+
+func TestSpreadWSSelectorPreference(t *testing.T) {
+	// equally loaded workers: the worker the selector prefers wins over the
+	// lowest worker ID
+	sh, acceptable, windows := newAssignerTestSched(t,
+		[]storiface.WorkerResources{decentWorkerResources, decentWorkerResources, decentWorkerResources},
+		sealtasks.TTPreCommit1)
+	task := (*sh.SchedQueue)[0]
+	task.windowPrefs = map[int]int{2: 0, 0: 1, 1: 1}
+	acceptable[0] = []int{2, 1, 0}
+
+	require.Equal(t, 1, SpreadWS(false)(sh, len(acceptable), acceptable, windows))
+	require.Empty(t, windows[0].Todo)
+	require.Empty(t, windows[1].Todo)
+	require.Len(t, windows[2].Todo, 1)
+
+	// acceptable windows are left in selector order
+	require.Equal(t, []int{2, 1, 0}, acceptable[0])
+
+	// load still comes first: the second task goes to the lowest idle worker
+	// ID, not to the preferred one which got the first task
+	sh, acceptable, windows = newAssignerTestSched(t,
+		[]storiface.WorkerResources{decentWorkerResources, decentWorkerResources, decentWorkerResources},
+		sealtasks.TTAddPiece, sealtasks.TTAddPiece)
+	for _, w := range sh.Workers {
+		w.Info.IgnoreResources = true
+	}
+	for sqi := range acceptable {
+		(*sh.SchedQueue)[sqi].windowPrefs = map[int]int{2: 0, 0: 1, 1: 1}
+		acceptable[sqi] = []int{2, 1, 0}
+	}
+
+	require.Equal(t, 2, SpreadWS(false)(sh, len(acceptable), acceptable, windows))
+	require.Len(t, windows[0].Todo, 1)
+	require.Empty(t, windows[1].Todo)
+	require.Len(t, windows[2].Todo, 1)
+}
+
+func TestFairShareWS(t *testing.T) {
+	// one window with room for two 32G PC1s
+	tasks := []sealtasks.TaskType{sealtasks.TTPreCommit1, sealtasks.TTPreCommit1, sealtasks.TTPreCommit1, sealtasks.TTPreCommit1}
+
+	setup := func(t *testing.T) (*Scheduler, [][]int, []SchedWindow) {
+		sh, acceptable, windows := newAssignerTestSched(t, []storiface.WorkerResources{decentWorkerResources}, tasks...)
+
+		// SP 1000 queued a burst of three tasks ahead of a single task from SP 2000
+		(*sh.SchedQueue)[3].Sector.ID.Miner = 2000
+
+		return sh, acceptable, windows
+	}
+
+	windowSPs := func(w SchedWindow) []abi.ActorID {
+		var out []abi.ActorID
+		for _, r := range w.Todo {
+			out = append(out, r.Sector.ID.Miner)
+		}
+		return out
+	}
+
+	sh, acceptable, windows := setup(t)
+	require.Equal(t, 2, SpreadWS(false)(sh, len(acceptable), acceptable, windows))
+	require.Equal(t, []abi.ActorID{1000, 1000}, windowSPs(windows[0]))
+
+	sh, acceptable, windows = setup(t)
+	require.Equal(t, 2, FairShareWS(sh, len(acceptable), acceptable, windows))
+	require.Equal(t, []abi.ActorID{1000, 2000}, windowSPs(windows[0]))
+
+	// the unscheduled tasks are both from the bursting SP
+	require.Equal(t, 2, sh.SchedQueue.Len())
+	for _, r := range *sh.SchedQueue {
+		require.Equal(t, abi.ActorID(1000), r.Sector.ID.Miner)
+	}
+}
+
+func TestSpreadWSNoWindowMetric(t *testing.T) {
+	require.NoError(t, view.Register(metrics.SchedNoWindowSkipsView))
+	defer view.Unregister(metrics.SchedNoWindowSkipsView)
+
+	skips := func() int64 {
+		rows, err := view.RetrieveData(metrics.SchedNoWindowSkipsView.Name)
+		require.NoError(t, err)
+
+		var n int64
+		for _, row := range rows {
+			n += row.Data.(*view.CountData).Value
+		}
+		return n
+	}
+
+	// room for exactly one 32G PC1
+	oneTask := decentWorkerResources
+	oneTask.MemPhysical = 64 << 30
+
+	sh, acceptable, windows := newAssignerTestSched(t,
+		[]storiface.WorkerResources{oneTask},
+		sealtasks.TTPreCommit1, sealtasks.TTPreCommit1, sealtasks.TTPreCommit1)
+
+	before := skips()
+
+	require.Equal(t, 1, SpreadWS(false)(sh, len(acceptable), acceptable, windows))
+	require.Equal(t, before+2, skips())
+}
+
+func TestAssignerRejectionReasonMetric(t *testing.T) {
+	require.NoError(t, view.Register(metrics.SchedWindowRejectionsView))
+	defer view.Unregister(metrics.SchedWindowRejectionsView)
+
+	rejections := func() map[string]int64 {
+		rows, err := view.RetrieveData(metrics.SchedWindowRejectionsView.Name)
+		require.NoError(t, err)
+
+		out := map[string]int64{}
+		for _, row := range rows {
+			for _, tg := range row.Tags {
+				if tg.Key == metrics.SchedRejectReason {
+					out[tg.Value] += int64(row.Data.(*view.SumData).Value)
+				}
+			}
+		}
+		return out
+	}
+
+	// not enough memory for a 32G PC1
+	starved := decentWorkerResources
+	starved.MemPhysical = 16 << 30
+
+	sh, _, _ := newAssignerTestSched(t,
+		[]storiface.WorkerResources{starved, decentWorkerResources},
+		sealtasks.TTPreCommit1)
+	for _, task := range *sh.SchedQueue {
+		task.Sel = simSelector{}
+	}
+
+	before := rejections()
+
+	NewSpreadAssigner(false).TrySched(sh)
+
+	after := rejections()
+	require.Equal(t, before[schedRejectRAM]+1, after[schedRejectRAM])
+	for _, reason := range []string{schedRejectCPU, schedRejectGPU, schedRejectStorage, schedRejectCap, schedRejectPolicy} {
+		require.Equal(t, before[reason], after[reason], reason)
+	}
+
+	// the task went to the other worker
+	require.Len(t, sh.OpenWindows, 1)
+	require.Equal(t, assignerTestWid(0), sh.OpenWindows[0].Worker)
+}
+
+func TestPackWS(t *testing.T) {
+	// each worker has room for two 32G PC1s
+	sh, acceptable, windows := newAssignerTestSched(t,
+		[]storiface.WorkerResources{decentWorkerResources, decentWorkerResources, decentWorkerResources},
+		sealtasks.TTPreCommit1, sealtasks.TTPreCommit1, sealtasks.TTPreCommit1)
+
+	require.Equal(t, 3, PackWS(sh, len(acceptable), acceptable, windows))
+
+	// the first worker is filled before spilling over to the next one
+	require.Len(t, windows[0].Todo, 2)
+	require.Len(t, windows[1].Todo, 1)
+	require.Empty(t, windows[2].Todo)
+}
+
+func TestAssignerAntiAffinity(t *testing.T) {
+	_, err := parseAntiAffinity([]string{"PC1"})
+	require.Error(t, err)
+	_, err = parseAntiAffinity([]string{"PC1:XX"})
+	require.Error(t, err)
+
+	rules, err := parseAntiAffinity([]string{"PC1:PC1"})
+	require.NoError(t, err)
+
+	// without the rule the first worker takes two PC1s, see TestPackWS
+	sh, acceptable, windows := newAssignerTestSched(t,
+		[]storiface.WorkerResources{decentWorkerResources, decentWorkerResources, decentWorkerResources},
+		sealtasks.TTPreCommit1, sealtasks.TTPreCommit1)
+	sh.antiAffinity = rules
+
+	require.Equal(t, 2, PackWS(sh, len(acceptable), acceptable, windows))
+	require.Len(t, windows[0].Todo, 1)
+	require.Len(t, windows[1].Todo, 1)
+	require.Empty(t, windows[2].Todo)
+
+	// tasks already running on a worker count too
+	sh, acceptable, windows = newAssignerTestSched(t,
+		[]storiface.WorkerResources{decentWorkerResources, decentWorkerResources},
+		sealtasks.TTPreCommit1)
+	sh.antiAffinity = rules
+	sh.Workers[assignerTestWid(0)].active.taskCounters.Add(sealtasks.SealTaskType{TaskType: sealtasks.TTPreCommit1, RegisteredSealProof: assignerTestSpt}, uuid.New())
+
+	require.Equal(t, 1, PackWS(sh, len(acceptable), acceptable, windows))
+	require.Empty(t, windows[0].Todo)
+	require.Len(t, windows[1].Todo, 1)
+
+	// other task types aren't affected
+	sh, acceptable, windows = newAssignerTestSched(t,
+		[]storiface.WorkerResources{decentWorkerResources},
+		sealtasks.TTPreCommit1, sealtasks.TTAddPiece)
+	sh.antiAffinity = rules
+
+	require.Equal(t, 2, PackWS(sh, len(acceptable), acceptable, windows))
+	require.Len(t, windows[0].Todo, 2)
+}
+
+func TestAssignerTaskTypeCaps(t *testing.T) {
+	_, err := parseTaskTypeCaps(map[string]int{"XX": 1})
+	require.Error(t, err)
+	_, err = parseTaskTypeCaps(map[string]int{"PC1": -1})
+	require.Error(t, err)
+
+	caps, err := parseTaskTypeCaps(map[string]int{"PC1": 2})
+	require.NoError(t, err)
+
+	newSched := func(tasks ...sealtasks.TaskType) (*Scheduler, [][]int, []SchedWindow) {
+		sh, acceptable, windows := newAssignerTestSched(t,
+			[]storiface.WorkerResources{decentWorkerResources, decentWorkerResources}, tasks...)
+		sh.taskTypeCaps = caps
+		// resources would fit any number of tasks, only the cap limits them
+		for _, w := range sh.Workers {
+			w.Info.IgnoreResources = true
+		}
+		return sh, acceptable, windows
+	}
+
+	// the first worker would take all PC1s, see TestPackWS
+	sh, acceptable, windows := newSched(sealtasks.TTPreCommit1, sealtasks.TTPreCommit1, sealtasks.TTPreCommit1)
+	require.Equal(t, 3, PackWS(sh, len(acceptable), acceptable, windows))
+	require.Len(t, windows[0].Todo, 2)
+	require.Len(t, windows[1].Todo, 1, "the third PC1 must not land on a worker at its PC1 cap")
+
+	// tasks already running on a worker count too
+	sh, acceptable, windows = newSched(sealtasks.TTPreCommit1, sealtasks.TTPreCommit1)
+	sh.Workers[assignerTestWid(0)].active.taskCounters.Add(sealtasks.SealTaskType{TaskType: sealtasks.TTPreCommit1, RegisteredSealProof: assignerTestSpt}, uuid.New())
+	require.Equal(t, 2, PackWS(sh, len(acceptable), acceptable, windows))
+	require.Len(t, windows[0].Todo, 1)
+	require.Len(t, windows[1].Todo, 1)
+
+	// other task types aren't limited
+	sh, acceptable, windows = newSched(sealtasks.TTPreCommit1, sealtasks.TTPreCommit1, sealtasks.TTAddPiece)
+	require.Equal(t, 3, PackWS(sh, len(acceptable), acceptable, windows))
+	require.Len(t, windows[0].Todo, 3)
+}
+
+func TestAssignerMaintenanceWindows(t *testing.T) {
+	_, err := parseMaintenanceSchedules(map[string][]string{"w": {"02:00"}})
+	require.Error(t, err)
+	_, err = parseMaintenanceSchedules(map[string][]string{"w": {"02:00/25h"}})
+	require.Error(t, err)
+	_, err = parseTaskDurations(map[string]config.Duration{"XX": config.Duration(time.Hour)})
+	require.Error(t, err)
+
+	daily, err := parseMaintenanceSchedules(map[string][]string{"w": {"02:00/1h"}})
+	require.NoError(t, err)
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	require.False(t, daily["w"][0].overlaps(day, day.Add(time.Hour)))
+	require.True(t, daily["w"][0].overlaps(day, day.Add(3*time.Hour)))
+	require.True(t, daily["w"][0].overlaps(day.Add(150*time.Minute), day.Add(150*time.Minute)))
+	require.False(t, daily["w"][0].overlaps(day.Add(4*time.Hour), day.Add(20*time.Hour)))
+	require.True(t, daily["w"][0].overlaps(day.Add(4*time.Hour), day.Add(27*time.Hour)))
+
+	durations, err := parseTaskDurations(map[string]config.Duration{
+		"PC1": config.Duration(4 * time.Hour),
+		"AP":  config.Duration(10 * time.Minute),
+	})
+	require.NoError(t, err)
+
+	sh, acceptable, windows := newAssignerTestSched(t,
+		[]storiface.WorkerResources{decentWorkerResources, decentWorkerResources},
+		sealtasks.TTPreCommit1, sealtasks.TTAddPiece)
+	for _, w := range sh.Workers {
+		w.Info.IgnoreResources = true
+	}
+
+	// the first worker goes down for maintenance in an hour
+	sh.maintenance, err = parseMaintenanceSchedules(map[string][]string{
+		assignerTestWid(0).String(): {time.Now().Add(time.Hour).UTC().Format(time.RFC3339) + "/2h"},
+	})
+	require.NoError(t, err)
+	sh.taskDurations = durations
+
+	// the first worker would take both tasks, see TestPackWS, but the PC1
+	// wouldn't be done before the maintenance
+	require.Equal(t, 2, PackWS(sh, len(acceptable), acceptable, windows))
+	require.Len(t, windows[0].Todo, 1)
+	require.Equal(t, sealtasks.TTAddPiece, windows[0].Todo[0].TaskType)
+	require.Len(t, windows[1].Todo, 1)
+	require.Equal(t, sealtasks.TTPreCommit1, windows[1].Todo[0].TaskType)
+}
+
+// fixedPlacementServer is a placement service returning fixed assignments
+type fixedPlacementServer struct {
+	assignments []ExternalAssignment
+	requests    []*ExternalAssignRequest
+
+	// onAssign is called on each call, if set
+	onAssign func()
+}
+
+func (f *fixedPlacementServer) Assign(_ context.Context, req *ExternalAssignRequest) (*ExternalAssignResponse, error) {
+	f.requests = append(f.requests, req)
+	if f.onAssign != nil {
+		f.onAssign()
+	}
+	return &ExternalAssignResponse{Assignments: f.assignments}, nil
+}
+
+func TestExternalAssigner(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	// both tasks to the second worker, and an invalid window for the first
+	srv := &fixedPlacementServer{assignments: []ExternalAssignment{{Task: 0, Window: 1}, {Task: 1, Window: 1}, {Task: 0, Window: 5}}}
+	gs := grpc.NewServer(ExternalPlacementServerCodec())
+	RegisterExternalPlacementServer(gs, srv)
+	go func() {
+		_ = gs.Serve(lis)
+	}()
+	defer gs.Stop()
+
+	newSched := func() (*Scheduler, [][]int, []SchedWindow) {
+		sh, acceptable, windows := newAssignerTestSched(t,
+			[]storiface.WorkerResources{decentWorkerResources, decentWorkerResources},
+			sealtasks.TTPreCommit1, sealtasks.TTPreCommit1)
+		for _, w := range sh.Workers {
+			w.Info.IgnoreResources = true
+		}
+		return sh, acceptable, windows
+	}
+
+	a, err := NewExternalAssigner(lis.Addr().String())
+	require.NoError(t, err)
+	ws := func(sh *Scheduler, queueLen int, acceptableWindows [][]int, windows []SchedWindow) int {
+		// window selectors run with the workers lock held, see trySched
+		sh.workersLk.RLock()
+		defer sh.workersLk.RUnlock()
+		return a.(*AssignerCommon).WindowSel(sh, queueLen, acceptableWindows, windows)
+	}
+
+	sh, acceptable, windows := newSched()
+	require.Equal(t, 2, ws(sh, len(acceptable), acceptable, windows))
+	require.Empty(t, windows[0].Todo)
+	require.Len(t, windows[1].Todo, 2)
+	require.Zero(t, sh.SchedQueue.Len())
+
+	require.Len(t, srv.requests, 1)
+	require.Len(t, srv.requests[0].Tasks, 2)
+	require.Equal(t, []int{0, 1}, srv.requests[0].Tasks[0].Candidates)
+	require.Len(t, srv.requests[0].Windows, 2)
+	require.Equal(t, assignerTestWid(1), srv.requests[0].Windows[1].Worker)
+
+	// the JSON codec is only used for placement calls
+	require.Nil(t, encoding.GetCodec("json"))
+
+	// the workers lock is released during the call, assignments to a worker
+	// which went away meanwhile are skipped
+	sh, acceptable, windows = newSched()
+	srv.onAssign = func() {
+		sh.workersLk.Lock()
+		delete(sh.Workers, assignerTestWid(1))
+		sh.workersLk.Unlock()
+	}
+	require.Equal(t, 0, ws(sh, len(acceptable), acceptable, windows))
+	require.Empty(t, windows[1].Todo)
+	require.Equal(t, 2, sh.SchedQueue.Len())
+	srv.onAssign = nil
+
+	// with the service gone tasks are spread locally
+	gs.Stop()
+	sh, acceptable, windows = newSched()
+	require.Equal(t, 2, ws(sh, len(acceptable), acceptable, windows))
+	require.Len(t, windows[0].Todo, 1)
+	require.Len(t, windows[1].Todo, 1)
+}
+
+func TestExternalAssignerSkips(t *testing.T) {
+	sh, acceptable, windows := newAssignerTestSched(t,
+		[]storiface.WorkerResources{decentWorkerResources},
+		sealtasks.TTPreCommit1, sealtasks.TTPreCommit1, sealtasks.TTPreCommit1, sealtasks.TTPreCommit1)
+	for _, w := range sh.Workers {
+		w.Info.IgnoreResources = true
+	}
+	sh.maxWorkerTasks = 1
+
+	tasks := append([]*WorkerRequest(nil), (*sh.SchedQueue)...)
+
+	// no window can run the last task
+	acceptable[3] = nil
+
+	// the second task is left queued by the service, the third doesn't fit the
+	// window once the first is assigned to it
+	require.Equal(t, 1, applyExternalAssignments(sh, len(acceptable), acceptable, windows,
+		[]ExternalAssignment{{Task: 0, Window: 0}, {Task: 2, Window: 0}}))
+	require.Len(t, windows[0].Todo, 1)
+	require.Equal(t, 3, sh.SchedQueue.Len())
+
+	require.Equal(t, 0, tasks[1].skipped)
+	require.Equal(t, 1, tasks[2].skipped)
+	require.Equal(t, 1, tasks[3].skipped)
+}
+
+func TestExternalAssignerLimits(t *testing.T) {
+	gpuWorker := decentWorkerResources
+	gpuWorker.GPUs = []string{"gpu0"}
+
+	// each window of the single-GPU worker fits a C2, but the worker only
+	// takes one GPU task in a pass, see TestSpreadWSOneGPUTaskPerGPU
+	sh, acceptable, windows := newAssignerTestSched(t,
+		[]storiface.WorkerResources{gpuWorker},
+		sealtasks.TTCommit2, sealtasks.TTCommit2)
+	acceptable, windows = addAssignerTestWindow(sh, acceptable, windows, 0)
+
+	require.Equal(t, 1, applyExternalAssignments(sh, len(acceptable), acceptable, windows,
+		[]ExternalAssignment{{Task: 0, Window: 0}, {Task: 1, Window: 1}}))
+	require.Len(t, windows[0].Todo, 1)
+	require.Empty(t, windows[1].Todo)
+	require.Equal(t, 1, sh.SchedQueue.Len())
+
+	// resources reserved for C2 aren't used for PC1, see
+	// TestSpreadWSCapacityReservation
+	reservation, err := parseCapacityReservation(0.5, []string{"C2"})
+	require.NoError(t, err)
+
+	sh, acceptable, windows = newAssignerTestSched(t, []storiface.WorkerResources{decentWorkerResources},
+		sealtasks.TTPreCommit1, sealtasks.TTPreCommit1, sealtasks.TTPreCommit1)
+	sh.reservation = reservation
+
+	require.Equal(t, 1, applyExternalAssignments(sh, len(acceptable), acceptable, windows,
+		[]ExternalAssignment{{Task: 0, Window: 0}, {Task: 1, Window: 0}, {Task: 2, Window: 0}}))
+	require.Len(t, windows[0].Todo, 1)
+}
+
+func TestAssignersRespectPriority(t *testing.T) {
+	// room for exactly one 32G PC1
+	oneTask := decentWorkerResources
+	oneTask.MemPhysical = 64 << 30
+
+	for name, ws := range map[string]WindowSelector{
+		"utilization": LowestUtilizationWS,
+		"spread":      SpreadWS(false),
+		"spread-gpu":  SpreadGPUWS(false),
+		"fair-share":  FairShareWS,
+		"pack":        PackWS,
+	} {
+		t.Run(name, func(t *testing.T) {
+			sh, acceptable, windows := newAssignerTestSched(t,
+				[]storiface.WorkerResources{oneTask},
+				sealtasks.TTPreCommit1, sealtasks.TTPreCommit1, sealtasks.TTPreCommit1)
+
+			// the last submitted sector is urgent
+			for _, r := range *sh.SchedQueue {
+				if r.Sector.ID.Number == 2 {
+					r.Priority = 10
+				}
+			}
+			sort.Sort(sh.SchedQueue)
+			for sqi, r := range *sh.SchedQueue {
+				r.IndexHeap = sqi
+			}
+
+			require.Equal(t, 1, ws(sh, len(acceptable), acceptable, windows))
+			require.Len(t, windows[0].Todo, 1)
+			require.Equal(t, abi.SectorNumber(2), windows[0].Todo[0].Sector.ID.Number)
+		})
+	}
+}
+
+func TestAssignerCheckSpace(t *testing.T) {
+	ctx := context.Background()
+
+	index := paths.NewMemIndex(nil)
+	for id, avail := range map[storiface.ID]int64{
+		"w0-seal": 0, // out of space
+		"w1-seal": 1 << 40,
+	} {
+		require.NoError(t, index.StorageAttach(ctx, storiface.StorageInfo{
+			ID:      id,
+			Weight:  1,
+			CanSeal: true,
+		}, fsutil.FsStat{
+			Capacity:    1 << 40,
+			Available:   avail,
+			FSAvailable: avail,
+		}))
+	}
+
+	run := func(t *testing.T, checkSpace bool) (*Scheduler, []SchedWindowRequest) {
+		workers := []storiface.WorkerResources{decentWorkerResources, decentWorkerResources}
+		sh, _, _ := newAssignerTestSched(t, workers, sealtasks.TTPreCommit1)
+		sh.traceAssign = true
+
+		for i := range workers {
+			sh.Workers[assignerTestWid(i)].workerRpc = &schedTestWorker{
+				taskTypes: map[sealtasks.TaskType]struct{}{sealtasks.TTPreCommit1: {}},
+				paths:     []storiface.StoragePath{{ID: storiface.ID(fmt.Sprintf("w%d-seal", i)), CanSeal: true}},
+			}
+		}
+		(*sh.SchedQueue)[0].Sel = newTaskSelector()
+
+		if checkSpace {
+			sh.spaceIndex = index
+		}
+
+		var scheduled []SchedWindowRequest
+		for _, wr := range sh.OpenWindows {
+			scheduled = append(scheduled, *wr)
+		}
+
+		NewSpreadAssigner(false).TrySched(sh)
+		return sh, scheduled
+	}
+
+	gotTask := func(wr SchedWindowRequest) bool {
+		select {
+		case w := <-wr.Done:
+			return len(w.Todo) == 1
+		default:
+			return false
+		}
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		_, wrs := run(t, false)
+		require.True(t, gotTask(wrs[0]))
+		require.False(t, gotTask(wrs[1]))
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		sh, wrs := run(t, true)
+		require.False(t, gotTask(wrs[0]))
+		require.True(t, gotTask(wrs[1]))
+
+		trace := sh.diag().Trace
+		require.Len(t, trace, 1)
+		require.Contains(t, trace[0].Windows, SchedTraceWindow{Window: 0, Worker: assignerTestWid(0), Rejected: SchedRejectStorage})
+	})
+}
+
+func TestAssignerPathHints(t *testing.T) {
+	ctx := context.Background()
+
+	index := paths.NewMemIndex(nil)
+	for id, p := range map[storiface.ID]struct {
+		group string
+		avail int64
+	}{
+		"nvme":  {"fast", 1 << 40},
+		"hdd-1": {"slow", 4 << 40},
+		"hdd-2": {"slow", 2 << 40},
+		"other": {"fast", 8 << 40}, // not attached to the worker
+	} {
+		require.NoError(t, index.StorageAttach(ctx, storiface.StorageInfo{
+			ID:      id,
+			Weight:  1,
+			CanSeal: true,
+			Groups:  []storiface.Group{p.group},
+		}, fsutil.FsStat{
+			Capacity:    8 << 40,
+			Available:   p.avail,
+			FSAvailable: p.avail,
+		}))
+	}
+
+	runOn := func(t *testing.T, local bool, task sealtasks.TaskType, tiers ...string) storiface.ID {
+		sh, _, _ := newAssignerTestSched(t, []storiface.WorkerResources{decentWorkerResources}, task)
+		sh.Workers[assignerTestWid(0)].local = local
+		sh.Workers[assignerTestWid(0)].workerRpc = &schedTestWorker{
+			taskTypes: map[sealtasks.TaskType]struct{}{task: {}},
+			paths:     []storiface.StoragePath{{ID: "nvme", CanSeal: true}, {ID: "hdd-1", CanSeal: true}, {ID: "hdd-2", CanSeal: true}},
+		}
+		(*sh.SchedQueue)[0].Sel = newTaskSelector()
+		sh.pathIndex = index
+		sh.pathTiers = tiers
+
+		wr := sh.OpenWindows[0]
+		NewSpreadAssigner(false).TrySched(sh)
+
+		w := <-wr.Done
+		require.Len(t, w.Todo, 1)
+		return w.Todo[0].PathHint
+	}
+	run := func(t *testing.T, task sealtasks.TaskType, tiers ...string) storiface.ID {
+		return runOn(t, true, task, tiers...)
+	}
+
+	// without tiers the path with most free space is picked
+	require.Equal(t, storiface.ID("hdd-1"), run(t, sealtasks.TTPreCommit1))
+	require.Equal(t, storiface.ID("nvme"), run(t, sealtasks.TTPreCommit1, "fast", "slow"))
+	require.Equal(t, storiface.ID("hdd-1"), run(t, sealtasks.TTPreCommit1, "slow", "fast"))
+	require.Equal(t, storiface.ID("hdd-1"), run(t, sealtasks.TTPreCommit1, "unknown"))
+
+	// tasks which don't allocate files get no hint
+	require.Equal(t, storiface.ID(""), run(t, sealtasks.TTPreCommit2, "fast"))
+
+	// remote workers don't see hints passed in the task context
+	require.Equal(t, storiface.ID(""), runOn(t, false, sealtasks.TTPreCommit1, "fast"))
+}
+
+func TestWorkerFault(t *testing.T) {
+	ctx := context.Background()
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	require.False(t, workerFault(ctx, nil))
+
+	// the worker or the connection to it failed
+	require.True(t, workerFault(ctx, xerrors.Errorf("RPC client error: sendRequest failed")))
+	require.True(t, workerFault(ctx, storiface.Err(storiface.ErrTempWorkerRestart, xerrors.New("worker restarted"))))
+	require.True(t, workerFault(ctx, xerrors.Errorf("wrapped: %w", storiface.Err(storiface.ErrTempAllocateSpace, xerrors.New("no space")))))
+
+	// the task was cancelled, or failed on the worker for the sector
+	require.False(t, workerFault(cancelled, xerrors.New("anything")))
+	require.False(t, workerFault(ctx, xerrors.Errorf("waiting: %w", context.Canceled)))
+	require.False(t, workerFault(ctx, xerrors.Errorf("acquire: %w", storiface.ErrSectorNotFound)))
+	require.False(t, workerFault(ctx, storiface.Err(storiface.ErrUnknown, xerrors.New("pc1 failed: bad piece data"))))
+}
+
+func TestSpreadWSWorkerHealth(t *testing.T) {
+	run := func(t *testing.T, report func(sh *Scheduler)) []SchedWindow {
+		sh, acceptable, windows := newAssignerTestSched(t,
+			[]storiface.WorkerResources{decentWorkerResources, decentWorkerResources}, sealtasks.TTAddPiece)
+		report(sh)
+		require.Equal(t, 1, SpreadWS(false)(sh, len(acceptable), acceptable, windows))
+		return windows
+	}
+
+	// ties go to the first worker
+	windows := run(t, func(sh *Scheduler) {})
+	require.Len(t, windows[0].Todo, 1)
+
+	// recent failures deprioritize it
+	windows = run(t, func(sh *Scheduler) {
+		sh.ReportTaskOutcome(assignerTestWid(0), false)
+	})
+	require.Len(t, windows[0].Todo, 0)
+	require.Len(t, windows[1].Todo, 1)
+
+	// failures decay back to healthy
+	windows = run(t, func(sh *Scheduler) {
+		sh.health.report(assignerTestWid(0), false, time.Now().Add(-10*workerHealthHalfLife))
+	})
+	require.Len(t, windows[0].Todo, 1)
+
+	// successes restore health
+	windows = run(t, func(sh *Scheduler) {
+		sh.ReportTaskOutcome(assignerTestWid(0), false)
+		for i := 0; i < 8; i++ {
+			sh.ReportTaskOutcome(assignerTestWid(0), true)
+		}
+	})
+	require.Len(t, windows[0].Todo, 1)
+}
+
+func TestSpreadWSWarmWorkers(t *testing.T) {
+	run := func(t *testing.T, warmup time.Duration, joined ...time.Time) []SchedWindow {
+		sh, acceptable, windows := newAssignerTestSched(t,
+			[]storiface.WorkerResources{decentWorkerResources, decentWorkerResources}, sealtasks.TTPreCommit2)
+		sh.warmupPeriod = warmup
+		for i, j := range joined {
+			sh.Workers[assignerTestWid(i)].joined = j
+		}
+		require.Equal(t, 1, SpreadWS(false)(sh, len(acceptable), acceptable, windows))
+		return windows
+	}
+
+	now := time.Now()
+
+	// the first worker just joined, the second is established
+	windows := run(t, time.Hour, now, now.Add(-2*time.Hour))
+	require.Empty(t, windows[0].Todo)
+	require.Len(t, windows[1].Todo, 1)
+
+	// without a warmup period ties go to the first worker
+	windows = run(t, 0, now, now.Add(-2*time.Hour))
+	require.Len(t, windows[0].Todo, 1)
+
+	// warmth only breaks ties, a less loaded cold worker still wins
+	sh, acceptable, windows := newAssignerTestSched(t,
+		[]storiface.WorkerResources{decentWorkerResources, decentWorkerResources}, sealtasks.TTPreCommit2)
+	sh.warmupPeriod = time.Hour
+	sh.Workers[assignerTestWid(0)].joined = now
+	sh.ReportTaskOutcome(assignerTestWid(1), false)
+	require.Equal(t, 1, SpreadWS(false)(sh, len(acceptable), acceptable, windows))
+	require.Len(t, windows[0].Todo, 1)
+}
+
+func TestSpreadWSPreferProofMatch(t *testing.T) {
+	run := func(t *testing.T, prefer bool) []SchedWindow {
+		sh, acceptable, windows := newAssignerTestSched(t,
+			[]storiface.WorkerResources{decentWorkerResources, decentWorkerResources}, sealtasks.TTPreCommit1)
+		sh.preferProofMatch = prefer
+
+		// the second worker recently ran a task of the same proof type
+		sh.proofRecency.record(assignerTestWid(0), abi.RegisteredSealProof_StackedDrg64GiBV1_1)
+		sh.proofRecency.record(assignerTestWid(1), assignerTestSpt)
+
+		require.Equal(t, 1, SpreadWS(false)(sh, len(acceptable), acceptable, windows))
+		return windows
+	}
+
+	windows := run(t, true)
+	require.Empty(t, windows[0].Todo)
+	require.Len(t, windows[1].Todo, 1)
+
+	// without the preference ties go to the first worker
+	windows = run(t, false)
+	require.Len(t, windows[0].Todo, 1)
+
+	// the match only breaks ties, a less loaded worker still wins
+	sh, acceptable, windows := newAssignerTestSched(t,
+		[]storiface.WorkerResources{decentWorkerResources, decentWorkerResources}, sealtasks.TTPreCommit1)
+	sh.preferProofMatch = true
+	sh.proofRecency.record(assignerTestWid(1), assignerTestSpt)
+	sh.ReportTaskOutcome(assignerTestWid(1), false)
+	require.Equal(t, 1, SpreadWS(false)(sh, len(acceptable), acceptable, windows))
+	require.Len(t, windows[0].Todo, 1)
+
+	// only the most recent proof types are remembered
+	var r workerProofRecency
+	wid := assignerTestWid(0)
+	r.record(wid, abi.RegisteredSealProof_StackedDrg32GiBV1_1)
+	r.record(wid, abi.RegisteredSealProof_StackedDrg64GiBV1_1)
+	r.record(wid, abi.RegisteredSealProof_StackedDrg64GiBV1_1)
+	require.True(t, r.matches(wid, abi.RegisteredSealProof_StackedDrg32GiBV1_1))
+	r.record(wid, abi.RegisteredSealProof_StackedDrg2KiBV1_1)
+	require.False(t, r.matches(wid, abi.RegisteredSealProof_StackedDrg32GiBV1_1))
+	require.True(t, r.matches(wid, abi.RegisteredSealProof_StackedDrg64GiBV1_1))
+}
+
+func TestDomainSpreadWS(t *testing.T) {
+	workers := []storiface.WorkerResources{decentWorkerResources, decentWorkerResources, decentWorkerResources, decentWorkerResources}
+
+	// three workers in one rack, one in another
+	domains := map[string]string{
+		assignerTestWid(0).String(): "rack-a",
+		assignerTestWid(1).String(): "rack-a",
+		assignerTestWid(2).String(): "rack-a",
+		assignerTestWid(3).String(): "rack-b",
+	}
+
+	// plain spreading fills the first rack
+	sh, acceptable, windows := newAssignerTestSched(t, workers, sealtasks.TTPreCommit2, sealtasks.TTPreCommit2)
+	sh.workerDomains = domains
+	require.Equal(t, 2, SpreadWS(false)(sh, len(acceptable), acceptable, windows))
+	require.Len(t, windows[0].Todo, 1)
+	require.Len(t, windows[1].Todo, 1)
+	require.Empty(t, windows[3].Todo)
+
+	sh, acceptable, windows = newAssignerTestSched(t, workers, sealtasks.TTPreCommit2, sealtasks.TTPreCommit2)
+	sh.workerDomains = domains
+	require.Equal(t, 2, DomainSpreadWS(false)(sh, len(acceptable), acceptable, windows))
+	require.Len(t, windows[0].Todo, 1)
+	require.Len(t, windows[3].Todo, 1, "tasks must spread across domains")
+
+	// unlabelled workers are each in a domain of their own
+	sh, acceptable, windows = newAssignerTestSched(t, workers[:2], sealtasks.TTPreCommit2, sealtasks.TTPreCommit2)
+	require.Equal(t, 2, DomainSpreadWS(false)(sh, len(acceptable), acceptable, windows))
+	require.Len(t, windows[0].Todo, 1)
+	require.Len(t, windows[1].Todo, 1)
+}
+
+func TestSpreadWSCapacityReservation(t *testing.T) {
+	run := func(t *testing.T, reservation *capacityReservation) (int, bool) {
+		sh, acceptable, windows := newAssignerTestSched(t, []storiface.WorkerResources{decentWorkerResources},
+			sealtasks.TTPreCommit1, sealtasks.TTPreCommit1, sealtasks.TTPreCommit1)
+		sh.reservation = reservation
+
+		scheduled := SpreadWS(false)(sh, len(acceptable), acceptable, windows)
+
+		// whether a C2 task still fits on the saturated worker
+		c2 := &WorkerRequest{Sector: storiface.SectorRef{ProofType: assignerTestSpt}, TaskType: sealtasks.TTCommit2}
+		w := sh.Workers[assignerTestWid(0)]
+		res := sh.resourceSpec(w, c2)
+		fits := windows[0].Allocated.CanHandleRequest(c2.SchedId, c2.SealTask(), res, assignerTestWid(0), "test", w.Info) &&
+			!sh.intoReserved(c2, &windows[0].Allocated, res, w.Info)
+
+		return scheduled, fits
+	}
+
+	// without a reservation PC1 tasks take all memory
+	scheduled, fits := run(t, nil)
+	require.Equal(t, 2, scheduled)
+	require.False(t, fits)
+
+	reservation, err := parseCapacityReservation(0.5, []string{"C2"})
+	require.NoError(t, err)
+
+	scheduled, fits = run(t, reservation)
+	require.Equal(t, 1, scheduled)
+	require.True(t, fits)
+
+	_, err = parseCapacityReservation(0.5, []string{"XX"})
+	require.Error(t, err)
+	_, err = parseCapacityReservation(1, []string{"C2"})
+	require.Error(t, err)
+}
+
+func TestSpreadWSWorkerWeights(t *testing.T) {
+	tasks := make([]sealtasks.TaskType, 8)
+	for i := range tasks {
+		tasks[i] = sealtasks.TTAddPiece
+	}
+
+	sh, acceptable, windows := newAssignerTestSched(t,
+		[]storiface.WorkerResources{decentWorkerResources, decentWorkerResources}, tasks...)
+	for _, w := range sh.Workers {
+		w.Info.IgnoreResources = true
+	}
+	sh.workerWeights = map[string]float64{
+		assignerTestWid(1).String(): 3,
+	}
+
+	require.Equal(t, len(tasks), SpreadWS(false)(sh, len(acceptable), acceptable, windows))
+	require.Len(t, windows[0].Todo, 2)
+	require.Len(t, windows[1].Todo, 6)
+}
+
+func TestSpreadWSResourceOverrides(t *testing.T) {
+	run := func(t *testing.T, overrides map[string]string) []SchedWindow {
+		sh, acceptable, windows := newAssignerTestSched(t,
+			[]storiface.WorkerResources{decentWorkerResources},
+			sealtasks.TTAddPiece, sealtasks.TTAddPiece)
+
+		var err error
+		sh.resourceOverrides, err = parseResourceOverrides(overrides)
+		require.NoError(t, err)
+
+		SpreadWS(false)(sh, len(acceptable), acceptable, windows)
+		return windows
+	}
+
+	// both tasks fit the worker with the default resource table
+	require.Len(t, run(t, nil)[0].Todo, 2)
+
+	// with more memory reserved for AddPiece only one does
+	windows := run(t, map[string]string{
+		"AP_MIN_MEMORY": fmt.Sprint(100 << 30),
+		"AP_MAX_MEMORY": fmt.Sprint(100 << 30),
+	})
+	require.Len(t, windows[0].Todo, 1)
+
+	// overrides of other task types don't apply
+	require.Len(t, run(t, map[string]string{"PC2_MIN_MEMORY": fmt.Sprint(100 << 30)})[0].Todo, 2)
+}
+
+func TestParseResourceOverrides(t *testing.T) {
+	ro, err := parseResourceOverrides(map[string]string{
+		"PC2_MIN_MEMORY":      "1024",
+		"PC2_GPU_UTILIZATION": "0.5",
+	})
+	require.NoError(t, err)
+
+	w := &WorkerHandle{Info: storiface.WorkerInfo{Resources: decentWorkerResources}}
+	task := &WorkerRequest{Sector: storiface.SectorRef{ProofType: assignerTestSpt}, TaskType: sealtasks.TTPreCommit2}
+	def := decentWorkerResources.ResourceSpec(assignerTestSpt, sealtasks.TTPreCommit2)
+
+	res := (&Scheduler{resourceOverrides: ro}).resourceSpec(w, task)
+	require.EqualValues(t, 1024, res.MinMemory)
+	require.Equal(t, 0.5, res.GPUUtilization)
+	require.Equal(t, def.MaxMemory, res.MaxMemory)
+
+	for key, val := range map[string]string{
+		"PC2":                 "1",
+		"XX_MIN_MEMORY":       "1",
+		"PC2_MIN_MEM":         "1",
+		"PC2_MAX_PARALLELISM": "x",
+	} {
+		_, err := parseResourceOverrides(map[string]string{key: val})
+		require.Error(t, err, key)
+	}
+}
+
+func TestSpreadWSFullWindows(t *testing.T) {
+	sh, acceptable, windows := newAssignerTestSched(t,
+		[]storiface.WorkerResources{decentWorkerResources},
+		sealtasks.TTPreCommit2, sealtasks.TTPreCommit2, sealtasks.TTAddPiece, sealtasks.TTPreCommit2, sealtasks.TTAddPiece)
+
+	// a task without acceptable windows
+	acceptable[4] = nil
+
+	// the first PC2 uses most CPUs of the worker, so other PC2 tasks are
+	// skipped, but AddPiece, which needs a single core, still fits
+	require.Equal(t, 2, SpreadWS(false)(sh, len(acceptable), acceptable, windows))
+	require.Equal(t, []sealtasks.TaskType{sealtasks.TTPreCommit2, sealtasks.TTAddPiece}, windowTasks(windows[0]))
+	require.Equal(t, 3, sh.SchedQueue.Len())
+}
+
+func TestSpreadWSQueuedLoad(t *testing.T) {
+	setup := func(t *testing.T) (*Scheduler, [][]int, []SchedWindow) {
+		sh, acceptable, windows := newAssignerTestSched(t,
+			[]storiface.WorkerResources{decentWorkerResources, decentWorkerResources},
+			sealtasks.TTPreCommit1)
+
+		// the first worker accepted two AddPiece tasks in an earlier pass,
+		// which haven't started yet
+		busy := sh.Workers[assignerTestWid(0)]
+		wnd := &SchedWindow{Allocated: *NewActiveResources(newTaskCounter())}
+		for i := 0; i < 2; i++ {
+			res := busy.Info.Resources.ResourceSpec(assignerTestSpt, sealtasks.TTAddPiece)
+			wnd.Allocated.Add(uuid.New(), sealtasks.TTAddPiece.SealTask(assignerTestSpt), busy.Info.Resources, res)
+		}
+		busy.activeWindows = append(busy.activeWindows, wnd)
+
+		return sh, acceptable, windows
+	}
+
+	// only counting this pass, both workers look idle
+	sh, acceptable, windows := setup(t)
+	require.Equal(t, 1, SpreadWS(false)(sh, len(acceptable), acceptable, windows))
+	require.Len(t, windows[0].Todo, 1)
+	require.Empty(t, windows[1].Todo)
+
+	sh, acceptable, windows = setup(t)
+	require.Equal(t, 1, SpreadWS(true)(sh, len(acceptable), acceptable, windows))
+	require.Empty(t, windows[0].Todo)
+	require.Len(t, windows[1].Todo, 1)
+}
+
+func TestAssignerMaxWorkerTasks(t *testing.T) {
+	sh, acceptable, windows := newAssignerTestSched(t,
+		[]storiface.WorkerResources{decentWorkerResources, decentWorkerResources},
+		sealtasks.TTAddPiece, sealtasks.TTAddPiece, sealtasks.TTAddPiece)
+	sh.maxWorkerTasks = 2
+
+	// the first worker already holds two tasks from an earlier pass
+	busy := sh.Workers[assignerTestWid(0)]
+	wnd := &SchedWindow{Allocated: *NewActiveResources(newTaskCounter())}
+	for i := 0; i < 2; i++ {
+		res := busy.Info.Resources.ResourceSpec(assignerTestSpt, sealtasks.TTAddPiece)
+		wnd.Allocated.Add(uuid.New(), sealtasks.TTAddPiece.SealTask(assignerTestSpt), busy.Info.Resources, res)
+	}
+	busy.activeWindows = append(busy.activeWindows, wnd)
+
+	// the second worker reaches the cap within the pass, the last task waits
+	require.Equal(t, 2, SpreadWS(false)(sh, len(acceptable), acceptable, windows))
+	require.Empty(t, windows[0].Todo)
+	require.Len(t, windows[1].Todo, 2)
+	require.Equal(t, 1, sh.SchedQueue.Len())
+}
+
+func TestSpreadWSMaxWorkerPassTasks(t *testing.T) {
+	run := func(t *testing.T, limit int) (int, []SchedWindow, *Scheduler) {
+		sh, acceptable, windows := newAssignerTestSched(t,
+			[]storiface.WorkerResources{decentWorkerResources, decentWorkerResources},
+			sealtasks.TTAddPiece, sealtasks.TTAddPiece, sealtasks.TTAddPiece, sealtasks.TTAddPiece)
+		sh.maxWorkerPassTasks = limit
+
+		// each worker has a second open window
+		acceptable, windows = addAssignerTestWindow(sh, acceptable, windows, 0)
+		acceptable, windows = addAssignerTestWindow(sh, acceptable, windows, 1)
+
+		return SpreadWS(false)(sh, len(acceptable), acceptable, windows), windows, sh
+	}
+
+	perWorker := func(sh *Scheduler, windows []SchedWindow) map[storiface.WorkerID]int {
+		out := map[storiface.WorkerID]int{}
+		for wnd, w := range windows {
+			out[sh.OpenWindows[wnd].Worker] += len(w.Todo)
+		}
+		return out
+	}
+
+	// without a limit both windows of both workers are filled
+	scheduled, windows, sh := run(t, 0)
+	require.Equal(t, 4, scheduled)
+	require.Equal(t, map[storiface.WorkerID]int{assignerTestWid(0): 2, assignerTestWid(1): 2}, perWorker(sh, windows))
+
+	// each worker gets one task in the pass, the rest stays queued
+	scheduled, windows, sh = run(t, 1)
+	require.Equal(t, 2, scheduled)
+	require.Equal(t, map[storiface.WorkerID]int{assignerTestWid(0): 1, assignerTestWid(1): 1}, perWorker(sh, windows))
+	require.Equal(t, 2, sh.SchedQueue.Len())
+}
+
+func TestRoundRobinWS(t *testing.T) {
+	workers := []storiface.WorkerResources{decentWorkerResources, decentWorkerResources, decentWorkerResources}
+
+	windowSectors := func(w SchedWindow) []abi.SectorNumber {
+		var out []abi.SectorNumber
+		for _, r := range w.Todo {
+			out = append(out, r.Sector.ID.Number)
+		}
+		return out
+	}
+
+	ws := RoundRobinWS()
+
+	sh, acceptable, windows := newAssignerTestSched(t, workers,
+		sealtasks.TTAddPiece, sealtasks.TTAddPiece, sealtasks.TTAddPiece, sealtasks.TTAddPiece)
+	require.Equal(t, 4, ws(sh, len(acceptable), acceptable, windows))
+	require.Equal(t, []abi.SectorNumber{0, 3}, windowSectors(windows[0]))
+	require.Equal(t, []abi.SectorNumber{1}, windowSectors(windows[1]))
+	require.Equal(t, []abi.SectorNumber{2}, windowSectors(windows[2]))
+
+	// the rotation continues in the next pass, skipping the worker which can't
+	// fit the task
+	workers[2] = constrainedWorkerResources
+	sh, acceptable, windows = newAssignerTestSched(t, workers, sealtasks.TTPreCommit1, sealtasks.TTPreCommit1)
+	require.Equal(t, 2, ws(sh, len(acceptable), acceptable, windows))
+	require.Equal(t, []abi.SectorNumber{1}, windowSectors(windows[0]))
+	require.Equal(t, []abi.SectorNumber{0}, windowSectors(windows[1]))
+	require.Empty(t, windows[2].Todo)
+}
+
+func TestSessionWS(t *testing.T) {
+	workers := []storiface.WorkerResources{decentWorkerResources, decentWorkerResources}
+
+	ws := SessionWS()
+
+	// AddPiece starts sessions for sectors 0 and 1, spread over both workers
+	sh, acceptable, windows := newAssignerTestSched(t, workers, sealtasks.TTAddPiece, sealtasks.TTAddPiece)
+	require.Equal(t, 2, ws(sh, len(acceptable), acceptable, windows))
+	require.Equal(t, abi.SectorNumber(0), windows[0].Todo[0].Sector.ID.Number)
+	require.Equal(t, abi.SectorNumber(1), windows[1].Todo[0].Sector.ID.Number)
+
+	// PC1 of sector 1 would go to the first worker when spreading, but stays on
+	// the worker holding the session
+	sh, acceptable, windows = newAssignerTestSched(t, workers, sealtasks.TTPreCommit1)
+	(*sh.SchedQueue)[0].Sector.ID.Number = 1
+	require.Equal(t, 1, ws(sh, len(acceptable), acceptable, windows))
+	require.Empty(t, windows[0].Todo)
+	require.Equal(t, []sealtasks.TaskType{sealtasks.TTPreCommit1}, windowTasks(windows[1]))
+
+	// without a window on the session worker the task waits
+	sh, acceptable, windows = newAssignerTestSched(t, workers, sealtasks.TTPreCommit2)
+	(*sh.SchedQueue)[0].Sector.ID.Number = 1
+	acceptable[0] = []int{0}
+	require.Equal(t, 0, ws(sh, len(acceptable), acceptable, windows))
+	require.Empty(t, windows[0].Todo)
+	require.Equal(t, 1, sh.SchedQueue.Len())
+
+	// C2 isn't bound to the session
+	sh, acceptable, windows = newAssignerTestSched(t, workers, sealtasks.TTCommit2)
+	(*sh.SchedQueue)[0].Sector.ID.Number = 1
+	require.Equal(t, 1, ws(sh, len(acceptable), acceptable, windows))
+	require.Len(t, windows[0].Todo, 1)
+
+	// the session moves when its worker is disabled
+	sh, acceptable, windows = newAssignerTestSched(t, workers, sealtasks.TTPreCommit2)
+	(*sh.SchedQueue)[0].Sector.ID.Number = 1
+	sh.Workers[assignerTestWid(1)].Enabled = false
+	acceptable[0] = []int{0}
+	require.Equal(t, 1, ws(sh, len(acceptable), acceptable, windows))
+	require.Len(t, windows[0].Todo, 1)
+
+	sh, acceptable, windows = newAssignerTestSched(t, workers, sealtasks.TTFinalize)
+	(*sh.SchedQueue)[0].Sector.ID.Number = 1
+	require.Equal(t, 1, ws(sh, len(acceptable), acceptable, windows))
+	require.Len(t, windows[0].Todo, 1)
+	require.Empty(t, windows[1].Todo)
+}
+
+func TestColocateWS(t *testing.T) {
+	windowSectors := func(w SchedWindow) []abi.SectorNumber {
+		var out []abi.SectorNumber
+		for _, r := range w.Todo {
+			out = append(out, r.Sector.ID.Number)
+		}
+		return out
+	}
+
+	t.Run("together", func(t *testing.T) {
+		workers := []storiface.WorkerResources{decentWorkerResources, decentWorkerResources, decentWorkerResources}
+		sh, acceptable, windows := newAssignerTestSched(t, workers,
+			sealtasks.TTAddPiece, sealtasks.TTAddPiece, sealtasks.TTAddPiece)
+		(*sh.SchedQueue)[2].Sector.ID.Number = 0
+
+		// spreading would give every task its own worker
+		require.Equal(t, 3, ColocateWS(sh, len(acceptable), acceptable, windows))
+		require.Equal(t, []abi.SectorNumber{0, 0}, windowSectors(windows[0]))
+		require.Equal(t, []abi.SectorNumber{1}, windowSectors(windows[1]))
+		require.Empty(t, windows[2].Todo)
+		require.Equal(t, 0, sh.SchedQueue.Len())
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		// room for exactly one 32G PC1 per window
+		oneTask := decentWorkerResources
+		oneTask.MemPhysical = 64 << 30
+
+		sh, acceptable, windows := newAssignerTestSched(t, []storiface.WorkerResources{oneTask, oneTask},
+			sealtasks.TTPreCommit1, sealtasks.TTPreCommit1)
+		(*sh.SchedQueue)[1].Sector.ID.Number = 0
+
+		require.Equal(t, 2, ColocateWS(sh, len(acceptable), acceptable, windows))
+		require.Len(t, windows[0].Todo, 1)
+		require.Len(t, windows[1].Todo, 1)
+	})
+}
+
+func TestAssignerHonorsWorkerTaskTypes(t *testing.T) {
+	workers := []storiface.WorkerResources{decentWorkerResources, decentWorkerResources}
+	sh, _, _ := newAssignerTestSched(t, workers, sealtasks.TTPreCommit1)
+
+	// the first worker would fit the task, and would win spread tie-breaks,
+	// but is dedicated to C2
+	sh.Workers[assignerTestWid(0)].workerRpc = &schedTestWorker{
+		taskTypes: map[sealtasks.TaskType]struct{}{sealtasks.TTCommit2: {}},
+	}
+	sh.Workers[assignerTestWid(1)].workerRpc = &schedTestWorker{
+		taskTypes: map[sealtasks.TaskType]struct{}{sealtasks.TTPreCommit1: {}, sealtasks.TTCommit2: {}},
+	}
+	(*sh.SchedQueue)[0].Sel = newTaskSelector()
+
+	wrs := append([]*SchedWindowRequest{}, sh.OpenWindows...)
+
+	NewSpreadAssigner(false).TrySched(sh)
+
+	require.Empty(t, wrs[0].Done)
+	require.Len(t, wrs[1].Done, 1)
+	require.Len(t, (<-wrs[1].Done).Todo, 1)
+}
+
+func TestAssignerSkipsDrainingWorkers(t *testing.T) {
+	for _, name := range AssignerNames() {
+		t.Run(name, func(t *testing.T) {
+			workers := []storiface.WorkerResources{decentWorkerResources, decentWorkerResources}
+			sh, _, _ := newAssignerTestSched(t, workers, sealtasks.TTPreCommit1, sealtasks.TTPreCommit1)
+
+			for i := range workers {
+				sh.Workers[assignerTestWid(i)].workerRpc = simWorker{}
+			}
+			for _, task := range *sh.SchedQueue {
+				task.Sel = simSelector{}
+			}
+
+			require.NoError(t, sh.SetWorkerDraining(assignerTestWid(0), true))
+			require.Error(t, sh.SetWorkerDraining(assignerTestWid(2), true))
+
+			wrs := append([]*SchedWindowRequest{}, sh.OpenWindows...)
+
+			assigner, err := GetAssigner(name)
+			require.NoError(t, err)
+			assigner.TrySched(sh)
+
+			require.Empty(t, wrs[0].Done, "draining worker must not get new tasks")
+			require.Len(t, wrs[1].Done, 1)
+			require.NotEmpty(t, (<-wrs[1].Done).Todo)
+
+			// the draining worker gets tasks again once drain is cleared
+			require.NoError(t, sh.SetWorkerDraining(assignerTestWid(0), false))
+
+			wr := &SchedWindowRequest{Worker: assignerTestWid(0), Done: make(chan *SchedWindow, 1)}
+			sh.OpenWindows = []*SchedWindowRequest{wr}
+			sh.SchedQueue.Push(&WorkerRequest{
+				Sector: storiface.SectorRef{
+					ID:        abi.SectorID{Miner: 1000, Number: 100},
+					ProofType: assignerTestSpt,
+				},
+				TaskType: sealtasks.TTPreCommit1,
+				Sel:      simSelector{},
+				SchedId:  uuid.New(),
+				Ctx:      context.Background(),
+			})
+
+			assigner.TrySched(sh)
+			require.Len(t, wr.Done, 1)
+		})
+	}
+}
+
+func TestAssignerSectorPins(t *testing.T) {
+	for _, name := range AssignerNames() {
+		t.Run(name, func(t *testing.T) {
+			workers := []storiface.WorkerResources{decentWorkerResources, decentWorkerResources, decentWorkerResources}
+			sh, _, _ := newAssignerTestSched(t, workers, sealtasks.TTPreCommit1, sealtasks.TTPreCommit1, sealtasks.TTPreCommit1)
+
+			for i := range workers {
+				sh.Workers[assignerTestWid(i)].workerRpc = simWorker{}
+			}
+			for _, task := range *sh.SchedQueue {
+				task.Sel = simSelector{}
+			}
+
+			s0 := abi.SectorID{Miner: 1000, Number: 0}
+			s1 := abi.SectorID{Miner: 1000, Number: 1}
+
+			require.Error(t, sh.PinSector(s0, assignerTestWid(3)))
+			require.Error(t, sh.UnpinSector(s0))
+
+			require.NoError(t, sh.PinSector(s0, assignerTestWid(2)))
+			require.NoError(t, sh.PinSector(s1, assignerTestWid(2)))
+			require.Len(t, sh.SectorPins(), 2)
+
+			// worker 1 is pinned to but unavailable, its sector must wait
+			// instead of spreading to other workers
+			s2 := abi.SectorID{Miner: 1000, Number: 2}
+			require.NoError(t, sh.PinSector(s2, assignerTestWid(1)))
+			sh.Workers[assignerTestWid(1)].Enabled = false
+
+			wrs := append([]*SchedWindowRequest{}, sh.OpenWindows...)
+
+			assigner, err := GetAssigner(name)
+			require.NoError(t, err)
+			assigner.TrySched(sh)
+
+			pinned := sh.SectorPins()
+			for _, wr := range wrs {
+				select {
+				case w := <-wr.Done:
+					for _, task := range w.Todo {
+						require.Equal(t, pinned[task.Sector.ID], wr.Worker, "task of sector %d landed on a worker it isn't pinned to", task.Sector.ID.Number)
+					}
+				default:
+				}
+			}
+
+			require.Equal(t, 1, sh.SchedQueue.Len())
+			require.Equal(t, s2, (*sh.SchedQueue)[0].Sector.ID)
+
+			// once unpinned, the sector can go anywhere
+			require.NoError(t, sh.UnpinSector(s2))
+
+			wr := &SchedWindowRequest{Worker: assignerTestWid(0), Done: make(chan *SchedWindow, 1)}
+			sh.OpenWindows = []*SchedWindowRequest{wr}
+
+			assigner.TrySched(sh)
+			require.Len(t, wr.Done, 1)
+			require.Equal(t, 0, sh.SchedQueue.Len())
+		})
+	}
+}
+
+func TestAssignerUnmetDemand(t *testing.T) {
+	// room for exactly one 32G PC1
+	oneTask := decentWorkerResources
+	oneTask.MemPhysical = 64 << 30
+
+	sh, _, _ := newAssignerTestSched(t, []storiface.WorkerResources{oneTask},
+		sealtasks.TTPreCommit1, sealtasks.TTPreCommit1, sealtasks.TTPreCommit1)
+	sh.Workers[assignerTestWid(0)].workerRpc = simWorker{}
+	for _, task := range *sh.SchedQueue {
+		task.Sel = simSelector{}
+	}
+
+	pc1 := storiface.ResourceTable[sealtasks.TTPreCommit1][assignerTestSpt]
+	skipped := func(n int) []SchedUnmetDemand {
+		return []SchedUnmetDemand{{
+			TaskType:       sealtasks.TTPreCommit1,
+			ProofType:      assignerTestSpt,
+			Tasks:          n,
+			MinMemory:      uint64(n) * pc1.MinMemory,
+			MaxMemory:      uint64(n) * pc1.MaxMemory,
+			GPUUtilization: float64(n) * pc1.GPUUtilization,
+		}}
+	}
+
+	// the window is full after the first task
+	NewSpreadAssigner(false).TrySched(sh)
+	require.Equal(t, 2, sh.SchedQueue.Len())
+	require.Equal(t, skipped(2), sh.lastUnmet)
+
+	// without open windows, the whole queue is unmet
+	NewSpreadAssigner(false).TrySched(sh)
+	require.Equal(t, skipped(2), sh.lastUnmet)
+
+	diag := sh.diag()
+	require.Equal(t, skipped(2), diag.UnmetDemand)
+
+	for sh.SchedQueue.Len() > 0 {
+		sh.SchedQueue.Remove(0)
+	}
+	NewSpreadAssigner(false).TrySched(sh)
+	require.Empty(t, sh.lastUnmet)
+}
+
+func TestAssignerReserveStarving(t *testing.T) {
+	// the worker runs enough other work to have no room for a 32G PC1, but
+	// still has room for an AP
+	busy := decentWorkerResources
+	busy.MemUsed = 300 << 30
+
+	run := func(t *testing.T, reserve bool) (*Scheduler, *WorkerRequest, *SchedWindowRequest) {
+		sh, _, _ := newAssignerTestSched(t, []storiface.WorkerResources{busy}, sealtasks.TTPreCommit1)
+		sh.reserveStarving = reserve
+		sh.Workers[assignerTestWid(0)].workerRpc = simWorker{}
+
+		starved := (*sh.SchedQueue)[0]
+		starved.Sel = simSelector{}
+		starved.skipped = StarvationSkips
+
+		wr := sh.OpenWindows[0]
+
+		NewSpreadAssigner(false).TrySched(sh)
+		require.True(t, starved.starving)
+		require.Empty(t, wr.Done)
+
+		// a newer task which fits in the window
+		sh.SchedQueue.Push(&WorkerRequest{
+			Sector: storiface.SectorRef{
+				ID:        abi.SectorID{Miner: 1000, Number: 100},
+				ProofType: assignerTestSpt,
+			},
+			TaskType: sealtasks.TTAddPiece,
+			Sel:      simSelector{},
+			SchedId:  uuid.New(),
+			Ctx:      context.Background(),
+		})
+
+		NewSpreadAssigner(false).TrySched(sh)
+		return sh, starved, wr
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		sh, _, wr := run(t, false)
+		require.Len(t, wr.Done, 1, "newer task takes the window")
+		require.Equal(t, []sealtasks.TaskType{sealtasks.TTAddPiece}, windowTasks(*<-wr.Done))
+		require.Empty(t, sh.starvingReservations)
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		sh, starved, wr := run(t, true)
+		require.Equal(t, starved, sh.starvingReservations[assignerTestWid(0)])
+		require.Empty(t, wr.Done, "newer task must be held back from the reserved window")
+		require.Equal(t, 2, sh.SchedQueue.Len())
+
+		// once the worker has room, the window goes to the starving task
+		sh.Workers[assignerTestWid(0)].Info.Resources.MemUsed = 1 << 30
+		NewSpreadAssigner(false).TrySched(sh)
+
+		require.Len(t, wr.Done, 1)
+		require.Equal(t, []sealtasks.TaskType{sealtasks.TTPreCommit1}, windowTasks(*<-wr.Done))
+		require.Empty(t, sh.starvingReservations)
+		require.Equal(t, 1, sh.SchedQueue.Len())
+	})
+}
+
+func TestAssignerAdmission(t *testing.T) {
+	workers := []storiface.WorkerResources{decentWorkerResources, decentWorkerResources}
+	sh, _, _ := newAssignerTestSched(t, workers, sealtasks.TTPreCommit1, sealtasks.TTPreCommit1)
+
+	for i := range workers {
+		sh.Workers[assignerTestWid(i)].workerRpc = simWorker{}
+	}
+	for _, task := range *sh.SchedQueue {
+		task.Sel = simSelector{}
+	}
+
+	wrs := append([]*SchedWindowRequest{}, sh.OpenWindows...)
+
+	assigner := &AssignerCommon{
+		WindowSel: SpreadWS(false),
+		Admit: func(task *WorkerRequest, wid storiface.WorkerID, info storiface.WorkerInfo) bool {
+			return wid != assignerTestWid(0)
+		},
+	}
+	assigner.TrySched(sh)
+
+	require.Empty(t, wrs[0].Done, "rejected worker must not get tasks")
+	require.Len(t, wrs[1].Done, 1)
+	require.NotEmpty(t, (<-wrs[1].Done).Todo)
+}
+
+func TestAssignerTrace(t *testing.T) {
+	setup := func(t *testing.T) *Scheduler {
+		workers := []storiface.WorkerResources{constrainedWorkerResources, decentWorkerResources}
+		sh, _, _ := newAssignerTestSched(t, workers, sealtasks.TTPreCommit1)
+
+		for i := range workers {
+			sh.Workers[assignerTestWid(i)].workerRpc = &schedTestWorker{
+				taskTypes: map[sealtasks.TaskType]struct{}{sealtasks.TTPreCommit1: {}},
+			}
+		}
+		(*sh.SchedQueue)[0].Sel = newTaskSelector()
+
+		return sh
+	}
+
+	sh := setup(t)
+	sh.traceAssign = true
+	NewSpreadAssigner(false).TrySched(sh)
+
+	trace := sh.diag().Trace
+	require.Len(t, trace, 1)
+	require.Equal(t, sealtasks.TTPreCommit1, trace[0].TaskType)
+	require.ElementsMatch(t, []SchedTraceWindow{
+		{Window: 0, Worker: assignerTestWid(0), Rejected: SchedRejectResources},
+		{Window: 1, Worker: assignerTestWid(1), Assigned: true},
+	}, trace[0].Windows)
+
+	// tracing is off by default
+	sh = setup(t)
+	NewSpreadAssigner(false).TrySched(sh)
+	require.Nil(t, sh.diag().Trace)
+}
+
+func TestCheckAssignment(t *testing.T) {
+	// room for exactly one 32G PC1
+	oneTask := decentWorkerResources
+	oneTask.MemPhysical = 64 << 30
+
+	sh, acceptable, windows := newAssignerTestSched(t,
+		[]storiface.WorkerResources{oneTask, oneTask},
+		sealtasks.TTPreCommit1, sealtasks.TTPreCommit1, sealtasks.TTPreCommit1)
+	acceptable[2] = []int{1}
+	queued := append([]*WorkerRequest(nil), (*sh.SchedQueue)...)
+
+	// assigns everything to the first window without looking at resources,
+	// and forgets to remove the last task from the queue
+	windows[0].Todo = queued
+
+	sh.SchedQueue.Remove(1)
+	sh.SchedQueue.Remove(0)
+
+	kinds := map[string]int{}
+	for _, v := range checkAssignment(sh, queued, acceptable, windows) {
+		kinds[v.Kind]++
+	}
+	require.Equal(t, map[string]int{
+		schedViolationCapacity:   2,
+		schedViolationInfeasible: 1,
+		schedViolationQueue:      1,
+	}, kinds)
+}
+
+// TestAssignerInvariants runs every registered assigner on generated workers
+// and task queues, and checks that no window is assigned more than it can
+// fit, that tasks are only assigned to acceptable windows, and that every
+// assigned task is removed from the queue exactly once.
+func TestAssignerInvariants(t *testing.T) {
+	taskTypes := []sealtasks.TaskType{
+		sealtasks.TTAddPiece,
+		sealtasks.TTPreCommit1,
+		sealtasks.TTPreCommit2,
+		sealtasks.TTCommit2,
+		sealtasks.TTFinalize,
+	}
+
+	for _, name := range AssignerNames() {
+		t.Run(name, func(t *testing.T) {
+			for seed := int64(0); seed < 100; seed++ {
+				rng := rand.New(rand.NewSource(seed))
+
+				workers := make([]storiface.WorkerResources, 1+rng.Intn(4))
+				for i := range workers {
+					wr := decentWorkerResources
+					wr.MemPhysical = uint64(32+32*rng.Intn(8)) << 30
+					wr.CPUs = uint64(4) << rng.Intn(4)
+					wr.GPUs = nil
+					for g := rng.Intn(3); g > 0; g-- {
+						wr.GPUs = append(wr.GPUs, fmt.Sprintf("gpu%d", g))
+					}
+					workers[i] = wr
+				}
+
+				tasks := make([]sealtasks.TaskType, rng.Intn(16))
+				for i := range tasks {
+					tasks[i] = taskTypes[rng.Intn(len(taskTypes))]
+				}
+
+				sh, acceptable, windows := newAssignerTestSched(t, workers, tasks...)
+				for i := range workers {
+					if rng.Intn(2) == 0 {
+						acceptable, windows = addAssignerTestWindow(sh, acceptable, windows, i)
+					}
+				}
+
+				// sectors with more than one queued task
+				for _, r := range *sh.SchedQueue {
+					r.Sector.ID.Number = abi.SectorNumber(rng.Intn(len(tasks)))
+				}
+				sort.Sort(sh.SchedQueue)
+				for sqi, r := range *sh.SchedQueue {
+					r.IndexHeap = sqi
+
+					wnds := rng.Perm(len(sh.OpenWindows))
+					acceptable[sqi] = wnds[:rng.Intn(len(wnds)+1)]
+				}
+
+				a, err := GetAssigner(name)
+				require.NoError(t, err)
+				ac, ok := a.(*AssignerCommon)
+				require.True(t, ok)
+
+				queued := append([]*WorkerRequest(nil), (*sh.SchedQueue)...)
+				scheduled := ac.WindowSel(sh, len(queued), acceptable, windows)
+
+				require.Empty(t, checkAssignment(sh, queued, acceptable, windows), "seed %d", seed)
+
+				var todo int
+				for _, w := range windows {
+					todo += len(w.Todo)
+				}
+				require.Equal(t, todo, scheduled, "seed %d", seed)
+			}
+		})
+	}
+}
+
+func TestStarvingTaskScheduled(t *testing.T) {
+	defer func(skips int) { StarvationSkips = skips }(StarvationSkips)
+	StarvationSkips = 3
+
+	// room for exactly one 32G PC1
+	oneTask := decentWorkerResources
+	oneTask.MemPhysical = 64 << 30
+
+	sh, _, _ := newAssignerTestSched(t, []storiface.WorkerResources{oneTask}, sealtasks.TTPreCommit1)
+	wid := assignerTestWid(0)
+	sh.Workers[wid].workerRpc = simWorker{}
+
+	starved := (*sh.SchedQueue)[0]
+	starved.Sel = simSelector{}
+
+	assigner := NewSpreadAssigner(false)
+
+	// higher priority tasks keep arriving, taking the only window every pass
+	for pass := 0; pass <= StarvationSkips; pass++ {
+		sh.SchedQueue.Push(&WorkerRequest{
+			Sector: storiface.SectorRef{
+				ID:        abi.SectorID{Miner: 1000, Number: abi.SectorNumber(100 + pass)},
+				ProofType: assignerTestSpt,
+			},
+			TaskType: sealtasks.TTPreCommit1,
+			Priority: 10,
+			Sel:      simSelector{},
+			SchedId:  uuid.New(),
+			Ctx:      context.Background(),
+		})
+
+		wr := &SchedWindowRequest{Worker: wid, Done: make(chan *SchedWindow, 1)}
+		sh.OpenWindows = []*SchedWindowRequest{wr}
+
+		assigner.TrySched(sh)
+
+		require.Len(t, wr.Done, 1)
+		w := <-wr.Done
+		require.Len(t, w.Todo, 1)
+
+		if pass < StarvationSkips {
+			require.Equal(t, abi.SectorNumber(100+pass), w.Todo[0].Sector.ID.Number)
+			require.Equal(t, pass+1, starved.skipped)
+			require.False(t, starved.starving)
+			continue
+		}
+
+		// the starving task gets the window ahead of the higher priority one
+		require.Equal(t, starved, w.Todo[0])
+	}
+
+	require.Equal(t, 1, sh.SchedQueue.Len())
+}
+
+func BenchmarkAssignerLogging(b *testing.B) {
+	require.NoError(b, logging.SetLogLevel("advmgr", "debug"))
+	defer logging.SetLogLevel("advmgr", "info") // nolint:errcheck
+
+	workers := make([]storiface.WorkerResources, 8)
+	for i := range workers {
+		workers[i] = decentWorkerResources
+	}
+	tasks := make([]sealtasks.TaskType, 64)
+	for i := range tasks {
+		tasks[i] = sealtasks.TTAddPiece
+	}
+
+	for _, summary := range []bool{false, true} {
+		b.Run(fmt.Sprintf("summary-%t", summary), func(b *testing.B) {
+			assigner := NewSpreadAssigner(false)
+
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				sh, _, _ := newAssignerTestSched(b, workers, tasks...)
+				sh.assignLogSummary = summary
+				for _, w := range sh.Workers {
+					w.workerRpc = simWorker{}
+				}
+				for _, r := range *sh.SchedQueue {
+					r.Sel = simSelector{}
+				}
+				b.StartTimer()
+
+				assigner.TrySched(sh)
+			}
+		})
+	}
+}
+
+// BenchmarkSpreadWSFullWindows runs a pass in which most tasks don't fit any
+// window, or have no acceptable windows at all
+func BenchmarkSpreadWSFullWindows(b *testing.B) {
+	workers := make([]storiface.WorkerResources, 16)
+	for i := range workers {
+		workers[i] = decentWorkerResources
+	}
+	tasks := make([]sealtasks.TaskType, 1024)
+	for i := range tasks {
+		tasks[i] = sealtasks.TTPreCommit2
+	}
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		sh, acceptable, windows := newAssignerTestSched(b, workers, tasks...)
+		sh.assignLogSummary = true
+		for sqi := 0; sqi < len(acceptable); sqi += 2 {
+			acceptable[sqi] = nil
+		}
+		b.StartTimer()
+
+		SpreadWS(false)(sh, len(acceptable), acceptable, windows)
+	}
+}
+
+// BenchmarkSpreadWSIdleWorkers assigns small tasks to many workers. With fewer
+// tasks than workers each task goes to an idle worker, and the scan for it
+// stops at the first window it fits in; with more tasks than workers, tasks
+// past the first round have no idle worker left and scan all windows.
+func BenchmarkSpreadWSIdleWorkers(b *testing.B) {
+	workers := make([]storiface.WorkerResources, 256)
+	for i := range workers {
+		workers[i] = decentWorkerResources
+	}
+
+	for _, queueLen := range []int{64, 1024} {
+		b.Run(fmt.Sprintf("tasks-%d", queueLen), func(b *testing.B) {
+			tasks := make([]sealtasks.TaskType, queueLen)
+			for i := range tasks {
+				tasks[i] = sealtasks.TTFinalize
+			}
+
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				sh, acceptable, windows := newAssignerTestSched(b, workers, tasks...)
+				sh.assignLogSummary = true
+				b.StartTimer()
+
+				SpreadWS(false)(sh, len(acceptable), acceptable, windows)
+			}
+
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*queueLen), "ns/task")
+		})
+	}
+}
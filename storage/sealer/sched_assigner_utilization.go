@@ -32,13 +32,31 @@ func LowestUtilizationWS(sh *Scheduler, queueLen int, acceptableWindows [][]int,
 
 			res := w.Info.Resources.ResourceSpec(task.Sector.ProofType, task.TaskType)
 
-			log.Debugf("SCHED try assign sqi:%d sector %d to window %d (awi:%d)", sqi, task.Sector.ID.Number, wnd, i)
+			if !sh.assignLogSummary {
+				log.Debugf("SCHED try assign sqi:%d sector %d to window %d (awi:%d)", sqi, task.Sector.ID.Number, wnd, i)
+			}
 
 			// TODO: allow bigger windows
 			if !windows[wnd].Allocated.CanHandleRequest(task.SchedId, task.SealTask(), res, wid, "schedAssign", w.Info) {
 				continue
 			}
 
+			if sh.workerAtCap(wid, windows) {
+				continue
+			}
+
+			if sh.antiAffinityConflict(task, wid, windows) {
+				continue
+			}
+
+			if sh.taskTypeAtCap(task, wid, windows) {
+				continue
+			}
+
+			if sh.maintenanceConflict(task, wid) {
+				continue
+			}
+
 			wu, found := workerUtil[wid]
 			if !found {
 				wu = w.Utilization()
@@ -70,17 +88,128 @@ func LowestUtilizationWS(sh *Scheduler, queueLen int, acceptableWindows [][]int,
 
 		if selectedWindow < 0 {
 			// all windows full
+			recordNoWindow(sh, task)
 			continue
 		}
 
-		log.Debugw("SCHED ASSIGNED",
-			"assigner", "util",
-			"sqi", sqi,
-			"sector", task.Sector.ID.Number,
-			"task", task.TaskType,
-			"window", selectedWindow,
-			"worker", bestWid,
-			"utilization", bestUtilization)
+		if !sh.assignLogSummary {
+			log.Debugw("SCHED ASSIGNED",
+				"assigner", "util",
+				"sqi", sqi,
+				"sector", task.Sector.ID.Number,
+				"task", task.TaskType,
+				"window", selectedWindow,
+				"worker", bestWid,
+				"utilization", bestUtilization)
+		}
+
+		workerUtil[bestWid] += windows[selectedWindow].Allocated.Add(task.SchedId, task.SealTask(), info.Resources, needRes)
+		windows[selectedWindow].Todo = append(windows[selectedWindow].Todo, task)
+
+		rmQueue = append(rmQueue, sqi)
+		scheduled++
+	}
+
+	if len(rmQueue) > 0 {
+		for i := len(rmQueue) - 1; i >= 0; i-- {
+			sh.SchedQueue.Remove(rmQueue[i])
+		}
+	}
+
+	return scheduled
+}
+
+// NewUtilizationAssigner returns an assigner which places each task in the
+// window where it leaves its worker least utilized. Unlike the
+// lowest-utilization assigner, which compares workers before the task is
+// placed, the score includes the task's own requirements, so a heavy task
+// is steered towards a worker which has room to absorb it.
+func NewUtilizationAssigner() Assigner {
+	return &AssignerCommon{
+		WindowSel: ProjectedUtilizationWS,
+	}
+}
+
+func ProjectedUtilizationWS(sh *Scheduler, queueLen int, acceptableWindows [][]int, windows []SchedWindow) int {
+	scheduled := 0
+	rmQueue := make([]int, 0, queueLen)
+	workerUtil := map[storiface.WorkerID]float64{}
+
+	for sqi := 0; sqi < queueLen; sqi++ {
+		task := (*sh.SchedQueue)[sqi]
+
+		selectedWindow := -1
+		var needRes storiface.Resources
+		var info storiface.WorkerInfo
+		var bestWid storiface.WorkerID
+		bestUtilization := math.MaxFloat64 // smaller = better
+
+		for i, wnd := range acceptableWindows[task.IndexHeap] {
+			wid := sh.OpenWindows[wnd].Worker
+			w := sh.Workers[wid]
+
+			res := w.Info.Resources.ResourceSpec(task.Sector.ProofType, task.TaskType)
+
+			if !sh.assignLogSummary {
+				log.Debugf("SCHED try assign sqi:%d sector %d to window %d (awi:%d)", sqi, task.Sector.ID.Number, wnd, i)
+			}
+
+			if !windows[wnd].Allocated.CanHandleRequest(task.SchedId, task.SealTask(), res, wid, "schedAssign", w.Info) {
+				continue
+			}
+
+			if sh.workerAtCap(wid, windows) {
+				continue
+			}
+
+			if sh.antiAffinityConflict(task, wid, windows) {
+				continue
+			}
+
+			if sh.taskTypeAtCap(task, wid, windows) {
+				continue
+			}
+
+			if sh.maintenanceConflict(task, wid) {
+				continue
+			}
+
+			wu, found := workerUtil[wid]
+			if !found {
+				wu = w.Utilization()
+				workerUtil[wid] = wu
+			}
+
+			// utilization of the worker if the task was placed in this window
+			alloc := &windows[wnd].Allocated
+			projected := wu + alloc.projectedUtilization(w.Info.Resources, res) - alloc.utilization(w.Info.Resources)
+			if projected >= bestUtilization {
+				continue
+			}
+
+			info = w.Info
+			needRes = res
+			bestWid = wid
+			selectedWindow = wnd
+			bestUtilization = projected
+		}
+
+		if selectedWindow < 0 {
+			// all windows full
+			recordNoWindow(sh, task)
+			continue
+		}
+
+		if !sh.assignLogSummary {
+			log.Debugw("SCHED ASSIGNED",
+				"assigner", "util-projected",
+				"sqi", sqi,
+				"sector", task.Sector.ID.Number,
+				"task", task.TaskType,
+				"window", selectedWindow,
+				"worker", bestWid,
+				"utilization", bestUtilization)
+		}
 
 		workerUtil[bestWid] += windows[selectedWindow].Allocated.Add(task.SchedId, task.SealTask(), info.Resources, needRes)
 		windows[selectedWindow].Todo = append(windows[selectedWindow].Todo, task)
@@ -0,0 +1,103 @@
+package sealer
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+const (
+	// workerHealthHalfLife is the time in which a worker failure penalty
+	// decays to half, so flaky workers are trusted again after a while
+	workerHealthHalfLife = 15 * time.Minute
+
+	// workerHealthLoad is the number of assigned tasks a failure penalty of 1
+	// weighs as in spread assigners
+	workerHealthLoad = 4
+)
+
+// workerHealth tracks recent task failures of workers. Each failure adds 1 to
+// the failure penalty of a worker, each success halves it, and penalties decay
+// with workerHealthHalfLife back to 0 (healthy).
+type workerHealth struct {
+	lk     sync.Mutex
+	scores map[storiface.WorkerID]workerHealthScore
+}
+
+type workerHealthScore struct {
+	penalty float64
+	at      time.Time
+}
+
+// decayed returns the penalty decayed to now
+func (s workerHealthScore) decayed(now time.Time) float64 {
+	return s.penalty * math.Pow(0.5, float64(now.Sub(s.at))/float64(workerHealthHalfLife))
+}
+
+func (h *workerHealth) report(wid storiface.WorkerID, success bool, now time.Time) {
+	h.lk.Lock()
+	defer h.lk.Unlock()
+
+	if h.scores == nil {
+		h.scores = map[storiface.WorkerID]workerHealthScore{}
+	}
+
+	penalty := h.scores[wid].decayed(now)
+	if success {
+		penalty /= 2
+	} else {
+		penalty++
+	}
+
+	if penalty < 0.01 {
+		delete(h.scores, wid)
+		return
+	}
+	h.scores[wid] = workerHealthScore{penalty: penalty, at: now}
+}
+
+// penalty returns the current failure penalty of a worker, 0 for healthy
+// workers
+func (h *workerHealth) penalty(wid storiface.WorkerID, now time.Time) float64 {
+	h.lk.Lock()
+	defer h.lk.Unlock()
+
+	s, ok := h.scores[wid]
+	if !ok {
+		return 0
+	}
+	return s.decayed(now)
+}
+
+// workerFault reports whether a task failed because of the worker running it,
+// or the connection to it, rather than because of the sector or the caller.
+// Tasks cancelled by the caller, and errors the worker returned for the task
+// itself (storiface.ErrUnknown), such as a sector it can't find, don't count
+// against the worker.
+func workerFault(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil || xerrors.Is(err, context.Canceled) {
+		return false
+	}
+	if xerrors.Is(err, storiface.ErrSectorNotFound) {
+		return false
+	}
+
+	var we storiface.WorkError
+	if xerrors.As(err, &we) {
+		return we.ErrCode() != storiface.ErrUnknown
+	}
+
+	// not returned by the worker, e.g. the RPC call failed
+	return true
+}
+
+// ReportTaskOutcome records whether a task the worker ran succeeded. Workers
+// with recent failures are picked last by spread assigners.
+func (sh *Scheduler) ReportTaskOutcome(wid storiface.WorkerID, success bool) {
+	sh.health.report(wid, success, time.Now())
+}
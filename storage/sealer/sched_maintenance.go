@@ -0,0 +1,139 @@
+package sealer
+
+import (
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/node/config"
+	"github.com/filecoin-project/lotus/storage/sealer/sealtasks"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// maintenanceWindow is a period in which a worker is taken down for
+// maintenance, either once or every day
+type maintenanceWindow struct {
+	// start is the start of a one-off window
+	start time.Time
+	// daily windows start every day at dayOffset after midnight UTC
+	daily     bool
+	dayOffset time.Duration
+
+	length time.Duration
+}
+
+// overlaps reports whether the window, or any of its daily occurrences,
+// overlaps the [from, to] period
+func (mw maintenanceWindow) overlaps(from, to time.Time) bool {
+	if !mw.daily {
+		return !mw.start.After(to) && from.Before(mw.start.Add(mw.length))
+	}
+
+	// the occurrence of the previous day may still be in progress
+	day := from.UTC().Truncate(24 * time.Hour)
+	for start := day.Add(mw.dayOffset - 24*time.Hour); !start.After(to); start = start.Add(24 * time.Hour) {
+		if from.Before(start.Add(mw.length)) {
+			return true
+		}
+	}
+	return false
+}
+
+// maintenanceSchedules are the maintenance windows of workers, by worker
+// hostname
+type maintenanceSchedules map[string][]maintenanceWindow
+
+// parseMaintenanceSchedules parses worker maintenance windows, given as a
+// start and a length joined with a slash. The start is either an RFC3339 time,
+// for a one-off window, e.g. "2024-05-01T02:00:00Z/4h", or a UTC time of day,
+// for a window every day, e.g. "02:00/1h".
+func parseMaintenanceSchedules(schedules map[string][]string) (maintenanceSchedules, error) {
+	if len(schedules) == 0 {
+		return nil, nil
+	}
+
+	out := maintenanceSchedules{}
+	for host, windows := range schedules {
+		for _, window := range windows {
+			starts, lengths, ok := strings.Cut(strings.TrimSpace(window), "/")
+			if !ok {
+				return nil, xerrors.Errorf("maintenance window %q of worker %s must be a start and a length, like '02:00/1h'", window, host)
+			}
+
+			length, err := time.ParseDuration(lengths)
+			if err != nil {
+				return nil, xerrors.Errorf("parsing length of maintenance window %q of worker %s: %w", window, host, err)
+			}
+			if length <= 0 {
+				return nil, xerrors.Errorf("maintenance window %q of worker %s must have a positive length", window, host)
+			}
+
+			mw := maintenanceWindow{length: length}
+			if tod, err := time.Parse("15:04", starts); err == nil {
+				if length > 24*time.Hour {
+					return nil, xerrors.Errorf("daily maintenance window %q of worker %s is longer than a day", window, host)
+				}
+				mw.daily = true
+				mw.dayOffset = time.Duration(tod.Hour())*time.Hour + time.Duration(tod.Minute())*time.Minute
+			} else {
+				mw.start, err = time.Parse(time.RFC3339, starts)
+				if err != nil {
+					return nil, xerrors.Errorf("parsing start of maintenance window %q of worker %s: %w", window, host, err)
+				}
+			}
+
+			out[host] = append(out[host], mw)
+		}
+	}
+
+	return out, nil
+}
+
+// parseTaskDurations parses estimated task run times keyed by task type short
+// name, e.g. "PC1" = "4h"
+func parseTaskDurations(durations map[string]config.Duration) (map[sealtasks.TaskType]time.Duration, error) {
+	if len(durations) == 0 {
+		return nil, nil
+	}
+
+	taskTypes := shortTaskTypes()
+
+	out := map[sealtasks.TaskType]time.Duration{}
+	for name, d := range durations {
+		tt, ok := taskTypes[strings.TrimSpace(name)]
+		if !ok {
+			return nil, xerrors.Errorf("unknown task type %q", name)
+		}
+		if d < 0 {
+			return nil, xerrors.Errorf("negative duration %s for task type %q", time.Duration(d), name)
+		}
+		out[tt] = time.Duration(d)
+	}
+
+	return out, nil
+}
+
+// maintenanceConflict reports whether the task, started on the worker now,
+// would still be running in one of the worker's maintenance windows, going by
+// the estimated run time of the task type in sh.taskDurations. Tasks of types
+// without an estimate only conflict with a window in progress.
+func (sh *Scheduler) maintenanceConflict(task *WorkerRequest, wid storiface.WorkerID) bool {
+	if len(sh.maintenance) == 0 {
+		return false
+	}
+
+	w, ok := sh.Workers[wid]
+	if !ok {
+		return false
+	}
+
+	now := time.Now()
+	end := now.Add(sh.taskDurations[task.TaskType])
+	for _, mw := range sh.maintenance[w.Info.Hostname] {
+		if mw.overlaps(now, end) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package sealer
+
+import (
+	"sync"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// sectorPins are the workers sectors are pinned to, see PinSector
+type sectorPins struct {
+	lk   sync.RWMutex
+	pins map[abi.SectorID]storiface.WorkerID
+}
+
+func (p *sectorPins) set(sector abi.SectorID, wid storiface.WorkerID) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	if p.pins == nil {
+		p.pins = map[abi.SectorID]storiface.WorkerID{}
+	}
+	p.pins[sector] = wid
+}
+
+func (p *sectorPins) clear(sector abi.SectorID) bool {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	_, ok := p.pins[sector]
+	delete(p.pins, sector)
+	return ok
+}
+
+// snapshot returns a copy of the pins, which can be read concurrently
+func (p *sectorPins) snapshot() map[abi.SectorID]storiface.WorkerID {
+	p.lk.RLock()
+	defer p.lk.RUnlock()
+
+	out := make(map[abi.SectorID]storiface.WorkerID, len(p.pins))
+	for sector, wid := range p.pins {
+		out[sector] = wid
+	}
+	return out
+}
+
+// PinSector makes assigners put all tasks of a sector on the given worker.
+// Windows of other workers are never considered for the sector's tasks; while
+// the pinned worker is gone, disabled or busy, the tasks wait for it. Pins are
+// kept in memory only.
+func (sh *Scheduler) PinSector(sector abi.SectorID, wid storiface.WorkerID) error {
+	sh.workersLk.RLock()
+	_, ok := sh.Workers[wid]
+	sh.workersLk.RUnlock()
+
+	if !ok {
+		return xerrors.Errorf("worker %s not found", wid)
+	}
+
+	sh.pins.set(sector, wid)
+
+	select {
+	case sh.workerChange <- struct{}{}:
+	default: // workerChange is buffered, and scheduling is global, so it's ok if we don't send here
+	}
+
+	return nil
+}
+
+// UnpinSector removes the pin of a sector, letting its tasks run on any
+// worker again
+func (sh *Scheduler) UnpinSector(sector abi.SectorID) error {
+	if !sh.pins.clear(sector) {
+		return xerrors.Errorf("sector %d of miner %d is not pinned", sector.Number, sector.Miner)
+	}
+
+	select {
+	case sh.workerChange <- struct{}{}:
+	default: // workerChange is buffered, and scheduling is global, so it's ok if we don't send here
+	}
+
+	return nil
+}
+
+// SectorPins returns the workers sectors are pinned to
+func (sh *Scheduler) SectorPins() map[abi.SectorID]storiface.WorkerID {
+	return sh.pins.snapshot()
+}
@@ -0,0 +1,73 @@
+package sealer
+
+import (
+	"sync"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// workerProofRecencySize is the number of distinct seal proof types remembered
+// per worker
+const workerProofRecencySize = 2
+
+// workerProofRecency tracks the seal proof types of the tasks workers ran
+// most recently, most recent first
+type workerProofRecency struct {
+	lk     sync.Mutex
+	recent map[storiface.WorkerID][]abi.RegisteredSealProof
+}
+
+func (r *workerProofRecency) record(wid storiface.WorkerID, spt abi.RegisteredSealProof) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	if r.recent == nil {
+		r.recent = map[storiface.WorkerID][]abi.RegisteredSealProof{}
+	}
+
+	prev := r.recent[wid]
+	out := make([]abi.RegisteredSealProof, 0, workerProofRecencySize)
+	out = append(out, spt)
+	for _, p := range prev {
+		if len(out) == workerProofRecencySize {
+			break
+		}
+		if p != spt {
+			out = append(out, p)
+		}
+	}
+	r.recent[wid] = out
+}
+
+func (r *workerProofRecency) matches(wid storiface.WorkerID, spt abi.RegisteredSealProof) bool {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	for _, p := range r.recent[wid] {
+		if p == spt {
+			return true
+		}
+	}
+	return false
+}
+
+// reportTaskProof records the seal proof type of a task the worker started.
+// Tasks without a sector, like DataCid, aren't recorded.
+func (sh *Scheduler) reportTaskProof(wid storiface.WorkerID, req *WorkerRequest) {
+	if req.Sector == storiface.NoSectorRef {
+		return
+	}
+	sh.proofRecency.record(wid, req.Sector.ProofType)
+}
+
+// proofMatch reports whether the worker recently ran tasks of the task's seal
+// proof type. It's always false unless sh.preferProofMatch is set
+// (AssignerPreferProofMatch).
+func (sh *Scheduler) proofMatch(wid storiface.WorkerID, task *WorkerRequest) bool {
+	if !sh.preferProofMatch {
+		return false
+	}
+	return sh.proofRecency.matches(wid, task.Sector.ProofType)
+}
@@ -0,0 +1,88 @@
+package sealer
+
+import (
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/storage/sealer/sealtasks"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// capacityReservation keeps a fraction of the resources of each worker for a
+// set of task types, so that these tasks still find room on workers which are
+// saturated with other work
+type capacityReservation struct {
+	fraction float64
+	tasks    map[sealtasks.TaskType]struct{}
+}
+
+// parseCapacityReservation builds the reservation of the fraction of worker
+// resources for task types given by short names, nil if nothing is reserved
+func parseCapacityReservation(fraction float64, tasks []string) (*capacityReservation, error) {
+	if fraction == 0 || len(tasks) == 0 {
+		return nil, nil
+	}
+	if fraction < 0 || fraction >= 1 {
+		return nil, xerrors.Errorf("reserved fraction %f must be in [0, 1)", fraction)
+	}
+
+	taskTypes := shortTaskTypes()
+
+	r := &capacityReservation{
+		fraction: fraction,
+		tasks:    map[sealtasks.TaskType]struct{}{},
+	}
+	for _, short := range tasks {
+		tt, ok := taskTypes[short]
+		if !ok {
+			return nil, xerrors.Errorf("unknown task type %q", short)
+		}
+		r.tasks[tt] = struct{}{}
+	}
+
+	return r, nil
+}
+
+// shortTaskTypes maps short names of task types in the resource table, as used
+// in the sealer config, to the task types
+func shortTaskTypes() map[string]sealtasks.TaskType {
+	out := map[string]sealtasks.TaskType{}
+	for tt := range storiface.ResourceTable {
+		out[tt.Short()] = tt
+	}
+	return out
+}
+
+// intoReserved reports whether a task would use resources of the worker which
+// are reserved for other task types, given the resources already allocated in
+// the window
+func (sh *Scheduler) intoReserved(task *WorkerRequest, a *ActiveResources, needRes storiface.Resources, info storiface.WorkerInfo) bool {
+	r := sh.reservation
+	if r == nil || info.IgnoreResources {
+		return false
+	}
+	if _, ok := r.tasks[task.TaskType]; ok {
+		return false
+	}
+
+	res := info.Resources
+	avail := 1 - r.fraction
+
+	if float64(a.memUsedMin+needRes.MinMemory+needRes.BaseMinMemory) > avail*float64(res.MemPhysical) {
+		return true
+	}
+	if float64(a.cpuUse+needRes.Threads(res.CPUs, len(res.GPUs))) > avail*float64(res.CPUs) {
+		return true
+	}
+	if len(res.GPUs) > 0 && needRes.GPUUtilization > 0 && a.gpuUsed+needRes.GPUUtilization > avail*float64(len(res.GPUs)) {
+		return true
+	}
+
+	return false
+}
+
+// windowHasRoom reports whether a task fits the resources left in a window,
+// without using resources reserved for other task types (see intoReserved)
+func (sh *Scheduler) windowHasRoom(task *WorkerRequest, window *SchedWindow, needRes storiface.Resources, wid storiface.WorkerID, info storiface.WorkerInfo) bool {
+	return window.Allocated.CanHandleRequest(task.SchedId, task.SealTask(), needRes, wid, "schedAssign", info) &&
+		!sh.intoReserved(task, &window.Allocated, needRes, info)
+}
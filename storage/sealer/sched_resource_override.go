@@ -0,0 +1,103 @@
+package sealer
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/storage/sealer/sealtasks"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// resourceOverride sets a single storiface.Resources field
+type resourceOverride struct {
+	field int
+	value reflect.Value
+}
+
+// resourceOverrides are resource spec fields which assigners use instead of
+// the worker resource spec of a task type
+type resourceOverrides map[sealtasks.TaskType][]resourceOverride
+
+// parseResourceOverrides parses SealerConfig.AssignerResourceOverrides. Keys
+// are a task type short name and a resource field, named like the worker
+// resource env vars, e.g. PC2_MIN_MEMORY.
+func parseResourceOverrides(cfg map[string]string) (resourceOverrides, error) {
+	if len(cfg) == 0 {
+		return nil, nil
+	}
+
+	taskTypes := map[string]sealtasks.TaskType{}
+	for tt := range storiface.ResourceTable {
+		taskTypes[tt.Short()] = tt
+	}
+
+	fields := map[string]int{}
+	rt := reflect.TypeOf(storiface.Resources{})
+	for i := 0; i < rt.NumField(); i++ {
+		fields[rt.Field(i).Tag.Get("envname")] = i
+	}
+
+	out := resourceOverrides{}
+	for key, val := range cfg {
+		short, name, ok := strings.Cut(key, "_")
+		if !ok {
+			return nil, xerrors.Errorf("resource override %q: expected <TASK>_<FIELD>", key)
+		}
+
+		tt, ok := taskTypes[short]
+		if !ok {
+			return nil, xerrors.Errorf("resource override %q: unknown task type %q", key, short)
+		}
+
+		field, ok := fields[name]
+		if !ok {
+			return nil, xerrors.Errorf("resource override %q: unknown resource field %q", key, name)
+		}
+
+		value := reflect.New(rt.Field(field).Type).Elem()
+		var err error
+		switch value.Kind() {
+		case reflect.Uint64:
+			var v uint64
+			v, err = strconv.ParseUint(val, 10, 64)
+			value.SetUint(v)
+		case reflect.Int:
+			var v int
+			v, err = strconv.Atoi(val)
+			value.SetInt(int64(v))
+		case reflect.Float64:
+			var v float64
+			v, err = strconv.ParseFloat(val, 64)
+			value.SetFloat(v)
+		default:
+			return nil, xerrors.Errorf("resource override %q: unknown resource field type", key)
+		}
+		if err != nil {
+			return nil, xerrors.Errorf("resource override %q: parsing value: %w", key, err)
+		}
+
+		out[tt] = append(out[tt], resourceOverride{field: field, value: value})
+	}
+
+	return out, nil
+}
+
+// resourceSpec returns the resources a task needs on a worker, with fields set
+// in sh.resourceOverrides replacing the ones from the worker resource spec
+func (sh *Scheduler) resourceSpec(w *WorkerHandle, task *WorkerRequest) storiface.Resources {
+	res := w.Info.Resources.ResourceSpec(task.Sector.ProofType, task.TaskType)
+
+	overrides := sh.resourceOverrides[task.TaskType]
+	if len(overrides) == 0 {
+		return res
+	}
+
+	rv := reflect.ValueOf(&res).Elem()
+	for _, o := range overrides {
+		rv.Field(o.field).Set(o.value)
+	}
+	return res
+}
@@ -147,17 +147,23 @@ func (a *ActiveResources) Free(schedID uuid.UUID, tt sealtasks.SealTaskType, wr
 // CanHandleRequest evaluates if the worker has enough available resources to
 // handle the request.
 func (a *ActiveResources) CanHandleRequest(schedID uuid.UUID, tt sealtasks.SealTaskType, needRes storiface.Resources, wid storiface.WorkerID, caller string, info storiface.WorkerInfo) bool {
+	return a.shortage(schedID, tt, needRes, wid, caller, info) == ""
+}
+
+// shortage is CanHandleRequest returning which resource the worker is short of,
+// one of the schedReject* window rejection reasons, or "" if the task fits
+func (a *ActiveResources) shortage(schedID uuid.UUID, tt sealtasks.SealTaskType, needRes storiface.Resources, wid storiface.WorkerID, caller string, info storiface.WorkerInfo) string {
 	if needRes.MaxConcurrent > 0 {
 		tasks := a.taskCounters.Get(tt)
 		if len(tasks) >= needRes.MaxConcurrent && (schedID == uuid.UUID{} || tasks[schedID] == 0) {
 			log.Debugf("sched: not scheduling on worker %s for %s; at task limit tt=%s, curcount=%d", wid, caller, tt, a.taskCounters.Get(tt))
-			return false
+			return schedRejectPolicy
 		}
 	}
 
 	if info.IgnoreResources {
 		// shortcircuit; if this worker is ignoring resources, it can always handle the request.
-		return true
+		return ""
 	}
 
 	res := info.Resources
@@ -169,7 +175,7 @@ func (a *ActiveResources) CanHandleRequest(schedID uuid.UUID, tt sealtasks.SealT
 	memAvail := res.MemPhysical - memUsed
 	if memNeeded > memAvail {
 		log.Debugf("sched: not scheduling on worker %s for %s; not enough physical memory - need: %dM, have %dM available", wid, caller, memNeeded/mib, memAvail/mib)
-		return false
+		return schedRejectRAM
 	}
 
 	vmemNeeded := needRes.MaxMemory + needRes.BaseMinMemory
@@ -183,22 +189,38 @@ func (a *ActiveResources) CanHandleRequest(schedID uuid.UUID, tt sealtasks.SealT
 
 	if vmemNeeded > vmemAvail {
 		log.Debugf("sched: not scheduling on worker %s for %s; not enough virtual memory - need: %dM, have %dM available", wid, caller, vmemNeeded/mib, vmemAvail/mib)
-		return false
+		return schedRejectRAM
 	}
 
 	if a.cpuUse+needRes.Threads(res.CPUs, len(res.GPUs)) > res.CPUs {
 		log.Debugf("sched: not scheduling on worker %s for %s; not enough threads, need %d, %d in use, target %d", wid, caller, needRes.Threads(res.CPUs, len(res.GPUs)), a.cpuUse, res.CPUs)
-		return false
+		return schedRejectCPU
 	}
 
 	if len(res.GPUs) > 0 && needRes.GPUUtilization > 0 {
 		if a.gpuUsed+needRes.GPUUtilization > float64(len(res.GPUs)) {
 			log.Debugf("sched: not scheduling on worker %s for %s; GPU(s) in use", wid, caller)
-			return false
+			return schedRejectGPU
 		}
 	}
 
-	return true
+	return ""
+}
+
+// projectedUtilization returns the utilization the resources would have after
+// adding a task with the given requirements, without modifying them
+func (a *ActiveResources) projectedUtilization(wr storiface.WorkerResources, r storiface.Resources) float64 {
+	p := ActiveResources{
+		memUsedMin: a.memUsedMin + r.MinMemory,
+		memUsedMax: a.memUsedMax + r.MaxMemory,
+		gpuUsed:    a.gpuUsed,
+		cpuUse:     a.cpuUse + r.Threads(wr.CPUs, len(wr.GPUs)),
+	}
+	if r.GPUUtilization > 0 {
+		p.gpuUsed += r.GPUUtilization
+	}
+
+	return p.utilization(wr)
 }
 
 // utilization returns a number in 0..1 range indicating fraction of used resources
@@ -287,3 +309,18 @@ func (wh *WorkerHandle) TaskCount(tt *sealtasks.SealTaskType) int {
 
 	return u
 }
+
+// gpuUsed returns the number of GPUs in use by active, preparing and scheduled tasks
+func (wh *WorkerHandle) gpuUsed() float64 {
+	wh.lk.Lock()
+	u := wh.active.gpuUsed
+	u += wh.preparing.gpuUsed
+	wh.lk.Unlock()
+	wh.wndLk.Lock()
+	for _, window := range wh.activeWindows {
+		u += window.Allocated.gpuUsed
+	}
+	wh.wndLk.Unlock()
+
+	return u
+}
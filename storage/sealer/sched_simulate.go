@@ -0,0 +1,186 @@
+package sealer
+
+import (
+	"context"
+	"encoding/binary"
+
+	"github.com/google/uuid"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/storage/sealer/sealtasks"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// SchedTask is a synthetic task for SimulateAssignment
+type SchedTask struct {
+	Sector   storiface.SectorRef
+	TaskType sealtasks.TaskType
+	Priority int
+}
+
+// AssignmentResult is the outcome of a simulated scheduling pass
+type AssignmentResult struct {
+	// Assigned maps indexes of assigned tasks to the index of the worker they
+	// were assigned to
+	Assigned map[int]int
+	// Unassigned lists indexes of tasks which didn't fit on any worker
+	Unassigned []int
+
+	// WorkerTasks is the number of tasks assigned to each worker
+	WorkerTasks []int
+	// WorkerUtilization is the utilization of each worker resulting from the
+	// tasks assigned in the pass
+	WorkerUtilization []float64
+}
+
+// SimulateAssignment runs a single scheduling pass of the assigner over idle
+// workers with one open window each, and reports where tasks were assigned.
+// No real workers are contacted; all tasks are acceptable on all workers,
+// limited only by worker resources. This allows comparing assigners offline.
+func SimulateAssignment(assigner Assigner, workers []storiface.WorkerInfo, tasks []SchedTask) AssignmentResult {
+	snap := SchedSnapshot{Tasks: tasks}
+	for i, info := range workers {
+		// IDs sort in worker order, which keeps tie-breaks by worker ID intuitive
+		var id uuid.UUID
+		binary.BigEndian.PutUint64(id[8:], uint64(i+1))
+
+		snap.Workers = append(snap.Workers, SchedSnapshotWorker{
+			ID:      storiface.WorkerID(id),
+			Info:    info,
+			Enabled: true,
+			Windows: 1,
+		})
+	}
+
+	return simulate(assigner, snap)
+}
+
+// simulate runs a scheduling pass of the assigner over the snapshot state
+func simulate(assigner Assigner, snap SchedSnapshot) AssignmentResult {
+	sh := &Scheduler{
+		mctx:     context.Background(),
+		assigner: assigner,
+
+		maxWorkerTasks: snap.MaxWorkerTasks,
+		workerWeights:  snap.WorkerWeights,
+
+		Workers:    map[storiface.WorkerID]*WorkerHandle{},
+		SchedQueue: &RequestQueue{},
+	}
+
+	var wrs []*SchedWindowRequest
+	workerIdx := map[storiface.WorkerID]int{}
+	for i, w := range snap.Workers {
+		sh.Workers[w.ID] = &WorkerHandle{
+			workerRpc: simWorker{},
+			Info:      w.Info,
+			preparing: w.Preparing.activeResources(),
+			active:    w.Active.activeResources(),
+			Enabled:   w.Enabled,
+			Draining:  w.Draining,
+		}
+		workerIdx[w.ID] = i
+
+		for wnd := 0; wnd < w.Windows; wnd++ {
+			wr := &SchedWindowRequest{
+				Worker: w.ID,
+				Done:   make(chan *SchedWindow, 1),
+			}
+			sh.OpenWindows = append(sh.OpenWindows, wr)
+			wrs = append(wrs, wr)
+		}
+	}
+
+	taskIdx := map[uuid.UUID]int{}
+	for i, task := range snap.Tasks {
+		req := &WorkerRequest{
+			Sector:   task.Sector,
+			TaskType: task.TaskType,
+			Priority: task.Priority,
+			Sel:      simSelector{},
+			SchedId:  uuid.New(),
+			Ctx:      context.Background(),
+		}
+		taskIdx[req.SchedId] = i
+		sh.SchedQueue.Push(req)
+	}
+
+	// assigners run with the workers lock held, see trySched
+	sh.workersLk.RLock()
+	assigner.TrySched(sh)
+	sh.workersLk.RUnlock()
+
+	res := AssignmentResult{
+		Assigned:          map[int]int{},
+		WorkerTasks:       make([]int, len(snap.Workers)),
+		WorkerUtilization: make([]float64, len(snap.Workers)),
+	}
+
+	// allocated sums allocations of the windows sent to each worker
+	allocated := make([]*ActiveResources, len(snap.Workers))
+	for _, wr := range wrs {
+		var window *SchedWindow
+		select {
+		case window = <-wr.Done:
+		default:
+			continue
+		}
+
+		wi := workerIdx[wr.Worker]
+		for _, req := range window.Todo {
+			res.Assigned[taskIdx[req.SchedId]] = wi
+		}
+		res.WorkerTasks[wi] += len(window.Todo)
+
+		if allocated[wi] == nil {
+			allocated[wi] = NewActiveResources(newTaskCounter())
+		}
+		allocated[wi].memUsedMin += window.Allocated.memUsedMin
+		allocated[wi].memUsedMax += window.Allocated.memUsedMax
+		allocated[wi].gpuUsed += window.Allocated.gpuUsed
+		allocated[wi].cpuUse += window.Allocated.cpuUse
+	}
+
+	for wi, w := range snap.Workers {
+		if allocated[wi] != nil {
+			res.WorkerUtilization[wi] = allocated[wi].utilization(w.Info.Resources)
+		}
+	}
+
+	for i := range snap.Tasks {
+		if _, ok := res.Assigned[i]; !ok {
+			res.Unassigned = append(res.Unassigned, i)
+		}
+	}
+
+	return res
+}
+
+// simWorker stands in for worker RPC in simulations. The scheduling pass only
+// calls the methods implemented here.
+type simWorker struct {
+	Worker
+}
+
+func (simWorker) TaskTypes(context.Context) (map[sealtasks.TaskType]struct{}, error) {
+	return nil, nil
+}
+
+func (simWorker) Paths(context.Context) ([]storiface.StoragePath, error) {
+	return nil, nil
+}
+
+// simSelector accepts all workers, preferring less utilized ones like most
+// real selectors
+type simSelector struct{}
+
+func (simSelector) Ok(ctx context.Context, task sealtasks.TaskType, spt abi.RegisteredSealProof, a SchedWorker) (bool, bool, error) {
+	return true, false, nil
+}
+
+func (simSelector) Cmp(ctx context.Context, task sealtasks.TaskType, a, b SchedWorker) (bool, error) {
+	return a.Utilization() < b.Utilization(), nil
+}
+
+var _ WorkerSelector = simSelector{}
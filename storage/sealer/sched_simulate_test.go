@@ -0,0 +1,173 @@
+package sealer
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/storage/sealer/sealtasks"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+func TestSimulateAssignment(t *testing.T) {
+	workers := []storiface.WorkerInfo{
+		{Hostname: "w0", Resources: decentWorkerResources},
+		{Hostname: "w1", Resources: decentWorkerResources},
+	}
+
+	var tasks []SchedTask
+	for i := 0; i < 3; i++ {
+		tasks = append(tasks, SchedTask{
+			Sector: storiface.SectorRef{
+				ID:        abi.SectorID{Miner: 1000, Number: abi.SectorNumber(i)},
+				ProofType: assignerTestSpt,
+			},
+			TaskType: sealtasks.TTPreCommit1,
+		})
+	}
+
+	t.Run("spread", func(t *testing.T) {
+		res := SimulateAssignment(NewSpreadAssigner(false), workers, tasks)
+		require.Equal(t, map[int]int{0: 0, 1: 1, 2: 0}, res.Assigned)
+		require.Empty(t, res.Unassigned)
+		require.Equal(t, []int{2, 1}, res.WorkerTasks)
+		require.Greater(t, res.WorkerUtilization[0], res.WorkerUtilization[1])
+		require.Greater(t, res.WorkerUtilization[1], 0.0)
+	})
+
+	t.Run("pack", func(t *testing.T) {
+		res := SimulateAssignment(NewPackAssigner(), workers, tasks)
+		require.Equal(t, map[int]int{0: 0, 1: 0, 2: 1}, res.Assigned)
+		require.Empty(t, res.Unassigned)
+		require.Equal(t, []int{2, 1}, res.WorkerTasks)
+	})
+
+	t.Run("over-capacity", func(t *testing.T) {
+		res := SimulateAssignment(NewPackAssigner(), workers[:1], tasks)
+		require.Len(t, res.Assigned, 2)
+		require.Equal(t, []int{2}, res.Unassigned)
+		require.Equal(t, []int{2}, res.WorkerTasks)
+	})
+}
+
+func TestSchedSnapshotRoundTrip(t *testing.T) {
+	tasks := []sealtasks.TaskType{
+		sealtasks.TTPreCommit1, sealtasks.TTPreCommit2, sealtasks.TTPreCommit1,
+		sealtasks.TTPreCommit1, sealtasks.TTPreCommit2, sealtasks.TTPreCommit1,
+	}
+
+	// assigners which break ties between equally good workers by worker ID, so
+	// that replays of a snapshot assign the same way
+	for _, name := range []string{"spread", "experiment-pack"} {
+		t.Run(name, func(t *testing.T) {
+			sh, _, _ := newAssignerTestSched(t, []storiface.WorkerResources{decentWorkerResources, decentWorkerResources, decentWorkerResources}, tasks...)
+			sh.assignerName = name
+
+			// worker 0 is already running a PC1, and worker 1 has two windows open
+			w0 := sh.Workers[assignerTestWid(0)]
+			pc1 := sealtasks.TTPreCommit1.SealTask(assignerTestSpt)
+			w0.active.Add(uuid.New(), pc1, w0.Info.Resources, w0.Info.Resources.ResourceSpec(assignerTestSpt, sealtasks.TTPreCommit1))
+			sh.OpenWindows = append(sh.OpenWindows, &SchedWindowRequest{Worker: assignerTestWid(1), Done: make(chan *SchedWindow, 1)})
+
+			snap := sh.snapshot()
+			require.Len(t, snap.Workers, 3)
+			require.Equal(t, 2, snap.Workers[1].Windows)
+			require.Equal(t, []SchedSnapshotTaskCount{{Task: pc1, Count: 1}}, snap.Workers[0].Active.Tasks)
+			require.Len(t, snap.Tasks, len(tasks))
+
+			data, err := json.Marshal(snap)
+			require.NoError(t, err)
+
+			var decoded SchedSnapshot
+			require.NoError(t, json.Unmarshal(data, &decoded))
+			require.Equal(t, *snap, decoded)
+
+			want, err := SimulateSnapshot(*snap)
+			require.NoError(t, err)
+			require.NotEmpty(t, want.Assigned)
+
+			got, err := SimulateSnapshot(decoded)
+			require.NoError(t, err)
+			require.Equal(t, want, got)
+		})
+	}
+}
+
+// BenchmarkAssigners compares registered assigners on simulated scheduling
+// passes over a mixed queue and a heterogeneous worker set. Besides the time
+// of a pass (which includes building the simulated scheduler), it reports the
+// number of tasks assigned, and the variance of the resulting worker
+// utilization - lower variance means load is spread more evenly.
+func BenchmarkAssigners(b *testing.B) {
+	bigWorkerResources := decentWorkerResources
+	bigWorkerResources.MemPhysical = 512 << 30
+	bigWorkerResources.CPUs = 64
+	bigWorkerResources.GPUs = []string{"gpu0"}
+
+	var workers []storiface.WorkerInfo
+	for i := 0; i < 8; i++ {
+		res := decentWorkerResources
+		if i%2 == 1 {
+			res = bigWorkerResources
+		}
+		workers = append(workers, storiface.WorkerInfo{Hostname: fmt.Sprintf("w%d", i), Resources: res})
+	}
+
+	taskTypes := []sealtasks.TaskType{sealtasks.TTPreCommit1, sealtasks.TTPreCommit1, sealtasks.TTPreCommit2, sealtasks.TTCommit2}
+
+	for _, queueLen := range []int{16, 64, 256} {
+		var tasks []SchedTask
+		for i := 0; i < queueLen; i++ {
+			tasks = append(tasks, SchedTask{
+				Sector: storiface.SectorRef{
+					ID:        abi.SectorID{Miner: 1000, Number: abi.SectorNumber(i)},
+					ProofType: assignerTestSpt,
+				},
+				TaskType: taskTypes[i%len(taskTypes)],
+				Priority: i % 3,
+			})
+		}
+
+		for _, name := range AssignerNames() {
+			b.Run(fmt.Sprintf("%s/queue-%d", name, queueLen), func(b *testing.B) {
+				var assigned int
+				var variance float64
+
+				for i := 0; i < b.N; i++ {
+					assigner, err := GetAssigner(name)
+					require.NoError(b, err)
+
+					res := SimulateAssignment(assigner, workers, tasks)
+					assigned += len(res.Assigned)
+					variance += utilizationVariance(res.WorkerUtilization)
+				}
+
+				b.ReportMetric(float64(assigned)/float64(b.N), "assigned/op")
+				b.ReportMetric(variance/float64(b.N), "util-variance/op")
+			})
+		}
+	}
+}
+
+func utilizationVariance(util []float64) float64 {
+	if len(util) == 0 {
+		return 0
+	}
+
+	var mean float64
+	for _, u := range util {
+		mean += u
+	}
+	mean /= float64(len(util))
+
+	var variance float64
+	for _, u := range util {
+		variance += (u - mean) * (u - mean)
+	}
+	return variance / float64(len(util))
+}
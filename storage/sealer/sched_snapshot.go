@@ -0,0 +1,172 @@
+package sealer
+
+import (
+	"bytes"
+	"context"
+	"sort"
+
+	"github.com/google/uuid"
+
+	"github.com/filecoin-project/lotus/storage/sealer/sealtasks"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// SchedSnapshot is the scheduler state a scheduling pass works on, for
+// reproducing assignment decisions offline with SimulateSnapshot. It only holds
+// what assigners look at: worker resources and allocations, open windows, the
+// request queue and assigner settings. Worker RPC handles, storage paths and
+// selectors aren't included; in simulation every task is acceptable on every
+// worker, and AssignerResourceOverrides don't apply.
+type SchedSnapshot struct {
+	Assigner       string
+	MaxWorkerTasks int                `json:",omitempty"`
+	WorkerWeights  map[string]float64 `json:",omitempty"`
+
+	Workers []SchedSnapshotWorker
+	// Tasks is the request queue, in queue order
+	Tasks []SchedTask
+}
+
+// SchedSnapshotWorker is a worker in a SchedSnapshot
+type SchedSnapshotWorker struct {
+	ID   storiface.WorkerID
+	Info storiface.WorkerInfo
+
+	Enabled  bool
+	Draining bool `json:",omitempty"`
+
+	// Windows is the number of open scheduling windows of the worker
+	Windows int
+
+	Preparing SchedSnapshotResources
+	Active    SchedSnapshotResources
+}
+
+// SchedSnapshotResources are the resources allocated on a worker
+type SchedSnapshotResources struct {
+	MemUsedMin uint64
+	MemUsedMax uint64
+	GPUUsed    float64
+	CPUUse     uint64
+
+	Tasks []SchedSnapshotTaskCount `json:",omitempty"`
+}
+
+// SchedSnapshotTaskCount is the number of tasks of a type holding resources
+type SchedSnapshotTaskCount struct {
+	Task  sealtasks.SealTaskType
+	Count int
+}
+
+// Snapshot returns the current state of the scheduler, see SchedSnapshot
+func (sh *Scheduler) Snapshot(ctx context.Context) (*SchedSnapshot, error) {
+	ch := make(chan *SchedSnapshot, 1)
+
+	select {
+	case sh.snapshotReq <- ch:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case snap := <-ch:
+		return snap, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// snapshot captures the scheduler state, must be called from the sh.runSched
+// goroutine
+func (sh *Scheduler) snapshot() *SchedSnapshot {
+	sh.workersLk.RLock()
+	defer sh.workersLk.RUnlock()
+
+	snap := &SchedSnapshot{
+		Assigner:       sh.assignerName,
+		MaxWorkerTasks: sh.maxWorkerTasks,
+		WorkerWeights:  sh.workerWeights,
+	}
+
+	windows := map[storiface.WorkerID]int{}
+	for _, window := range sh.OpenWindows {
+		windows[window.Worker]++
+	}
+
+	for wid, w := range sh.Workers {
+		w.lk.Lock()
+		snap.Workers = append(snap.Workers, SchedSnapshotWorker{
+			ID:        wid,
+			Info:      w.Info,
+			Enabled:   w.Enabled,
+			Draining:  w.Draining,
+			Windows:   windows[wid],
+			Preparing: snapshotResources(w.preparing),
+			Active:    snapshotResources(w.active),
+		})
+		w.lk.Unlock()
+	}
+	sort.Slice(snap.Workers, func(i, j int) bool {
+		return bytes.Compare(snap.Workers[i].ID[:], snap.Workers[j].ID[:]) < 0
+	})
+
+	for sqi := 0; sqi < sh.SchedQueue.Len(); sqi++ {
+		task := (*sh.SchedQueue)[sqi]
+		snap.Tasks = append(snap.Tasks, SchedTask{
+			Sector:   task.Sector,
+			TaskType: task.TaskType,
+			Priority: task.Priority,
+		})
+	}
+
+	return snap
+}
+
+func snapshotResources(a *ActiveResources) SchedSnapshotResources {
+	res := SchedSnapshotResources{
+		MemUsedMin: a.memUsedMin,
+		MemUsedMax: a.memUsedMax,
+		GPUUsed:    a.gpuUsed,
+		CPUUse:     a.cpuUse,
+	}
+
+	a.taskCounters.ForEach(func(tt sealtasks.SealTaskType, count int) {
+		if count > 0 {
+			res.Tasks = append(res.Tasks, SchedSnapshotTaskCount{Task: tt, Count: count})
+		}
+	})
+	sort.Slice(res.Tasks, func(i, j int) bool {
+		return res.Tasks[i].Task.String() < res.Tasks[j].Task.String()
+	})
+
+	return res
+}
+
+// activeResources restores the allocation of a snapshot
+func (r SchedSnapshotResources) activeResources() *ActiveResources {
+	a := NewActiveResources(newTaskCounter())
+	a.memUsedMin = r.MemUsedMin
+	a.memUsedMax = r.MemUsedMax
+	a.gpuUsed = r.GPUUsed
+	a.cpuUse = r.CPUUse
+
+	for _, tc := range r.Tasks {
+		for i := 0; i < tc.Count; i++ {
+			a.taskCounters.Add(tc.Task, uuid.New())
+		}
+	}
+
+	return a
+}
+
+// SimulateSnapshot runs a single scheduling pass of the snapshot assigner over
+// the snapshot state, like SimulateAssignment. Worker indexes in the result
+// are indexes into snap.Workers.
+func SimulateSnapshot(snap SchedSnapshot) (AssignmentResult, error) {
+	assigner, err := GetAssigner(snap.Assigner)
+	if err != nil {
+		return AssignmentResult{}, err
+	}
+
+	return simulate(assigner, snap), nil
+}
@@ -0,0 +1,181 @@
+package sealer
+
+import (
+	"context"
+	"sort"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/storage/sealer/sealtasks"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// taskAllocTypes are the sector files which tasks allocate on the sealing
+// paths of the worker executing them
+var taskAllocTypes = map[sealtasks.TaskType]storiface.SectorFileType{
+	sealtasks.TTAddPiece:       storiface.FTUnsealed,
+	sealtasks.TTPreCommit1:     storiface.FTSealed | storiface.FTCache,
+	sealtasks.TTReplicaUpdate:  storiface.FTUpdate | storiface.FTUpdateCache,
+	sealtasks.TTRegenSectorKey: storiface.FTSealed | storiface.FTCache,
+}
+
+// hasSealingSpace checks whether the worker has local sealing paths with room
+// for the files the task will allocate. It always passes when space checks
+// aren't enabled, or when the task doesn't allocate new sector files.
+//
+// Note that the check uses the free space last reported to the index, and
+// doesn't account for other tasks assigned to the worker in the same pass.
+func (sh *Scheduler) hasSealingSpace(ctx context.Context, task *WorkerRequest, w SchedWorker) bool {
+	if sh.spaceIndex == nil {
+		return true
+	}
+
+	alloc, ok := taskAllocTypes[task.TaskType]
+	if !ok {
+		return true
+	}
+
+	ssize, err := task.Sector.ProofType.SectorSize()
+	if err != nil {
+		log.Errorw("getting sector size", "sector", task.Sector.ID, "error", err)
+		return false
+	}
+
+	ok, err = canAllocate(ctx, sh.spaceIndex, w, alloc, ssize, storiface.PathSealing)
+	if err != nil {
+		log.Debugw("checking worker sealing space", "sector", task.Sector.ID, "task", task.TaskType, "error", err)
+		return false
+	}
+
+	return ok
+}
+
+// pathHints picks storage path hints for the tasks assigned in one scheduling
+// pass. Worker paths come from the pass's worker cache, and hints are computed
+// once per worker, file types and sector size, so that Step 3 of TrySched
+// doesn't query workers and the index for every assigned task.
+type pathHints struct {
+	sh      *Scheduler
+	workers *schedWorkerCache
+
+	best  map[pathAllocKey][]storiface.StorageInfo
+	hints map[pathHintKey]storiface.ID
+}
+
+type pathAllocKey struct {
+	alloc storiface.SectorFileType
+	ssize abi.SectorSize
+}
+
+type pathHintKey struct {
+	wid storiface.WorkerID
+	pathAllocKey
+}
+
+func newPathHints(sh *Scheduler, workers *schedWorkerCache) *pathHints {
+	return &pathHints{
+		sh:      sh,
+		workers: workers,
+
+		best:  map[pathAllocKey][]storiface.StorageInfo{},
+		hints: map[pathHintKey]storiface.ID{},
+	}
+}
+
+// hint picks the sealing path of the worker in which the files the task will
+// allocate should be placed. Paths in earlier sh.pathTiers groups are
+// preferred, paths in the same tier are ranked by the index, which prefers
+// paths with the most (weighted) free space. Hints travel in the task context,
+// which doesn't cross the worker API, so only workers running in the miner
+// process get them. Returns an empty ID when the task doesn't allocate new
+// sector files, the worker is remote or it has no suitable path.
+//
+// Like hasSealingSpace, the hint uses the free space last reported to the
+// index, so tasks assigned to a worker in the same pass get the same path.
+func (ph *pathHints) hint(task *WorkerRequest, wid storiface.WorkerID) storiface.ID {
+	alloc, ok := taskAllocTypes[task.TaskType]
+	if !ok {
+		return ""
+	}
+
+	if w, ok := ph.sh.Workers[wid]; !ok || !w.local {
+		return ""
+	}
+
+	ssize, err := task.Sector.ProofType.SectorSize()
+	if err != nil {
+		log.Errorw("getting sector size", "sector", task.Sector.ID, "error", err)
+		return ""
+	}
+
+	key := pathHintKey{wid: wid, pathAllocKey: pathAllocKey{alloc: alloc, ssize: ssize}}
+	if hint, ok := ph.hints[key]; ok {
+		return hint
+	}
+
+	ctx, cancel := context.WithTimeout(task.Ctx, SelectorTimeout)
+	defer cancel()
+
+	hint, err := ph.pick(ctx, key)
+	if err != nil {
+		log.Debugw("picking path hint", "sector", task.Sector.ID, "worker", wid, "error", err)
+		return ""
+	}
+
+	ph.hints[key] = hint
+	return hint
+}
+
+func (ph *pathHints) pick(ctx context.Context, key pathHintKey) (storiface.ID, error) {
+	w, ok := ph.workers.Get(key.wid)
+	if !ok {
+		return "", nil
+	}
+
+	wpaths, err := w.Paths(ctx)
+	if err != nil {
+		return "", xerrors.Errorf("getting worker paths: %w", err)
+	}
+
+	best, ok := ph.best[key.pathAllocKey]
+	if !ok {
+		best, err = ph.sh.pathIndex.StorageBestAlloc(ctx, key.alloc, key.ssize, storiface.PathSealing)
+		if err != nil {
+			return "", xerrors.Errorf("finding best alloc storage: %w", err)
+		}
+		ph.best[key.pathAllocKey] = best
+	}
+
+	have := map[storiface.ID]struct{}{}
+	for _, path := range wpaths {
+		have[path.ID] = struct{}{}
+	}
+
+	var candidates []storiface.StorageInfo
+	for _, info := range best {
+		if _, ok := have[info.ID]; ok && key.alloc.Allowed(info.AllowTypes, info.DenyTypes) {
+			candidates = append(candidates, info)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", nil
+	}
+
+	tier := func(info storiface.StorageInfo) int {
+		for i, group := range ph.sh.pathTiers {
+			for _, g := range info.Groups {
+				if g == group {
+					return i
+				}
+			}
+		}
+		return len(ph.sh.pathTiers)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return tier(candidates[i]) < tier(candidates[j])
+	})
+
+	return candidates[0].ID, nil
+}
@@ -0,0 +1,117 @@
+package sealer
+
+import (
+	"context"
+
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// heldBack reports whether a window of the worker is kept from a task because
+// the worker is reserved for a starving task the task doesn't sort ahead of,
+// see reserveStarving
+func (sh *Scheduler) heldBack(task *WorkerRequest, wid storiface.WorkerID) bool {
+	r, ok := sh.starvingReservations[wid]
+	if !ok || r == task {
+		return false
+	}
+	return !(RequestQueue{task, r}).Less(0, 1)
+}
+
+// reserveStarving updates the workers reserved for starving tasks after the
+// window selection of a pass. Reservations of tasks which were assigned or
+// left the queue are released. Starving tasks which are still unassigned
+// reserve the first worker with an open window their selector accepts, which
+// assigners then keep from lower priority tasks (see heldBack) until the
+// worker has room for the starving task. This way a task needing a lot of
+// resources isn't forever outrun by smaller tasks filling each window the
+// worker opens.
+func reserveStarving(sh *Scheduler, queued []*WorkerRequest, windows []SchedWindow) {
+	if !sh.reserveStarving {
+		return
+	}
+
+	assigned := map[*WorkerRequest]struct{}{}
+	for _, window := range windows {
+		for _, task := range window.Todo {
+			assigned[task] = struct{}{}
+		}
+	}
+	inQueue := map[*WorkerRequest]struct{}{}
+	for _, task := range *sh.SchedQueue {
+		inQueue[task] = struct{}{}
+	}
+
+	reserved := map[*WorkerRequest]struct{}{}
+	for wid, task := range sh.starvingReservations {
+		_, isAssigned := assigned[task]
+		_, queued := inQueue[task]
+		_, workerOk := sh.Workers[wid]
+		if isAssigned || !queued || !workerOk {
+			delete(sh.starvingReservations, wid)
+			continue
+		}
+		reserved[task] = struct{}{}
+	}
+
+	for _, task := range queued {
+		if !task.starving {
+			continue
+		}
+		if _, ok := assigned[task]; ok {
+			continue
+		}
+		if _, ok := reserved[task]; ok {
+			continue
+		}
+
+		wid, ok := sh.starvingReservationWorker(task)
+		if !ok {
+			continue
+		}
+
+		log.Warnw("reserving worker for starving task",
+			"sector", task.Sector.ID,
+			"task", task.TaskType,
+			"skipped", task.skipped,
+			"worker", wid)
+
+		if sh.starvingReservations == nil {
+			sh.starvingReservations = map[storiface.WorkerID]*WorkerRequest{}
+		}
+		sh.starvingReservations[wid] = task
+		reserved[task] = struct{}{}
+	}
+}
+
+// starvingReservationWorker returns the first worker with an open window which
+// can run the task once it has room, and isn't reserved yet
+func (sh *Scheduler) starvingReservationWorker(task *WorkerRequest) (storiface.WorkerID, bool) {
+	pinned, isPinned := sh.pins.snapshot()[task.Sector.ID]
+
+	for _, wr := range sh.OpenWindows {
+		if _, ok := sh.starvingReservations[wr.Worker]; ok {
+			continue
+		}
+		if isPinned && pinned != wr.Worker {
+			continue
+		}
+
+		w, ok := sh.Workers[wr.Worker]
+		if !ok || !w.Enabled || w.Draining {
+			continue
+		}
+
+		rpcCtx, cancel := context.WithTimeout(task.Ctx, SelectorTimeout)
+		ok, _, err := task.Sel.Ok(rpcCtx, task.TaskType, task.Sector.ProofType, w)
+		cancel()
+		if err != nil {
+			log.Errorf("reserving worker for starving task: selector error: %+v", err)
+			continue
+		}
+		if ok {
+			return wr.Worker, true
+		}
+	}
+
+	return storiface.WorkerID{}, false
+}
@@ -0,0 +1,96 @@
+package sealer
+
+import (
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/storage/sealer/sealtasks"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// taskTypeCaps limits the number of tasks of a type a single worker can hold
+type taskTypeCaps map[sealtasks.TaskType]int
+
+// parseTaskTypeCaps parses per-worker task type limits keyed by task type short
+// name, e.g. "PC1" = 2. Zero limits are ignored.
+func parseTaskTypeCaps(caps map[string]int) (taskTypeCaps, error) {
+	if len(caps) == 0 {
+		return nil, nil
+	}
+
+	taskTypes := shortTaskTypes()
+
+	out := taskTypeCaps{}
+	for name, limit := range caps {
+		tt, ok := taskTypes[strings.TrimSpace(name)]
+		if !ok {
+			return nil, xerrors.Errorf("unknown task type %q", name)
+		}
+		if limit < 0 {
+			return nil, xerrors.Errorf("negative limit %d for task type %q", limit, name)
+		}
+		if limit > 0 {
+			out[tt] = limit
+		}
+	}
+
+	return out, nil
+}
+
+// taskTypeAtCap reports whether the worker already holds as many tasks of the
+// task's type as sh.taskTypeCaps allows, counting tasks it runs, prepares or
+// has in scheduled windows, and tasks assigned to it in the current
+// scheduling pass
+func (sh *Scheduler) taskTypeAtCap(task *WorkerRequest, wid storiface.WorkerID, windows []SchedWindow) bool {
+	limit := sh.taskTypeCaps[task.TaskType]
+	if limit <= 0 {
+		return false
+	}
+
+	var n int
+	for wnd := range windows {
+		if sh.OpenWindows[wnd].Worker != wid {
+			continue
+		}
+		for _, todo := range windows[wnd].Todo {
+			if todo.TaskType == task.TaskType {
+				n++
+			}
+		}
+	}
+
+	if w, ok := sh.Workers[wid]; ok {
+		n += w.taskTypeCount(task.TaskType)
+	}
+
+	return n >= limit
+}
+
+// taskTypeCount counts tasks of the type the worker runs, prepares or has in
+// scheduled windows
+func (wh *WorkerHandle) taskTypeCount(tt sealtasks.TaskType) int {
+	var n int
+	count := func(stt sealtasks.SealTaskType, count int) {
+		if stt.TaskType == tt {
+			n += count
+		}
+	}
+
+	wh.lk.Lock()
+	wh.active.taskCounters.ForEach(count)
+	wh.preparing.taskCounters.ForEach(count)
+	wh.lk.Unlock()
+
+	wh.wndLk.Lock()
+	defer wh.wndLk.Unlock()
+	for _, window := range wh.activeWindows {
+		for _, todo := range window.Todo {
+			if todo.TaskType == tt {
+				n++
+			}
+		}
+	}
+
+	return n
+}
@@ -0,0 +1,129 @@
+package sealer
+
+import (
+	"github.com/google/uuid"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/storage/sealer/sealtasks"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// SchedRejectReason says why a scheduling pass didn't assign a task to a window
+type SchedRejectReason string
+
+const (
+	// SchedRejectResources - the worker doesn't have resources for the task,
+	// even with nothing else assigned to the window
+	SchedRejectResources SchedRejectReason = "resources"
+	// SchedRejectCap - the worker holds AssignerMaxWorkerTasks tasks
+	SchedRejectCap SchedRejectReason = "cap"
+	// SchedRejectStorage - the worker doesn't have local sealing paths with
+	// room for the files the task creates, see AssignerCheckSpace
+	SchedRejectStorage SchedRejectReason = "storage"
+	// SchedRejectPolicy - the worker was ruled out by the task selector, a more
+	// preferred worker, or the assigner strategy
+	SchedRejectPolicy SchedRejectReason = "policy"
+	// SchedRejectFull - tasks assigned to the window earlier in the pass used
+	// up the resources the task needs
+	SchedRejectFull SchedRejectReason = "full"
+)
+
+// SchedTraceWindow is an open window considered for a task
+type SchedTraceWindow struct {
+	Window   int
+	Worker   storiface.WorkerID
+	Rejected SchedRejectReason `json:",omitempty"`
+	Assigned bool              `json:",omitempty"`
+}
+
+// SchedTraceTask records the windows a scheduling pass considered for a task
+type SchedTraceTask struct {
+	Sector   abi.SectorID
+	TaskType sealtasks.TaskType
+	SchedId  uuid.UUID
+	Windows  []SchedTraceWindow
+
+	task *WorkerRequest
+}
+
+func (t *SchedTraceTask) reject(wnd int, wid storiface.WorkerID, reason SchedRejectReason) {
+	if t == nil {
+		return
+	}
+	t.Windows = append(t.Windows, SchedTraceWindow{Window: wnd, Worker: wid, Rejected: reason})
+}
+
+// newSchedTrace starts the decision trace of a scheduling pass, or returns nil
+// when tracing is disabled
+func newSchedTrace(sh *Scheduler, queueLen int) []SchedTraceTask {
+	if !sh.traceAssign {
+		return nil
+	}
+
+	trace := make([]SchedTraceTask, queueLen)
+	for sqi := range trace {
+		task := (*sh.SchedQueue)[sqi]
+		trace[sqi] = SchedTraceTask{
+			Sector:   task.Sector.ID,
+			TaskType: task.TaskType,
+			SchedId:  task.SchedId,
+			task:     task,
+		}
+	}
+	return trace
+}
+
+// finishSchedTrace records what happened to windows which were acceptable for
+// each task after the window selector ran, and keeps the trace for SchedDiag.
+// Why the selector passed over a window is inferred from the final state of
+// the pass.
+func finishSchedTrace(sh *Scheduler, trace []SchedTraceTask, acceptableWindows [][]int, windows []SchedWindow) {
+	if trace == nil {
+		return
+	}
+
+	assigned := map[*WorkerRequest]int{}
+	for wnd := range windows {
+		for _, task := range windows[wnd].Todo {
+			assigned[task] = wnd
+		}
+	}
+
+	for sqi := range trace {
+		t := &trace[sqi]
+		awnd, isAssigned := assigned[t.task]
+
+		for _, wnd := range acceptableWindows[sqi] {
+			wid := sh.OpenWindows[wnd].Worker
+			tw := SchedTraceWindow{Window: wnd, Worker: wid}
+
+			switch {
+			case isAssigned && wnd == awnd:
+				tw.Assigned = true
+			case sh.workerAtCap(wid, windows):
+				tw.Rejected = SchedRejectCap
+			case !windowFits(sh, t.task, wid, windows[wnd]):
+				tw.Rejected = SchedRejectFull
+			default:
+				tw.Rejected = SchedRejectPolicy
+			}
+
+			t.Windows = append(t.Windows, tw)
+		}
+
+		t.task = nil
+	}
+
+	sh.lastTrace = trace
+}
+
+func windowFits(sh *Scheduler, task *WorkerRequest, wid storiface.WorkerID, window SchedWindow) bool {
+	w, ok := sh.Workers[wid]
+	if !ok {
+		return false
+	}
+
+	res := sh.resourceSpec(w, task)
+	return window.Allocated.CanHandleRequest(task.SchedId, task.SealTask(), res, wid, "schedTrace", w.Info)
+}
@@ -0,0 +1,104 @@
+package sealer
+
+import (
+	"sort"
+	"strconv"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/metrics"
+	"github.com/filecoin-project/lotus/storage/sealer/sealtasks"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// SchedUnmetDemand is the work of one task and proof type which the latest
+// scheduling pass found no window for, meant for capacity planning and
+// autoscaling of workers
+type SchedUnmetDemand struct {
+	TaskType  sealtasks.TaskType
+	ProofType abi.RegisteredSealProof
+
+	Tasks int
+
+	// resources the tasks need together, by the default resource table
+	MinMemory      uint64
+	MaxMemory      uint64
+	GPUUtilization float64
+}
+
+type unmetDemandKey struct {
+	taskType  sealtasks.TaskType
+	proofType abi.RegisteredSealProof
+}
+
+// unmetDemand sums up the demand of the queued tasks of a pass which weren't
+// assigned to any of the windows, sorted by task and proof type
+func unmetDemand(queued []*WorkerRequest, windows []SchedWindow) []SchedUnmetDemand {
+	assigned := map[*WorkerRequest]struct{}{}
+	for _, window := range windows {
+		for _, task := range window.Todo {
+			assigned[task] = struct{}{}
+		}
+	}
+
+	byType := map[unmetDemandKey]*SchedUnmetDemand{}
+	for _, task := range queued {
+		if _, ok := assigned[task]; ok {
+			continue
+		}
+
+		key := unmetDemandKey{taskType: task.TaskType, proofType: task.Sector.ProofType}
+		d, ok := byType[key]
+		if !ok {
+			d = &SchedUnmetDemand{TaskType: key.taskType, ProofType: key.proofType}
+			byType[key] = d
+		}
+
+		res := storiface.ResourceTable[task.TaskType][task.Sector.ProofType]
+		d.Tasks++
+		d.MinMemory += res.MinMemory
+		d.MaxMemory += res.MaxMemory
+		d.GPUUtilization += res.GPUUtilization
+	}
+
+	out := make([]SchedUnmetDemand, 0, len(byType))
+	for _, d := range byType {
+		out = append(out, *d)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].TaskType != out[j].TaskType {
+			return out[i].TaskType < out[j].TaskType
+		}
+		return out[i].ProofType < out[j].ProofType
+	})
+	return out
+}
+
+// recordUnmetDemand keeps the unmet demand of a pass for SchedDiag and records
+// it in the SchedUnmetTasks and SchedUnmetMemory metrics. Task and proof types
+// with unmet demand in the previous pass but none now are recorded as 0.
+func recordUnmetDemand(sh *Scheduler, demand []SchedUnmetDemand) {
+	now := make(map[unmetDemandKey]struct{}, len(demand))
+	for _, d := range demand {
+		now[unmetDemandKey{taskType: d.TaskType, proofType: d.ProofType}] = struct{}{}
+		recordUnmet(sh, d)
+	}
+	for _, d := range sh.lastUnmet {
+		if _, ok := now[unmetDemandKey{taskType: d.TaskType, proofType: d.ProofType}]; !ok {
+			recordUnmet(sh, SchedUnmetDemand{TaskType: d.TaskType, ProofType: d.ProofType})
+		}
+	}
+
+	sh.lastUnmet = demand
+}
+
+func recordUnmet(sh *Scheduler, d SchedUnmetDemand) {
+	ctx, _ := tag.New(sh.mctx,
+		tag.Upsert(metrics.TaskType, string(d.TaskType)),
+		tag.Upsert(metrics.SealProofType, strconv.FormatInt(int64(d.ProofType), 10)),
+	)
+	stats.Record(ctx, metrics.SchedUnmetTasks.M(int64(d.Tasks)), metrics.SchedUnmetMemory.M(int64(d.MaxMemory)))
+}
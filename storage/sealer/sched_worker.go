@@ -32,9 +32,12 @@ func newWorkerHandle(ctx context.Context, w Worker) (*WorkerHandle, error) {
 
 	tc := newTaskCounter()
 
+	_, local := w.(*LocalWorker)
+
 	worker := &WorkerHandle{
 		workerRpc: w,
 		Info:      info,
+		local:     local,
 
 		preparing: NewActiveResources(tc),
 		active:    NewActiveResources(tc),
@@ -59,6 +62,7 @@ func (sh *Scheduler) runWorker(ctx context.Context, wid storiface.WorkerID, work
 		return nil
 	}
 
+	worker.joined = time.Now()
 	sh.Workers[wid] = worker
 	sh.workersLk.Unlock()
 
@@ -453,6 +457,10 @@ assignLoop:
 func (sw *schedWorker) startProcessingTask(req *WorkerRequest) error {
 	w, sh := sw.worker, sw.sched
 
+	if req.PathHint != "" {
+		req.Ctx = storiface.WithPathHint(req.Ctx, req.PathHint)
+	}
+
 	needRes := w.Info.Resources.ResourceSpec(req.Sector.ProofType, req.TaskType)
 	needResPrep := w.Info.Resources.PrepResourceSpec(req.Sector.ProofType, req.TaskType, req.prepare.PrepType)
 
@@ -511,7 +519,11 @@ func (sw *schedWorker) startProcessingTask(req *WorkerRequest) error {
 
 			// Do the work!
 			tw.start()
+			sh.reportTaskProof(sw.wid, req)
 			err = <-werr
+			if err == nil || workerFault(req.Ctx, err) {
+				sh.ReportTaskOutcome(sw.wid, err == nil)
+			}
 
 			select {
 			case req.ret <- workerResponse{err: err}:
@@ -35,8 +35,9 @@ func (s *schedWorkerCache) Get(id storiface.WorkerID) (*cachedSchedWorker, bool)
 				return whnd.Utilization(), nil
 			}),
 
-			Enabled: whnd.Enabled,
-			Info:    whnd.Info,
+			Enabled:  whnd.Enabled,
+			Draining: whnd.Draining,
+			Info:     whnd.Info,
 		}
 	}
 
@@ -48,8 +49,9 @@ type cachedSchedWorker struct {
 	paths       *lazy.LazyCtx[[]storiface.StoragePath]
 	utilization *lazy.Lazy[float64]
 
-	Enabled bool
-	Info    storiface.WorkerInfo
+	Enabled  bool
+	Draining bool
+	Info     storiface.WorkerInfo
 }
 
 func (c *cachedSchedWorker) TaskTypes(ctx context.Context) (map[sealtasks.TaskType]struct{}, error) {
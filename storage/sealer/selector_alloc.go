@@ -35,9 +35,25 @@ func (s *allocSelector) Ok(ctx context.Context, task sealtasks.TaskType, spt abi
 		return false, false, nil
 	}
 
+	ssize, err := spt.SectorSize()
+	if err != nil {
+		return false, false, xerrors.Errorf("getting sector size: %w", err)
+	}
+
+	ok, err := canAllocate(ctx, s.index, whnd, s.alloc, ssize, s.ptype)
+	if err != nil {
+		return false, false, err
+	}
+
+	return ok, false, nil
+}
+
+// canAllocate checks whether the worker has local paths of the given type with
+// enough space to allocate all requested sector file types
+func canAllocate(ctx context.Context, index paths.SectorIndex, whnd SchedWorker, alloc storiface.SectorFileType, ssize abi.SectorSize, ptype storiface.PathType) (bool, error) {
 	paths, err := whnd.Paths(ctx)
 	if err != nil {
-		return false, false, xerrors.Errorf("getting worker paths: %w", err)
+		return false, xerrors.Errorf("getting worker paths: %w", err)
 	}
 
 	have := map[storiface.ID]struct{}{}
@@ -45,17 +61,12 @@ func (s *allocSelector) Ok(ctx context.Context, task sealtasks.TaskType, spt abi
 		have[path.ID] = struct{}{}
 	}
 
-	ssize, err := spt.SectorSize()
-	if err != nil {
-		return false, false, xerrors.Errorf("getting sector size: %w", err)
-	}
-
-	best, err := s.index.StorageBestAlloc(ctx, s.alloc, ssize, s.ptype)
+	best, err := index.StorageBestAlloc(ctx, alloc, ssize, ptype)
 	if err != nil {
-		return false, false, xerrors.Errorf("finding best alloc storage: %w", err)
+		return false, xerrors.Errorf("finding best alloc storage: %w", err)
 	}
 
-	requested := s.alloc
+	requested := alloc
 
 	for _, info := range best {
 		if _, ok := have[info.ID]; ok {
@@ -68,7 +79,7 @@ func (s *allocSelector) Ok(ctx context.Context, task sealtasks.TaskType, spt abi
 		}
 	}
 
-	return requested == storiface.FTNone, false, nil
+	return requested == storiface.FTNone, nil
 }
 
 func (s *allocSelector) Cmp(ctx context.Context, task sealtasks.TaskType, a, b SchedWorker) (bool, error) {
@@ -1,6 +1,10 @@
 package storiface
 
-import "github.com/filecoin-project/go-state-types/abi"
+import (
+	"context"
+
+	"github.com/filecoin-project/go-state-types/abi"
+)
 
 type PathType string
 
@@ -37,3 +41,17 @@ func AcquireInto(pathIDs PathsWithIDs) AcquireOption {
 		settings.Into = &pathIDs
 	}
 }
+
+type pathHintKey struct{}
+
+// WithPathHint returns a context asking stores allocating sector files to
+// prefer the storage path with the given ID, if it's usable for the files
+func WithPathHint(ctx context.Context, id ID) context.Context {
+	return context.WithValue(ctx, pathHintKey{}, id)
+}
+
+// PathHint returns the storage path hint set with WithPathHint, or an empty ID
+func PathHint(ctx context.Context) ID {
+	id, _ := ctx.Value(pathHintKey{}).(ID)
+	return id
+}